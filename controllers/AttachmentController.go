@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"todo-app/models"
+	"todo-app/storage"
+	"todo-app/thumbnail"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+// multipartOverhead is the room left in an upload for the multipart framing
+// around the file.
+const multipartOverhead = 1 << 20
+
+type AttachmentController struct {
+	session *gocql.Session
+}
+
+func NewAttachmentController(session *gocql.Session) *AttachmentController {
+	return &AttachmentController{session: session}
+}
+
+// CreateAttachment attaches the file sent in the "file" field of a
+// multipart/form-data request to the task. The file is streamed to storage
+// rather than parsed into memory.
+func (c *AttachmentController) CreateAttachment(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, models.MaxAttachmentSize+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Expected a multipart/form-data request")
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			respondWithError(w, http.StatusBadRequest, "Missing file field")
+			return
+		} else if err != nil {
+			respondWithUploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		attachment := models.Attachment{
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+		}
+		err = attachment.Create(r.Context(), c.session, task, part, actorFromRequest(r))
+		part.Close()
+		if err != nil {
+			respondWithUploadError(w, err)
+			return
+		}
+
+		respondWithJSON(w, http.StatusCreated, Response{
+			Status: "success",
+			Data:   attachment,
+		})
+		return
+	}
+}
+
+func respondWithUploadError(w http.ResponseWriter, err error) {
+	var maxBytes *http.MaxBytesError
+	switch {
+	case errors.Is(err, models.ErrAttachmentTooLarge), errors.As(err, &maxBytes):
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+	case errors.Is(err, models.ErrInvalidAttachment):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("Failed to store attachment: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to store attachment")
+	}
+}
+
+func (c *AttachmentController) GetAttachments(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	attachments, err := models.GetAttachmentsByTaskID(c.session, task.TaskID)
+	if err != nil {
+		log.Printf("Failed to fetch attachments: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch attachments")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   attachments,
+	})
+}
+
+// DownloadAttachment streams the contents of an attachment, or with
+// ?size=small|medium a thumbnail of an image, answering 202 while it is
+// being generated. Range and conditional requests are supported, with the
+// content hash as the ETag.
+func (c *AttachmentController) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := c.loadAttachment(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	contentType, etag := attachment.ContentType, attachment.SHA256
+	var contents *storage.ReadSeeker
+	var err error
+	if size := r.URL.Query().Get("size"); size != "" {
+		contentType, etag = attachment.ThumbnailContentType(), etag+"-"+size
+		contents, err = attachment.OpenThumbnail(r.Context(), c.session, size)
+	} else {
+		contents, err = attachment.Open(r.Context())
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidThumbnailSize):
+			respondWithError(w, http.StatusBadRequest, "Invalid size, expected small or medium")
+		case errors.Is(err, models.ErrNoThumbnail), errors.Is(err, thumbnail.ErrUnsupported):
+			respondWithError(w, http.StatusNotFound, "No thumbnail available for this attachment")
+		case errors.Is(err, models.ErrThumbnailPending):
+			w.Header().Set("Retry-After", "5")
+			respondWithJSON(w, http.StatusAccepted, Response{
+				Status:  "success",
+				Message: "Thumbnail is being generated",
+			})
+		case errors.Is(err, storage.ErrNotFound):
+			respondWithError(w, http.StatusNotFound, "Attachment contents not found")
+		default:
+			log.Printf("Failed to open attachment: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch attachment")
+		}
+		return
+	}
+	defer contents.Close()
+
+	// Images and PDFs are shown in the browser; anything else is downloaded.
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") || contentType == "application/pdf" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, attachment.FileName, attachment.CreatedAt, contents)
+}
+
+func (c *AttachmentController) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	attachment, ok := c.loadAttachment(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := attachment.Delete(r.Context(), c.session); err != nil {
+		log.Printf("Failed to delete attachment: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete attachment")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Attachment deleted successfully",
+	})
+}
+
+// loadAttachment loads the attachment named by the route of a task in which
+// workspace the authenticated user has at least the given role. On failure
+// it writes the error response and returns false.
+func (c *AttachmentController) loadAttachment(w http.ResponseWriter, r *http.Request, role string) (*models.Attachment, bool) {
+	task, ok := loadTask(c.session, w, r, "id", role)
+	if !ok {
+		return nil, false
+	}
+	attachmentID, err := gocql.ParseUUID(mux.Vars(r)["attachment_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid attachment ID")
+		return nil, false
+	}
+
+	attachment, err := models.GetAttachment(c.session, task.TaskID, attachmentID)
+	if err == gocql.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Attachment not found")
+		return nil, false
+	} else if err != nil {
+		log.Printf("Failed to fetch attachment: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch attachment")
+		return nil, false
+	}
+	return attachment, true
+}
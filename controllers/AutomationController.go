@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+// maxAutomationRuns caps how many entries of a rule's log are listed.
+const maxAutomationRuns = 100
+
+// AutomationController manages the automation rules of a workspace. Members
+// who can edit its tasks manage the rules, which act on their behalf;
+// viewers can see them and try them out.
+type AutomationController struct {
+	session *gocql.Session
+}
+
+func NewAutomationController(session *gocql.Session) *AutomationController {
+	return &AutomationController{session: session}
+}
+
+func (c *AutomationController) CreateAutomation(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	rule := models.AutomationRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	rule.WorkspaceID = workspace.WorkspaceID
+	if err := rule.Create(c.session, actorFromRequest(r)); err != nil {
+		respondWithAutomationError(w, err, "Failed to create automation rule")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   rule,
+	})
+}
+
+func (c *AutomationController) GetAutomations(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	rules, err := models.GetAutomationRules(c.session, workspace.WorkspaceID)
+	if err != nil {
+		respondWithAutomationError(w, err, "Failed to fetch automation rules")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   rules,
+	})
+}
+
+func (c *AutomationController) GetAutomation(w http.ResponseWriter, r *http.Request) {
+	rule, ok := c.loadRule(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   rule,
+	})
+}
+
+// UpdateAutomation changes the fields given of a rule. The rule keeps
+// acting on behalf of the member who created it.
+func (c *AutomationController) UpdateAutomation(w http.ResponseWriter, r *http.Request) {
+	rule, ok := c.loadRule(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name       *string                   `json:"name"`
+		Enabled    *bool                     `json:"enabled"`
+		Trigger    *models.AutomationTrigger `json:"trigger"`
+		Conditions *string                   `json:"conditions"`
+		Actions    []models.AutomationAction `json:"actions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if input.Name != nil {
+		rule.Name = *input.Name
+	}
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+	if input.Trigger != nil {
+		rule.Trigger = *input.Trigger
+	}
+	if input.Conditions != nil {
+		rule.Conditions = *input.Conditions
+	}
+	if input.Actions != nil {
+		rule.Actions = input.Actions
+	}
+	if err := rule.Update(c.session); err != nil {
+		respondWithAutomationError(w, err, "Failed to update automation rule")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   rule,
+	})
+}
+
+func (c *AutomationController) DeleteAutomation(w http.ResponseWriter, r *http.Request) {
+	rule, ok := c.loadRule(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := rule.Delete(c.session); err != nil {
+		respondWithAutomationError(w, err, "Failed to delete automation rule")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Automation rule deleted successfully",
+	})
+}
+
+// GetAutomationRuns lists a rule's latest runs, newest first.
+func (c *AutomationController) GetAutomationRuns(w http.ResponseWriter, r *http.Request) {
+	rule, ok := c.loadRule(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	runs, err := models.GetAutomationRuns(c.session, rule.RuleID, maxAutomationRuns)
+	if err != nil {
+		respondWithAutomationError(w, err, "Failed to fetch automation runs")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   runs,
+	})
+}
+
+// DryRunAutomations shows which rules of the task's workspace would fire for
+// the task, and how they would change it, without applying any. The body may
+// name the trigger that occurred, such as {"trigger": {"type":
+// "status_changed", "status": "done"}}; without it every rule is judged as
+// if its own trigger had occurred.
+func (c *AutomationController) DryRunAutomations(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Trigger *models.AutomationTrigger `json:"trigger"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	results, err := models.DryRunAutomations(c.session, task, input.Trigger)
+	if err != nil {
+		respondWithAutomationError(w, err, "Failed to evaluate automation rules")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   results,
+	})
+}
+
+func (c *AutomationController) loadWorkspace(w http.ResponseWriter, r *http.Request, role string) (*models.Workspace, bool) {
+	workspaceID, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID")
+		return nil, false
+	}
+	return loadWorkspace(c.session, w, r, workspaceID, role)
+}
+
+// loadRule loads the rule named by the route after verifying that the
+// authenticated user has at least the given role in its workspace. On
+// failure it writes the error response and returns false.
+func (c *AutomationController) loadRule(w http.ResponseWriter, r *http.Request, role string) (*models.AutomationRule, bool) {
+	workspace, ok := c.loadWorkspace(w, r, role)
+	if !ok {
+		return nil, false
+	}
+	ruleID, err := gocql.ParseUUID(mux.Vars(r)["rule_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid rule ID")
+		return nil, false
+	}
+
+	rule, err := models.GetAutomationRule(c.session, workspace.WorkspaceID, ruleID)
+	if err != nil {
+		respondWithAutomationError(w, err, "Failed to fetch automation rule")
+		return nil, false
+	}
+	return rule, true
+}
+
+func respondWithAutomationError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidAutomation):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrAutomationNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Printf("%s: %v", message, err)
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
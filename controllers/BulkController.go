@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"todo-app/middleware"
+	"todo-app/models"
+	"todo-app/patch"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	maxBulkOperations = 100
+	bulkConcurrency   = 8
+)
+
+var (
+	errBulkForbidden = errors.New("access denied")
+	errBulkDuplicate = errors.New("task appears in more than one operation")
+	errBulkNotRun    = errors.New("not run because another operation failed")
+)
+
+type BulkController struct {
+	session *gocql.Session
+}
+
+func NewBulkController(session *gocql.Session) *BulkController {
+	return &BulkController{session: session}
+}
+
+// bulkRequest is the body of POST /tasks/bulk. With Atomic set, every
+// operation is validated before any is run and nothing runs unless all are
+// valid; operations then run in order and stop at the first failure. Writes
+// that already succeeded at that point are not undone, as Cassandra cannot
+// roll them back.
+type bulkRequest struct {
+	Atomic     bool            `json:"atomic"`
+	Force      bool            `json:"force"`
+	Operations []bulkOperation `json:"operations"`
+}
+
+// bulkOperation is one create, update, status, move or delete operation.
+// Update takes a merge patch in Task, create a full task.
+type bulkOperation struct {
+	Op         string          `json:"op"`
+	TaskID     *gocql.UUID     `json:"task_id,omitempty"`
+	Version    *int            `json:"version,omitempty"`
+	Task       json.RawMessage `json:"task,omitempty"`
+	Status     string          `json:"status,omitempty"`
+	CategoryID *gocql.UUID     `json:"category_id,omitempty"`
+}
+
+type bulkResult struct {
+	Index  int          `json:"index"`
+	Op     string       `json:"op"`
+	TaskID *gocql.UUID  `json:"task_id,omitempty"`
+	Status int          `json:"status"`
+	Error  string       `json:"error,omitempty"`
+	Task   *models.Task `json:"task,omitempty"`
+}
+
+// bulkStep is a validated operation waiting to run.
+type bulkStep struct {
+	result *bulkResult
+	task   *models.Task
+	status int
+	run    func() error
+}
+
+// BulkTasks runs several task operations in one request and reports a
+// result for each.
+func (c *BulkController) BulkTasks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input bulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if len(input.Operations) == 0 || len(input.Operations) > maxBulkOperations {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Send between 1 and %d operations", maxBulkOperations))
+		return
+	}
+
+	actor := actorFromRequest(r)
+	results := make([]*bulkResult, len(input.Operations))
+	steps := make([]*bulkStep, len(input.Operations))
+	seen := make(map[gocql.UUID]int)
+	valid := true
+	for i, op := range input.Operations {
+		results[i] = &bulkResult{Index: i, Op: op.Op, TaskID: op.TaskID}
+		if op.TaskID != nil {
+			seen[*op.TaskID]++
+		}
+	}
+	for i, op := range input.Operations {
+		var err error
+		if op.TaskID != nil && seen[*op.TaskID] > 1 {
+			err = errBulkDuplicate
+		} else {
+			steps[i], err = c.prepare(userID, op, input.Force, actor)
+		}
+		if err != nil {
+			results[i].fail(err)
+			valid = false
+			continue
+		}
+		steps[i].result = results[i]
+	}
+
+	switch {
+	case input.Atomic && !valid:
+		for i, step := range steps {
+			if step != nil {
+				results[i].Status, results[i].Error = http.StatusFailedDependency, errBulkNotRun.Error()
+			}
+		}
+	case input.Atomic:
+		failed := false
+		for _, step := range steps {
+			if failed {
+				step.result.Status, step.result.Error = http.StatusFailedDependency, errBulkNotRun.Error()
+				continue
+			}
+			failed = !step.execute()
+		}
+	default:
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, bulkConcurrency)
+		for _, step := range steps {
+			if step == nil {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(step *bulkStep) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				step.execute()
+			}(step)
+		}
+		wg.Wait()
+	}
+
+	failures := 0
+	for _, result := range results {
+		if result.Status >= 400 {
+			failures++
+		}
+	}
+	response := Response{Status: "success", Data: results}
+	if failures > 0 {
+		response.Status = "error"
+		response.Message = fmt.Sprintf("%d of %d operations failed", failures, len(results))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// execute runs the step and records its result. It reports whether the
+// operation succeeded.
+func (s *bulkStep) execute() bool {
+	if err := s.run(); err != nil {
+		s.result.fail(err)
+		return false
+	}
+	s.result.Status = s.status
+	s.result.Task = s.task
+	s.result.TaskID = &s.task.TaskID
+	return true
+}
+
+// prepare validates an operation without writing anything.
+func (c *BulkController) prepare(userID gocql.UUID, op bulkOperation, force bool, actor models.Actor) (*bulkStep, error) {
+	if op.Op == "create" {
+		var input models.Task
+		if err := json.Unmarshal(op.Task, &input); err != nil {
+			return nil, fmt.Errorf("%w: %v", models.ErrInvalidTask, err)
+		}
+		task := newTaskFromInput(userID, &input)
+		if err := c.authorize(userID, task.WorkspaceID); err != nil {
+			return nil, err
+		}
+		if err := task.ValidateCreate(c.session); err != nil {
+			return nil, err
+		}
+		return &bulkStep{task: task, status: http.StatusCreated, run: func() error {
+			return task.Create(c.session, actor)
+		}}, nil
+	}
+
+	if op.TaskID == nil {
+		return nil, fmt.Errorf("%w: task_id is required", models.ErrInvalidTask)
+	}
+	existing, err := models.GetTaskByID(c.session, *op.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.authorize(userID, existing.WorkspaceID); err != nil {
+		return nil, err
+	}
+	copied := *existing
+	task := &copied
+	if op.Version != nil {
+		task.Version = *op.Version
+	}
+
+	switch op.Op {
+	case "delete":
+		if task.Version != existing.Version {
+			return nil, models.ErrVersionMismatch
+		}
+		return &bulkStep{task: task, status: http.StatusOK, run: func() error {
+			return task.Trash(c.session, actor)
+		}}, nil
+	case "update":
+		doc, err := json.Marshal(existing)
+		if err != nil {
+			return nil, err
+		}
+		patched, err := patch.MergePatch(doc, op.Task)
+		if err != nil {
+			return nil, err
+		}
+		version := task.Version
+		if task, err = models.PatchedTask(existing, patched); err != nil {
+			return nil, err
+		}
+		task.Version = version
+	case "status":
+		task.Status = op.Status
+	case "move":
+		task.CategoryID = op.CategoryID
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", models.ErrInvalidTask, op.Op)
+	}
+
+	if err := task.ValidateUpdate(c.session, force); err != nil {
+		return nil, err
+	}
+	update := task.Update
+	if force {
+		update = task.ForceUpdate
+	}
+	return &bulkStep{task: task, status: http.StatusOK, run: func() error {
+		return update(c.session, actor)
+	}}, nil
+}
+
+// authorize checks that the user may change tasks in the workspace.
+func (c *BulkController) authorize(userID, workspaceID gocql.UUID) error {
+	role, err := models.GetWorkspaceRole(c.session, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if !models.HasRole(role, models.RoleEditor) {
+		return errBulkForbidden
+	}
+	return nil
+}
+
+// fail records the error of a failed operation.
+func (r *bulkResult) fail(err error) {
+	r.Status, r.Error = bulkErrorStatus(err), err.Error()
+	if r.Status == http.StatusInternalServerError {
+		log.Printf("Bulk %s failed: %v", r.Op, err)
+		r.Error = "Internal error"
+	}
+}
+
+func bulkErrorStatus(err error) int {
+	switch {
+	case err == gocql.ErrNotFound:
+		return http.StatusNotFound
+	case errors.Is(err, errBulkForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, models.ErrTaskBlocked), errors.Is(err, errBulkDuplicate), errors.Is(err, patch.ErrTestFailed):
+		return http.StatusConflict
+	case isTaskValidationError(err), errors.Is(err, models.ErrReadOnlyField), errors.Is(err, patch.ErrInvalidPatch):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
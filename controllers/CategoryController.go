@@ -2,6 +2,8 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"todo-app/models"
 
@@ -17,18 +19,31 @@ func NewCategoryController(session *gocql.Session) *CategoryController {
 	return &CategoryController{session: session}
 }
 
+// CreateCategory creates a category in a workspace, by default the user's
+// personal one.
 func (c *CategoryController) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
 	var category models.Category
 	if err := json.NewDecoder(r.Body).Decode(&category); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	category.WorkspaceID = workspaceID
 
 	if err := category.Create(c.session); err != nil {
+		if errors.Is(err, models.ErrInvalidCategory) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	setETag(w, category.Version)
 	respondWithJSON(w, http.StatusCreated, Response{
 		Status: "success",
 		Data:   category,
@@ -36,27 +51,27 @@ func (c *CategoryController) CreateCategory(w http.ResponseWriter, r *http.Reque
 }
 
 func (c *CategoryController) GetCategory(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := gocql.ParseUUID(params["id"])
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid category ID")
-		return
-	}
-
-	category, err := models.GetCategoryByID(c.session, id)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Category not found")
+	category, ok := loadCategory(c.session, w, r, models.RoleViewer)
+	if !ok {
 		return
 	}
 
+	setETag(w, category.Version)
 	respondWithJSON(w, http.StatusOK, Response{
 		Status: "success",
 		Data:   category,
 	})
 }
 
+// GetAllCategories lists the categories of a workspace, by default the
+// user's personal one.
 func (c *CategoryController) GetAllCategories(w http.ResponseWriter, r *http.Request) {
-	categories, err := models.GetAllCategories(c.session)
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	categories, err := models.GetCategories(c.session, workspaceID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to fetch categories")
 		return
@@ -69,10 +84,8 @@ func (c *CategoryController) GetAllCategories(w http.ResponseWriter, r *http.Req
 }
 
 func (c *CategoryController) UpdateCategory(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := gocql.ParseUUID(params["id"])
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid category ID")
+	existing, ok := loadCategory(c.session, w, r, models.RoleEditor)
+	if !ok {
 		return
 	}
 
@@ -82,12 +95,50 @@ func (c *CategoryController) UpdateCategory(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	category.CategoryID = id
+	category.CategoryID = existing.CategoryID
+	category.WorkspaceID = existing.WorkspaceID
+	category.CreatedAt = existing.CreatedAt
+	category.Version = expectedVersion(r, existing.Version)
+	c.saveCategory(w, &category)
+}
+
+// PatchCategory applies a JSON Merge Patch or JSON Patch to a category.
+func (c *CategoryController) PatchCategory(w http.ResponseWriter, r *http.Request) {
+	existing, ok := loadCategory(c.session, w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	doc, ok := applyPatch(w, r, existing)
+	if !ok {
+		return
+	}
+	category, err := models.PatchedCategory(existing, doc)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	category.Version = expectedVersion(r, existing.Version)
+	c.saveCategory(w, category)
+}
+
+// saveCategory updates the category and writes the response.
+func (c *CategoryController) saveCategory(w http.ResponseWriter, category *models.Category) {
 	if err := category.Update(c.session); err != nil {
+		if errors.Is(err, models.ErrVersionMismatch) {
+			respondWithError(w, http.StatusPreconditionFailed, "Category was modified by someone else; reload and try again")
+			return
+		}
+		if errors.Is(err, models.ErrInvalidCategory) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Failed to update category")
 		return
 	}
 
+	setETag(w, category.Version)
 	respondWithJSON(w, http.StatusOK, Response{
 		Status:  "success",
 		Message: "Category updated successfully",
@@ -96,20 +147,81 @@ func (c *CategoryController) UpdateCategory(w http.ResponseWriter, r *http.Reque
 }
 
 func (c *CategoryController) DeleteCategory(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := gocql.ParseUUID(params["id"])
+	category, ok := loadCategory(c.session, w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	category.Version = expectedVersion(r, category.Version)
+	if err := category.Trash(c.session); err != nil {
+		if errors.Is(err, models.ErrVersionMismatch) {
+			respondWithError(w, http.StatusPreconditionFailed, "Category was modified by someone else; reload and try again")
+			return
+		}
+		log.Printf("Failed to delete category: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete category")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Category moved to trash",
+	})
+}
+
+// RestoreCategory takes a category out of the trash of a workspace, by
+// default the user's personal one.
+func (c *CategoryController) RestoreCategory(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+	id, err := gocql.ParseUUID(mux.Vars(r)["id"])
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid category ID")
 		return
 	}
 
-	if err := models.DeleteCategoryByID(c.session, id); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete category")
+	category, err := models.RestoreCategory(c.session, workspaceID, id)
+	if err == gocql.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Category not found in trash")
+		return
+	} else if err != nil {
+		log.Printf("Failed to restore category: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to restore category")
 		return
 	}
 
+	setETag(w, category.Version)
 	respondWithJSON(w, http.StatusOK, Response{
 		Status:  "success",
-		Message: "Category deleted successfully",
+		Message: "Category restored successfully",
+		Data:    category,
 	})
 }
+
+// loadCategory returns the category named by the id URL parameter after
+// verifying that the authenticated user has at least the given role in its
+// workspace. On failure it writes the error response and returns false.
+func loadCategory(session *gocql.Session, w http.ResponseWriter, r *http.Request, role string) (*models.Category, bool) {
+	id, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid category ID")
+		return nil, false
+	}
+
+	category, err := models.GetCategoryByID(session, id)
+	if err == gocql.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Category not found")
+		return nil, false
+	} else if err != nil {
+		log.Printf("Failed to fetch category: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch category")
+		return nil, false
+	}
+
+	if !authorizeWorkspace(session, w, r, category.WorkspaceID, role) {
+		return nil, false
+	}
+	return category, true
+}
@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"todo-app/middleware"
+	"todo-app/models"
+	"todo-app/realtime"
+
+	"github.com/gocql/gocql"
+)
+
+type CollabController struct {
+	session *gocql.Session
+	hub     *realtime.Hub
+}
+
+func NewCollabController(session *gocql.Session, store realtime.PresenceStore) *CollabController {
+	c := &CollabController{session: session}
+	c.hub = realtime.NewHub(store, c.authorize)
+	return c
+}
+
+// Connect opens the collaboration WebSocket, over which clients subscribe
+// to workspaces and tasks and see who else is viewing or editing them.
+func (c *CollabController) Connect(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	c.hub.Serve(w, r, userID)
+}
+
+// authorize lets members of a workspace follow it and its tasks. Editors
+// may also signal that they are editing a task.
+func (c *CollabController) authorize(ctx context.Context, userID gocql.UUID, topic realtime.Topic) (bool, error) {
+	workspaceID := topic.ID
+	if topic.Kind == realtime.TopicTask {
+		task, err := models.GetTaskByID(c.session, topic.ID)
+		if err == gocql.ErrNotFound {
+			return false, realtime.ErrForbidden
+		} else if err != nil {
+			return false, err
+		}
+		workspaceID = task.WorkspaceID
+	}
+
+	role, err := models.GetWorkspaceRole(c.session, workspaceID, userID)
+	if err != nil {
+		return false, err
+	}
+	if role == "" {
+		return false, realtime.ErrForbidden
+	}
+	return models.HasRole(role, models.RoleEditor), nil
+}
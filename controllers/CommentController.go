@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+type CommentController struct {
+	session *gocql.Session
+}
+
+func NewCommentController(session *gocql.Session) *CommentController {
+	return &CommentController{session: session}
+}
+
+func (c *CommentController) CreateComment(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body     string      `json:"body"`
+		ParentID *gocql.UUID `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	comment := models.Comment{Body: input.Body, ParentID: input.ParentID}
+	if err := comment.Create(c.session, task, actorFromRequest(r)); err != nil {
+		respondWithCommentError(w, err, "Failed to create comment")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   comment,
+	})
+}
+
+// GetComments returns the task's comment threads.
+func (c *CommentController) GetComments(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	threads, err := models.GetCommentThreads(c.session, task.TaskID)
+	if err != nil {
+		log.Printf("Failed to fetch comments: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch comments")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   threads,
+	})
+}
+
+func (c *CommentController) UpdateComment(w http.ResponseWriter, r *http.Request) {
+	task, comment, ok := c.loadComment(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := comment.Edit(c.session, task, input.Body, actorFromRequest(r)); err != nil {
+		respondWithCommentError(w, err, "Failed to update comment")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Comment updated successfully",
+		Data:    comment,
+	})
+}
+
+func (c *CommentController) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	task, comment, ok := c.loadComment(w, r)
+	if !ok {
+		return
+	}
+
+	if err := comment.Delete(c.session, task, actorFromRequest(r)); err != nil {
+		respondWithCommentError(w, err, "Failed to delete comment")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Comment deleted successfully",
+	})
+}
+
+// loadComment loads the comment named by the route on a task the
+// authenticated user can access. On failure it writes the error response and
+// returns false.
+func (c *CommentController) loadComment(w http.ResponseWriter, r *http.Request) (*models.Task, *models.Comment, bool) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
+		return nil, nil, false
+	}
+	commentID, err := gocql.ParseUUID(mux.Vars(r)["comment_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment ID")
+		return nil, nil, false
+	}
+
+	comment, err := models.GetComment(c.session, task.TaskID, commentID)
+	if err == gocql.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Comment not found")
+		return nil, nil, false
+	} else if err != nil {
+		log.Printf("Failed to fetch comment: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch comment")
+		return nil, nil, false
+	}
+	return task, comment, true
+}
+
+func respondWithCommentError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidComment):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrNotCommentAuthor), errors.Is(err, models.ErrCommentEditWindow):
+		respondWithError(w, http.StatusForbidden, err.Error())
+	default:
+		log.Printf("%s: %v", message, err)
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
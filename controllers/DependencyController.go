@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+type DependencyController struct {
+	session *gocql.Session
+}
+
+func NewDependencyController(session *gocql.Session) *DependencyController {
+	return &DependencyController{session: session}
+}
+
+func (c *DependencyController) AddDependency(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		BlockerID gocql.UUID `json:"blocker_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	blocker, err := models.GetTaskByID(c.session, input.BlockerID)
+	if err != nil || blocker.WorkspaceID != task.WorkspaceID {
+		respondWithError(w, http.StatusNotFound, "Blocker task not found")
+		return
+	}
+
+	dep, err := models.AddDependency(c.session, task, blocker)
+	if err != nil {
+		if errors.Is(err, models.ErrDependencyCycle) || errors.Is(err, models.ErrSelfDependency) {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		log.Printf("Failed to add dependency: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to add dependency")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   dep,
+	})
+}
+
+func (c *DependencyController) DeleteDependency(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	blockerID, err := gocql.ParseUUID(mux.Vars(r)["blocker_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid blocker ID")
+		return
+	}
+
+	if err := models.DeleteDependency(c.session, task.WorkspaceID, task.TaskID, blockerID); err != nil {
+		log.Printf("Failed to delete dependency: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete dependency")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Dependency deleted successfully",
+	})
+}
+
+// GetGraph returns the dependency graph of a workspace, by default the
+// user's personal one.
+func (c *DependencyController) GetGraph(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	graph, err := models.GetDependencyGraph(c.session, workspaceID)
+	if err != nil {
+		log.Printf("Failed to build dependency graph: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch dependency graph")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   graph,
+	})
+}
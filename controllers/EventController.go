@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"todo-app/middleware"
+	"todo-app/models"
+	"todo-app/realtime"
+
+	"github.com/gocql/gocql"
+)
+
+// streamHeartbeat is how often an idle event stream sends a comment, which
+// keeps proxies from closing it, and refreshes the user's workspaces.
+const streamHeartbeat = 25 * time.Second
+
+type EventController struct {
+	session *gocql.Session
+	broker  realtime.Broker
+}
+
+func NewEventController(session *gocql.Session, broker realtime.Broker) *EventController {
+	return &EventController{session: session, broker: broker}
+}
+
+// StreamEvents pushes the changes to tasks and categories in the user's
+// workspaces as Server-Sent Events. A client reconnecting with the
+// Last-Event-ID header, or ?last_event_id, first receives the events it
+// missed. When those are no longer logged it receives a "reset" event and
+// should reload instead.
+func (c *EventController) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok || c.broker == nil {
+		respondWithError(w, http.StatusNotImplemented, "Event streaming is not supported")
+		return
+	}
+
+	// Subscribing before reading the log ensures nothing published in
+	// between is missed; events seen in both are sent once.
+	events, unsubscribe := c.broker.Subscribe()
+	defer unsubscribe()
+
+	scopes, err := c.scopes(userID)
+	if err != nil {
+		log.Printf("Failed to fetch workspaces for event stream: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to open event stream")
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	var missed []realtime.Event
+	reset := false
+	if lastEventID != "" {
+		after, err := gocql.ParseUUID(lastEventID)
+		if err != nil || time.Since(after.Time()) > models.EventLogRetention {
+			reset = true
+		} else if missed, err = models.GetEventsSince(c.session, scopeList(scopes), after); err != nil {
+			log.Printf("Failed to read event log: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to open event stream")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if reset {
+		fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+	}
+	sent := make(map[gocql.UUID]bool, len(missed))
+	for _, e := range missed {
+		writeEvent(w, e)
+		sent[e.ID] = true
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client reconnects and
+				// catches up from the log.
+				return
+			}
+			if !scopes[e.Scope] || sent[e.ID] {
+				continue
+			}
+			writeEvent(w, e)
+			flusher.Flush()
+		case <-heartbeat.C:
+			if refreshed, err := c.scopes(userID); err == nil {
+				scopes = refreshed
+			} else {
+				log.Printf("Failed to refresh workspaces for event stream: %v", err)
+			}
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func (c *EventController) scopes(userID gocql.UUID) (map[gocql.UUID]bool, error) {
+	list, err := models.GetStreamScopes(c.session, userID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := make(map[gocql.UUID]bool, len(list))
+	for _, scope := range list {
+		scopes[scope] = true
+	}
+	return scopes, nil
+}
+
+func scopeList(scopes map[gocql.UUID]bool) []gocql.UUID {
+	list := make([]gocql.UUID, 0, len(scopes))
+	for scope := range scopes {
+		list = append(list, scope)
+	}
+	return list
+}
+
+func writeEvent(w http.ResponseWriter, e realtime.Event) {
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+}
@@ -0,0 +1,75 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"todo-app/middleware"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+type NotificationController struct {
+	session *gocql.Session
+}
+
+func NewNotificationController(session *gocql.Session) *NotificationController {
+	return &NotificationController{session: session}
+}
+
+func (c *NotificationController) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 200 {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	notifications, err := models.GetNotificationsByUserID(c.session, userID, limit)
+	if err != nil {
+		log.Printf("Failed to fetch notifications: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch notifications")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   notifications,
+	})
+}
+
+func (c *NotificationController) MarkRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid notification ID")
+		return
+	}
+
+	if err := models.MarkNotificationRead(c.session, userID, id); err != nil {
+		log.Printf("Failed to mark notification read: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update notification")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Notification marked as read",
+	})
+}
@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+type ProjectController struct {
+	session *gocql.Session
+}
+
+func NewProjectController(session *gocql.Session) *ProjectController {
+	return &ProjectController{session: session}
+}
+
+func (c *ProjectController) CreateProject(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var project models.Project
+	if err := json.NewDecoder(r.Body).Decode(&project); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	project.WorkspaceID = workspace.WorkspaceID
+	if err := project.Create(c.session); err != nil {
+		respondWithProjectError(w, err, "Failed to create project")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   project,
+	})
+}
+
+func (c *ProjectController) GetProjects(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	projects, err := models.GetProjects(c.session, workspace.WorkspaceID)
+	if err != nil {
+		respondWithProjectError(w, err, "Failed to fetch projects")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   projects,
+	})
+}
+
+func (c *ProjectController) GetProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := c.loadProject(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   project,
+	})
+}
+
+func (c *ProjectController) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := c.loadProject(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	project.Name = input.Name
+	project.Description = input.Description
+	if err := project.Update(c.session); err != nil {
+		respondWithProjectError(w, err, "Failed to update project")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Project updated successfully",
+		Data:    project,
+	})
+}
+
+// DeleteProject deletes a project that no tasks belong to anymore.
+func (c *ProjectController) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	project, ok := c.loadProject(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := project.Delete(c.session); err != nil {
+		respondWithProjectError(w, err, "Failed to delete project")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Project deleted successfully",
+	})
+}
+
+func (c *ProjectController) loadWorkspace(w http.ResponseWriter, r *http.Request, role string) (*models.Workspace, bool) {
+	workspaceID, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID")
+		return nil, false
+	}
+	return loadWorkspace(c.session, w, r, workspaceID, role)
+}
+
+// loadProject loads the project named by the route after verifying that the
+// authenticated user has at least the given role in its workspace. On
+// failure it writes the error response and returns false.
+func (c *ProjectController) loadProject(w http.ResponseWriter, r *http.Request, role string) (*models.Project, bool) {
+	workspace, ok := c.loadWorkspace(w, r, role)
+	if !ok {
+		return nil, false
+	}
+	projectID, err := gocql.ParseUUID(mux.Vars(r)["project_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid project ID")
+		return nil, false
+	}
+
+	project, err := models.GetProject(c.session, workspace.WorkspaceID, projectID)
+	if err != nil {
+		respondWithProjectError(w, err, "Failed to fetch project")
+		return nil, false
+	}
+	return project, true
+}
+
+func respondWithProjectError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidProject):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrProjectNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, models.ErrProjectNotEmpty):
+		respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		log.Printf("%s: %v", message, err)
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
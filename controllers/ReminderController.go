@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+type ReminderController struct {
+	session *gocql.Session
+}
+
+func NewReminderController(session *gocql.Session) *ReminderController {
+	return &ReminderController{session: session}
+}
+
+func (c *ReminderController) CreateReminder(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var reminder models.Reminder
+	if err := json.NewDecoder(r.Body).Decode(&reminder); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := reminder.Create(c.session, task, actorFromRequest(r)); err != nil {
+		if errors.Is(err, models.ErrInvalidReminder) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Failed to create reminder: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to create reminder")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   reminder,
+	})
+}
+
+func (c *ReminderController) GetReminders(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	reminders, err := models.GetRemindersByTaskID(c.session, task.TaskID)
+	if err != nil {
+		log.Printf("Failed to fetch reminders: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch reminders")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   reminders,
+	})
+}
+
+func (c *ReminderController) DeleteReminder(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	reminderID, err := gocql.ParseUUID(mux.Vars(r)["reminder_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid reminder ID")
+		return
+	}
+
+	reminder, err := models.GetReminderByID(c.session, reminderID)
+	if err != nil || reminder.TaskID != task.TaskID {
+		respondWithError(w, http.StatusNotFound, "Reminder not found")
+		return
+	}
+
+	if err := reminder.Delete(c.session); err != nil {
+		log.Printf("Failed to delete reminder: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete reminder")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Reminder deleted successfully",
+	})
+}
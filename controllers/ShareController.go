@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+// maxShareAccesses caps how many entries of a link's audit trail are listed.
+const maxShareAccesses = 500
+
+type ShareController struct {
+	session   *gocql.Session
+	templates *template.Template
+}
+
+func NewShareController(session *gocql.Session, templates *template.Template) *ShareController {
+	return &ShareController{session: session, templates: templates}
+}
+
+// CreateShareLink creates a public link to a task, a category or a saved
+// filter of the workspace. The token is only returned here.
+func (c *ShareController) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var link models.ShareLink
+	if err := json.NewDecoder(r.Body).Decode(&link); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	link.WorkspaceID = workspace.WorkspaceID
+	if err := link.Create(c.session, actorFromRequest(r)); err != nil {
+		respondWithShareError(w, err, "Failed to create share link")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   link,
+	})
+}
+
+// GetShareLinks lists the workspace's links, including revoked and expired
+// ones.
+func (c *ShareController) GetShareLinks(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	links, err := models.GetShareLinks(c.session, workspace.WorkspaceID)
+	if err != nil {
+		respondWithShareError(w, err, "Failed to fetch share links")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   links,
+	})
+}
+
+func (c *ShareController) GetShareLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := c.loadShareLink(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   link,
+	})
+}
+
+// GetShareAccesses lists the most recent uses of a link.
+func (c *ShareController) GetShareAccesses(w http.ResponseWriter, r *http.Request) {
+	link, ok := c.loadShareLink(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	accesses, err := models.GetShareAccesses(c.session, link.LinkID, maxShareAccesses)
+	if err != nil {
+		respondWithShareError(w, err, "Failed to fetch share link uses")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   accesses,
+	})
+}
+
+// RevokeShareLink stops a link from working.
+func (c *ShareController) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := c.loadShareLink(w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := link.Revoke(c.session); err != nil {
+		respondWithShareError(w, err, "Failed to revoke share link")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Share link revoked",
+		Data:    link,
+	})
+}
+
+// ViewShared serves the read-only JSON view of a link. Links with a password
+// take it in the X-Share-Password header.
+func (c *ShareController) ViewShared(w http.ResponseWriter, r *http.Request) {
+	link, ok := c.openShareLink(w, r, r.Header.Get("X-Share-Password"))
+	if !ok {
+		return
+	}
+
+	view, err := link.View(c.session)
+	if err != nil {
+		respondWithShareError(w, err, "Failed to load shared tasks")
+		return
+	}
+	c.recordAccess(r, link, models.ShareAccessViewed)
+
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   view,
+	})
+}
+
+// sharePage is the data of the share.html template.
+type sharePage struct {
+	View          *models.SharedView
+	NeedsPassword bool
+	Error         string
+}
+
+// ViewSharedPage serves the HTML page of a link. Links with a password show
+// a form that posts it back to the same address.
+func (c *ShareController) ViewSharedPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	link, err := models.ResolveShareLink(c.session, mux.Vars(r)["token"])
+	if err != nil {
+		if !errors.Is(err, models.ErrShareLinkNotFound) {
+			log.Printf("Failed to resolve share link: %v", err)
+		}
+		c.renderSharePage(w, http.StatusNotFound, sharePage{Error: "This link does not exist, has expired or was revoked."})
+		return
+	}
+
+	if link.Protected {
+		if r.Method != http.MethodPost {
+			c.renderSharePage(w, http.StatusOK, sharePage{NeedsPassword: true})
+			return
+		}
+		if !link.CheckPassword(r.FormValue("password")) {
+			c.recordAccess(r, link, models.ShareAccessDenied)
+			c.renderSharePage(w, http.StatusUnauthorized, sharePage{NeedsPassword: true, Error: "Wrong password."})
+			return
+		}
+	}
+
+	view, err := link.View(c.session)
+	if err != nil {
+		if !errors.Is(err, models.ErrShareLinkNotFound) {
+			log.Printf("Failed to load shared tasks: %v", err)
+			c.renderSharePage(w, http.StatusInternalServerError, sharePage{Error: "The shared tasks could not be loaded."})
+			return
+		}
+		c.renderSharePage(w, http.StatusNotFound, sharePage{Error: "The shared task no longer exists."})
+		return
+	}
+	c.recordAccess(r, link, models.ShareAccessViewed)
+	c.renderSharePage(w, http.StatusOK, sharePage{View: view})
+}
+
+func (c *ShareController) renderSharePage(w http.ResponseWriter, code int, page sharePage) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	if err := c.templates.ExecuteTemplate(w, "share.html", page); err != nil {
+		log.Printf("Failed to render share page: %v", err)
+	}
+}
+
+// openShareLink resolves the link named by the {token} route variable and
+// checks its password, recording wrong ones. On failure it writes the error
+// response and returns false.
+func (c *ShareController) openShareLink(w http.ResponseWriter, r *http.Request, password string) (*models.ShareLink, bool) {
+	link, err := models.ResolveShareLink(c.session, mux.Vars(r)["token"])
+	if err != nil {
+		respondWithShareError(w, err, "Failed to resolve share link")
+		return nil, false
+	}
+	if !link.CheckPassword(password) {
+		if password != "" {
+			c.recordAccess(r, link, models.ShareAccessDenied)
+		}
+		respondWithError(w, http.StatusUnauthorized, "This link requires a valid password")
+		return nil, false
+	}
+	return link, true
+}
+
+// recordAccess adds the request to the link's audit trail. Failures are
+// logged; the request is served either way.
+func (c *ShareController) recordAccess(r *http.Request, link *models.ShareLink, outcome string) {
+	if err := link.RecordAccess(c.session, outcome, clientAddr(r), r.UserAgent()); err != nil {
+		log.Printf("Failed to record use of share link %s: %v", link.LinkID, err)
+	}
+}
+
+// clientAddr returns the address of the client, as reported by a proxy in
+// front of the server if there is one.
+func clientAddr(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+func (c *ShareController) loadWorkspace(w http.ResponseWriter, r *http.Request, role string) (*models.Workspace, bool) {
+	workspaceID, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID")
+		return nil, false
+	}
+	return loadWorkspace(c.session, w, r, workspaceID, role)
+}
+
+// loadShareLink loads the link named by the route after verifying that the
+// authenticated user has at least the given role in its workspace. On
+// failure it writes the error response and returns false.
+func (c *ShareController) loadShareLink(w http.ResponseWriter, r *http.Request, role string) (*models.ShareLink, bool) {
+	workspace, ok := c.loadWorkspace(w, r, role)
+	if !ok {
+		return nil, false
+	}
+	linkID, err := gocql.ParseUUID(mux.Vars(r)["link_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share link ID")
+		return nil, false
+	}
+
+	link, err := models.GetShareLink(c.session, workspace.WorkspaceID, linkID)
+	if err != nil {
+		respondWithShareError(w, err, "Failed to fetch share link")
+		return nil, false
+	}
+	return link, true
+}
+
+func respondWithShareError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidShareLink), errors.Is(err, models.ErrInvalidStatus),
+		errors.Is(err, models.ErrInvalidTag), errors.Is(err, models.ErrProjectNotFound),
+		errors.Is(err, models.ErrCategoryNotFound):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrShareLinkNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Printf("%s: %v", message, err)
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
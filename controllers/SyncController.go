@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+	"todo-app/middleware"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// maxPushedChanges caps how many changes one sync request may apply.
+const maxPushedChanges = 500
+
+var errSyncForbidden = errors.New("access denied")
+
+type SyncController struct {
+	session *gocql.Session
+}
+
+func NewSyncController(session *gocql.Session) *SyncController {
+	return &SyncController{session: session}
+}
+
+// GetChanges returns what changed among the user's tasks, categories and
+// tags since the ?since token, deletions included, along with the token to
+// pass next time. Without a token, or with one too old, it returns a
+// snapshot flagged with reset.
+func (c *SyncController) GetChanges(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	delta, err := models.GetSyncDelta(c.session, userID, r.URL.Query().Get("since"))
+	if errors.Is(err, models.ErrInvalidSyncToken) {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		log.Printf("Failed to fetch changes to sync: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch changes")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   delta,
+	})
+}
+
+// syncRequest is the body of POST /sync. Since is the token of the client's
+// last sync, which tells concurrent edits the client overwrote apart from
+// edits it had already seen.
+type syncRequest struct {
+	Since   string              `json:"since,omitempty"`
+	Changes []models.SyncChange `json:"changes"`
+}
+
+type syncResult struct {
+	Index     int                   `json:"index"`
+	Kind      string                `json:"kind"`
+	ID        gocql.UUID            `json:"id"`
+	Status    int                   `json:"status"`
+	Error     string                `json:"error,omitempty"`
+	Conflicts []models.SyncConflict `json:"conflicts,omitempty"`
+}
+
+// PushChanges applies changes a client made offline, in order, resolving
+// conflicting edits field by field, and reports a result with the
+// conflicts resolved for each change.
+func (c *SyncController) PushChanges(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input syncRequest
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if len(input.Changes) == 0 || len(input.Changes) > maxPushedChanges {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Send between 1 and %d changes", maxPushedChanges))
+		return
+	}
+	var since time.Time
+	if input.Since != "" {
+		token, err := models.ParseSyncToken(input.Since)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		since = token.Time()
+	}
+
+	authorize := func(workspaceID gocql.UUID) error {
+		role, err := models.GetWorkspaceRole(c.session, workspaceID, userID)
+		if err != nil {
+			return err
+		}
+		if !models.HasRole(role, models.RoleEditor) {
+			return errSyncForbidden
+		}
+		return nil
+	}
+
+	actor := actorFromRequest(r)
+	results := make([]syncResult, len(input.Changes))
+	failures := 0
+	for i := range input.Changes {
+		change := &input.Changes[i]
+		results[i] = syncResult{Index: i, Kind: change.Kind, ID: change.ID, Status: http.StatusOK}
+		conflicts, err := models.ApplySyncChange(c.session, change, since, actor, authorize)
+		if err != nil {
+			results[i].fail(err)
+			failures++
+			continue
+		}
+		results[i].Conflicts = conflicts
+	}
+
+	response := Response{Status: "success", Data: results}
+	if failures > 0 {
+		response.Status = "error"
+		response.Message = fmt.Sprintf("%d of %d changes failed", failures, len(results))
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+// fail records the error of a change that could not be applied.
+func (r *syncResult) fail(err error) {
+	r.Status, r.Error = syncErrorStatus(err), err.Error()
+	if r.Status == http.StatusInternalServerError {
+		log.Printf("Sync of %s %s failed: %v", r.Kind, r.ID, err)
+		r.Error = "Internal error"
+	}
+}
+
+func syncErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSyncForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrInvalidSyncChange), errors.Is(err, models.ErrInvalidCategory):
+		return http.StatusBadRequest
+	default:
+		return bulkErrorStatus(err)
+	}
+}
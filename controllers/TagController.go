@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+// Default and maximum number of suggestions returned by AutocompleteTags.
+const (
+	defaultTagSuggestions = 10
+	maxTagSuggestions     = 50
+)
+
+type TagController struct {
+	session *gocql.Session
+}
+
+func NewTagController(session *gocql.Session) *TagController {
+	return &TagController{session: session}
+}
+
+// GetTags lists the workspace's tags with the number of tasks carrying each.
+func (c *TagController) GetTags(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	tags, err := models.GetTags(c.session, workspaceID)
+	if err != nil {
+		log.Printf("Failed to fetch tags: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch tags")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   tags,
+	})
+}
+
+// AutocompleteTags suggests the workspace's tags starting with ?prefix=, up
+// to ?limit= of them.
+func (c *TagController) AutocompleteTags(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	limit := defaultTagSuggestions
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTagSuggestions {
+			respondWithError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	tags, err := models.AutocompleteTags(c.session, workspaceID, r.URL.Query().Get("prefix"), limit)
+	if err != nil {
+		log.Printf("Failed to autocomplete tags: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch tags")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   tags,
+	})
+}
+
+// GetTasksByTag lists the workspace's tasks carrying the tag.
+func (c *TagController) GetTasksByTag(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	tasks, err := models.GetTasksByTag(c.session, workspaceID, mux.Vars(r)["tag"])
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidTag) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Failed to fetch tasks by tag: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch tasks")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data: map[string]interface{}{
+			"tasks": tasks,
+		},
+	})
+}
+
+// RenameTag renames a tag on all of the workspace's tasks. Renaming to a tag
+// that is already in use merges the two.
+func (c *TagController) RenameTag(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	renamed, err := models.RenameTag(c.session, workspaceID, mux.Vars(r)["tag"], input.Name, actorFromRequest(r))
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidTag) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Failed to rename tag: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to rename tag")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Tag renamed successfully",
+		Data: map[string]interface{}{
+			"tasks_updated": renamed,
+		},
+	})
+}
@@ -2,8 +2,11 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
+	"todo-app/middleware"
 	"todo-app/models"
 
 	"github.com/gocql/gocql"
@@ -19,17 +22,32 @@ func NewTaskController(session *gocql.Session) *TaskController {
 }
 
 func (c *TaskController) CreateTask(w http.ResponseWriter, r *http.Request) {
-	var task models.Task
-	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input models.Task
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	if err := task.Create(c.session); err != nil {
+	task := newTaskFromInput(userID, &input)
+	if !authorizeWorkspace(c.session, w, r, task.WorkspaceID, models.RoleEditor) {
+		return
+	}
+	if err := task.Create(c.session, actorFromRequest(r)); err != nil {
+		if isTaskValidationError(err) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	setETag(w, task.Version)
 	respondWithJSON(w, http.StatusCreated, Response{
 		Status: "success",
 		Data:   task,
@@ -37,55 +55,104 @@ func (c *TaskController) CreateTask(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *TaskController) GetTask(w http.ResponseWriter, r *http.Request) {
-	// Get user_id from context
-	userID, ok := r.Context().Value("user_id").(gocql.UUID)
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
 	if !ok {
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	// Get task_id from URL params
-	params := mux.Vars(r)
-	taskID, err := gocql.ParseUUID(params["id"])
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid task ID")
+	if err := task.LoadProgress(c.session); err != nil {
+		log.Printf("Failed to load task progress: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch task")
+		return
+	}
+	if err := task.LoadBlocked(c.session); err != nil {
+		log.Printf("Failed to load task blockers: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch task")
 		return
 	}
 
-	// Get task and verify ownership
-	task, err := models.GetTasksByUserID(c.session, taskID)
+	setETag(w, task.Version)
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   task,
+	})
+}
+
+// GetAllTasks lists the tasks of a workspace, by default the user's
+// personal one. ?project_id=<id> narrows the list to one project and
+// ?q=<filter> to the tasks matching a filter expression.
+func (c *TaskController) GetAllTasks(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	var projectID *gocql.UUID
+	if value := r.URL.Query().Get("project_id"); value != "" {
+		id, err := gocql.ParseUUID(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid project ID")
+			return
+		}
+		projectID = &id
+	}
+	filter, err := models.ParseTaskFilter(r.URL.Query().Get("q"))
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Task not found")
+		respondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Verify tasks belong to user
-	for _, t := range task {
-		if t.UserID != userID {
-			respondWithError(w, http.StatusForbidden, "Access denied")
+	tasks, err := models.GetTasksByWorkspaceID(c.session, workspaceID)
+	if err != nil {
+		log.Printf("Error fetching tasks: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch tasks")
+		return
+	}
+	if projectID != nil {
+		inProject := []*models.Task{}
+		for _, task := range tasks {
+			if task.ProjectID != nil && *task.ProjectID == *projectID {
+				inProject = append(inProject, task)
+			}
+		}
+		tasks = inProject
+	}
+	if filter.String() != "" {
+		workflow, err := models.GetWorkflow(c.session, workspaceID)
+		if err != nil {
+			log.Printf("Error fetching workflow: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch tasks")
 			return
 		}
+		now := time.Now()
+		matching := []*models.Task{}
+		for _, task := range tasks {
+			if filter.Matches(task, workflow, now) {
+				matching = append(matching, task)
+			}
+		}
+		tasks = matching
 	}
 
 	respondWithJSON(w, http.StatusOK, Response{
 		Status: "success",
-		Data:   task,
+		Data: map[string]interface{}{
+			"tasks": tasks,
+		},
 	})
 }
 
-func (c *TaskController) GetAllTasks(w http.ResponseWriter, r *http.Request) {
-	// Get user_id from context (set by auth middleware)
-	userID, ok := r.Context().Value("user_id").(gocql.UUID)
+// GetAssignedTasks lists the tasks assigned to the user across all of their
+// workspaces.
+func (c *TaskController) GetAssignedTasks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
 	if !ok {
-		log.Printf("Failed to get user_id from context")
 		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	// Get tasks for specific user
-	tasks, err := models.GetTasksByUserID(c.session, userID)
+	tasks, err := models.GetTasksAssignedTo(c.session, userID)
 	if err != nil {
-		log.Printf("Error fetching tasks: %v", err)
+		log.Printf("Error fetching assigned tasks: %v", err)
 		respondWithError(w, http.StatusInternalServerError, "Failed to fetch tasks")
 		return
 	}
@@ -99,10 +166,8 @@ func (c *TaskController) GetAllTasks(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *TaskController) UpdateTask(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-	id, err := gocql.ParseUUID(params["id"])
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid task ID")
+	existing, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
 		return
 	}
 
@@ -112,12 +177,60 @@ func (c *TaskController) UpdateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	task.TaskID = id
-	if err := task.Update(c.session); err != nil {
+	task.TaskID = existing.TaskID
+	task.UserID = existing.UserID
+	task.WorkspaceID = existing.WorkspaceID
+	task.CreatedAt = existing.CreatedAt
+	task.Version = expectedVersion(r, existing.Version)
+	c.saveTask(w, r, &task)
+}
+
+// PatchTask applies a JSON Merge Patch or JSON Patch to a task.
+func (c *TaskController) PatchTask(w http.ResponseWriter, r *http.Request) {
+	existing, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	doc, ok := applyPatch(w, r, existing)
+	if !ok {
+		return
+	}
+	task, err := models.PatchedTask(existing, doc)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task.Version = expectedVersion(r, existing.Version)
+	c.saveTask(w, r, task)
+}
+
+// saveTask updates the task and writes the response.
+func (c *TaskController) saveTask(w http.ResponseWriter, r *http.Request, task *models.Task) {
+	// ?force=true completes the task even if its blockers are still open
+	update := task.Update
+	if r.URL.Query().Get("force") == "true" {
+		update = task.ForceUpdate
+	}
+	if err := update(c.session, actorFromRequest(r)); err != nil {
+		if errors.Is(err, models.ErrVersionMismatch) {
+			respondWithError(w, http.StatusPreconditionFailed, "Task was modified by someone else; reload and try again")
+			return
+		}
+		if errors.Is(err, models.ErrTaskBlocked) {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if isTaskValidationError(err) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError, "Failed to update task")
 		return
 	}
 
+	setETag(w, task.Version)
 	respondWithJSON(w, http.StatusOK, Response{
 		Status:  "success",
 		Message: "Task updated successfully",
@@ -126,46 +239,187 @@ func (c *TaskController) UpdateTask(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *TaskController) DeleteTask(w http.ResponseWriter, r *http.Request) {
-	// Get user_id from context
-	userID, ok := r.Context().Value("user_id").(gocql.UUID)
+	task, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
 	if !ok {
-		log.Printf("Failed to get user_id from context")
-		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
-	// Get task_id from URL
-	params := mux.Vars(r)
-	taskID, err := gocql.ParseUUID(params["id"])
+	// Move the task to the trash; it is purged after the retention period
+	task.Version = expectedVersion(r, task.Version)
+	if err := task.Trash(c.session, actorFromRequest(r)); err != nil {
+		if errors.Is(err, models.ErrVersionMismatch) {
+			respondWithError(w, http.StatusPreconditionFailed, "Task was modified by someone else; reload and try again")
+			return
+		}
+		log.Printf("Failed to delete task: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to delete task")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Task moved to trash",
+	})
+}
+
+// RestoreTask takes a task out of the trash of a workspace, by default the
+// user's personal one.
+func (c *TaskController) RestoreTask(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleEditor)
+	if !ok {
+		return
+	}
+	taskID, err := gocql.ParseUUID(mux.Vars(r)["id"])
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "Invalid task ID")
 		return
 	}
 
-	// Get task to verify ownership
-	task, err := models.GetTasksByUserID(c.session, taskID)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Task not found")
+	task, err := models.RestoreTask(c.session, workspaceID, taskID, actorFromRequest(r))
+	if err == gocql.ErrNotFound {
+		respondWithError(w, http.StatusNotFound, "Task not found in trash")
+		return
+	} else if err != nil {
+		log.Printf("Failed to restore task: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to restore task")
 		return
 	}
 
-	// Verify tasks belong to user
-	for _, t := range task {
-		if t.UserID != userID {
-			respondWithError(w, http.StatusForbidden, "Access denied")
+	setETag(w, task.Version)
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Task restored successfully",
+		Data:    task,
+	})
+}
+
+// SkipOccurrence moves a recurring task to its next occurrence without
+// completing the current one.
+func (c *TaskController) SkipOccurrence(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := task.SkipOccurrence(c.session, actorFromRequest(r)); err != nil {
+		if errors.Is(err, models.ErrNoMoreOccurrences) || errors.Is(err, models.ErrVersionMismatch) {
+			respondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		if isTaskValidationError(err) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
+		log.Printf("Failed to skip occurrence: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to skip occurrence")
+		return
 	}
 
-	// Delete task
-	if err := models.DeleteTaskByID(c.session, taskID); err != nil {
-		log.Printf("Failed to delete task: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete task")
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Occurrence skipped successfully",
+		Data:    task,
+	})
+}
+
+// GetTaskHistory returns the task's change history. With ?at=<RFC 3339 time>
+// it also returns the task as it was at that time.
+func (c *TaskController) GetTaskHistory(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
 		return
 	}
 
+	events, err := models.GetTaskEvents(c.session, task.TaskID)
+	if err != nil {
+		log.Printf("Failed to fetch task history: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch task history")
+		return
+	}
+
+	data := map[string]interface{}{
+		"events": events,
+	}
+	if value := r.URL.Query().Get("at"); value != "" {
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid time, expected RFC 3339")
+			return
+		}
+		snapshot, err := models.TaskAt(c.session, task.TaskID, at)
+		if err != nil && err != gocql.ErrNotFound {
+			log.Printf("Failed to reconstruct task: %v", err)
+			respondWithError(w, http.StatusInternalServerError, "Failed to fetch task history")
+			return
+		}
+		data["task"] = snapshot
+	}
+
 	respondWithJSON(w, http.StatusOK, Response{
-		Status:  "success",
-		Message: "Task deleted successfully",
+		Status: "success",
+		Data:   data,
 	})
 }
+
+// newTaskFromInput builds a new task of the user from the client-settable
+// fields of input. Without a workspace the task goes to the user's personal
+// workspace.
+func newTaskFromInput(userID gocql.UUID, input *models.Task) *models.Task {
+	task := models.NewTask(userID, input.Title, input.Description, input.Status)
+	if input.WorkspaceID != (gocql.UUID{}) {
+		task.WorkspaceID = input.WorkspaceID
+	}
+	task.ProjectID = input.ProjectID
+	task.Assignees = input.Assignees
+	task.ParentID = input.ParentID
+	task.CategoryID = input.CategoryID
+	task.Checklist = input.Checklist
+	task.Tags = input.Tags
+	task.AutoComplete = input.AutoComplete
+	task.DueAt = input.DueAt
+	task.Recurrence = input.Recurrence
+	task.RecurFrom = input.RecurFrom
+	task.TimeZone = input.TimeZone
+	task.Priority = input.Priority
+	return task
+}
+
+// loadTask loads the task named by the given route variable and verifies
+// that the authenticated user has at least the given role in its workspace.
+// On failure it writes the error response and returns false.
+func loadTask(session *gocql.Session, w http.ResponseWriter, r *http.Request, param, role string) (*models.Task, bool) {
+	// Get task_id from URL params
+	params := mux.Vars(r)
+	taskID, err := gocql.ParseUUID(params[param])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid task ID")
+		return nil, false
+	}
+
+	// Get task and verify access to its workspace
+	task, err := models.GetTaskByID(session, taskID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Task not found")
+		return nil, false
+	}
+
+	if !authorizeWorkspace(session, w, r, task.WorkspaceID, role) {
+		return nil, false
+	}
+
+	return task, true
+}
+
+func isTaskValidationError(err error) bool {
+	return errors.Is(err, models.ErrTaskCycle) ||
+		errors.Is(err, models.ErrTaskDepthExceeded) ||
+		errors.Is(err, models.ErrParentNotFound) ||
+		errors.Is(err, models.ErrInvalidRecurrence) ||
+		errors.Is(err, models.ErrInvalidStatus) ||
+		errors.Is(err, models.ErrTransitionNotAllowed) ||
+		errors.Is(err, models.ErrInvalidTask) ||
+		errors.Is(err, models.ErrCategoryNotFound) ||
+		errors.Is(err, models.ErrInvalidTag) ||
+		errors.Is(err, models.ErrProjectNotFound) ||
+		errors.Is(err, models.ErrInvalidAssignee)
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"todo-app/middleware"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+// TemplateController manages the task templates of the authenticated user.
+// Templates belong to a user rather than a workspace and can be
+// instantiated in any workspace where the user may create tasks.
+type TemplateController struct {
+	session *gocql.Session
+}
+
+func NewTemplateController(session *gocql.Session) *TemplateController {
+	return &TemplateController{session: session}
+}
+
+func (c *TemplateController) CreateTemplate(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var template models.TaskTemplate
+	if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := template.Create(c.session, userID); err != nil {
+		respondWithTemplateError(w, err, "Failed to create template")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   template,
+	})
+}
+
+func (c *TemplateController) GetTemplates(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	templates, err := models.GetTaskTemplates(c.session, userID)
+	if err != nil {
+		respondWithTemplateError(w, err, "Failed to fetch templates")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   templates,
+	})
+}
+
+func (c *TemplateController) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := c.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   template,
+	})
+}
+
+// UpdateTemplate replaces a template's name and tasks.
+func (c *TemplateController) UpdateTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := c.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.TaskTemplate
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	template.Name = input.Name
+	template.TemplateTask = input.TemplateTask
+	if err := template.Update(c.session); err != nil {
+		respondWithTemplateError(w, err, "Failed to update template")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   template,
+	})
+}
+
+func (c *TemplateController) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := c.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	if err := template.Delete(c.session); err != nil {
+		respondWithTemplateError(w, err, "Failed to delete template")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Template deleted successfully",
+	})
+}
+
+// InstantiateTemplate creates the tasks a template describes. The body says
+// where they go and gives the values of the template's variables, for
+// example {"project_id": "...", "variables": {"sprint": "42"}}.
+func (c *TemplateController) InstantiateTemplate(w http.ResponseWriter, r *http.Request) {
+	template, ok := c.loadTemplate(w, r)
+	if !ok {
+		return
+	}
+
+	var input models.TemplateInstance
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	actor := actorFromRequest(r)
+	if !authorizeWorkspace(c.session, w, r, input.Workspace(actor.UserID), models.RoleEditor) {
+		return
+	}
+	tasks, err := template.Instantiate(c.session, input, actor)
+	if err != nil {
+		respondWithTemplateError(w, err, "Failed to instantiate template")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data: map[string]interface{}{
+			"tasks": tasks,
+		},
+	})
+}
+
+// CreateTemplateFromTask saves a task and its subtasks as a template of the
+// user, named after the task unless the body gives a name.
+func (c *TemplateController) CreateTemplateFromTask(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadTask(c.session, w, r, "id", models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	template, err := models.NewTemplateFromTask(c.session, task, input.Name)
+	if err != nil {
+		respondWithTemplateError(w, err, "Failed to create template")
+		return
+	}
+	userID, _ := middleware.GetUserID(r.Context())
+	if err := template.Create(c.session, userID); err != nil {
+		respondWithTemplateError(w, err, "Failed to create template")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   template,
+	})
+}
+
+// loadTemplate loads the template named by the route if it belongs to the
+// authenticated user. On failure it writes the error response and returns
+// false.
+func (c *TemplateController) loadTemplate(w http.ResponseWriter, r *http.Request) (*models.TaskTemplate, bool) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+	templateID, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid template ID")
+		return nil, false
+	}
+
+	template, err := models.GetTaskTemplate(c.session, userID, templateID)
+	if err != nil {
+		respondWithTemplateError(w, err, "Failed to fetch template")
+		return nil, false
+	}
+	return template, true
+}
+
+func respondWithTemplateError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidTemplate), errors.Is(err, models.ErrMissingVariables),
+		isTaskValidationError(err):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrTemplateNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Printf("%s: %v", message, err)
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
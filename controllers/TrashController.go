@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+type TrashController struct {
+	session *gocql.Session
+}
+
+func NewTrashController(session *gocql.Session) *TrashController {
+	return &TrashController{session: session}
+}
+
+// GetTrash lists the tasks and categories deleted from a workspace, by
+// default the user's personal one, that have not been purged yet.
+func (c *TrashController) GetTrash(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	items, err := models.GetTrash(c.session, workspaceID)
+	if err != nil {
+		log.Printf("Failed to fetch trash: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch trash")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   items,
+	})
+}
@@ -4,12 +4,9 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"todo-app/middleware"
 	"todo-app/models"
 
-	"github.com/golang-jwt/jwt"
-
-	"time"
-
 	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
 )
@@ -46,7 +43,7 @@ func (c *UserController) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := generateJWT(user.UserID)
+	token, err := middleware.IssueToken(user.UserID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Error generating token")
 		return
@@ -65,14 +62,6 @@ func (c *UserController) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func generateJWT(userID gocql.UUID) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID.String(),
-		"exp":     time.Now().Add(24 * time.Hour).Unix(),
-	})
-	return token.SignedString([]byte("your-secret-key"))
-}
-
 func (c *UserController) CreateUser(w http.ResponseWriter, r *http.Request) {
 	var userData struct {
 		Username string `json:"username"`
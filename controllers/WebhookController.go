@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+// maxWebhookDeliveries caps how many entries of an endpoint's delivery log
+// are listed.
+const maxWebhookDeliveries = 100
+
+// WebhookController manages the webhook endpoints of a workspace. Endpoints
+// receive every change of the workspace they subscribe to, so they are left
+// to its owners.
+type WebhookController struct {
+	session *gocql.Session
+}
+
+func NewWebhookController(session *gocql.Session) *WebhookController {
+	return &WebhookController{session: session}
+}
+
+// CreateWebhook registers an endpoint. Its signing secret is only returned
+// here and when it is rotated.
+func (c *WebhookController) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	var hook models.Webhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	hook.WorkspaceID = workspace.WorkspaceID
+	if err := hook.Create(c.session, actorFromRequest(r)); err != nil {
+		respondWithWebhookError(w, err, "Failed to create webhook")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   hook,
+	})
+}
+
+func (c *WebhookController) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	hooks, err := models.GetWebhooks(c.session, workspace.WorkspaceID)
+	if err != nil {
+		respondWithWebhookError(w, err, "Failed to fetch webhooks")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   hooks,
+	})
+}
+
+func (c *WebhookController) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := c.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   hook,
+	})
+}
+
+// UpdateWebhook changes the fields given of an endpoint. Enabling a disabled
+// endpoint clears its failures.
+func (c *WebhookController) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := c.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL         *string  `json:"url"`
+		Description *string  `json:"description"`
+		Events      []string `json:"events"`
+		Enabled     *bool    `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if input.URL != nil {
+		hook.URL = *input.URL
+	}
+	if input.Description != nil {
+		hook.Description = *input.Description
+	}
+	if input.Events != nil {
+		hook.Events = input.Events
+	}
+	if input.Enabled != nil {
+		hook.SetEnabled(*input.Enabled)
+	}
+	if err := hook.Update(c.session); err != nil {
+		respondWithWebhookError(w, err, "Failed to update webhook")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   hook,
+	})
+}
+
+func (c *WebhookController) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := c.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := hook.Delete(c.session); err != nil {
+		respondWithWebhookError(w, err, "Failed to delete webhook")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Webhook deleted",
+	})
+}
+
+// RotateWebhookSecret replaces an endpoint's signing secret and returns the
+// new one.
+func (c *WebhookController) RotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	hook, ok := c.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	if err := hook.RotateSecret(c.session); err != nil {
+		respondWithWebhookError(w, err, "Failed to rotate webhook secret")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   hook,
+	})
+}
+
+// PingWebhook queues a ping event for an endpoint.
+func (c *WebhookController) PingWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := c.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := hook.Ping(c.session)
+	if err != nil {
+		respondWithWebhookError(w, err, "Failed to queue ping")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, Response{
+		Status: "success",
+		Data:   delivery,
+	})
+}
+
+// GetWebhookDeliveries lists an endpoint's most recent deliveries.
+func (c *WebhookController) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hook, ok := c.loadWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := models.GetWebhookDeliveries(c.session, hook.WebhookID, maxWebhookDeliveries)
+	if err != nil {
+		respondWithWebhookError(w, err, "Failed to fetch webhook deliveries")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   deliveries,
+	})
+}
+
+// GetWebhookDelivery returns a delivery with the attempts made at it.
+func (c *WebhookController) GetWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := c.loadDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   delivery,
+	})
+}
+
+// RedeliverWebhook queues a delivery's event again as a new delivery, which
+// is attempted right away.
+func (c *WebhookController) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	delivery, ok := c.loadDelivery(w, r)
+	if !ok {
+		return
+	}
+
+	redelivery, err := delivery.Redeliver(c.session)
+	if err != nil {
+		respondWithWebhookError(w, err, "Failed to queue redelivery")
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, Response{
+		Status: "success",
+		Data:   redelivery,
+	})
+}
+
+func (c *WebhookController) loadWorkspace(w http.ResponseWriter, r *http.Request) (*models.Workspace, bool) {
+	workspaceID, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID")
+		return nil, false
+	}
+	return loadWorkspace(c.session, w, r, workspaceID, models.RoleOwner)
+}
+
+// loadWebhook loads the endpoint named by the route after verifying that the
+// authenticated user owns its workspace. On failure it writes the error
+// response and returns false.
+func (c *WebhookController) loadWebhook(w http.ResponseWriter, r *http.Request) (*models.Webhook, bool) {
+	workspace, ok := c.loadWorkspace(w, r)
+	if !ok {
+		return nil, false
+	}
+	webhookID, err := gocql.ParseUUID(mux.Vars(r)["webhook_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return nil, false
+	}
+
+	hook, err := models.GetWebhook(c.session, workspace.WorkspaceID, webhookID)
+	if err != nil {
+		respondWithWebhookError(w, err, "Failed to fetch webhook")
+		return nil, false
+	}
+	return hook, true
+}
+
+// loadDelivery loads the delivery named by the route like loadWebhook.
+func (c *WebhookController) loadDelivery(w http.ResponseWriter, r *http.Request) (*models.WebhookDelivery, bool) {
+	hook, ok := c.loadWebhook(w, r)
+	if !ok {
+		return nil, false
+	}
+	deliveryID, err := gocql.ParseUUID(mux.Vars(r)["delivery_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return nil, false
+	}
+
+	delivery, err := models.GetWebhookDelivery(c.session, hook.WebhookID, deliveryID)
+	if err != nil {
+		respondWithWebhookError(w, err, "Failed to fetch webhook delivery")
+		return nil, false
+	}
+	return delivery, true
+}
+
+func respondWithWebhookError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidWebhook):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrWebhookNotFound), errors.Is(err, models.ErrWebhookDeliveryNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		log.Printf("%s: %v", message, err)
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+type WorkflowController struct {
+	session *gocql.Session
+}
+
+func NewWorkflowController(session *gocql.Session) *WorkflowController {
+	return &WorkflowController{session: session}
+}
+
+// GetWorkflow returns the workflow of a workspace, by default the user's
+// personal one.
+func (c *WorkflowController) GetWorkflow(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	workflow, err := models.GetWorkflow(c.session, workspaceID)
+	if err != nil {
+		log.Printf("Failed to fetch workflow: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch workflow")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   workflow,
+	})
+}
+
+// UpdateWorkflow replaces the workspace's workflow, which only owners may
+// do. Tasks in statuses that are removed are moved according to
+// status_mapping.
+func (c *WorkflowController) UpdateWorkflow(w http.ResponseWriter, r *http.Request) {
+	workspaceID, ok := workspaceFromRequest(c.session, w, r, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Statuses      []models.WorkflowStatus `json:"statuses"`
+		Transitions   map[string][]string     `json:"transitions"`
+		StatusMapping map[string]string       `json:"status_mapping"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	workflow := &models.Workflow{
+		WorkspaceID: workspaceID,
+		Statuses:    input.Statuses,
+		Transitions: input.Transitions,
+	}
+	if workflow.Transitions == nil {
+		workflow.Transitions = map[string][]string{}
+	}
+
+	if err := models.ReplaceWorkflow(c.session, workflow, input.StatusMapping, actorFromRequest(r)); err != nil {
+		if errors.Is(err, models.ErrInvalidWorkflow) {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, models.ErrVersionMismatch) {
+			respondWithError(w, http.StatusConflict, "Tasks were modified while moving them; try again")
+			return
+		}
+		log.Printf("Failed to update workflow: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to update workflow")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Workflow updated successfully",
+		Data:    workflow,
+	})
+}
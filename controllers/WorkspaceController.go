@@ -0,0 +1,435 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"todo-app/middleware"
+	"todo-app/models"
+	"todo-app/notify"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+type WorkspaceController struct {
+	session *gocql.Session
+	mailer  notify.Notifier
+}
+
+// NewWorkspaceController creates the controller. Invitations are emailed
+// through mailer; without one they are only returned to the inviting owner.
+func NewWorkspaceController(session *gocql.Session, mailer notify.Notifier) *WorkspaceController {
+	return &WorkspaceController{session: session, mailer: mailer}
+}
+
+// GetWorkspaces lists the workspaces the user belongs to with their role in
+// each.
+func (c *WorkspaceController) GetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	workspaces, err := models.GetWorkspacesByUser(c.session, userID)
+	if err != nil {
+		log.Printf("Failed to fetch workspaces: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch workspaces")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   workspaces,
+	})
+}
+
+// CreateWorkspace creates a shared workspace owned by the user.
+func (c *WorkspaceController) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	workspace := &models.Workspace{Name: input.Name}
+	if err := workspace.Create(c.session, userID); err != nil {
+		respondWithWorkspaceError(w, err, "Failed to create workspace")
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status: "success",
+		Data:   workspace,
+	})
+}
+
+func (c *WorkspaceController) GetWorkspace(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   workspace,
+	})
+}
+
+// UpdateWorkspace renames the workspace.
+func (c *WorkspaceController) UpdateWorkspace(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := workspace.Rename(c.session, input.Name); err != nil {
+		respondWithWorkspaceError(w, err, "Failed to update workspace")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Workspace updated successfully",
+		Data:    workspace,
+	})
+}
+
+func (c *WorkspaceController) GetMembers(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+
+	members, err := models.GetWorkspaceMembers(c.session, workspace.WorkspaceID)
+	if err != nil {
+		log.Printf("Failed to fetch workspace members: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch members")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   members,
+	})
+}
+
+// UpdateMember changes a member's role.
+func (c *WorkspaceController) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleOwner)
+	if !ok {
+		return
+	}
+	memberID, err := gocql.ParseUUID(mux.Vars(r)["user_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := models.SetMemberRole(c.session, workspace, memberID, input.Role); err != nil {
+		respondWithWorkspaceError(w, err, "Failed to update member")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Member updated successfully",
+	})
+}
+
+// RemoveMember takes a member out of the workspace. Owners can remove
+// anyone; other members can only leave.
+func (c *WorkspaceController) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetUserID(r.Context())
+	memberID, err := gocql.ParseUUID(mux.Vars(r)["user_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	role := models.RoleOwner
+	if memberID == userID {
+		role = models.RoleViewer
+	}
+	workspace, ok := c.loadWorkspace(w, r, role)
+	if !ok {
+		return
+	}
+
+	if err := models.RemoveMember(c.session, workspace, memberID); err != nil {
+		respondWithWorkspaceError(w, err, "Failed to remove member")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Member removed successfully",
+	})
+}
+
+// CreateInvitation invites an email address to the workspace and emails the
+// invitee a link to accept it. The token is also returned, so the owner can
+// pass it on when email delivery is not set up.
+func (c *WorkspaceController) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	var invitation models.Invitation
+	if err := json.NewDecoder(r.Body).Decode(&invitation); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := invitation.Create(c.session, workspace, actorFromRequest(r)); err != nil {
+		respondWithWorkspaceError(w, err, "Failed to create invitation")
+		return
+	}
+
+	message := "Invitation created"
+	if err := c.sendInvitation(r, workspace, &invitation); err != nil {
+		log.Printf("Failed to email invitation %s: %v", invitation.InvitationID, err)
+		message = "Invitation created, but the email could not be sent"
+	}
+
+	respondWithJSON(w, http.StatusCreated, Response{
+		Status:  "success",
+		Message: message,
+		Data:    invitation,
+	})
+}
+
+func (c *WorkspaceController) sendInvitation(r *http.Request, workspace *models.Workspace, invitation *models.Invitation) error {
+	if c.mailer == nil {
+		return errors.New("no mailer configured")
+	}
+	inviter := "A teammate"
+	if user, err := models.GetUserByID(c.session, invitation.InvitedBy); err == nil {
+		inviter = user.Username
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	link := fmt.Sprintf("%s://%s/api/v1/invitations/%s/accept", scheme, r.Host, invitation.Token)
+
+	return c.mailer.Notify(r.Context(), notify.Message{
+		Kind:      "invitation",
+		Recipient: invitation.Email,
+		Subject:   fmt.Sprintf("%s invited you to %q", inviter, workspace.Name),
+		Body: fmt.Sprintf("%s invited you to join the workspace %q as %s.\n\n"+
+			"Sign in with this email address and send a POST request to\n%s\nto accept. "+
+			"The invitation expires on %s.",
+			inviter, workspace.Name, invitation.Role, link, invitation.ExpiresAt.Format("January 2, 2006")),
+	})
+}
+
+func (c *WorkspaceController) GetInvitations(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleOwner)
+	if !ok {
+		return
+	}
+
+	invitations, err := models.GetInvitations(c.session, workspace.WorkspaceID)
+	if err != nil {
+		log.Printf("Failed to fetch invitations: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch invitations")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status: "success",
+		Data:   invitations,
+	})
+}
+
+func (c *WorkspaceController) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	workspace, ok := c.loadWorkspace(w, r, models.RoleOwner)
+	if !ok {
+		return
+	}
+	invitationID, err := gocql.ParseUUID(mux.Vars(r)["invitation_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid invitation ID")
+		return
+	}
+
+	invitation, err := models.GetInvitation(c.session, workspace.WorkspaceID, invitationID)
+	if err != nil {
+		respondWithWorkspaceError(w, err, "Failed to revoke invitation")
+		return
+	}
+	if err := invitation.Revoke(c.session); err != nil {
+		respondWithWorkspaceError(w, err, "Failed to revoke invitation")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Invitation revoked",
+	})
+}
+
+// AcceptInvitation adds the user to the workspace an invitation token was
+// issued for.
+func (c *WorkspaceController) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	user, err := models.GetUserByID(c.session, userID)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	workspace, err := models.AcceptInvitation(c.session, mux.Vars(r)["token"], user)
+	if err != nil {
+		respondWithWorkspaceError(w, err, "Failed to accept invitation")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, Response{
+		Status:  "success",
+		Message: "Invitation accepted",
+		Data:    workspace,
+	})
+}
+
+// loadWorkspace loads the workspace named by the {id} route variable and
+// verifies that the authenticated user has at least the given role in it.
+// On failure it writes the error response and returns false.
+func (c *WorkspaceController) loadWorkspace(w http.ResponseWriter, r *http.Request, role string) (*models.Workspace, bool) {
+	workspaceID, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid workspace ID")
+		return nil, false
+	}
+	return loadWorkspace(c.session, w, r, workspaceID, role)
+}
+
+func loadWorkspace(session *gocql.Session, w http.ResponseWriter, r *http.Request, workspaceID gocql.UUID, role string) (*models.Workspace, bool) {
+	userID, _ := middleware.GetUserID(r.Context())
+	current, err := models.GetWorkspaceRole(session, workspaceID, userID)
+	if err != nil {
+		log.Printf("Failed to look up workspace role: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to fetch workspace")
+		return nil, false
+	}
+	if current == "" {
+		respondWithError(w, http.StatusNotFound, "Workspace not found")
+		return nil, false
+	}
+
+	workspace, err := models.GetWorkspace(session, workspaceID)
+	if err != nil {
+		respondWithWorkspaceError(w, err, "Failed to fetch workspace")
+		return nil, false
+	}
+	workspace.Role = current
+	if !models.HasRole(current, role) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("This requires the %s role in the workspace", role))
+		return nil, false
+	}
+	return workspace, true
+}
+
+// workspaceFromRequest returns the workspace named by the workspace_id query
+// parameter, or the user's personal workspace without one, after verifying
+// that the authenticated user has at least the given role in it. On failure
+// it writes the error response and returns false.
+func workspaceFromRequest(session *gocql.Session, w http.ResponseWriter, r *http.Request, role string) (gocql.UUID, bool) {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return gocql.UUID{}, false
+	}
+
+	workspaceID := models.PersonalWorkspaceID(userID)
+	if value := r.URL.Query().Get("workspace_id"); value != "" {
+		id, err := gocql.ParseUUID(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid workspace ID")
+			return gocql.UUID{}, false
+		}
+		workspaceID = id
+	}
+	if !authorizeWorkspace(session, w, r, workspaceID, role) {
+		return gocql.UUID{}, false
+	}
+	return workspaceID, true
+}
+
+// authorizeWorkspace verifies that the authenticated user has at least the
+// given role in the workspace. On failure it writes the error response and
+// returns false.
+func authorizeWorkspace(session *gocql.Session, w http.ResponseWriter, r *http.Request, workspaceID gocql.UUID, role string) bool {
+	userID, ok := middleware.GetUserID(r.Context())
+	if !ok {
+		log.Printf("Failed to get user_id from context")
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return false
+	}
+
+	current, err := models.GetWorkspaceRole(session, workspaceID, userID)
+	if err != nil {
+		log.Printf("Failed to look up workspace role: %v", err)
+		respondWithError(w, http.StatusInternalServerError, "Failed to check access")
+		return false
+	}
+	if current == "" {
+		respondWithError(w, http.StatusForbidden, "Access denied")
+		return false
+	}
+	if !models.HasRole(current, role) {
+		respondWithError(w, http.StatusForbidden, fmt.Sprintf("This requires the %s role in the workspace", role))
+		return false
+	}
+	return true
+}
+
+func respondWithWorkspaceError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, models.ErrInvalidWorkspace), errors.Is(err, models.ErrInvalidInvitation):
+		respondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, models.ErrWorkspaceNotFound), errors.Is(err, models.ErrNotMember),
+		errors.Is(err, models.ErrInvitationNotFound):
+		respondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, models.ErrInvitationEmail):
+		respondWithError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, models.ErrPersonalWorkspace), errors.Is(err, models.ErrLastOwner):
+		respondWithError(w, http.StatusConflict, err.Error())
+	default:
+		log.Printf("%s: %v", message, err)
+		respondWithError(w, http.StatusInternalServerError, message)
+	}
+}
@@ -2,7 +2,15 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"todo-app/middleware"
+	"todo-app/models"
+	"todo-app/patch"
 )
 
 type Response struct {
@@ -24,3 +32,74 @@ func respondWithError(w http.ResponseWriter, code int, message string) {
 		Message: message,
 	})
 }
+
+// actorFromRequest identifies the authenticated user and request for the
+// task history.
+func actorFromRequest(r *http.Request) models.Actor {
+	userID, _ := middleware.GetUserID(r.Context())
+	return models.Actor{
+		UserID:    userID,
+		RequestID: middleware.GetRequestID(r.Context()),
+	}
+}
+
+// setETag sets the ETag header from a task or category version.
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// expectedVersion returns the version the client expects the resource to
+// have. Without an If-Match header, or with "*", that is the current one; if
+// none of the listed tags is the current version it returns -1, which no
+// stored version matches. If-Match uses the strong comparison, so weak tags
+// never match.
+func expectedVersion(r *http.Request, current int) int {
+	header := r.Header.Get("If-Match")
+	if header == "" || strings.TrimSpace(header) == "*" {
+		return current
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if version, err := strconv.Atoi(strings.Trim(tag, `"`)); err == nil && version == current {
+			return current
+		}
+	}
+	return -1
+}
+
+// applyPatch applies the request body, a JSON Merge Patch or JSON Patch, to
+// the JSON encoding of current and returns the patched document. On failure
+// it writes the error response and returns false.
+func applyPatch(w http.ResponseWriter, r *http.Request, current interface{}) ([]byte, bool) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != patch.MergePatchType && contentType != patch.JSONPatchType {
+		w.Header().Set("Accept-Patch", patch.MergePatchType+", "+patch.JSONPatchType)
+		respondWithError(w, http.StatusUnsupportedMediaType,
+			"Content-Type must be "+patch.MergePatchType+" or "+patch.JSONPatchType)
+		return nil, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return nil, false
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Failed to apply patch")
+		return nil, false
+	}
+
+	patched, err := patch.Apply(contentType, doc, body)
+	if errors.Is(err, patch.ErrTestFailed) {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return nil, false
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return patched, true
+}
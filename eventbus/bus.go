@@ -0,0 +1,107 @@
+// Package eventbus delivers domain events to the in-process subscribers
+// registered for them.
+//
+// Events reach the bus through the outbox: the model layer writes them
+// together with the change they describe, and a dispatcher hands each one to
+// every subscriber at least once. Subscribers are named; the dispatcher
+// keeps each one's progress under its name, so a name should not change
+// between releases. Handlers must tolerate seeing an event twice.
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Event is a domain event. EventName identifies its type in the outbox and
+// must be defined on the value, not a pointer.
+type Event interface {
+	EventName() string
+}
+
+// Meta describes an event delivered to a handler.
+type Meta struct {
+	ID         gocql.UUID
+	Name       string
+	OccurredAt time.Time
+}
+
+// handler decodes an event and handles it.
+type handler func(ctx context.Context, meta Meta, payload []byte) error
+
+// Bus holds the subscribers of each event type.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[string]handler
+}
+
+func New() *Bus {
+	return &Bus{subscribers: make(map[string]map[string]handler)}
+}
+
+// Subscribe registers handle for the events of type E under the subscriber's
+// name. A subscriber handles each type at most once; subscribing it again
+// replaces the handler.
+func Subscribe[E Event](b *Bus, subscriber string, handle func(ctx context.Context, meta Meta, e E) error) {
+	var zero E
+	name := zero.EventName()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	handlers, ok := b.subscribers[subscriber]
+	if !ok {
+		handlers = make(map[string]handler)
+		b.subscribers[subscriber] = handlers
+	}
+	handlers[name] = func(ctx context.Context, meta Meta, payload []byte) error {
+		var e E
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return fmt.Errorf("failed to decode %s event: %w", name, err)
+		}
+		return handle(ctx, meta, e)
+	}
+}
+
+// Encode returns the name and JSON payload an event is stored under.
+func Encode(e Event) (string, []byte, error) {
+	payload, err := json.Marshal(e)
+	return e.EventName(), payload, err
+}
+
+// Subscribers returns the names of the subscribers, sorted.
+func (b *Bus) Subscribers() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	names := make([]string, 0, len(b.subscribers))
+	for name := range b.subscribers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Handles reports whether the subscriber handles events of the named type.
+func (b *Bus) Handles(subscriber, eventName string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.subscribers[subscriber][eventName]
+	return ok
+}
+
+// Deliver hands a stored event to the subscriber. Events the subscriber does
+// not handle are ignored.
+func (b *Bus) Deliver(ctx context.Context, subscriber string, meta Meta, payload []byte) error {
+	b.mu.RLock()
+	handle, ok := b.subscribers[subscriber][meta.Name]
+	b.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+	return handle(ctx, meta, payload)
+}
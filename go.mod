@@ -6,11 +6,17 @@ require (
 	github.com/gocql/gocql v1.7.0
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
+	github.com/microcosm-cc/bluemonday v1.0.27
+	github.com/yuin/goldmark v1.7.8
 	golang.org/x/crypto v0.32.0
 )
 
 require (
+	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/golang/snappy v0.0.3 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
 	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
+	golang.org/x/net v0.26.0 // indirect
 	gopkg.in/inf.v0 v0.9.1 // indirect
 )
@@ -0,0 +1,134 @@
+// Package hlc implements hybrid logical clocks.
+//
+// A hybrid logical clock timestamp pairs a wall clock reading, in
+// milliseconds, with a logical counter that orders events within the same
+// millisecond, and the node that issued it to break the remaining ties.
+// Timestamps follow real time closely, yet an event is always ordered after
+// every event its node had seen, however far the nodes' wall clocks drift
+// apart.
+package hlc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxDrift is how far ahead of the local wall clock a remote timestamp may
+// be. Anything further is rejected rather than dragging the clock along.
+const MaxDrift = time.Minute
+
+var (
+	ErrInvalidTimestamp = errors.New("timestamp must be <wall ms>-<counter>-<node>")
+	ErrClockDrift       = fmt.Errorf("timestamp is more than %s ahead of the server clock", MaxDrift)
+)
+
+// Timestamp is a point on a hybrid logical clock. The zero Timestamp is
+// before every other.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+	Node    string
+}
+
+// Parse reads a timestamp in the format written by String.
+func Parse(s string) (Timestamp, error) {
+	parts := strings.SplitN(s, "-", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return Timestamp{}, ErrInvalidTimestamp
+	}
+	wall, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || wall < 0 {
+		return Timestamp{}, ErrInvalidTimestamp
+	}
+	logical, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return Timestamp{}, ErrInvalidTimestamp
+	}
+	return Timestamp{Wall: wall, Logical: uint32(logical), Node: parts[2]}, nil
+}
+
+// String formats the timestamp as <wall ms>-<counter>-<node>. Both numbers
+// are zero-padded, so timestamps of the same node sort as strings too.
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%015d-%010d-%s", t.Wall, t.Logical, t.Node)
+}
+
+func (t Timestamp) IsZero() bool {
+	return t == Timestamp{}
+}
+
+// Time returns the wall clock part of the timestamp.
+func (t Timestamp) Time() time.Time {
+	return time.UnixMilli(t.Wall).UTC()
+}
+
+// Compare returns -1, 0 or +1 depending on whether t is before, equal to or
+// after u.
+func (t Timestamp) Compare(u Timestamp) int {
+	if c := cmp.Compare(t.Wall, u.Wall); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(t.Logical, u.Logical); c != 0 {
+		return c
+	}
+	return strings.Compare(t.Node, u.Node)
+}
+
+// Clock issues the timestamps of one node.
+type Clock struct {
+	node string
+
+	mu   sync.Mutex
+	last Timestamp
+}
+
+func NewClock(node string) *Clock {
+	return &Clock{node: node}
+}
+
+// Now returns a timestamp after every timestamp the clock has issued or
+// seen.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	wall := time.Now().UnixMilli()
+	if wall > c.last.Wall {
+		c.last = Timestamp{Wall: wall, Node: c.node}
+	} else {
+		c.last = Timestamp{Wall: c.last.Wall, Logical: c.last.Logical + 1, Node: c.node}
+	}
+	return c.last
+}
+
+// Update moves the clock past a timestamp received from another node, so
+// that later local events are ordered after it. It returns ErrClockDrift
+// if the timestamp is too far ahead of the local wall clock.
+func (c *Clock) Update(remote Timestamp) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if remote.Time().After(now.Add(MaxDrift)) {
+		return ErrClockDrift
+	}
+	wall := now.UnixMilli()
+	switch {
+	case wall > c.last.Wall && wall > remote.Wall:
+		c.last = Timestamp{Wall: wall, Node: c.node}
+	case remote.Wall > c.last.Wall:
+		c.last = Timestamp{Wall: remote.Wall, Logical: remote.Logical + 1, Node: c.node}
+	case c.last.Wall > remote.Wall:
+		c.last.Logical++
+	default:
+		logical := c.last.Logical
+		if remote.Logical > logical {
+			logical = remote.Logical
+		}
+		c.last.Logical = logical + 1
+	}
+	return nil
+}
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 	"todo-app/config"
+	"todo-app/eventbus"
 	"todo-app/keyspace"
+	"todo-app/models"
+	"todo-app/netguard"
+	"todo-app/notify"
+	"todo-app/realtime"
 	"todo-app/routes"
+	"todo-app/scheduler"
+	"todo-app/storage"
 	"todo-app/tables"
+	"todo-app/webhook"
 )
 
 func main() {
@@ -36,6 +47,128 @@ func main() {
 	tables.CreateUsersTable(todoSession)
 	tables.CreateTasksTable(todoSession)
 	tables.CreateCategoriesTable(todoSession)
+	tables.CreateTaskDependenciesTable(todoSession)
+	tables.CreateRemindersTable(todoSession)
+	tables.CreateNotificationsTable(todoSession)
+	tables.CreateWorkflowsTable(todoSession)
+	tables.CreateTaskEventsTable(todoSession)
+	tables.CreateTrashTable(todoSession)
+	tables.CreateIdempotencyKeysTable(todoSession)
+	tables.CreateTasksByTagTable(todoSession)
+	tables.CreateAttachmentsTable(todoSession)
+	tables.CreateThumbnailJobsTable(todoSession)
+	tables.CreateCommentsTable(todoSession)
+	tables.CreateWorkspacesTables(todoSession)
+	tables.CreateTasksByAssigneeTable(todoSession)
+	tables.CreateShareLinksTables(todoSession)
+	tables.CreateEventLogTable(todoSession)
+	tables.CreateSyncLogTable(todoSession)
+	tables.CreateWebhooksTables(todoSession)
+	tables.CreateOutboxTables(todoSession)
+	tables.CreatePendingEventsTable(todoSession)
+	tables.CreateAutomationsTables(todoSession)
+	tables.CreateTaskTemplatesTable(todoSession)
+	tables.CreateMigrationsTable(todoSession)
+
+	// Data migrations
+	if err := models.ApplyMigration(todoSession, "default_workflow", models.MigrateToDefaultWorkflow); err != nil {
+		log.Fatal(err)
+	}
+	if err := models.ApplyMigration(todoSession, "versions", models.MigrateToVersions); err != nil {
+		log.Fatal(err)
+	}
+	if err := models.ApplyMigration(todoSession, "workspaces", models.MigrateToWorkspaces); err != nil {
+		log.Fatal(err)
+	}
+	if err := models.ApplyMigration(todoSession, "assignee_index", models.MigrateAssigneeIndex); err != nil {
+		log.Fatal(err)
+	}
+	if err := models.ApplyMigration(todoSession, "category_workspaces", models.MigrateCategoriesToWorkspaces); err != nil {
+		log.Fatal(err)
+	}
+
+	// Changes are pushed to streaming clients through an in-process broker,
+	// which suits a single instance.
+	models.EventBroker = realtime.NewLocalBroker()
+
+	// Background jobs
+	hostname, _ := os.Hostname()
+	instanceID := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+
+	// Webhook URLs are chosen by users, so outside development they must
+	// use https and may only reach public addresses.
+	if os.Getenv("DEV_MODE") != "" {
+		netguard.AllowHTTP = true
+		netguard.AllowPrivate = true
+	}
+
+	notifiers := map[string]notify.Notifier{
+		models.ChannelInApp: notify.NewInAppNotifier(todoSession),
+		models.ChannelEmail: notify.NewEmailNotifier(notify.EmailConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}),
+		models.ChannelWebhook: notify.NewWebhookNotifier(nil),
+	}
+	reminderScheduler := scheduler.NewReminderScheduler(todoSession, notifiers, instanceID)
+	go reminderScheduler.Run(context.Background())
+
+	// Side effects of domain events are triggered through the outbox.
+	bus := eventbus.New()
+	models.SubscribeWebhooks(bus, todoSession)
+	models.SubscribeAutomations(bus, todoSession)
+	go scheduler.NewOutboxDispatcher(todoSession, bus, instanceID).Run(context.Background())
+	go scheduler.NewPendingEventSweeper(todoSession).Run(context.Background())
+	go scheduler.NewAutomationScheduler(todoSession).Run(context.Background())
+	go scheduler.NewWebhookDispatcher(todoSession, webhook.NewSender(nil), instanceID).Run(context.Background())
+
+	if retention := os.Getenv("TRASH_RETENTION"); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid TRASH_RETENTION %q", retention)
+		}
+		models.TrashRetention = d
+	}
+	go scheduler.NewTrashPurger(todoSession).Run(context.Background())
+
+	// Attachment storage
+	switch backend := os.Getenv("BLOB_STORE"); backend {
+	case "", "local":
+		dir := os.Getenv("ATTACHMENTS_DIR")
+		if dir == "" {
+			dir = filepath.Join("data", "attachments")
+		}
+		store, err := storage.NewLocalStore(dir)
+		if err != nil {
+			log.Fatalf("Failed to set up attachment storage: %v", err)
+		}
+		models.AttachmentStore = store
+	case "s3":
+		store, err := storage.NewS3Store(storage.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}, nil)
+		if err != nil {
+			log.Fatalf("Failed to set up attachment storage: %v", err)
+		}
+		models.AttachmentStore = store
+	default:
+		log.Fatalf("Unknown BLOB_STORE %q", backend)
+	}
+	if maxSize := os.Getenv("ATTACHMENT_MAX_SIZE"); maxSize != "" {
+		n, err := strconv.ParseInt(maxSize, 10, 64)
+		if err != nil || n <= 0 {
+			log.Fatalf("Invalid ATTACHMENT_MAX_SIZE %q", maxSize)
+		}
+		models.MaxAttachmentSize = n
+	}
+	go scheduler.NewThumbnailWorker(todoSession).Run(context.Background())
 
 	// Initialize router from routes package
 	workDir, _ := os.Getwd()
@@ -48,6 +181,9 @@ func main() {
 		Session:       todoSession,
 		Templates:     templates,
 		ComponentsDir: componentsDir,
+		Mailer:        notifiers[models.ChannelEmail],
+		Broker:        models.EventBroker,
+		Presence:      realtime.NewMemoryPresenceStore(),
 	}
 
 	router := routes.NewRouter(routerConfig)
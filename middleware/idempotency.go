@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// maxIdempotentBody returns the bound on the request bodies buffered for
+// hashing: 32 MiB, or more if attachment uploads may be larger.
+func maxIdempotentBody() int64 {
+	// Leave room for the multipart framing around the file.
+	if limit := models.MaxAttachmentSize + 1<<20; limit > 32<<20 {
+		return limit
+	}
+	return 32 << 20
+}
+
+// replayedHeaders are the response headers stored with an idempotent
+// response and sent again on replay.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// replayMode says what is stored of the responses of a route for replay.
+type replayMode int
+
+const (
+	replayResponse replayMode = iota
+	replayStatus
+	replayNothing
+)
+
+// ReplayStatusOnly marks a handler whose responses hold secrets, such as
+// tokens, that are only stored hashed: retries get the status and Location
+// of the first response, without its body.
+func ReplayStatusOnly(next http.HandlerFunc) http.HandlerFunc {
+	return withReplayMode(next, replayStatus)
+}
+
+// NotReplayed marks a handler whose responses hold secrets and which is safe
+// to run again, such as logging in: nothing is stored, and once the handler
+// has run the key is released for retries.
+func NotReplayed(next http.HandlerFunc) http.HandlerFunc {
+	return withReplayMode(next, replayNothing)
+}
+
+func withReplayMode(next http.HandlerFunc, mode replayMode) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m, ok := r.Context().Value(replayModeKey).(*replayMode); ok {
+			*m = mode
+		}
+		next(w, r)
+	}
+}
+
+// IdempotencyMiddleware makes POST requests carrying an Idempotency-Key
+// header safe to retry. The first response for a user and key is stored and
+// replayed to retries of the same request, except on routes marked with
+// ReplayStatusOnly or NotReplayed. Reusing a key for a different request
+// fails with 422, and a retry that arrives while the first request is still
+// running gets 409 with a Retry-After header. It runs on every route, ahead
+// of AuthMiddleware: requests with a valid token are keyed by their user.
+// Anonymous ones, such as registering or logging in, cannot be told apart,
+// so their keys are scoped by the request itself. Requests with an invalid
+// token are passed through untouched for AuthMiddleware to reject.
+func IdempotencyMiddleware(session *gocql.Session) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if r.Method != http.MethodPost || key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			var userID gocql.UUID
+			if token := r.Header.Get("Authorization"); token != "" {
+				var err error
+				if userID, err = parseToken(token); err != nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			if len(key) > 255 {
+				writeJSONError(w, http.StatusBadRequest, "Idempotency-Key cannot be longer than 255 characters")
+				return
+			}
+
+			limit := maxIdempotentBody()
+			body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+			if err != nil || int64(len(body)) > limit {
+				writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			hash := sha256.New()
+			io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+			hash.Write(body)
+			requestHash := hex.EncodeToString(hash.Sum(nil))
+			if userID == (gocql.UUID{}) {
+				key += " " + requestHash
+			}
+
+			record, claimed, err := models.ClaimIdempotencyKey(session, userID, key, requestHash)
+			if err != nil {
+				log.Printf("Failed to claim idempotency key: %v", err)
+				writeJSONError(w, http.StatusInternalServerError, "Failed to process request")
+				return
+			}
+			if !claimed {
+				replay(w, record, requestHash)
+				return
+			}
+
+			mode := new(replayMode)
+			rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), replayModeKey, mode)))
+
+			// Server errors are not stored, so the client can retry them
+			if rec.status >= 500 || *mode == replayNothing {
+				err = record.Release(session)
+			} else {
+				headers := make(map[string]string)
+				for _, name := range replayedHeaders {
+					if value := rec.Header().Get(name); value != "" {
+						headers[name] = value
+					}
+				}
+				body := rec.body.Bytes()
+				if *mode == replayStatus {
+					delete(headers, "Content-Type")
+					body = nil
+				}
+				err = record.Complete(session, rec.status, headers, body)
+			}
+			if err != nil {
+				log.Printf("Failed to store idempotent response: %v", err)
+			}
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, record *models.IdempotencyRecord, requestHash string) {
+	switch {
+	case record.RequestHash != requestHash:
+		writeJSONError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+	case record.State != models.IdempotencyCompleted:
+		w.Header().Set("Retry-After", "1")
+		writeJSONError(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+	default:
+		for name, value := range record.Headers {
+			w.Header().Set(name, value)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(record.StatusCode)
+		w.Write(record.Body)
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(p)
+	return rec.ResponseWriter.Write(p)
+}
+
+// writeJSONError writes an error in the API's response format.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "error",
+		"message": message,
+	})
+}
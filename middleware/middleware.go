@@ -2,6 +2,9 @@ package middleware
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -10,53 +13,45 @@ import (
 
 	"github.com/gocql/gocql"
 	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
 )
 
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey     contextKey = "userID"
+	requestIDKey  contextKey = "requestID"
+	replayModeKey contextKey = "replayMode"
+)
+
+var jwtSecret = []byte("your-secret-key")
+
+// IssueToken returns a signed token authenticating the user for a day.
+func IssueToken(userID gocql.UUID) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID.String(),
+		"exp":     time.Now().Add(24 * time.Hour).Unix(),
+	})
+	return token.SignedString(jwtSecret)
+}
 
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get("Authorization")
+		if tokenString == "" && (r.Header.Get("Accept") == "text/event-stream" || websocket.IsWebSocketUpgrade(r)) {
+			// EventSource and WebSocket cannot set headers, so event streams
+			// and collaboration sockets pass the token in the query string.
+			tokenString = r.URL.Query().Get("access_token")
+		}
 		if tokenString == "" {
 			log.Printf("No Authorization header found")
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		// Remove "Bearer " prefix
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-
-		// Parse and validate token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte("your-secret-key"), nil
-		})
-
-		if err != nil || !token.Valid {
-			log.Printf("Invalid token: %v", err)
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		// Extract user_id from claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			log.Printf("Invalid token claims")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		userIDStr, ok := claims["user_id"].(string)
-		if !ok {
-			log.Printf("No user_id in token claims")
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
-
-		userID, err := gocql.ParseUUID(userIDStr)
+		userID, err := parseToken(tokenString)
 		if err != nil {
-			log.Printf("Invalid user_id in token: %v", err)
+			log.Printf("Invalid token: %v", err)
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -67,12 +62,64 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// parseToken validates a bearer token and returns the user it
+// authenticates.
+func parseToken(tokenString string) (gocql.UUID, error) {
+	// Remove "Bearer " prefix
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	// Parse and validate token
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	})
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+	if !token.Valid {
+		return gocql.UUID{}, errors.New("token is not valid")
+	}
+
+	// Extract user_id from claims
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return gocql.UUID{}, errors.New("invalid token claims")
+	}
+	userIDStr, ok := claims["user_id"].(string)
+	if !ok {
+		return gocql.UUID{}, errors.New("no user_id in token claims")
+	}
+	return gocql.ParseUUID(userIDStr)
+}
+
 // GetUserID retrieves user ID from context
 func GetUserID(ctx context.Context) (gocql.UUID, bool) {
 	id, ok := ctx.Value(userIDKey).(gocql.UUID)
 	return id, ok
 }
 
+// RequestIDMiddleware tags each request with an ID, taken from the
+// X-Request-ID header when the client sends one, and echoes it back.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" || len(requestID) > 128 {
+			buf := make([]byte, 16)
+			rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey, requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetRequestID retrieves the request ID from context
+func GetRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
 func LoggingMiddleware(next http.Handler) http.Handler {
 	// Create logs directory if it doesn't exist
 	os.MkdirAll("logs", 0755)
@@ -88,7 +135,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		logger.Printf("Request: %s %s", r.Method, r.URL.Path)
+		logger.Printf("Request: %s %s (%s)", r.Method, r.URL.Path, GetRequestID(r.Context()))
 		logger.Printf("Headers: %v", r.Header)
 		logger.Printf("Body: %v", r.Body)
 
@@ -0,0 +1,189 @@
+package models
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/gocql/gocql"
+)
+
+// addAssigneeIndex queues the index rows for the given assignees of the task.
+func addAssigneeIndex(batch *gocql.Batch, t *Task, assignees []gocql.UUID) {
+	for _, userID := range assignees {
+		batch.Query(`INSERT INTO tasks_by_assignee (user_id, task_id, workspace_id) VALUES (?, ?, ?)`,
+			userID, t.TaskID, t.WorkspaceID)
+	}
+}
+
+// removeAssigneeIndex queues the removal of the index rows for the given
+// assignees of the task.
+func removeAssigneeIndex(batch *gocql.Batch, t *Task, assignees []gocql.UUID) {
+	for _, userID := range assignees {
+		batch.Query(`DELETE FROM tasks_by_assignee WHERE user_id = ? AND task_id = ?`, userID, t.TaskID)
+	}
+}
+
+// syncAssigneeIndex brings the index in line with the task's assignees after
+// they changed from previous, and returns the users newly assigned.
+func syncAssigneeIndex(session *gocql.Session, t *Task, previous []gocql.UUID) ([]gocql.UUID, error) {
+	added := missingUUIDs(t.Assignees, previous)
+	batch := session.NewBatch(gocql.LoggedBatch)
+	removeAssigneeIndex(batch, t, missingUUIDs(previous, t.Assignees))
+	addAssigneeIndex(batch, t, added)
+	return added, session.ExecuteBatch(batch)
+}
+
+// missingUUIDs returns the IDs in a that are not in b.
+func missingUUIDs(a, b []gocql.UUID) []gocql.UUID {
+	in := make(map[gocql.UUID]bool, len(b))
+	for _, id := range b {
+		in[id] = true
+	}
+	var missing []gocql.UUID
+	for _, id := range a {
+		if !in[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func containsUUID(ids []gocql.UUID, id gocql.UUID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyAssignees tells the users newly assigned to the task, other than the
+// actor, about it. Failures are logged; the assignment stands either way.
+func notifyAssignees(session *gocql.Session, t *Task, assignees []gocql.UUID, actor Actor) {
+	if len(assignees) == 0 {
+		return
+	}
+	assigner := "Someone"
+	if user, err := GetUserByID(session, actor.UserID); err == nil {
+		assigner = user.Username
+	}
+	body := ""
+	if t.DueAt != nil {
+		body = "Due " + t.DueAt.UTC().Format("January 2, 2006 15:04 MST")
+	}
+
+	taskID := t.TaskID
+	for _, userID := range assignees {
+		if userID == actor.UserID {
+			continue
+		}
+		notification := &Notification{
+			UserID: userID,
+			Kind:   "assignment",
+			Title:  fmt.Sprintf("%s assigned you to %q", assigner, t.Title),
+			Body:   body,
+			TaskID: &taskID,
+		}
+		if err := notification.Create(session); err != nil {
+			log.Printf("Failed to notify user %s of assignment: %v", userID, err)
+		}
+	}
+}
+
+// GetTasksAssignedTo returns the tasks assigned to the user in all of their
+// workspaces, those due soonest first and undated ones last. Tasks in
+// workspaces the user has left are not listed.
+func GetTasksAssignedTo(session *gocql.Session, userID gocql.UUID) ([]*Task, error) {
+	var ids []gocql.UUID
+	iter := session.Query(`SELECT task_id FROM tasks_by_assignee WHERE user_id = ?`, userID).Iter()
+	var id gocql.UUID
+	for iter.Scan(&id) {
+		ids = append(ids, id)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []*Task{}, nil
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE task_id IN ?`
+	tasks, err := scanTasks(session.Query(query, ids).Iter())
+	if err != nil {
+		return nil, err
+	}
+
+	assigned := []*Task{}
+	byWorkspace := make(map[gocql.UUID][]*Task)
+	roles := make(map[gocql.UUID]string)
+	for _, task := range withoutTrashed(tasks) {
+		if !containsUUID(task.Assignees, userID) {
+			continue
+		}
+		role, ok := roles[task.WorkspaceID]
+		if !ok {
+			if role, err = GetWorkspaceRole(session, task.WorkspaceID, userID); err != nil {
+				return nil, err
+			}
+			roles[task.WorkspaceID] = role
+		}
+		if role == "" {
+			continue
+		}
+		assigned = append(assigned, task)
+		byWorkspace[task.WorkspaceID] = append(byWorkspace[task.WorkspaceID], task)
+	}
+
+	for workspaceID, group := range byWorkspace {
+		workflow, err := GetWorkflow(session, workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		deps, err := GetDependenciesByWorkspaceID(session, workspaceID)
+		if err != nil {
+			return nil, err
+		}
+		applyBlocked(group, deps, workflow)
+	}
+
+	sortByDueDate(assigned)
+	return assigned, nil
+}
+
+// sortByDueDate orders tasks by due date, undated ones last, and tasks due
+// at the same time by creation.
+func sortByDueDate(tasks []*Task) {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if (a.DueAt == nil) != (b.DueAt == nil) {
+			return a.DueAt != nil
+		}
+		if a.DueAt != nil && !a.DueAt.Equal(*b.DueAt) {
+			return a.DueAt.Before(*b.DueAt)
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+}
+
+// MigrateAssigneeIndex fills tasks_by_assignee from the assignees of the
+// tasks that are not in the trash.
+func MigrateAssigneeIndex(session *gocql.Session) error {
+	iter := session.Query(`SELECT ` + taskColumns + ` FROM tasks`).Iter()
+	for {
+		task := &Task{}
+		if !iter.Scan(task.scanDest()...) {
+			break
+		}
+		if task.DeletedAt != nil || len(task.Assignees) == 0 {
+			continue
+		}
+		batch := session.NewBatch(gocql.LoggedBatch)
+		addAssigneeIndex(batch, task, task.Assignees)
+		if err := session.ExecuteBatch(batch); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
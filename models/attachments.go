@@ -0,0 +1,265 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"todo-app/storage"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+)
+
+// AttachmentStore holds the contents of attachments. It must be set before
+// attachments are used.
+var AttachmentStore storage.BlobStore
+
+// MaxAttachmentSize is the largest file that can be attached, in bytes.
+var MaxAttachmentSize int64 = 25 << 20
+
+// AttachmentTypes are the media types of files that can be attached.
+var AttachmentTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"application/pdf":          true,
+	"text/plain":               true,
+	"text/csv":                 true,
+	"text/markdown":            true,
+	"application/zip":          true,
+	"application/msword":       true,
+	"application/vnd.ms-excel": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+}
+
+var (
+	ErrInvalidAttachment  = errors.New("invalid attachment")
+	ErrAttachmentTooLarge = errors.New("attachment too large")
+)
+
+// Attachment is a file attached to a task.
+type Attachment struct {
+	AttachmentID gocql.UUID `json:"attachment_id"`
+	TaskID       gocql.UUID `json:"task_id"`
+	UserID       gocql.UUID `json:"user_id"`
+	FileName     string     `json:"file_name"`
+	ContentType  string     `json:"content_type"`
+	Size         int64      `json:"size"`
+	// SHA256 is the hex-encoded SHA-256 hash of the contents.
+	SHA256     string    `json:"sha256"`
+	StorageKey string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Thumbnails maps the generated thumbnail sizes to their length in bytes.
+	Thumbnails map[string]int64 `json:"-"`
+	// ThumbnailError says why no thumbnails could be made of the attachment.
+	ThumbnailError string `json:"-"`
+}
+
+const attachmentColumns = `attachment_id, task_id, user_id, file_name, content_type, size, sha256, storage_key,
+	created_at, thumbnails, thumbnail_error`
+
+func (a *Attachment) scanDest() []interface{} {
+	return []interface{}{
+		&a.AttachmentID,
+		&a.TaskID,
+		&a.UserID,
+		&a.FileName,
+		&a.ContentType,
+		&a.Size,
+		&a.SHA256,
+		&a.StorageKey,
+		&a.CreatedAt,
+		&a.Thumbnails,
+		&a.ThumbnailError,
+	}
+}
+
+// Create stores the contents read from r and attaches them to the task on
+// behalf of the actor. FileName and ContentType are taken from the client; when ContentType is
+// missing or generic it is detected from the contents.
+func (a *Attachment) Create(ctx context.Context, session *gocql.Session, task *Task, r io.Reader, actor Actor) error {
+	name, err := attachmentFileName(a.FileName)
+	if err != nil {
+		return err
+	}
+
+	// The file is spooled to disk first so it can be checked and hashed
+	// before it reaches the store, which also needs its size up front.
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, MaxAttachmentSize+1))
+	if err != nil {
+		return err
+	}
+	if size > MaxAttachmentSize {
+		return fmt.Errorf("%w: the limit is %d bytes", ErrAttachmentTooLarge, MaxAttachmentSize)
+	}
+	if size == 0 {
+		return fmt.Errorf("%w: file is empty", ErrInvalidAttachment)
+	}
+
+	head := make([]byte, 512)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	contentType, err := attachmentContentType(a.ContentType, head[:n])
+	if err != nil {
+		return err
+	}
+
+	a.AttachmentID = gocql.TimeUUID()
+	a.TaskID = task.TaskID
+	a.UserID = actor.UserID
+	a.FileName = name
+	a.ContentType = contentType
+	a.Size = size
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	a.StorageKey = fmt.Sprintf("attachments/%s/%s", a.TaskID, a.AttachmentID)
+	a.CreatedAt = time.Now().UTC()
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := AttachmentStore.Put(ctx, a.StorageKey, tmp, a.Size, a.ContentType); err != nil {
+		return err
+	}
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO attachments (attachment_id, task_id, user_id, file_name, content_type, size, sha256,
+             storage_key, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.AttachmentID, a.TaskID, a.UserID, a.FileName, a.ContentType, a.Size, a.SHA256, a.StorageKey, a.CreatedAt)
+	if a.HasThumbnails() {
+		batch.Query(insertThumbnailJobQuery, a.TaskID, a.AttachmentID, a.CreatedAt)
+	}
+	if err := session.ExecuteBatch(batch); err != nil {
+		AttachmentStore.Delete(ctx, a.StorageKey)
+		return err
+	}
+	return nil
+}
+
+// attachmentFileName strips any directories from a client-supplied file name.
+func attachmentFileName(name string) (string, error) {
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, `\`, "/")))
+	if name == "" || name == "." || name == "/" {
+		return "", fmt.Errorf("%w: file name is required", ErrInvalidAttachment)
+	}
+	if utf8.RuneCountInString(name) > 255 {
+		return "", fmt.Errorf("%w: file name cannot be longer than 255 characters", ErrInvalidAttachment)
+	}
+	return name, nil
+}
+
+// attachmentContentType picks the media type of an attachment from the
+// declared type and the first bytes of its contents. Images must really be
+// images of the declared type.
+func attachmentContentType(declared string, head []byte) (string, error) {
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	contentType := sniffed
+	if declared != "" {
+		mediaType, _, err := mime.ParseMediaType(declared)
+		if err != nil {
+			return "", fmt.Errorf("%w: invalid content type %q", ErrInvalidAttachment, declared)
+		}
+		if mediaType != "application/octet-stream" {
+			contentType = mediaType
+		}
+	}
+
+	if !AttachmentTypes[contentType] {
+		return "", fmt.Errorf("%w: files of type %s cannot be attached", ErrInvalidAttachment, contentType)
+	}
+	if strings.HasPrefix(contentType, "image/") && sniffed != contentType {
+		return "", fmt.Errorf("%w: contents are not %s", ErrInvalidAttachment, contentType)
+	}
+	return contentType, nil
+}
+
+func GetAttachment(session *gocql.Session, taskID, attachmentID gocql.UUID) (*Attachment, error) {
+	a := &Attachment{}
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE task_id = ? AND attachment_id = ?`
+	if err := session.Query(query, taskID, attachmentID).Scan(a.scanDest()...); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetAttachmentsByTaskID returns the task's attachments, oldest first.
+func GetAttachmentsByTaskID(session *gocql.Session, taskID gocql.UUID) ([]*Attachment, error) {
+	attachments := []*Attachment{}
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE task_id = ?`
+	iter := session.Query(query, taskID).Iter()
+	for {
+		a := &Attachment{}
+		if !iter.Scan(a.scanDest()...) {
+			break
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, iter.Close()
+}
+
+// Open returns the contents of the attachment. It returns storage.ErrNotFound
+// if they are missing from the store.
+func (a *Attachment) Open(ctx context.Context) (*storage.ReadSeeker, error) {
+	return storage.OpenReadSeeker(ctx, AttachmentStore, a.StorageKey, a.Size)
+}
+
+// Delete removes the attachment and its thumbnails. The metadata goes first,
+// so the contents are never referenced once they are gone.
+func (a *Attachment) Delete(ctx context.Context, session *gocql.Session) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM attachments WHERE task_id = ? AND attachment_id = ?`, a.TaskID, a.AttachmentID)
+	batch.Query(deleteThumbnailJobQuery, a.TaskID, a.AttachmentID)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	return a.deleteContents(ctx)
+}
+
+// deleteContents removes the attachment's blobs from the store.
+func (a *Attachment) deleteContents(ctx context.Context) error {
+	for size := range ThumbnailSizes {
+		if err := AttachmentStore.Delete(ctx, a.thumbnailKey(size)); err != nil {
+			return err
+		}
+	}
+	return AttachmentStore.Delete(ctx, a.StorageKey)
+}
+
+// deleteTaskAttachments removes all attachments of a task. Unlike Delete it
+// removes the contents first, so an interrupted run can be repeated.
+func deleteTaskAttachments(ctx context.Context, session *gocql.Session, taskID gocql.UUID) error {
+	attachments, err := GetAttachmentsByTaskID(session, taskID)
+	if err != nil {
+		return err
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	for _, a := range attachments {
+		if err := a.deleteContents(ctx); err != nil {
+			return err
+		}
+		batch.Query(deleteThumbnailJobQuery, a.TaskID, a.AttachmentID)
+	}
+	batch.Query(`DELETE FROM attachments WHERE task_id = ?`, taskID)
+	return session.ExecuteBatch(batch)
+}
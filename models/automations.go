@@ -0,0 +1,707 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"todo-app/eventbus"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+)
+
+// Triggers of automation rules.
+const (
+	TriggerTaskCreated   = "task_created"
+	TriggerStatusChanged = "status_changed"
+	TriggerDuePassed     = "due_passed"
+	TriggerTagAdded      = "tag_added"
+)
+
+// Actions of automation rules.
+const (
+	ActionSetStatus    = "set_status"
+	ActionAddTag       = "add_tag"
+	ActionMoveCategory = "move_category"
+	ActionCreateTask   = "create_task"
+	ActionCallWebhook  = "call_webhook"
+)
+
+// Outcomes of automation runs. A run whose instance died midway stays
+// running rather than risk applying the rule twice.
+const (
+	AutomationRunRunning = "running"
+	AutomationRunApplied = "applied"
+	AutomationRunFailed  = "failed"
+)
+
+const (
+	// MaxAutomationRules caps the rules of a workspace.
+	MaxAutomationRules = 50
+
+	// MaxAutomationActions caps the actions of a rule.
+	MaxAutomationActions = 10
+
+	// MaxAutomationDepth is how many rules may fire one after the other,
+	// each on a change made by the previous one. It keeps rules that set
+	// each other off from running forever.
+	MaxAutomationDepth = 3
+
+	// MaxFollowUpDays caps how far ahead a follow-up task may be due.
+	MaxFollowUpDays = 365
+)
+
+// AutomationRunRetention is how long the runs of a rule are kept in its log.
+var AutomationRunRetention = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidAutomation  = errors.New("invalid automation rule")
+	ErrAutomationNotFound = errors.New("automation rule not found")
+)
+
+// AutomationTrigger is what sets a rule off. Status narrows status_changed
+// to changes to that status and Tag narrows tag_added to that tag; left
+// empty, any status or tag will do. The occurrence of a trigger is described
+// the same way, with the status changed to or the tag added.
+type AutomationTrigger struct {
+	Type   string `json:"type"`
+	Status string `json:"status,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+}
+
+// covers reports whether the occurrence sets off rules with the trigger.
+func (tr AutomationTrigger) covers(occurred AutomationTrigger) bool {
+	return tr.Type == occurred.Type &&
+		(tr.Status == "" || tr.Status == occurred.Status) &&
+		(tr.Tag == "" || tr.Tag == occurred.Tag)
+}
+
+// normalize checks the trigger against the workspace's workflow.
+func (tr *AutomationTrigger) normalize(workflow *Workflow) error {
+	switch tr.Type {
+	case TriggerTaskCreated, TriggerDuePassed:
+		if tr.Status != "" || tr.Tag != "" {
+			return fmt.Errorf("%w: %s takes no status or tag", ErrInvalidAutomation, tr.Type)
+		}
+	case TriggerStatusChanged:
+		if tr.Tag != "" {
+			return fmt.Errorf("%w: %s takes no tag", ErrInvalidAutomation, tr.Type)
+		}
+		if tr.Status == "" {
+			return nil
+		}
+		tr.Status = normalizeStatusKey(tr.Status)
+		if _, ok := workflow.Status(tr.Status); !ok {
+			return fmt.Errorf("%w: unknown status %q", ErrInvalidAutomation, tr.Status)
+		}
+	case TriggerTagAdded:
+		if tr.Status != "" {
+			return fmt.Errorf("%w: %s takes no status", ErrInvalidAutomation, tr.Type)
+		}
+		if tr.Tag == "" {
+			return nil
+		}
+		tag, err := NormalizeTag(tr.Tag)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAutomation, err)
+		}
+		tr.Tag = tag
+	default:
+		return fmt.Errorf("%w: unknown trigger %q", ErrInvalidAutomation, tr.Type)
+	}
+	return nil
+}
+
+// AutomationAction is a step of a rule. set_status takes Status, add_tag
+// Tag, move_category CategoryID and call_webhook the WebhookID of an
+// endpoint of the workspace. create_task creates a follow-up task in the
+// task's project and category titled Title, in which {{title}} stands for
+// the task's title, and due DueInDays days after it is created if set.
+type AutomationAction struct {
+	Type       string      `json:"type"`
+	Status     string      `json:"status,omitempty"`
+	Tag        string      `json:"tag,omitempty"`
+	CategoryID *gocql.UUID `json:"category_id,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	DueInDays  *int        `json:"due_in_days,omitempty"`
+	WebhookID  *gocql.UUID `json:"webhook_id,omitempty"`
+}
+
+// normalize checks the action and drops the fields its type does not take.
+func (a *AutomationAction) normalize(session *gocql.Session, workspaceID gocql.UUID, workflow *Workflow) error {
+	switch a.Type {
+	case ActionSetStatus:
+		status := normalizeStatusKey(a.Status)
+		if _, ok := workflow.Status(status); !ok {
+			return fmt.Errorf("%w: %s needs a status of the workflow", ErrInvalidAutomation, a.Type)
+		}
+		*a = AutomationAction{Type: a.Type, Status: status}
+	case ActionAddTag:
+		tag, err := NormalizeTag(a.Tag)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAutomation, err)
+		}
+		*a = AutomationAction{Type: a.Type, Tag: tag}
+	case ActionMoveCategory:
+		if a.CategoryID == nil {
+			return fmt.Errorf("%w: %s needs a category_id", ErrInvalidAutomation, a.Type)
+		}
+		if err := checkCategory(session, workspaceID, *a.CategoryID); err == ErrCategoryNotFound {
+			return fmt.Errorf("%w: %v", ErrInvalidAutomation, err)
+		} else if err != nil {
+			return err
+		}
+		*a = AutomationAction{Type: a.Type, CategoryID: a.CategoryID}
+	case ActionCreateTask:
+		title := strings.TrimSpace(a.Title)
+		if title == "" || utf8.RuneCountInString(title) > 200 {
+			return fmt.Errorf("%w: %s needs a title of at most 200 characters", ErrInvalidAutomation, a.Type)
+		}
+		if a.DueInDays != nil && (*a.DueInDays < 0 || *a.DueInDays > MaxFollowUpDays) {
+			return fmt.Errorf("%w: due_in_days must be between 0 and %d", ErrInvalidAutomation, MaxFollowUpDays)
+		}
+		*a = AutomationAction{Type: a.Type, Title: title, DueInDays: a.DueInDays}
+	case ActionCallWebhook:
+		if a.WebhookID == nil {
+			return fmt.Errorf("%w: %s needs a webhook_id", ErrInvalidAutomation, a.Type)
+		}
+		if _, err := GetWebhook(session, workspaceID, *a.WebhookID); err == ErrWebhookNotFound {
+			return fmt.Errorf("%w: %v", ErrInvalidAutomation, err)
+		} else if err != nil {
+			return err
+		}
+		*a = AutomationAction{Type: a.Type, WebhookID: a.WebhookID}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidAutomation, a.Type)
+	}
+	return nil
+}
+
+// AutomationRule applies its actions to a task when its trigger occurs and
+// the task, as it was then, matches its conditions, a task filter
+// expression. Rules run in the background, on behalf of the member who
+// created them, and only while that member may still edit the workspace's
+// tasks.
+type AutomationRule struct {
+	WorkspaceID gocql.UUID         `json:"workspace_id"`
+	RuleID      gocql.UUID         `json:"rule_id"`
+	Name        string             `json:"name"`
+	Enabled     bool               `json:"enabled"`
+	Trigger     AutomationTrigger  `json:"trigger"`
+	Conditions  string             `json:"conditions"`
+	Actions     []AutomationAction `json:"actions"`
+	CreatedBy   gocql.UUID         `json:"created_by"`
+	CreatedAt   time.Time          `json:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at"`
+
+	filter *TaskFilter
+}
+
+const automationRuleColumns = `workspace_id, rule_id, name, enabled, trigger_type, trigger_status, trigger_tag,
+	conditions, actions, created_by, created_at, updated_at`
+
+// Create saves the rule from the actor.
+func (rule *AutomationRule) Create(session *gocql.Session, actor Actor) error {
+	if err := rule.validate(session); err != nil {
+		return err
+	}
+	existing, err := GetAutomationRules(session, rule.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	if len(existing) >= MaxAutomationRules {
+		return fmt.Errorf("%w: a workspace can have at most %d rules", ErrInvalidAutomation, MaxAutomationRules)
+	}
+
+	rule.RuleID = gocql.TimeUUID()
+	rule.CreatedBy = actor.UserID
+	rule.CreatedAt = rule.RuleID.Time().UTC()
+	rule.UpdatedAt = rule.CreatedAt
+	return rule.save(session)
+}
+
+// Update saves the rule's name, trigger, conditions, actions and whether it
+// is enabled.
+func (rule *AutomationRule) Update(session *gocql.Session) error {
+	if err := rule.validate(session); err != nil {
+		return err
+	}
+	rule.UpdatedAt = time.Now().UTC()
+	return rule.save(session)
+}
+
+func (rule *AutomationRule) save(session *gocql.Session) error {
+	actions, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO automation_rules (` + automationRuleColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	return session.Query(query, rule.WorkspaceID, rule.RuleID, rule.Name, rule.Enabled, rule.Trigger.Type,
+		rule.Trigger.Status, rule.Trigger.Tag, rule.Conditions, string(actions), rule.CreatedBy, rule.CreatedAt,
+		rule.UpdatedAt).Exec()
+}
+
+func (rule *AutomationRule) Delete(session *gocql.Session) error {
+	query := `DELETE FROM automation_rules WHERE workspace_id = ? AND rule_id = ?`
+	return session.Query(query, rule.WorkspaceID, rule.RuleID).Exec()
+}
+
+func (rule *AutomationRule) validate(session *gocql.Session) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidAutomation)
+	}
+	if utf8.RuneCountInString(rule.Name) > 100 {
+		return fmt.Errorf("%w: name cannot be longer than 100 characters", ErrInvalidAutomation)
+	}
+	workflow, err := GetWorkflow(session, rule.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	if err := rule.Trigger.normalize(workflow); err != nil {
+		return err
+	}
+	filter, err := ParseTaskFilter(rule.Conditions)
+	if err != nil {
+		return fmt.Errorf("%w: conditions: %v", ErrInvalidAutomation, err)
+	}
+	rule.Conditions = filter.String()
+	rule.filter = filter
+
+	if len(rule.Actions) == 0 {
+		return fmt.Errorf("%w: at least one action is required", ErrInvalidAutomation)
+	}
+	if len(rule.Actions) > MaxAutomationActions {
+		return fmt.Errorf("%w: a rule can have at most %d actions", ErrInvalidAutomation, MaxAutomationActions)
+	}
+	for i := range rule.Actions {
+		if err := rule.Actions[i].normalize(session, rule.WorkspaceID, workflow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAutomationRule returns a rule of the workspace or ErrAutomationNotFound.
+func GetAutomationRule(session *gocql.Session, workspaceID, ruleID gocql.UUID) (*AutomationRule, error) {
+	query := `SELECT ` + automationRuleColumns + ` FROM automation_rules WHERE workspace_id = ? AND rule_id = ?`
+	rules, err := scanAutomationRules(session.Query(query, workspaceID, ruleID).Iter())
+	if err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, ErrAutomationNotFound
+	}
+	return rules[0], nil
+}
+
+// GetAutomationRules returns the workspace's rules, oldest first.
+func GetAutomationRules(session *gocql.Session, workspaceID gocql.UUID) ([]*AutomationRule, error) {
+	query := `SELECT ` + automationRuleColumns + ` FROM automation_rules WHERE workspace_id = ?`
+	return scanAutomationRules(session.Query(query, workspaceID).Iter())
+}
+
+func scanAutomationRules(iter *gocql.Iter) ([]*AutomationRule, error) {
+	rules := []*AutomationRule{}
+	for {
+		rule := &AutomationRule{}
+		var actions string
+		if !iter.Scan(&rule.WorkspaceID, &rule.RuleID, &rule.Name, &rule.Enabled, &rule.Trigger.Type,
+			&rule.Trigger.Status, &rule.Trigger.Tag, &rule.Conditions, &actions, &rule.CreatedBy, &rule.CreatedAt,
+			&rule.UpdatedAt) {
+			break
+		}
+		if err := json.Unmarshal([]byte(actions), &rule.Actions); err != nil {
+			iter.Close()
+			return nil, fmt.Errorf("failed to decode actions of rule %s: %v", rule.RuleID, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, iter.Close()
+}
+
+// triggeredBy reports whether any of the occurrences sets the rule off.
+func (rule *AutomationRule) triggeredBy(occurred []AutomationTrigger) bool {
+	for _, o := range occurred {
+		if rule.Trigger.covers(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the task matches the rule's conditions.
+func (rule *AutomationRule) matches(t *Task, workflow *Workflow, now time.Time) bool {
+	if rule.filter == nil {
+		filter, err := ParseTaskFilter(rule.Conditions)
+		if err != nil {
+			log.Printf("Rule %s has invalid conditions: %v", rule.RuleID, err)
+			return false
+		}
+		rule.filter = filter
+	}
+	return rule.filter.Matches(t, workflow, now)
+}
+
+// applyFields makes the rule's changes to the task's fields and reports
+// whether anything changed. The task's tags are copied before a tag is
+// added.
+func (rule *AutomationRule) applyFields(t *Task) bool {
+	changed := false
+	for _, a := range rule.Actions {
+		switch a.Type {
+		case ActionSetStatus:
+			if t.Status != a.Status {
+				t.Status = a.Status
+				changed = true
+			}
+		case ActionAddTag:
+			if !containsString(t.Tags, a.Tag) {
+				t.Tags = append(append([]string(nil), t.Tags...), a.Tag)
+				changed = true
+			}
+		case ActionMoveCategory:
+			if !sameUUID(t.CategoryID, a.CategoryID) {
+				id := *a.CategoryID
+				t.CategoryID = &id
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// RunAutomations fires the enabled rules of the task's workspace that the
+// occurrences set off and whose conditions the task, as it was when they
+// occurred, matches. eventID identifies the occurrences: each rule runs at
+// most once for them, however often they are handed over. depth is the
+// Automation of the change that caused them; from MaxAutomationDepth on no
+// rule fires. Failed actions are recorded in the rule's runs; the error
+// returned is about reading or recording the runs.
+func RunAutomations(session *gocql.Session, eventID gocql.UUID, task *Task, occurred []AutomationTrigger, depth int) error {
+	if len(occurred) == 0 {
+		return nil
+	}
+	rules, err := GetAutomationRules(session, task.WorkspaceID)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	workflow, err := GetWorkflow(session, task.WorkspaceID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		if !rule.Enabled || !rule.triggeredBy(occurred) || !rule.matches(task, workflow, now) {
+			continue
+		}
+		if depth >= MaxAutomationDepth {
+			log.Printf("Rule %s not run on task %s: %d rules already ran in a row", rule.RuleID, task.TaskID, depth)
+			continue
+		}
+		run := &AutomationRun{RuleID: rule.RuleID, EventID: eventID, TaskID: task.TaskID, Depth: depth}
+		claimed, err := run.claim(session)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		if err := run.finish(session, rule.apply(session, task.TaskID, depth)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply carries out the rule's actions on the task on behalf of its creator.
+// The changes to the task's fields are saved together, before the follow-up
+// task is created and the webhook called.
+func (rule *AutomationRule) apply(session *gocql.Session, taskID gocql.UUID, depth int) error {
+	role, err := GetWorkspaceRole(session, rule.WorkspaceID, rule.CreatedBy)
+	if err != nil {
+		return err
+	}
+	if !HasRole(role, RoleEditor) {
+		return errors.New("the rule's creator can no longer edit the workspace's tasks")
+	}
+	actor := Actor{
+		UserID:     rule.CreatedBy,
+		RequestID:  "automation:" + rule.RuleID.String(),
+		Automation: depth + 1,
+	}
+
+	task, err := rule.updateTask(session, taskID, actor)
+	if err != nil {
+		return err
+	}
+	for _, a := range rule.Actions {
+		switch a.Type {
+		case ActionCreateTask:
+			err = rule.createFollowUp(session, task, a, actor)
+		case ActionCallWebhook:
+			err = rule.callWebhook(session, task, a)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateTask applies the rule's changes to the task's fields as it is now,
+// trying again a couple of times if it changes in the meantime. It returns
+// the task as the rule left it.
+func (rule *AutomationRule) updateTask(session *gocql.Session, taskID gocql.UUID, actor Actor) (*Task, error) {
+	for attempt := 1; ; attempt++ {
+		task, err := GetTaskByID(session, taskID)
+		if err != nil {
+			return nil, fmt.Errorf("task unavailable: %v", err)
+		}
+		if !rule.applyFields(task) {
+			return task, nil
+		}
+		err = task.Update(session, actor)
+		if errors.Is(err, ErrVersionMismatch) && attempt < 3 {
+			continue
+		}
+		return task, err
+	}
+}
+
+func (rule *AutomationRule) createFollowUp(session *gocql.Session, task *Task, a AutomationAction, actor Actor) error {
+	followUp := NewTask(rule.CreatedBy, strings.ReplaceAll(a.Title, "{{title}}", task.Title), "", "")
+	followUp.WorkspaceID = task.WorkspaceID
+	followUp.ProjectID = task.ProjectID
+	followUp.CategoryID = task.CategoryID
+	if a.DueInDays != nil {
+		due := followUp.CreatedAt.AddDate(0, 0, *a.DueInDays)
+		followUp.DueAt = &due
+	}
+	if err := followUp.Create(session, actor); err != nil {
+		return fmt.Errorf("failed to create follow-up task: %w", err)
+	}
+	return nil
+}
+
+func (rule *AutomationRule) callWebhook(session *gocql.Session, task *Task, a AutomationAction) error {
+	h, err := GetWebhook(session, rule.WorkspaceID, *a.WebhookID)
+	if err != nil {
+		return err
+	}
+	if !h.Enabled {
+		return fmt.Errorf("webhook %s is disabled", h.WebhookID)
+	}
+	data, err := json.Marshal(map[string]interface{}{"rule_id": rule.RuleID, "rule": rule.Name, "task": task})
+	if err != nil {
+		return err
+	}
+	_, err = h.queue(session, gocql.TimeUUID(), WebhookAutomation, data)
+	return err
+}
+
+// AutomationRun is an entry of a rule's log: the rule firing for the event
+// EventID on a task, Depth rules into a chain.
+type AutomationRun struct {
+	RuleID     gocql.UUID `json:"rule_id"`
+	EventID    gocql.UUID `json:"event_id"`
+	TaskID     gocql.UUID `json:"task_id"`
+	Depth      int        `json:"depth"`
+	Outcome    string     `json:"outcome"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// claim records the start of the run with a lightweight transaction. It
+// returns false if the rule has already run for the event.
+func (run *AutomationRun) claim(session *gocql.Session) (bool, error) {
+	run.Outcome = AutomationRunRunning
+	run.StartedAt = time.Now().UTC()
+	query := `INSERT INTO automation_runs (rule_id, event_id, task_id, depth, outcome, started_at)
+             VALUES (?, ?, ?, ?, ?, ?) IF NOT EXISTS USING TTL ?`
+	return session.Query(query, run.RuleID, run.EventID, run.TaskID, run.Depth, run.Outcome, run.StartedAt,
+		int(AutomationRunRetention.Seconds())).MapScanCAS(map[string]interface{}{})
+}
+
+// finish records the outcome of the run; cause is nil if every action was
+// applied.
+func (run *AutomationRun) finish(session *gocql.Session, cause error) error {
+	now := time.Now().UTC()
+	run.FinishedAt = &now
+	run.Outcome = AutomationRunApplied
+	if cause != nil {
+		run.Outcome = AutomationRunFailed
+		run.Error = cause.Error()
+		log.Printf("Rule %s failed on task %s: %v", run.RuleID, run.TaskID, cause)
+	}
+	query := `UPDATE automation_runs USING TTL ? SET outcome = ?, error = ?, finished_at = ?
+             WHERE rule_id = ? AND event_id = ?`
+	return session.Query(query, int(AutomationRunRetention.Seconds()), run.Outcome, run.Error, run.FinishedAt,
+		run.RuleID, run.EventID).Exec()
+}
+
+// GetAutomationRuns returns up to limit of the rule's latest runs, newest
+// first.
+func GetAutomationRuns(session *gocql.Session, ruleID gocql.UUID, limit int) ([]*AutomationRun, error) {
+	runs := []*AutomationRun{}
+	query := `SELECT rule_id, event_id, task_id, depth, outcome, error, started_at, finished_at
+             FROM automation_runs WHERE rule_id = ? LIMIT ?`
+	iter := session.Query(query, ruleID, limit).Iter()
+	for {
+		run := &AutomationRun{}
+		if !iter.Scan(&run.RuleID, &run.EventID, &run.TaskID, &run.Depth, &run.Outcome, &run.Error,
+			&run.StartedAt, &run.FinishedAt) {
+			break
+		}
+		runs = append(runs, run)
+	}
+	return runs, iter.Close()
+}
+
+// AutomationDryRun tells whether a rule would fire for a task and, if it
+// would, how its changes would leave the task.
+type AutomationDryRun struct {
+	Rule            *AutomationRule `json:"rule"`
+	Triggered       bool            `json:"triggered"`
+	ConditionsMatch bool            `json:"conditions_match"`
+	WouldFire       bool            `json:"would_fire"`
+	Result          *Task           `json:"result,omitempty"`
+}
+
+// DryRunAutomations judges the rules of the task's workspace against the
+// task without applying any of them. Given an occurrence of a trigger, only
+// the rules it sets off are triggered; without one, every rule is judged as
+// if its own trigger had occurred. Disabled rules never fire.
+func DryRunAutomations(session *gocql.Session, task *Task, occurred *AutomationTrigger) ([]AutomationDryRun, error) {
+	workflow, err := GetWorkflow(session, task.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if occurred != nil {
+		if err := occurred.normalize(workflow); err != nil {
+			return nil, err
+		}
+	}
+	rules, err := GetAutomationRules(session, task.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	results := make([]AutomationDryRun, 0, len(rules))
+	for _, rule := range rules {
+		result := AutomationDryRun{
+			Rule:            rule,
+			Triggered:       occurred == nil || rule.Trigger.covers(*occurred),
+			ConditionsMatch: rule.matches(task, workflow, now),
+		}
+		result.WouldFire = rule.Enabled && result.Triggered && result.ConditionsMatch
+		if result.WouldFire {
+			after := *task
+			if rule.applyFields(&after) {
+				result.Result = &after
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// AutomationSubscriber is the name the automations are subscribed to the
+// event bus under.
+const AutomationSubscriber = "automations"
+
+// SubscribeAutomations runs the rules set off by task events and queues the
+// due dates of tasks for the due_passed rules.
+func SubscribeAutomations(bus *eventbus.Bus, session *gocql.Session) {
+	eventbus.Subscribe(bus, AutomationSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskCreated) error {
+		if err := queueDueTask(session, e.Task); err != nil {
+			return err
+		}
+		occurred := []AutomationTrigger{{Type: TriggerTaskCreated}}
+		return RunAutomations(session, meta.ID, e.Task, occurred, e.Automation)
+	})
+	eventbus.Subscribe(bus, AutomationSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskUpdated) error {
+		if containsString(e.Changed, "due_at") {
+			if err := queueDueTask(session, e.Task); err != nil {
+				return err
+			}
+		}
+		return RunAutomations(session, meta.ID, e.Task, tagsAdded(e.TagsAdded), e.Automation)
+	})
+	eventbus.Subscribe(bus, AutomationSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskStatusChanged) error {
+		if containsString(e.Changed, "due_at") {
+			if err := queueDueTask(session, e.Task); err != nil {
+				return err
+			}
+		}
+		occurred := append(tagsAdded(e.TagsAdded), AutomationTrigger{Type: TriggerStatusChanged, Status: e.To})
+		return RunAutomations(session, meta.ID, e.Task, occurred, e.Automation)
+	})
+	eventbus.Subscribe(bus, AutomationSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskRestored) error {
+		return queueDueTask(session, e.Task)
+	})
+}
+
+func tagsAdded(tags []string) []AutomationTrigger {
+	var occurred []AutomationTrigger
+	for _, tag := range tags {
+		occurred = append(occurred, AutomationTrigger{Type: TriggerTagAdded, Tag: tag})
+	}
+	return occurred
+}
+
+// PendingDueTask is an entry of the queue of due dates the automation
+// scheduler waits for.
+type PendingDueTask struct {
+	Bucket string
+	DueAt  time.Time
+	TaskID gocql.UUID
+}
+
+// DueTaskBucket returns the pending_due_tasks partition for a due date.
+func DueTaskBucket(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15")
+}
+
+// queueDueTask queues the task's due date, if it has one. Entries left
+// behind when the due date changes are discarded by the scheduler.
+func queueDueTask(session *gocql.Session, t *Task) error {
+	if t.DueAt == nil {
+		return nil
+	}
+	due := t.DueAt.UTC()
+	query := `INSERT INTO pending_due_tasks (bucket, due_at, task_id) VALUES (?, ?, ?)`
+	return session.Query(query, DueTaskBucket(due), due, t.TaskID).Exec()
+}
+
+// GetDueTasks returns the queue entries in the bucket that are due by now.
+func GetDueTasks(session *gocql.Session, bucket string, now time.Time) ([]PendingDueTask, error) {
+	var pending []PendingDueTask
+	query := `SELECT bucket, due_at, task_id FROM pending_due_tasks WHERE bucket = ? AND due_at <= ?`
+	iter := session.Query(query, bucket, now).Iter()
+	var p PendingDueTask
+	for iter.Scan(&p.Bucket, &p.DueAt, &p.TaskID) {
+		pending = append(pending, p)
+	}
+	return pending, iter.Close()
+}
+
+func DeletePendingDueTask(session *gocql.Session, p PendingDueTask) error {
+	query := `DELETE FROM pending_due_tasks WHERE bucket = ? AND due_at = ? AND task_id = ?`
+	return session.Query(query, p.Bucket, p.DueAt, p.TaskID).Exec()
+}
+
+// ClaimDueTask removes the entry from the queue with a lightweight
+// transaction, so that only one instance handles it. It returns false if
+// another instance got there first.
+func ClaimDueTask(session *gocql.Session, p PendingDueTask) (bool, error) {
+	query := `DELETE FROM pending_due_tasks WHERE bucket = ? AND due_at = ? AND task_id = ? IF EXISTS`
+	return session.Query(query, p.Bucket, p.DueAt, p.TaskID).MapScanCAS(map[string]interface{}{})
+}
@@ -1,58 +1,300 @@
 package models
 
 import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gocql/gocql"
 )
 
+var ErrInvalidCategory = errors.New("invalid category")
+
+// Category groups tasks of a workspace.
 type Category struct {
-	CategoryID gocql.UUID `json:"category_id"`
-	Name       string     `json:"name"`
-	CreatedAt  time.Time  `json:"created_at"`
+	CategoryID  gocql.UUID `json:"category_id"`
+	WorkspaceID gocql.UUID `json:"workspace_id"`
+	Name        string     `json:"name"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Version     int        `json:"version"`
+
+	// clocks are the clocks of the last writes to the category's name and
+	// deletion, for syncing clients.
+	clocks map[string]string
 }
 
-// Create method
+// Create saves a new category in its WorkspaceID.
 func (c *Category) Create(session *gocql.Session) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
 	c.CategoryID = gocql.TimeUUID()
 	c.CreatedAt = time.Now()
-	query := `INSERT INTO categories (category_id, name, created_at) VALUES (?, ?, ?)`
-	return session.Query(query, c.CategoryID, c.Name, c.CreatedAt).Exec()
+	c.Version = 1
+	c.clocks = map[string]string{"name": syncClock.Now().String()}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO categories (category_id, workspace_id, name, created_at, version, field_clocks) VALUES (?, ?, ?, ?, ?, ?)`,
+		c.CategoryID, c.WorkspaceID, c.Name, c.CreatedAt, c.Version, c.clocks)
+	addSyncChange(batch, c.WorkspaceID, SyncKindCategory, c.CategoryID)
+	addOutboxEvent(batch, CategoryCreated{Category: c})
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	publishEvent(session, c.WorkspaceID, StreamCategoryCreated, c)
+	return nil
+}
+
+// createWithID saves a category a client created offline under the ID the
+// client gave it, its name stamped with the client's clock. It returns
+// ErrVersionMismatch if the ID is taken.
+func (c *Category) createWithID(session *gocql.Session, clock string) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	c.CreatedAt = time.Now()
+	c.Version = 1
+	c.clocks = map[string]string{"name": clock}
+	pending := newCategoryWrite(c, CategoryCreated{Category: c})
+	entry, err := pending.stage(session)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO categories (category_id, workspace_id, name, created_at, version, field_clocks, pending_events)
+	          VALUES (?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`
+	applied, err := session.Query(query, c.CategoryID, c.WorkspaceID, c.Name, c.CreatedAt, c.Version, c.clocks, entry).
+		MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrVersionMismatch
+	}
+	recordCategoryEvent(session, pending, c, StreamCategoryCreated)
+	return nil
 }
 
-// Update method
+// Update method. The category's Version must be the stored one, otherwise
+// ErrVersionMismatch is returned; on success it holds the new version.
 func (c *Category) Update(session *gocql.Session) error {
-	query := `UPDATE categories SET name = ? WHERE category_id = ?`
-	return session.Query(query, c.Name, c.CategoryID).Exec()
+	return c.update(session, syncClock.Now().String())
+}
+
+// update saves the category's name, stamped with the given clock.
+func (c *Category) update(session *gocql.Session, clock string) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	updated := *c
+	updated.Version++
+	updated.clocks = make(map[string]string, len(c.clocks)+1)
+	for name, stamp := range c.clocks {
+		updated.clocks[name] = stamp
+	}
+	updated.clocks["name"] = clock
+	pending := newCategoryWrite(&updated, CategoryUpdated{Category: &updated})
+	entry, err := pending.stage(session)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE categories SET name = ?, version = ?, field_clocks = field_clocks + ?,
+	          pending_events = pending_events + ? WHERE category_id = ? IF version = ?`
+	err = applyIfVersion(session.Query(query, c.Name, updated.Version, map[string]string{"name": clock}, entry,
+		c.CategoryID, c.Version))
+	if err != nil {
+		return err
+	}
+	*c = updated
+	recordCategoryEvent(session, pending, c, StreamCategoryUpdated)
+	return nil
+}
+
+func (c *Category) validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidCategory)
+	}
+	if utf8.RuneCountInString(c.Name) > 100 {
+		return fmt.Errorf("%w: name cannot be longer than 100 characters", ErrInvalidCategory)
+	}
+	return nil
 }
 
 // Get methods
+
+// GetCategoryByID returns the category, or gocql.ErrNotFound if it is in the
+// trash. Callers check that the user may access its workspace.
 func GetCategoryByID(session *gocql.Session, categoryID gocql.UUID) (*Category, error) {
+	category, err := getCategory(session, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	if category.DeletedAt != nil {
+		return nil, gocql.ErrNotFound
+	}
+	return category, nil
+}
+
+// checkCategory returns ErrCategoryNotFound unless the category exists,
+// outside the trash, in the workspace.
+func checkCategory(session *gocql.Session, workspaceID, categoryID gocql.UUID) error {
+	category, err := GetCategoryByID(session, categoryID)
+	if err == gocql.ErrNotFound || err == nil && category.WorkspaceID != workspaceID {
+		return ErrCategoryNotFound
+	}
+	return err
+}
+
+const categoryColumns = `category_id, workspace_id, name, created_at, deleted_at, version, field_clocks`
+
+func (c *Category) scanDest() []interface{} {
+	return []interface{}{&c.CategoryID, &c.WorkspaceID, &c.Name, &c.CreatedAt, &c.DeletedAt, &c.Version, &c.clocks}
+}
+
+func getCategory(session *gocql.Session, categoryID gocql.UUID) (*Category, error) {
 	category := &Category{}
-	query := `SELECT category_id, name, created_at FROM categories WHERE category_id = ?`
-	err := session.Query(query, categoryID).Scan(
-		&category.CategoryID,
-		&category.Name,
-		&category.CreatedAt)
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE category_id = ?`
+	err := session.Query(query, categoryID).Scan(category.scanDest()...)
 	return category, err
 }
 
-func GetAllCategories(session *gocql.Session) ([]Category, error) {
-	var categories []Category
-	query := "SELECT category_id, name, created_at FROM categories"
-	iter := session.Query(query).Iter()
+// GetCategories returns the categories of the workspace that are not in the
+// trash.
+func GetCategories(session *gocql.Session, workspaceID gocql.UUID) ([]Category, error) {
+	categories := []Category{}
+	query := `SELECT ` + categoryColumns + ` FROM categories WHERE workspace_id = ?`
+	iter := session.Query(query, workspaceID).Iter()
 	var category Category
-	for iter.Scan(
-		&category.CategoryID,
-		&category.Name,
-		&category.CreatedAt) {
-		categories = append(categories, category)
+	for iter.Scan(category.scanDest()...) {
+		if category.DeletedAt == nil {
+			categories = append(categories, category)
+		}
+		category = Category{}
 	}
 	return categories, iter.Close()
 }
 
-// Delete method
-func DeleteCategoryByID(session *gocql.Session, categoryID gocql.UUID) error {
-	query := `DELETE FROM categories WHERE category_id = ?`
-	return session.Query(query, categoryID).Exec()
+// MigrateCategoriesToWorkspaces files the categories, which every user
+// shared before workspaces, in workspaces. A category goes to the workspace
+// of the tasks filed under it, and each further workspace with such tasks
+// gets a copy its tasks are moved to. A category in the trash goes to the
+// workspace whose trash holds it, and one no task uses is copied to every
+// personal workspace, so that everyone keeps the categories they saw.
+// Copies get IDs derived from the category and workspace, so an interrupted
+// run can be repeated.
+func MigrateCategoriesToWorkspaces(session *gocql.Session) error {
+	type categoryWorkspace struct{ categoryID, workspaceID gocql.UUID }
+	usedIn := make(map[gocql.UUID][]gocql.UUID)
+	tasksIn := make(map[categoryWorkspace][]gocql.UUID)
+	iter := session.Query(`SELECT task_id, workspace_id, category_id FROM tasks`).Iter()
+	var taskID gocql.UUID
+	var workspaceID, categoryID *gocql.UUID
+	for iter.Scan(&taskID, &workspaceID, &categoryID) {
+		if workspaceID == nil || categoryID == nil {
+			continue
+		}
+		key := categoryWorkspace{*categoryID, *workspaceID}
+		if len(tasksIn[key]) == 0 {
+			usedIn[*categoryID] = append(usedIn[*categoryID], *workspaceID)
+		}
+		tasksIn[key] = append(tasksIn[key], taskID)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	trashedIn := make(map[gocql.UUID]gocql.UUID)
+	items, err := scanTrashItems(session.Query(`SELECT workspace_id, item_type, item_id, name, deleted_at FROM trash`).Iter())
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.ItemType == TrashItemCategory {
+			trashedIn[item.ItemID] = item.WorkspaceID
+		}
+	}
+
+	var personal []gocql.UUID
+	iter = session.Query(`SELECT user_id FROM users`).Iter()
+	var userID gocql.UUID
+	for iter.Scan(&userID) {
+		personal = append(personal, PersonalWorkspaceID(userID))
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	var categories []*Category
+	var ttls []int
+	iter = session.Query(`SELECT ` + categoryColumns + `, TTL(name) FROM categories`).Iter()
+	for {
+		c := &Category{}
+		var ttl int
+		if !iter.Scan(append(c.scanDest(), &ttl)...) {
+			break
+		}
+		categories = append(categories, c)
+		ttls = append(ttls, ttl)
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for i, c := range categories {
+		if c.WorkspaceID != (gocql.UUID{}) {
+			continue
+		}
+		var targets []gocql.UUID
+		switch {
+		case c.DeletedAt != nil:
+			if workspaceID, ok := trashedIn[c.CategoryID]; ok {
+				targets = []gocql.UUID{workspaceID}
+			}
+		case len(usedIn[c.CategoryID]) > 0:
+			targets = usedIn[c.CategoryID]
+		default:
+			targets = personal
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		for _, workspaceID := range targets[1:] {
+			copyID := derivedID(c.CategoryID, workspaceID)
+			err := session.Query(`INSERT INTO categories (category_id, workspace_id, name, created_at, version, field_clocks)
+			                      VALUES (?, ?, ?, ?, ?, ?)`,
+				copyID, workspaceID, c.Name, c.CreatedAt, 1, c.clocks).Exec()
+			if err != nil {
+				return err
+			}
+			for _, taskID := range tasksIn[categoryWorkspace{c.CategoryID, workspaceID}] {
+				if err := session.Query(`UPDATE tasks SET category_id = ? WHERE task_id = ?`, copyID, taskID).Exec(); err != nil {
+					return err
+				}
+			}
+		}
+		// A trashed category keeps the TTL it expires with.
+		query := session.Query(`UPDATE categories SET workspace_id = ? WHERE category_id = ?`, targets[0], c.CategoryID)
+		if ttls[i] > 0 {
+			query = session.Query(`UPDATE categories USING TTL ? SET workspace_id = ? WHERE category_id = ?`,
+				ttls[i], targets[0], c.CategoryID)
+		}
+		if err := query.Exec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// derivedID returns a version 5 style UUID determined by the two given.
+func derivedID(a, b gocql.UUID) gocql.UUID {
+	sum := sha1.Sum(append(a.Bytes(), b.Bytes()...))
+	var id gocql.UUID
+	copy(id[:], sum[:16])
+	id[6] = id[6]&0x0f | 0x50
+	id[8] = id[8]&0x3f | 0x80
+	return id
 }
@@ -0,0 +1,349 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+)
+
+// CommentEditWindow is how long after posting the author can still edit a
+// comment.
+var CommentEditWindow = 15 * time.Minute
+
+const maxCommentLength = 10000
+
+var (
+	ErrInvalidComment    = errors.New("invalid comment")
+	ErrNotCommentAuthor  = errors.New("only the author can change this comment")
+	ErrCommentEditWindow = errors.New("comment can no longer be edited")
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]{1,50})`)
+
+// Comment is a Markdown comment on a task. Body is the Markdown as written
+// and BodyHTML its rendering, which is safe to display. Replies name the
+// comment they answer in ParentID. Deleted comments keep their place in the
+// thread but lose their body.
+type Comment struct {
+	CommentID gocql.UUID   `json:"comment_id"`
+	TaskID    gocql.UUID   `json:"task_id"`
+	ParentID  *gocql.UUID  `json:"parent_id,omitempty"`
+	AuthorID  gocql.UUID   `json:"author_id"`
+	Body      string       `json:"body"`
+	BodyHTML  string       `json:"body_html"`
+	Mentions  []gocql.UUID `json:"mentions"`
+	CreatedAt time.Time    `json:"created_at"`
+	EditedAt  *time.Time   `json:"edited_at,omitempty"`
+	DeletedAt *time.Time   `json:"deleted_at,omitempty"`
+	Replies   []*Comment   `json:"replies,omitempty"`
+}
+
+const commentColumns = `comment_id, task_id, parent_id, author_id, body, mentions, created_at, edited_at, deleted_at`
+
+func (c *Comment) scanDest() []interface{} {
+	return []interface{}{
+		&c.CommentID,
+		&c.TaskID,
+		&c.ParentID,
+		&c.AuthorID,
+		&c.Body,
+		&c.Mentions,
+		&c.CreatedAt,
+		&c.EditedAt,
+		&c.DeletedAt,
+	}
+}
+
+// Create posts the comment on the task as the actor and notifies the users
+// it mentions.
+func (c *Comment) Create(session *gocql.Session, task *Task, actor Actor) error {
+	body, err := validateCommentBody(c.Body)
+	if err != nil {
+		return err
+	}
+	if c.ParentID != nil {
+		parent, err := GetComment(session, task.TaskID, *c.ParentID)
+		if err == gocql.ErrNotFound {
+			return fmt.Errorf("%w: parent comment not found", ErrInvalidComment)
+		} else if err != nil {
+			return err
+		}
+		if parent.DeletedAt != nil {
+			return fmt.Errorf("%w: cannot reply to a deleted comment", ErrInvalidComment)
+		}
+	}
+	mentions, err := resolveMentions(session, task, body)
+	if err != nil {
+		return err
+	}
+
+	c.CommentID = gocql.TimeUUID()
+	c.TaskID = task.TaskID
+	c.AuthorID = actor.UserID
+	c.Body = body
+	c.Mentions = mentions
+	c.CreatedAt = c.CommentID.Time().UTC()
+	c.EditedAt = nil
+	c.DeletedAt = nil
+	c.present()
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO comments (comment_id, task_id, parent_id, author_id, body, mentions, created_at)
+             VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.CommentID, c.TaskID, c.ParentID, c.AuthorID, c.Body, c.Mentions, c.CreatedAt)
+	newCommentEvent(task, TaskEventCommentAdded, c, actor).addToBatch(batch)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+
+	notifyMentions(session, task, c, mentions)
+	return nil
+}
+
+// Edit replaces the body of the comment. Only the author can edit a comment,
+// and only within CommentEditWindow of posting it. Users mentioned for the
+// first time are notified.
+func (c *Comment) Edit(session *gocql.Session, task *Task, body string, actor Actor) error {
+	if c.AuthorID != actor.UserID {
+		return ErrNotCommentAuthor
+	}
+	if c.DeletedAt != nil {
+		return fmt.Errorf("%w: comment was deleted", ErrInvalidComment)
+	}
+	if time.Since(c.CreatedAt) > CommentEditWindow {
+		return ErrCommentEditWindow
+	}
+	body, err := validateCommentBody(body)
+	if err != nil {
+		return err
+	}
+	if body == c.Body {
+		return nil
+	}
+	mentions, err := resolveMentions(session, task, body)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`UPDATE comments SET body = ?, mentions = ?, edited_at = ? WHERE task_id = ? AND comment_id = ?`,
+		body, mentions, now, c.TaskID, c.CommentID)
+	newCommentEvent(task, TaskEventCommentEdited, c, actor).addToBatch(batch)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+
+	previous := make(map[gocql.UUID]bool, len(c.Mentions))
+	for _, id := range c.Mentions {
+		previous[id] = true
+	}
+	var added []gocql.UUID
+	for _, id := range mentions {
+		if !previous[id] {
+			added = append(added, id)
+		}
+	}
+
+	c.Body = body
+	c.Mentions = mentions
+	c.EditedAt = &now
+	c.present()
+	notifyMentions(session, task, c, added)
+	return nil
+}
+
+// Delete soft-deletes the comment. The author and the owners of the task's
+// workspace can delete it; its replies stay in place.
+func (c *Comment) Delete(session *gocql.Session, task *Task, actor Actor) error {
+	if c.AuthorID != actor.UserID {
+		role, err := GetWorkspaceRole(session, task.WorkspaceID, actor.UserID)
+		if err != nil {
+			return err
+		}
+		if role != RoleOwner {
+			return ErrNotCommentAuthor
+		}
+	}
+	if c.DeletedAt != nil {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`UPDATE comments SET deleted_at = ? WHERE task_id = ? AND comment_id = ?`,
+		now, c.TaskID, c.CommentID)
+	newCommentEvent(task, TaskEventCommentDeleted, c, actor).addToBatch(batch)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	c.DeletedAt = &now
+	c.present()
+	return nil
+}
+
+// present renders the body of the comment, or hides the contents of a
+// deleted one.
+func (c *Comment) present() {
+	if c.DeletedAt != nil {
+		c.Body = ""
+		c.BodyHTML = ""
+		c.Mentions = nil
+		return
+	}
+	c.BodyHTML = renderMarkdown(c.Body)
+}
+
+func validateCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(cleanMarkdown(body))
+	if body == "" {
+		return "", fmt.Errorf("%w: body is required", ErrInvalidComment)
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return "", fmt.Errorf("%w: body cannot be longer than %d characters", ErrInvalidComment, maxCommentLength)
+	}
+	return body, nil
+}
+
+// taskMembers returns the users with access to the task, the members of its
+// workspace.
+func taskMembers(session *gocql.Session, task *Task) ([]gocql.UUID, error) {
+	return memberIDs(session, task.WorkspaceID)
+}
+
+// resolveMentions returns the users with access to the task whose
+// usernames are @-mentioned in the body. Unknown names are ignored.
+func resolveMentions(session *gocql.Session, task *Task, body string) ([]gocql.UUID, error) {
+	matches := mentionPattern.FindAllStringSubmatch(body, -1)
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	names := make(map[string]bool, len(matches))
+	for _, m := range matches {
+		names[strings.ToLower(strings.TrimRight(m[1], ".-"))] = true
+	}
+
+	members, err := taskMembers(session, task)
+	if err != nil {
+		return nil, err
+	}
+	var mentions []gocql.UUID
+	for _, id := range members {
+		user, err := GetUserByID(session, id)
+		if err != nil {
+			continue
+		}
+		if names[strings.ToLower(user.Username)] {
+			mentions = append(mentions, id)
+		}
+	}
+	return mentions, nil
+}
+
+// notifyMentions tells the mentioned users, other than the author, about the
+// comment. Failures are logged; the comment is saved either way.
+func notifyMentions(session *gocql.Session, task *Task, c *Comment, mentions []gocql.UUID) {
+	author := "Someone"
+	if user, err := GetUserByID(session, c.AuthorID); err == nil {
+		author = user.Username
+	}
+	excerpt := c.Body
+	if utf8.RuneCountInString(excerpt) > 200 {
+		excerpt = string([]rune(excerpt)[:200]) + "…"
+	}
+
+	taskID := task.TaskID
+	for _, userID := range mentions {
+		if userID == c.AuthorID {
+			continue
+		}
+		notification := &Notification{
+			UserID: userID,
+			Kind:   "mention",
+			Title:  fmt.Sprintf("%s mentioned you on %q", author, task.Title),
+			Body:   excerpt,
+			TaskID: &taskID,
+		}
+		if err := notification.Create(session); err != nil {
+			log.Printf("Failed to notify user %s of mention: %v", userID, err)
+		}
+	}
+}
+
+// newCommentEvent records a change to a comment in the task's history. The
+// history names the comment and its author but leaves out the text, which
+// stays with the comment, so that deleting a comment hides it from everyone.
+func newCommentEvent(task *Task, eventType string, c *Comment, actor Actor) *TaskEvent {
+	id, _ := json.Marshal(c.CommentID)
+	author, _ := json.Marshal(c.AuthorID)
+	changes := map[string]FieldChange{
+		"comment_id": {New: string(id)},
+		"author_id":  {New: string(author)},
+	}
+
+	event := &TaskEvent{
+		TaskID:    task.TaskID,
+		EventID:   gocql.TimeUUID(),
+		Type:      eventType,
+		UserID:    task.UserID,
+		ActorID:   actor.UserID,
+		RequestID: actor.RequestID,
+		Changes:   changes,
+	}
+	event.CreatedAt = event.EventID.Time().UTC()
+	return event
+}
+
+// GetComment returns a comment of the task. The body of a deleted comment
+// is left out.
+func GetComment(session *gocql.Session, taskID, commentID gocql.UUID) (*Comment, error) {
+	c := &Comment{}
+	query := `SELECT ` + commentColumns + ` FROM comments WHERE task_id = ? AND comment_id = ?`
+	if err := session.Query(query, taskID, commentID).Scan(c.scanDest()...); err != nil {
+		return nil, err
+	}
+	c.present()
+	return c, nil
+}
+
+// GetCommentThreads returns the task's top-level comments, oldest first,
+// with their replies nested below them.
+func GetCommentThreads(session *gocql.Session, taskID gocql.UUID) ([]*Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments WHERE task_id = ?`
+	iter := session.Query(query, taskID).Iter()
+	var comments []*Comment
+	for {
+		c := &Comment{}
+		if !iter.Scan(c.scanDest()...) {
+			break
+		}
+		c.present()
+		comments = append(comments, c)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[gocql.UUID]*Comment, len(comments))
+	for _, c := range comments {
+		byID[c.CommentID] = c
+	}
+	threads := []*Comment{}
+	for _, c := range comments {
+		if c.ParentID != nil {
+			if parent, ok := byID[*c.ParentID]; ok {
+				parent.Replies = append(parent.Replies, c)
+				continue
+			}
+		}
+		threads = append(threads, c)
+	}
+	return threads, nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+// TestCommentHistoryLeavesOutBody checks that once a comment is deleted its
+// text cannot be read back from the task's history, including from events
+// written while the history still recorded it.
+func TestCommentHistoryLeavesOutBody(t *testing.T) {
+	const text = "the staging password is hunter2"
+	task := NewTask(gocql.TimeUUID(), "Deploy", "", StatusPending)
+	task.TaskID = gocql.TimeUUID()
+	author := Actor{UserID: task.UserID, RequestID: "req-1"}
+	c := &Comment{CommentID: gocql.TimeUUID(), TaskID: task.TaskID, AuthorID: author.UserID, Body: text}
+
+	body, _ := json.Marshal(text)
+	earlier := TaskEvent{
+		TaskID:  task.TaskID,
+		EventID: gocql.TimeUUID(),
+		Type:    TaskEventCommentAdded,
+		Changes: map[string]FieldChange{"body": {New: string(body)}},
+	}
+	earlier.redactComment()
+	events := []TaskEvent{
+		earlier,
+		*newCommentEvent(task, TaskEventCommentAdded, c, author),
+		*newCommentEvent(task, TaskEventCommentEdited, c, author),
+		*newCommentEvent(task, TaskEventCommentDeleted, c, author),
+	}
+
+	data, err := json.Marshal(map[string]interface{}{"events": events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("history %s holds the deleted comment's text", data)
+	}
+	for _, e := range events[1:] {
+		var id, authorID gocql.UUID
+		if err := json.Unmarshal([]byte(e.Changes["comment_id"].New), &id); err != nil || id != c.CommentID {
+			t.Errorf("%s event names comment %s, want %s", e.Type, e.Changes["comment_id"].New, c.CommentID)
+		}
+		if err := json.Unmarshal([]byte(e.Changes["author_id"].New), &authorID); err != nil || authorID != c.AuthorID {
+			t.Errorf("%s event names author %s, want %s", e.Type, e.Changes["author_id"].New, c.AuthorID)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrDependencyCycle = errors.New("dependency would create a cycle")
+	ErrSelfDependency  = errors.New("task cannot depend on itself")
+	ErrTaskBlocked     = errors.New("task is blocked by open tasks")
+	ErrBlockerNotFound = errors.New("blocker task not found")
+)
+
+// Dependency records that TaskID cannot be completed before BlockerID.
+type Dependency struct {
+	WorkspaceID gocql.UUID `json:"-"`
+	TaskID      gocql.UUID `json:"task_id"`
+	BlockerID   gocql.UUID `json:"blocker_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// DependencyGraph is a workspace's tasks together with the edges between them and
+// an order in which the tasks can be worked through.
+type DependencyGraph struct {
+	Tasks []*Task      `json:"tasks"`
+	Edges []Dependency `json:"edges"`
+	Order []gocql.UUID `json:"order"`
+}
+
+func GetDependenciesByWorkspaceID(session *gocql.Session, workspaceID gocql.UUID) ([]Dependency, error) {
+	var deps []Dependency
+	query := `SELECT workspace_id, task_id, blocker_id, created_at FROM task_dependencies WHERE workspace_id = ?`
+	iter := session.Query(query, workspaceID).Iter()
+	var dep Dependency
+	for iter.Scan(&dep.WorkspaceID, &dep.TaskID, &dep.BlockerID, &dep.CreatedAt) {
+		deps = append(deps, dep)
+	}
+	return deps, iter.Close()
+}
+
+// AddDependency records that task is blocked by blocker. Both tasks must
+// belong to the same workspace and the new edge must not close a cycle.
+func AddDependency(session *gocql.Session, task, blocker *Task) (*Dependency, error) {
+	if task.TaskID == blocker.TaskID {
+		return nil, ErrSelfDependency
+	}
+	if task.WorkspaceID != blocker.WorkspaceID {
+		return nil, ErrBlockerNotFound
+	}
+
+	deps, err := GetDependenciesByWorkspaceID(session, task.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The edge closes a cycle if the task already blocks the new blocker,
+	// directly or transitively.
+	blockers := make(map[gocql.UUID][]gocql.UUID)
+	for _, dep := range deps {
+		blockers[dep.TaskID] = append(blockers[dep.TaskID], dep.BlockerID)
+	}
+	visited := make(map[gocql.UUID]bool)
+	stack := []gocql.UUID{blocker.TaskID}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if id == task.TaskID {
+			return nil, ErrDependencyCycle
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+		stack = append(stack, blockers[id]...)
+	}
+
+	dep := &Dependency{
+		WorkspaceID: task.WorkspaceID,
+		TaskID:      task.TaskID,
+		BlockerID:   blocker.TaskID,
+		CreatedAt:   time.Now().UTC(),
+	}
+	query := `INSERT INTO task_dependencies (workspace_id, task_id, blocker_id, created_at) VALUES (?, ?, ?, ?)`
+	if err := session.Query(query, dep.WorkspaceID, dep.TaskID, dep.BlockerID, dep.CreatedAt).Exec(); err != nil {
+		return nil, err
+	}
+	return dep, nil
+}
+
+func DeleteDependency(session *gocql.Session, workspaceID, taskID, blockerID gocql.UUID) error {
+	query := `DELETE FROM task_dependencies WHERE workspace_id = ? AND task_id = ? AND blocker_id = ?`
+	return session.Query(query, workspaceID, taskID, blockerID).Exec()
+}
+
+// DeleteDependenciesOfTask removes every edge that starts or ends at the task.
+func DeleteDependenciesOfTask(session *gocql.Session, workspaceID, taskID gocql.UUID) error {
+	deps, err := GetDependenciesByWorkspaceID(session, workspaceID)
+	if err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		if dep.TaskID == taskID || dep.BlockerID == taskID {
+			if err := DeleteDependency(session, workspaceID, dep.TaskID, dep.BlockerID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// OpenBlockers returns the tasks blocking the given task that are not closed.
+func OpenBlockers(session *gocql.Session, task *Task) ([]*Task, error) {
+	workflow, err := GetWorkflow(session, task.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return openBlockers(session, task, workflow)
+}
+
+func openBlockers(session *gocql.Session, task *Task, workflow *Workflow) ([]*Task, error) {
+	var ids []gocql.UUID
+	query := `SELECT blocker_id FROM task_dependencies WHERE workspace_id = ? AND task_id = ?`
+	iter := session.Query(query, task.WorkspaceID, task.TaskID).Iter()
+	var id gocql.UUID
+	for iter.Scan(&id) {
+		ids = append(ids, id)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	var open []*Task
+	for _, id := range ids {
+		blocker, err := GetTaskByID(session, id)
+		if err == gocql.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !workflow.IsClosed(blocker.Status) {
+			open = append(open, blocker)
+		}
+	}
+	return open, nil
+}
+
+// LoadBlocked sets the task's blocked flag.
+func (t *Task) LoadBlocked(session *gocql.Session) error {
+	open, err := OpenBlockers(session, t)
+	if err != nil {
+		return err
+	}
+	t.Blocked = len(open) > 0
+	return nil
+}
+
+// applyBlocked sets the blocked flag on each task from the workspace's edges.
+func applyBlocked(tasks []*Task, deps []Dependency, workflow *Workflow) {
+	byID := make(map[gocql.UUID]*Task, len(tasks))
+	for _, task := range tasks {
+		byID[task.TaskID] = task
+	}
+	for _, dep := range deps {
+		task, blocker := byID[dep.TaskID], byID[dep.BlockerID]
+		if task != nil && blocker != nil && !workflow.IsClosed(blocker.Status) {
+			task.Blocked = true
+		}
+	}
+}
+
+// GetDependencyGraph returns the workspace's dependency graph. Edges pointing at
+// deleted tasks are dropped and Order lists blockers before the tasks they
+// block.
+func GetDependencyGraph(session *gocql.Session, workspaceID gocql.UUID) (*DependencyGraph, error) {
+	tasks, err := GetTasksByWorkspaceID(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := GetDependenciesByWorkspaceID(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+	known := make(map[gocql.UUID]bool, len(tasks))
+	for _, task := range tasks {
+		known[task.TaskID] = true
+	}
+
+	graph := &DependencyGraph{Tasks: tasks, Edges: []Dependency{}}
+	inDegree := make(map[gocql.UUID]int)
+	dependents := make(map[gocql.UUID][]gocql.UUID)
+	for _, dep := range deps {
+		if !known[dep.TaskID] || !known[dep.BlockerID] {
+			continue
+		}
+		graph.Edges = append(graph.Edges, dep)
+		inDegree[dep.TaskID]++
+		dependents[dep.BlockerID] = append(dependents[dep.BlockerID], dep.TaskID)
+	}
+
+	// Kahn's algorithm, taking ready tasks in creation order.
+	var ready []gocql.UUID
+	for _, task := range tasks {
+		if inDegree[task.TaskID] == 0 {
+			ready = append(ready, task.TaskID)
+		}
+	}
+	position := make(map[gocql.UUID]int, len(tasks))
+	for i, task := range tasks {
+		position[task.TaskID] = i
+	}
+	graph.Order = make([]gocql.UUID, 0, len(tasks))
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		graph.Order = append(graph.Order, id)
+		for _, next := range dependents[id] {
+			inDegree[next]--
+			if inDegree[next] == 0 {
+				ready = append(ready, next)
+				sort.Slice(ready, func(i, j int) bool {
+					return position[ready[i]] < position[ready[j]]
+				})
+			}
+		}
+	}
+
+	return graph, nil
+}
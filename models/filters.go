@@ -0,0 +1,308 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gocql/gocql"
+)
+
+// MaxFilterLength caps the length of a filter expression.
+const MaxFilterLength = 500
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// TaskFilter is a parsed task filter expression. An expression is a list of
+// terms separated by spaces, all of which a task must match:
+//
+//	status:todo,in_progress   the status is one of the listed keys
+//	is:open, is:closed        the status is open or closed in the workflow
+//	is:overdue                the task is open and its due date has passed
+//	is:subtask                the task has a parent
+//	tag:urgent                the task has the tag
+//	priority:high,urgent      the priority is one of the listed ones;
+//	                          priority:none matches tasks without one
+//	project:<id>, project:none
+//	category:<id>, category:none
+//	assignee:<id>, assignee:none
+//	due:none, due:any         the task has no due date, or has one
+//	due:<2026-12-01, due:>3d  the due date is before or after a date, or a
+//	                          number of days or hours from now
+//	title:"weekly report"     the title contains the text; a bare word
+//	                          does the same
+//
+// Every term but is: and due: takes several values separated by commas, of
+// which the task must match one. A term preceded by "-" matches the tasks
+// the term alone does not. Values containing spaces are written in double
+// quotes. The empty expression matches every task.
+type TaskFilter struct {
+	expr  string
+	terms []filterTerm
+}
+
+// filterTerm is a term of an expression; it holds for the tasks match
+// accepts, or rejects if negate is set.
+type filterTerm struct {
+	negate bool
+	match  func(t *Task, env *filterEnv) bool
+}
+
+// filterEnv is what terms are evaluated against besides the task.
+type filterEnv struct {
+	workflow *Workflow
+	now      time.Time
+}
+
+// ParseTaskFilter parses a filter expression. Errors wrap ErrInvalidFilter.
+func ParseTaskFilter(expr string) (*TaskFilter, error) {
+	expr = strings.TrimSpace(expr)
+	if len(expr) > MaxFilterLength {
+		return nil, fmt.Errorf("%w: longer than %d characters", ErrInvalidFilter, MaxFilterLength)
+	}
+	words, err := splitFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	f := &TaskFilter{expr: expr}
+	for _, word := range words {
+		term, err := parseFilterTerm(word)
+		if err != nil {
+			return nil, err
+		}
+		f.terms = append(f.terms, term)
+	}
+	return f, nil
+}
+
+// String returns the expression the filter was parsed from.
+func (f *TaskFilter) String() string {
+	return f.expr
+}
+
+// Matches reports whether the task passes the filter, with is:open, is:closed
+// and is:overdue judged by the workflow and the current time.
+func (f *TaskFilter) Matches(t *Task, workflow *Workflow, now time.Time) bool {
+	env := &filterEnv{workflow: workflow, now: now}
+	for _, term := range f.terms {
+		if term.match(t, env) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// splitFilter splits an expression into its terms, keeping quoted text
+// together and dropping the quotes.
+func splitFilter(expr string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	quoted, started := false, false
+	for _, r := range expr {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case unicode.IsSpace(r) && !quoted:
+			if started {
+				words = append(words, word.String())
+			}
+			word.Reset()
+			started = false
+		default:
+			word.WriteRune(r)
+			started = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%w: unterminated quote", ErrInvalidFilter)
+	}
+	if started {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func parseFilterTerm(word string) (filterTerm, error) {
+	var term filterTerm
+	if strings.HasPrefix(word, "-") && len(word) > 1 {
+		term.negate = true
+		word = word[1:]
+	}
+	field, value, ok := strings.Cut(word, ":")
+	if !ok {
+		field, value = "title", word
+	}
+	field = strings.ToLower(field)
+	if value == "" {
+		return term, fmt.Errorf("%w: %s has no value", ErrInvalidFilter, field)
+	}
+
+	var err error
+	switch field {
+	case "is":
+		term.match, err = parseIsTerm(value)
+	case "due":
+		term.match, err = parseDueTerm(value)
+	case "status", "tag", "priority", "project", "category", "assignee", "title":
+		term.match, err = parseListTerm(field, strings.Split(value, ","))
+	default:
+		err = fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, field)
+	}
+	return term, err
+}
+
+func parseIsTerm(value string) (func(*Task, *filterEnv) bool, error) {
+	switch strings.ToLower(value) {
+	case "open":
+		return func(t *Task, env *filterEnv) bool { return !env.workflow.IsClosed(t.Status) }, nil
+	case "closed":
+		return func(t *Task, env *filterEnv) bool { return env.workflow.IsClosed(t.Status) }, nil
+	case "overdue":
+		return func(t *Task, env *filterEnv) bool {
+			return t.DueAt != nil && t.DueAt.Before(env.now) && !env.workflow.IsClosed(t.Status)
+		}, nil
+	case "subtask":
+		return func(t *Task, env *filterEnv) bool { return t.ParentID != nil }, nil
+	}
+	return nil, fmt.Errorf("%w: unknown is:%s", ErrInvalidFilter, value)
+}
+
+// parseDueTerm parses the value of a due: term: none, any, or a bound
+// preceded by < or >. A bound is a date, taken as midnight UTC, or a number
+// of days or hours from the time of evaluation, such as 3d or 12h.
+func parseDueTerm(value string) (func(*Task, *filterEnv) bool, error) {
+	switch strings.ToLower(value) {
+	case "none":
+		return func(t *Task, env *filterEnv) bool { return t.DueAt == nil }, nil
+	case "any":
+		return func(t *Task, env *filterEnv) bool { return t.DueAt != nil }, nil
+	}
+	if value[0] != '<' && value[0] != '>' {
+		return nil, fmt.Errorf("%w: due:%s", ErrInvalidFilter, value)
+	}
+	before := value[0] == '<'
+	bound, err := parseDueBound(value[1:])
+	if err != nil {
+		return nil, err
+	}
+	return func(t *Task, env *filterEnv) bool {
+		if t.DueAt == nil {
+			return false
+		}
+		at := bound(env.now)
+		if before {
+			return t.DueAt.Before(at)
+		}
+		return t.DueAt.After(at)
+	}, nil
+}
+
+func parseDueBound(value string) (func(now time.Time) time.Time, error) {
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return func(time.Time) time.Time { return date }, nil
+	}
+	if len(value) > 1 {
+		n, err := strconv.Atoi(value[:len(value)-1])
+		if err == nil && n >= 0 {
+			switch value[len(value)-1] {
+			case 'd':
+				return func(now time.Time) time.Time { return now.AddDate(0, 0, n) }, nil
+			case 'h':
+				return func(now time.Time) time.Time { return now.Add(time.Duration(n) * time.Hour) }, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: due date %q is neither YYYY-MM-DD nor a number of days or hours", ErrInvalidFilter, value)
+}
+
+// parseListTerm parses a term matching any of several values of a field.
+func parseListTerm(field string, values []string) (func(*Task, *filterEnv) bool, error) {
+	var matchers []func(*Task) bool
+	for _, value := range values {
+		m, err := parseFieldValue(field, value)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return func(t *Task, env *filterEnv) bool {
+		for _, m := range matchers {
+			if m(t) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseFieldValue(field, value string) (func(*Task) bool, error) {
+	if value == "" {
+		return nil, fmt.Errorf("%w: empty value in %s", ErrInvalidFilter, field)
+	}
+	switch field {
+	case "status":
+		status := normalizeStatusKey(value)
+		return func(t *Task) bool { return t.Status == status }, nil
+	case "tag":
+		tag, err := NormalizeTag(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+		}
+		return func(t *Task) bool { return containsString(t.Tags, tag) }, nil
+	case "priority":
+		priority := strings.ToLower(value)
+		switch priority {
+		case "none":
+			return func(t *Task) bool { return t.Priority == "" }, nil
+		case PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent:
+			return func(t *Task) bool { return t.Priority == priority }, nil
+		}
+		return nil, fmt.Errorf("%w: unknown priority %q", ErrInvalidFilter, value)
+	case "title":
+		text := strings.ToLower(value)
+		return func(t *Task) bool { return strings.Contains(strings.ToLower(t.Title), text) }, nil
+	case "assignee":
+		if strings.EqualFold(value, "none") {
+			return func(t *Task) bool { return len(t.Assignees) == 0 }, nil
+		}
+		id, err := gocql.ParseUUID(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid assignee ID %q", ErrInvalidFilter, value)
+		}
+		return func(t *Task) bool {
+			for _, assignee := range t.Assignees {
+				if assignee == id {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	// project and category
+	reference := func(t *Task) *gocql.UUID { return t.ProjectID }
+	if field == "category" {
+		reference = func(t *Task) *gocql.UUID { return t.CategoryID }
+	}
+	if strings.EqualFold(value, "none") {
+		return func(t *Task) bool { return reference(t) == nil }, nil
+	}
+	id, err := gocql.ParseUUID(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid %s ID %q", ErrInvalidFilter, field, value)
+	}
+	return func(t *Task) bool { return sameUUID(reference(t), &id) }, nil
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// States of an idempotency key.
+const (
+	IdempotencyInFlight  = "in_flight"
+	IdempotencyCompleted = "completed"
+)
+
+// ErrIdempotencyClaimLost is returned when a request's claim on its key
+// expired or was taken over before its response could be stored.
+var ErrIdempotencyClaimLost = errors.New("idempotency key is no longer claimed by the request")
+
+// IdempotencyKeyTTL is how long a completed response is kept for replay.
+// IdempotencyLease is how long a key stays claimed by a request that has not
+// completed, after which a retry may run the request again.
+const (
+	IdempotencyKeyTTL = 24 * time.Hour
+	IdempotencyLease  = time.Minute
+)
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key header.
+type IdempotencyRecord struct {
+	UserID      gocql.UUID
+	Key         string
+	RequestHash string
+	State       string
+	StatusCode  int
+	Headers     map[string]string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// ClaimIdempotencyKey claims the key for a request with the given hash. If
+// the key was already used it returns false along with the existing record.
+// Anonymous requests are claimed with the zero user ID.
+func ClaimIdempotencyKey(session *gocql.Session, userID gocql.UUID, key, requestHash string) (*IdempotencyRecord, bool, error) {
+	record := &IdempotencyRecord{
+		UserID:      userID,
+		Key:         key,
+		RequestHash: requestHash,
+		State:       IdempotencyInFlight,
+		CreatedAt:   time.Now().UTC(),
+	}
+	query := `INSERT INTO idempotency_keys (user_id, key, request_hash, state, created_at)
+             VALUES (?, ?, ?, ?, ?) IF NOT EXISTS USING TTL ?`
+	existing := map[string]interface{}{}
+	applied, err := session.Query(query,
+		userID, key, requestHash, record.State, record.CreatedAt,
+		int(IdempotencyLease.Seconds())).MapScanCAS(existing)
+	if err != nil {
+		return nil, false, err
+	}
+	if applied {
+		return record, true, nil
+	}
+
+	record = &IdempotencyRecord{UserID: userID, Key: key}
+	record.RequestHash, _ = existing["request_hash"].(string)
+	record.State, _ = existing["state"].(string)
+	record.StatusCode, _ = existing["status_code"].(int)
+	record.Headers, _ = existing["headers"].(map[string]string)
+	record.Body, _ = existing["body"].([]byte)
+	record.CreatedAt, _ = existing["created_at"].(time.Time)
+	return record, false, nil
+}
+
+// Complete stores the response so that retries can replay it. It returns
+// ErrIdempotencyClaimLost, storing nothing, if the key is no longer claimed
+// for the request, as when the lease ran out and another request took it.
+func (r *IdempotencyRecord) Complete(session *gocql.Session, statusCode int, headers map[string]string, body []byte) error {
+	// The hash is written again so that it takes the longer TTL as well.
+	query := `UPDATE idempotency_keys USING TTL ?
+             SET request_hash = ?, state = ?, status_code = ?, headers = ?, body = ?, created_at = ?
+             WHERE user_id = ? AND key = ? IF request_hash = ? AND state = ?`
+	applied, err := session.Query(query, int(IdempotencyKeyTTL.Seconds()),
+		r.RequestHash, IdempotencyCompleted, statusCode, headers, body, r.CreatedAt,
+		r.UserID, r.Key, r.RequestHash, IdempotencyInFlight).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrIdempotencyClaimLost
+	}
+	r.State = IdempotencyCompleted
+	r.StatusCode = statusCode
+	r.Headers = headers
+	r.Body = body
+	return nil
+}
+
+// Release frees the key so that the request can be retried, for requests
+// that failed without a result worth replaying. A key no longer claimed for
+// the request is left alone.
+func (r *IdempotencyRecord) Release(session *gocql.Session) error {
+	query := `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? IF request_hash = ? AND state = ?`
+	_, err := session.Query(query, r.UserID, r.Key, r.RequestHash, IdempotencyInFlight).
+		MapScanCAS(map[string]interface{}{})
+	return err
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// InvitationTTL is how long an invitation can be accepted after it is sent.
+var InvitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidInvitation  = errors.New("invalid invitation")
+	ErrInvitationNotFound = errors.New("invitation not found or expired")
+	ErrInvitationEmail    = errors.New("invitation was sent to a different email address")
+)
+
+// Invitation asks the owner of an email address to join a workspace. Only a
+// hash of its token is stored; the token itself is handed out once, when the
+// invitation is created.
+type Invitation struct {
+	WorkspaceID  gocql.UUID `json:"workspace_id"`
+	InvitationID gocql.UUID `json:"invitation_id"`
+	Email        string     `json:"email"`
+	Role         string     `json:"role"`
+	InvitedBy    gocql.UUID `json:"invited_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	Token        string     `json:"token,omitempty"`
+
+	tokenHash string
+}
+
+const invitationColumns = `workspace_id, invitation_id, email, role, token_hash, invited_by, created_at, expires_at`
+
+func (inv *Invitation) scanDest() []interface{} {
+	return []interface{}{
+		&inv.WorkspaceID,
+		&inv.InvitationID,
+		&inv.Email,
+		&inv.Role,
+		&inv.tokenHash,
+		&inv.InvitedBy,
+		&inv.CreatedAt,
+		&inv.ExpiresAt,
+	}
+}
+
+// Create saves an invitation to the workspace from the actor and sets Token.
+// Both rows expire with the invitation.
+func (inv *Invitation) Create(session *gocql.Session, ws *Workspace, actor Actor) error {
+	if ws.Personal {
+		return ErrPersonalWorkspace
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(inv.Email))
+	if err != nil || address.Name != "" {
+		return fmt.Errorf("%w: %q is not an email address", ErrInvalidInvitation, inv.Email)
+	}
+	if inv.Role == "" {
+		inv.Role = RoleEditor
+	}
+	if !ValidRole(inv.Role) {
+		return fmt.Errorf("%w: role must be owner, editor or viewer", ErrInvalidInvitation)
+	}
+
+	if inv.Token, err = newToken(); err != nil {
+		return err
+	}
+	inv.tokenHash = hashToken(inv.Token)
+	inv.WorkspaceID = ws.WorkspaceID
+	inv.InvitationID = gocql.TimeUUID()
+	inv.Email = address.Address
+	inv.InvitedBy = actor.UserID
+	inv.CreatedAt = inv.InvitationID.Time().UTC()
+	inv.ExpiresAt = inv.CreatedAt.Add(InvitationTTL)
+
+	ttl := int(InvitationTTL.Seconds())
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO invitations (`+invitationColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`,
+		inv.WorkspaceID, inv.InvitationID, inv.Email, inv.Role, inv.tokenHash, inv.InvitedBy, inv.CreatedAt,
+		inv.ExpiresAt, ttl)
+	batch.Query(`INSERT INTO invitation_tokens (token_hash, workspace_id, invitation_id) VALUES (?, ?, ?) USING TTL ?`,
+		inv.tokenHash, inv.WorkspaceID, inv.InvitationID, ttl)
+	return session.ExecuteBatch(batch)
+}
+
+// GetInvitation returns a pending invitation, or ErrInvitationNotFound.
+func GetInvitation(session *gocql.Session, workspaceID, invitationID gocql.UUID) (*Invitation, error) {
+	inv := &Invitation{}
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE workspace_id = ? AND invitation_id = ?`
+	err := session.Query(query, workspaceID, invitationID).Scan(inv.scanDest()...)
+	if err == gocql.ErrNotFound || err == nil && time.Now().After(inv.ExpiresAt) {
+		return nil, ErrInvitationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// GetInvitations returns the workspace's pending invitations.
+func GetInvitations(session *gocql.Session, workspaceID gocql.UUID) ([]*Invitation, error) {
+	invitations := []*Invitation{}
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE workspace_id = ?`
+	iter := session.Query(query, workspaceID).Iter()
+	now := time.Now()
+	for {
+		inv := &Invitation{}
+		if !iter.Scan(inv.scanDest()...) {
+			break
+		}
+		if now.Before(inv.ExpiresAt) {
+			invitations = append(invitations, inv)
+		}
+	}
+	return invitations, iter.Close()
+}
+
+// Revoke deletes the invitation so its token no longer works.
+func (inv *Invitation) Revoke(session *gocql.Session) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM invitations WHERE workspace_id = ? AND invitation_id = ?`, inv.WorkspaceID, inv.InvitationID)
+	batch.Query(`DELETE FROM invitation_tokens WHERE token_hash = ?`, inv.tokenHash)
+	return session.ExecuteBatch(batch)
+}
+
+// AcceptInvitation adds the user to the workspace the token invites them to
+// and uses up the invitation. The user's email address must be the one the
+// invitation was sent to. Members who are already in the workspace keep
+// their role unless the invitation grants a higher one.
+func AcceptInvitation(session *gocql.Session, token string, user *User) (*Workspace, error) {
+	var workspaceID, invitationID gocql.UUID
+	query := `SELECT workspace_id, invitation_id FROM invitation_tokens WHERE token_hash = ?`
+	err := session.Query(query, hashToken(token)).Scan(&workspaceID, &invitationID)
+	if err == gocql.ErrNotFound {
+		return nil, ErrInvitationNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	inv, err := GetInvitation(session, workspaceID, invitationID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(inv.Email, strings.TrimSpace(user.Email)) {
+		return nil, ErrInvitationEmail
+	}
+	ws, err := GetWorkspace(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := GetWorkspaceRole(session, workspaceID, user.UserID)
+	if err != nil {
+		return nil, err
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	if !HasRole(role, inv.Role) {
+		addMember(batch, workspaceID, user.UserID, inv.Role, time.Now().UTC())
+		role = inv.Role
+	}
+	batch.Query(`DELETE FROM invitations WHERE workspace_id = ? AND invitation_id = ?`, workspaceID, invitationID)
+	batch.Query(`DELETE FROM invitation_tokens WHERE token_hash = ?`, inv.tokenHash)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return nil, err
+	}
+	ws.Role = role
+	return ws, nil
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"bytes"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdownRenderer turns Markdown into HTML. Raw HTML in the source is left
+// out rather than passed through.
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownPolicy is what rendered Markdown may contain: formatting, links
+// and images, with URLs limited to http, https and mailto. URLs are checked
+// after the parser has decoded entities and escapes, so a scheme spelled
+// with them cannot slip through.
+var markdownPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.RequireParseableURLs(true)
+	p.AllowRelativeURLs(false)
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	// Task list items render as disabled checkboxes.
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	return p
+}()
+
+// cleanMarkdown normalizes a Markdown body before it is stored: line endings
+// become \n and control characters are dropped. The body stays as written
+// otherwise; it is made safe when rendered.
+func cleanMarkdown(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' && r != '\t' || r == 0x7F {
+			return -1
+		}
+		return r
+	}, body)
+}
+
+// renderMarkdown renders a Markdown body to HTML that is safe to embed in a
+// page.
+func renderMarkdown(body string) string {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(body), &buf); err != nil {
+		log.Printf("Failed to render Markdown: %v", err)
+		return ""
+	}
+	return strings.TrimSpace(markdownPolicy.Sanitize(buf.String()))
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var urlAttrPattern = regexp.MustCompile(`(?i)(?:href|src)\s*=\s*"([^"]*)"`)
+
+func TestRenderMarkdownDropsUnsafeLinks(t *testing.T) {
+	payloads := []string{
+		"[a](javascript:alert(1))",
+		"[a](JavaScript:alert(1))",
+		"[a](java&#115;cript:alert(1))",
+		"[a](&#106;avascript:alert(1))",
+		"[a](&#x6A;avascript:alert(1))",
+		"[a](javascript&colon;alert(1))",
+		"[a](java%73cript:alert(1))",
+		"[a](<javascript:alert(1)>)",
+		"[a](  javascript:alert(1))",
+		"[a](vbscript:msgbox(1))",
+		"[a](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)",
+		"[a](file:///etc/passwd)",
+		"![a](javascript:alert(1))",
+		"[a][ref]\n\n[ref]: java&#115;cript:alert(1)",
+		"<javascript:alert(1)>",
+		"<a href=\"javascript:alert(1)\">a</a>",
+		"<img src=x onerror=alert(1)>",
+		"<script>alert(1)</script>",
+		"[a](//evil.example/path)",
+		"[a](/relative)",
+	}
+	for _, payload := range payloads {
+		html := renderMarkdown(payload)
+		for _, m := range urlAttrPattern.FindAllStringSubmatch(html, -1) {
+			if !strings.HasPrefix(m[1], "https://") && !strings.HasPrefix(m[1], "http://") &&
+				!strings.HasPrefix(m[1], "mailto:") {
+				t.Errorf("renderMarkdown(%q) = %q, links to %q", payload, html, m[1])
+			}
+		}
+		if lower := strings.ToLower(html); strings.Contains(lower, "<script") || strings.Contains(lower, "onerror") {
+			t.Errorf("renderMarkdown(%q) = %q, kept HTML", payload, html)
+		}
+	}
+}
+
+func TestRenderMarkdownKeepsSafeContent(t *testing.T) {
+	tests := []struct {
+		markdown string
+		want     string
+	}{
+		{"**bold** and _em_", "<p><strong>bold</strong> and <em>em</em></p>"},
+		{"[docs](https://example.com/a?b=1&c=2)",
+			`<p><a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener" target="_blank">docs</a></p>`},
+		{"[mail](mailto:team@example.com)", `<p><a href="mailto:team@example.com" rel="nofollow">mail</a></p>`},
+		{"`<b>` stays code", "<p><code>&lt;b&gt;</code> stays code</p>"},
+		{"```\n<script>x</script>\n```", "<pre><code>&lt;script&gt;x&lt;/script&gt;\n</code></pre>"},
+		{"- [x] done", `<ul>
+<li><input checked="" disabled="" type="checkbox"> done</li>
+</ul>`},
+	}
+	for _, tt := range tests {
+		if got := renderMarkdown(tt.markdown); got != tt.want {
+			t.Errorf("renderMarkdown(%q) =\n%s\nwant\n%s", tt.markdown, got, tt.want)
+		}
+	}
+}
+
+func TestCleanMarkdown(t *testing.T) {
+	if got := cleanMarkdown("a\r\nb\x00c\x1bd\te\x7f"); got != "a\nbcd\te" {
+		t.Errorf("cleanMarkdown = %q", got)
+	}
+}
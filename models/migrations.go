@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// ApplyMigration runs a data migration once. The migration is recorded with
+// a lightweight transaction before it runs, so concurrent instances do not
+// run it twice; if it fails the record is removed and the next start retries.
+func ApplyMigration(session *gocql.Session, name string, migrate func(*gocql.Session) error) error {
+	query := `INSERT INTO schema_migrations (name, applied_at) VALUES (?, ?) IF NOT EXISTS`
+	applied, err := session.Query(query, name, time.Now().UTC()).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return fmt.Errorf("failed to record migration %s: %v", name, err)
+	}
+	if !applied {
+		return nil
+	}
+
+	if err := migrate(session); err != nil {
+		session.Query(`DELETE FROM schema_migrations WHERE name = ?`, name).Exec()
+		return fmt.Errorf("migration %s failed: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type Notification struct {
+	UserID         gocql.UUID  `json:"user_id"`
+	NotificationID gocql.UUID  `json:"notification_id"`
+	Kind           string      `json:"kind"`
+	Title          string      `json:"title"`
+	Body           string      `json:"body"`
+	TaskID         *gocql.UUID `json:"task_id,omitempty"`
+	Read           bool        `json:"read"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+func (n *Notification) Create(session *gocql.Session) error {
+	n.NotificationID = gocql.TimeUUID()
+	n.CreatedAt = time.Now().UTC()
+	query := `INSERT INTO notifications (user_id, notification_id, kind, title, body, task_id, read, created_at)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	return session.Query(query,
+		n.UserID,
+		n.NotificationID,
+		n.Kind,
+		n.Title,
+		n.Body,
+		n.TaskID,
+		n.Read,
+		n.CreatedAt).Exec()
+}
+
+// GetNotificationsByUserID returns the user's most recent notifications.
+func GetNotificationsByUserID(session *gocql.Session, userID gocql.UUID, limit int) ([]Notification, error) {
+	notifications := []Notification{}
+	query := `SELECT user_id, notification_id, kind, title, body, task_id, read, created_at
+             FROM notifications WHERE user_id = ? LIMIT ?`
+	iter := session.Query(query, userID, limit).Iter()
+	var n Notification
+	for iter.Scan(&n.UserID, &n.NotificationID, &n.Kind, &n.Title, &n.Body, &n.TaskID, &n.Read, &n.CreatedAt) {
+		notifications = append(notifications, n)
+		n = Notification{}
+	}
+	return notifications, iter.Close()
+}
+
+func MarkNotificationRead(session *gocql.Session, userID, notificationID gocql.UUID) error {
+	query := `UPDATE notifications SET read = true WHERE user_id = ? AND notification_id = ?`
+	return session.Query(query, userID, notificationID).Exec()
+}
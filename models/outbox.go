@@ -0,0 +1,315 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+	"todo-app/eventbus"
+
+	"github.com/gocql/gocql"
+)
+
+// Domain events written to the outbox. Each describes a change that has
+// been made; the task events follow the entries of the task's history.
+// Automation is the Automation of the actor.
+type (
+	TaskCreated struct {
+		Task       *Task      `json:"task"`
+		ActorID    gocql.UUID `json:"actor_id"`
+		Automation int        `json:"automation,omitempty"`
+	}
+
+	// TaskUpdated is a change to the task other than its status. Changed
+	// lists the fields, by JSON name, and TagsAdded the tags the task
+	// gained.
+	TaskUpdated struct {
+		Task       *Task      `json:"task"`
+		Changed    []string   `json:"changed"`
+		TagsAdded  []string   `json:"tags_added,omitempty"`
+		ActorID    gocql.UUID `json:"actor_id"`
+		Automation int        `json:"automation,omitempty"`
+	}
+
+	// TaskStatusChanged is a change to the task's status, possibly along
+	// with other fields.
+	TaskStatusChanged struct {
+		Task       *Task      `json:"task"`
+		From       string     `json:"from"`
+		To         string     `json:"to"`
+		Changed    []string   `json:"changed"`
+		TagsAdded  []string   `json:"tags_added,omitempty"`
+		ActorID    gocql.UUID `json:"actor_id"`
+		Automation int        `json:"automation,omitempty"`
+	}
+
+	TaskDeleted struct {
+		Task    *Task      `json:"task"`
+		ActorID gocql.UUID `json:"actor_id"`
+	}
+
+	TaskRestored struct {
+		Task    *Task      `json:"task"`
+		ActorID gocql.UUID `json:"actor_id"`
+	}
+
+	CategoryCreated struct {
+		Category *Category `json:"category"`
+	}
+	CategoryUpdated struct {
+		Category *Category `json:"category"`
+	}
+	CategoryDeleted struct {
+		Category *Category `json:"category"`
+	}
+	CategoryRestored struct {
+		Category *Category `json:"category"`
+	}
+
+	UserRegistered struct {
+		UserID   gocql.UUID `json:"user_id"`
+		Username string     `json:"username"`
+		Email    string     `json:"email"`
+	}
+)
+
+func (TaskCreated) EventName() string       { return "TaskCreated" }
+func (TaskUpdated) EventName() string       { return "TaskUpdated" }
+func (TaskStatusChanged) EventName() string { return "TaskStatusChanged" }
+func (TaskDeleted) EventName() string       { return "TaskDeleted" }
+func (TaskRestored) EventName() string      { return "TaskRestored" }
+func (CategoryCreated) EventName() string   { return "CategoryCreated" }
+func (CategoryUpdated) EventName() string   { return "CategoryUpdated" }
+func (CategoryDeleted) EventName() string   { return "CategoryDeleted" }
+func (CategoryRestored) EventName() string  { return "CategoryRestored" }
+func (UserRegistered) EventName() string    { return "UserRegistered" }
+
+// ErrLeaseLost is returned when a cursor's lease has passed to another
+// instance.
+var ErrLeaseLost = errors.New("lease was taken over by another instance")
+
+// OutboxRetention is how long events stay in the outbox. A subscriber that
+// falls further behind misses events.
+var OutboxRetention = 7 * 24 * time.Hour
+
+// OutboxSettleTime is how far behind the present subscribers stop reading
+// the outbox, like SyncSettleTime for the sync log: event IDs are taken
+// before their batch commits, so the latest events may still be joined by
+// earlier ones.
+var OutboxSettleTime = 5 * time.Second
+
+// OutboxBucket returns the outbox partition for events written at t.
+func OutboxBucket(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15")
+}
+
+// addOutboxEvent queues the event's insert into the outbox, so that it is
+// written together with the change it describes.
+func addOutboxEvent(batch *gocql.Batch, e eventbus.Event) {
+	name, payload, err := eventbus.Encode(e)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", name, err)
+		return
+	}
+	addOutboxEntry(batch, name, payload)
+}
+
+// addOutboxEntry queues the insert of an encoded event into the outbox.
+func addOutboxEntry(batch *gocql.Batch, name string, payload []byte) {
+	id := gocql.TimeUUID()
+	batch.Query(`INSERT INTO outbox (bucket, event_id, name, payload) VALUES (?, ?, ?, ?) USING TTL ?`,
+		OutboxBucket(id.Time()), id, name, string(payload), int(OutboxRetention.Seconds()))
+}
+
+// addTaskOutbox queues the domain event of a change recorded in the task's
+// history.
+func addTaskOutbox(batch *gocql.Batch, event *TaskEvent, before, after *Task) {
+	addOutboxEvent(batch, taskDomainEvent(event, before, after))
+}
+
+// taskDomainEvent returns the domain event of a change recorded in the
+// task's history.
+func taskDomainEvent(event *TaskEvent, before, after *Task) eventbus.Event {
+	changed := make([]string, 0, len(event.Changes))
+	for field := range event.Changes {
+		changed = append(changed, field)
+	}
+	sort.Strings(changed)
+	var tagsAdded []string
+	if before != nil {
+		for _, tag := range after.Tags {
+			if !containsString(before.Tags, tag) {
+				tagsAdded = append(tagsAdded, tag)
+			}
+		}
+	}
+
+	switch event.Type {
+	case TaskEventCreated:
+		return TaskCreated{Task: after, ActorID: event.ActorID, Automation: event.automation}
+	case TaskEventDeleted:
+		return TaskDeleted{Task: after, ActorID: event.ActorID}
+	case TaskEventRestored:
+		return TaskRestored{Task: after, ActorID: event.ActorID}
+	case TaskEventStatusChanged:
+		return TaskStatusChanged{
+			Task:       after,
+			From:       before.Status,
+			To:         after.Status,
+			Changed:    changed,
+			TagsAdded:  tagsAdded,
+			ActorID:    event.ActorID,
+			Automation: event.automation,
+		}
+	default:
+		return TaskUpdated{
+			Task:       after,
+			Changed:    changed,
+			TagsAdded:  tagsAdded,
+			ActorID:    event.ActorID,
+			Automation: event.automation,
+		}
+	}
+}
+
+// OutboxEvent is an event read back from the outbox.
+type OutboxEvent struct {
+	EventID gocql.UUID
+	Name    string
+	Payload json.RawMessage
+}
+
+// Meta returns the event's description for the bus.
+func (e OutboxEvent) Meta() eventbus.Meta {
+	return eventbus.Meta{ID: e.EventID, Name: e.Name, OccurredAt: e.EventID.Time().UTC()}
+}
+
+// GetOutboxEvents returns up to limit events written after the one at after
+// and up to until, oldest first.
+func GetOutboxEvents(session *gocql.Session, after gocql.UUID, until time.Time, limit int) ([]OutboxEvent, error) {
+	events := []OutboxEvent{}
+	last := gocql.MaxTimeUUID(until)
+	for t := after.Time().Truncate(time.Hour); !t.After(until) && len(events) < limit; t = t.Add(time.Hour) {
+		query := `SELECT event_id, name, payload FROM outbox WHERE bucket = ? AND event_id > ? AND event_id <= ? LIMIT ?`
+		iter := session.Query(query, OutboxBucket(t), after, last, limit-len(events)).Iter()
+		var e OutboxEvent
+		for iter.Scan(&e.EventID, &e.Name, &e.Payload) {
+			events = append(events, e)
+			e = OutboxEvent{}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// OutboxCursor is a subscriber's progress through the outbox: every event
+// up to Position has been handled. Attempts counts the failed attempts at
+// the event after it.
+type OutboxCursor struct {
+	Subscriber string
+	Position   gocql.UUID
+	Attempts   int
+	LastError  string
+	LeaseOwner string
+	LeaseUntil time.Time
+	UpdatedAt  time.Time
+}
+
+// AcquireOutboxCursor takes a lease on the subscriber's cursor with a
+// lightweight transaction, so that only one instance delivers its events.
+// It returns nil if another instance holds the lease. New subscribers start
+// with the events written from now on.
+func AcquireOutboxCursor(session *gocql.Session, subscriber, owner string, lease time.Duration) (*OutboxCursor, error) {
+	now := time.Now().UTC()
+	c := &OutboxCursor{Subscriber: subscriber}
+	query := `SELECT position, attempts, last_error, lease_owner, lease_until, updated_at FROM outbox_cursors
+             WHERE subscriber = ?`
+	err := session.Query(query, subscriber).Scan(&c.Position, &c.Attempts, &c.LastError, &c.LeaseOwner,
+		&c.LeaseUntil, &c.UpdatedAt)
+	if err == gocql.ErrNotFound {
+		c.Position = gocql.MinTimeUUID(now)
+		c.LeaseOwner = owner
+		c.LeaseUntil = now.Add(lease)
+		c.UpdatedAt = now
+		query := `INSERT INTO outbox_cursors (subscriber, position, attempts, lease_owner, lease_until, updated_at)
+                 VALUES (?, ?, 0, ?, ?, ?) IF NOT EXISTS`
+		applied, err := session.Query(query, subscriber, c.Position, owner, c.LeaseUntil, c.UpdatedAt).
+			MapScanCAS(map[string]interface{}{})
+		if err != nil || !applied {
+			return nil, err
+		}
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if c.LeaseOwner != owner && now.Before(c.LeaseUntil) {
+		return nil, nil
+	}
+	leaseUntil := now.Add(lease)
+	query = `UPDATE outbox_cursors SET lease_owner = ?, lease_until = ? WHERE subscriber = ?
+             IF lease_owner = ? AND lease_until = ?`
+	applied, err := session.Query(query, owner, leaseUntil, subscriber, c.LeaseOwner, c.LeaseUntil).
+		MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return nil, err
+	}
+	c.LeaseOwner = owner
+	c.LeaseUntil = leaseUntil
+	return c, nil
+}
+
+// Advance records that every event up to position has been handled. It
+// returns ErrLeaseLost if the cursor is no longer leased to its owner.
+func (c *OutboxCursor) Advance(session *gocql.Session, position gocql.UUID) error {
+	now := time.Now().UTC()
+	query := `UPDATE outbox_cursors SET position = ?, attempts = 0, last_error = null, updated_at = ?
+             WHERE subscriber = ? IF lease_owner = ?`
+	applied, err := session.Query(query, position, now, c.Subscriber, c.LeaseOwner).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrLeaseLost
+	}
+	c.Position = position
+	c.Attempts = 0
+	c.LastError = ""
+	c.UpdatedAt = now
+	return nil
+}
+
+// RecordFailure records that every event up to position has been handled
+// and the next one failed. It returns ErrLeaseLost like Advance.
+func (c *OutboxCursor) RecordFailure(session *gocql.Session, position gocql.UUID, cause error) error {
+	now := time.Now().UTC()
+	attempts := c.Attempts + 1
+	if position != c.Position {
+		attempts = 1
+	}
+	query := `UPDATE outbox_cursors SET position = ?, attempts = ?, last_error = ?, updated_at = ?
+             WHERE subscriber = ? IF lease_owner = ?`
+	applied, err := session.Query(query, position, attempts, cause.Error(), now, c.Subscriber, c.LeaseOwner).
+		MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrLeaseLost
+	}
+	c.Position = position
+	c.Attempts = attempts
+	c.LastError = cause.Error()
+	c.UpdatedAt = now
+	return nil
+}
+
+// DeadLetter sets aside an event the subscriber gave up on, for someone to
+// look into.
+func (c *OutboxCursor) DeadLetter(session *gocql.Session, e OutboxEvent, cause error) error {
+	query := `INSERT INTO outbox_dead_letters (subscriber, event_id, name, payload, error, attempts) VALUES (?, ?, ?, ?, ?, ?)`
+	return session.Query(query, c.Subscriber, e.EventID, e.Name, string(e.Payload), cause.Error(), c.Attempts).Exec()
+}
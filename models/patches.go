@@ -0,0 +1,73 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var ErrReadOnlyField = errors.New("field cannot be changed")
+
+// PatchedTask decodes doc, the JSON document of t after a patch was applied
+// to it, into a new task. Only the fields clients may update can differ from
+// t; the result still has to be validated by Update.
+func PatchedTask(t *Task, doc []byte) (*Task, error) {
+	patched := &Task{}
+	if err := decodeStrict(doc, patched); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTask, err)
+	}
+
+	for name := range diffTasks(t, patched) {
+		if !isUpdatableTaskField(name) {
+			return nil, fmt.Errorf("%w: %s", ErrReadOnlyField, name)
+		}
+	}
+	if patched.Version != t.Version || !patched.UpdatedAt.Equal(t.UpdatedAt) {
+		return nil, fmt.Errorf("%w: version and updated_at are set by the server", ErrReadOnlyField)
+	}
+	if patched.Blocked != t.Blocked || !reflect.DeepEqual(patched.Progress, t.Progress) || patched.NextOccurrence != nil {
+		return nil, fmt.Errorf("%w: progress, blocked and next_occurrence are computed", ErrReadOnlyField)
+	}
+	return patched, nil
+}
+
+func isUpdatableTaskField(name string) bool {
+	for _, field := range updatableTaskFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PatchedCategory decodes doc, the JSON document of c after a patch was
+// applied to it, into a new category. Only the name may differ from c.
+func PatchedCategory(c *Category, doc []byte) (*Category, error) {
+	patched := &Category{}
+	if err := decodeStrict(doc, patched); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCategory, err)
+	}
+
+	switch {
+	case patched.CategoryID != c.CategoryID:
+		return nil, fmt.Errorf("%w: category_id", ErrReadOnlyField)
+	case patched.WorkspaceID != c.WorkspaceID:
+		return nil, fmt.Errorf("%w: workspace_id", ErrReadOnlyField)
+	case !patched.CreatedAt.Equal(c.CreatedAt):
+		return nil, fmt.Errorf("%w: created_at", ErrReadOnlyField)
+	case !reflect.DeepEqual(patched.DeletedAt, c.DeletedAt):
+		return nil, fmt.Errorf("%w: deleted_at", ErrReadOnlyField)
+	case patched.Version != c.Version:
+		return nil, fmt.Errorf("%w: version", ErrReadOnlyField)
+	}
+	return patched, nil
+}
+
+// decodeStrict decodes a JSON document, rejecting unknown members.
+func decodeStrict(doc []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"todo-app/eventbus"
+
+	"github.com/gocql/gocql"
+)
+
+// Conditional writes cannot be batched with writes to other tables, so the
+// history entry, sync log entry and domain event of a change made with one
+// are written after it. So that they are not lost when that fails or the
+// instance dies in between, they are staged: an intent naming the row is
+// written to pending_events first, and the conditional write stores the
+// follow-up in the row's own pending_events, so that it applies together
+// with the change. Once the follow-up is written both are cleared; intents
+// left behind are reconciled by ReconcilePendingEvent.
+
+// PendingEventRetention is how long intents are kept for reconciling.
+var PendingEventRetention = 24 * time.Hour
+
+// pendingWriteRows maps the kinds of items written conditionally to their
+// table and its key column.
+var pendingWriteRows = map[string][2]string{
+	SyncKindTask:     {"tasks", "task_id"},
+	SyncKindCategory: {"categories", "category_id"},
+}
+
+// pendingWrite is the follow-up of a conditional write to a task or
+// category. Scope is the workspace whose sync log records the change, if
+// syncing clients are told about it; Name and Payload are the encoded
+// domain event, if there is one.
+type pendingWrite struct {
+	ID      gocql.UUID      `json:"id"`
+	Kind    string          `json:"kind"`
+	ItemID  gocql.UUID      `json:"item_id"`
+	Scope   *gocql.UUID     `json:"scope,omitempty"`
+	History *TaskEvent      `json:"history,omitempty"`
+	Name    string          `json:"name,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// newCategoryWrite returns the follow-up of a change to the category that
+// the event describes.
+func newCategoryWrite(c *Category, e eventbus.Event) *pendingWrite {
+	scope := c.WorkspaceID
+	w := &pendingWrite{ID: gocql.TimeUUID(), Kind: SyncKindCategory, ItemID: c.CategoryID, Scope: &scope}
+	w.setDomainEvent(e)
+	return w
+}
+
+func (w *pendingWrite) setDomainEvent(e eventbus.Event) {
+	name, payload, err := eventbus.Encode(e)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", name, err)
+		return
+	}
+	w.Name = name
+	w.Payload = payload
+}
+
+// PendingEventBucket returns the pending_events partition for intents
+// staged at t.
+func PendingEventBucket(t time.Time) string {
+	return OutboxBucket(t)
+}
+
+// stage writes the follow-up's intent and returns the entry the conditional
+// write adds to the row's pending_events.
+func (w *pendingWrite) stage(session *gocql.Session) (map[gocql.UUID]string, error) {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return nil, err
+	}
+	query := `INSERT INTO pending_events (bucket, event_id, kind, item_id) VALUES (?, ?, ?, ?) USING TTL ?`
+	err = session.Query(query, PendingEventBucket(w.ID.Time()), w.ID, w.Kind, w.ItemID,
+		int(PendingEventRetention.Seconds())).Exec()
+	if err != nil {
+		return nil, err
+	}
+	return map[gocql.UUID]string{w.ID: string(data)}, nil
+}
+
+// flush writes the follow-up, then clears it from the row and drops its
+// intent.
+func (w *pendingWrite) flush(session *gocql.Session) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	if w.History != nil {
+		w.History.addToBatch(batch)
+	}
+	if w.Scope != nil {
+		addSyncChange(batch, *w.Scope, w.Kind, w.ItemID)
+	}
+	if w.Name != "" {
+		addOutboxEntry(batch, w.Name, w.Payload)
+	}
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+
+	// The entry was added by a conditional write, which plain writes cannot
+	// be ordered after, so it is cleared by one too.
+	row := pendingWriteRows[w.Kind]
+	query := fmt.Sprintf(`UPDATE %s SET pending_events = pending_events - ? WHERE %s = ? IF EXISTS`, row[0], row[1])
+	if _, err := session.Query(query, []gocql.UUID{w.ID}, w.ItemID).MapScanCAS(map[string]interface{}{}); err != nil {
+		return err
+	}
+	return deletePendingEvent(session, PendingEventBucket(w.ID.Time()), w.ID)
+}
+
+// writeTaskChange changes the task from before to after with a write
+// conditional on before's version, setting the given columns along with the
+// clocks of the changed fields and the staged follow-up: the history entry
+// of the change and, unless the task stays in the trash, its sync log entry
+// and domain event. It returns ErrVersionMismatch if the task changed, and
+// the follow-up to hand to recordTaskEvent otherwise, nil if nothing tracked
+// changed.
+func writeTaskChange(session *gocql.Session, before, after *Task, actor Actor, assignments []string,
+	values ...interface{}) (*pendingWrite, error) {
+	var w *pendingWrite
+	if event := newTaskEvent(before, after, actor); event != nil {
+		w = &pendingWrite{ID: event.EventID, Kind: SyncKindTask, ItemID: after.TaskID, History: event}
+		// Tasks in the trash only have changes recorded in their history:
+		// to everyone else they stay deleted until they are restored.
+		if before.DeletedAt == nil || after.DeletedAt == nil {
+			scope := after.WorkspaceID
+			w.Scope = &scope
+			w.setDomainEvent(taskDomainEvent(event, before, after))
+			if clocks := taskClockStamps(before, after); len(clocks) > 0 {
+				assignments = append(assignments, "field_clocks = field_clocks + ?")
+				values = append(values, clocks)
+			}
+		}
+		entry, err := w.stage(session)
+		if err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, "pending_events = pending_events + ?")
+		values = append(values, entry)
+	}
+
+	query := `UPDATE tasks SET ` + strings.Join(assignments, ", ") + ` WHERE task_id = ? IF version = ?`
+	values = append(values, before.TaskID, before.Version)
+	if err := applyIfVersion(session.Query(query, values...)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// recordTaskEvent writes the follow-up of a change made with
+// writeTaskChange and publishes the change. A follow-up that cannot be
+// written now is left for ReconcilePendingEvent.
+func recordTaskEvent(session *gocql.Session, w *pendingWrite, after *Task) {
+	if w == nil {
+		return
+	}
+	if err := w.flush(session); err != nil {
+		log.Printf("Failed to record event %s of task %s, leaving it to be reconciled: %v", w.ID, after.TaskID, err)
+	}
+	if w.Scope != nil {
+		publishTaskChange(session, w.History.Type, after)
+	}
+}
+
+// recordCategoryEvent writes the follow-up of a conditional write to the
+// category and publishes the change as an event of the given type, like
+// recordTaskEvent.
+func recordCategoryEvent(session *gocql.Session, w *pendingWrite, c *Category, eventType string) {
+	if err := w.flush(session); err != nil {
+		log.Printf("Failed to record event %s of category %s, leaving it to be reconciled: %v", w.ID, c.CategoryID, err)
+	}
+	publishEvent(session, c.WorkspaceID, eventType, c)
+}
+
+// PendingEvent is the intent of a follow-up staged for a conditional write.
+type PendingEvent struct {
+	Bucket  string
+	EventID gocql.UUID
+	Kind    string
+	ItemID  gocql.UUID
+}
+
+// GetPendingEvents returns the intents of the bucket staged before the given
+// time.
+func GetPendingEvents(session *gocql.Session, bucket string, before time.Time) ([]PendingEvent, error) {
+	events := []PendingEvent{}
+	query := `SELECT bucket, event_id, kind, item_id FROM pending_events WHERE bucket = ? AND event_id < ?`
+	iter := session.Query(query, bucket, gocql.MinTimeUUID(before)).Iter()
+	var e PendingEvent
+	for iter.Scan(&e.Bucket, &e.EventID, &e.Kind, &e.ItemID) {
+		events = append(events, e)
+		e = PendingEvent{}
+	}
+	return events, iter.Close()
+}
+
+// ReconcilePendingEvent writes the follow-up the intent names if its
+// conditional write applied and the follow-up is still pending on the row,
+// and drops the intent. The row is read at serial consistency, so that a
+// conditional write still in progress is completed or rolled back first.
+func ReconcilePendingEvent(session *gocql.Session, e PendingEvent) error {
+	row, ok := pendingWriteRows[e.Kind]
+	if !ok {
+		return deletePendingEvent(session, e.Bucket, e.EventID)
+	}
+	var pending map[gocql.UUID]string
+	query := fmt.Sprintf(`SELECT pending_events FROM %s WHERE %s = ?`, row[0], row[1])
+	err := session.Query(query, e.ItemID).Consistency(gocql.Consistency(gocql.Serial)).Scan(&pending)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	data, ok := pending[e.EventID]
+	if !ok {
+		return deletePendingEvent(session, e.Bucket, e.EventID)
+	}
+
+	var w pendingWrite
+	if err := json.Unmarshal([]byte(data), &w); err != nil {
+		return fmt.Errorf("pending event %s of %s %s: %v", e.EventID, e.Kind, e.ItemID, err)
+	}
+	return w.flush(session)
+}
+
+func deletePendingEvent(session *gocql.Session, bucket string, eventID gocql.UUID) error {
+	query := `DELETE FROM pending_events WHERE bucket = ? AND event_id = ?`
+	return session.Query(query, bucket, eventID).Exec()
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// TestPendingWriteRoundTrip checks that a staged follow-up reads back as it
+// was written, so that reconciling it writes the same history entry and
+// domain event the request would have.
+func TestPendingWriteRoundTrip(t *testing.T) {
+	before := NewTask(gocql.TimeUUID(), "Water the plants", "", StatusPending)
+	before.TaskID = gocql.TimeUUID()
+	before.WorkspaceID = gocql.TimeUUID()
+	before.Tags = []string{"home"}
+	due := time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)
+	after := *before
+	after.Title = "Water the \"plants\""
+	after.Tags = []string{"garden", "home"}
+	after.DueAt = &due
+	after.Version = before.Version + 1
+
+	event := newTaskEvent(before, &after, Actor{UserID: before.UserID, RequestID: "req-1"})
+	scope := after.WorkspaceID
+	w := &pendingWrite{ID: event.EventID, Kind: SyncKindTask, ItemID: after.TaskID, Scope: &scope, History: event}
+	w.setDomainEvent(taskDomainEvent(event, before, &after))
+
+	data, err := json.Marshal(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got pendingWrite
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != w.ID || got.Kind != w.Kind || got.ItemID != w.ItemID || got.Scope == nil || *got.Scope != scope {
+		t.Errorf("read back %+v, want %+v", got, w)
+	}
+	if got.Name != "TaskUpdated" || string(got.Payload) != string(w.Payload) {
+		t.Errorf("domain event %s %s, want %s %s", got.Name, got.Payload, w.Name, w.Payload)
+	}
+	if got.History == nil || !reflect.DeepEqual(got.History.Changes, event.Changes) ||
+		got.History.Type != event.Type || got.History.RequestID != "req-1" || !got.History.CreatedAt.Equal(event.CreatedAt) {
+		t.Errorf("history %+v, want %+v", got.History, event)
+	}
+}
+
+func TestFieldChangeJSON(t *testing.T) {
+	for _, c := range []FieldChange{{Old: "", New: `"a"`}, {Old: `["x","y"]`, New: ""}, {Old: "1", New: "2"}} {
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got FieldChange
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != c {
+			t.Errorf("%s read back as %+v, want %+v", data, got, c)
+		}
+	}
+}
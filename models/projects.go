@@ -0,0 +1,111 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	ErrInvalidProject  = errors.New("invalid project")
+	ErrProjectNotFound = errors.New("project not found")
+	ErrProjectNotEmpty = errors.New("project still has tasks")
+)
+
+// Project groups related tasks within a workspace.
+type Project struct {
+	WorkspaceID gocql.UUID `json:"workspace_id"`
+	ProjectID   gocql.UUID `json:"project_id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (p *Project) Create(session *gocql.Session) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	p.ProjectID = gocql.TimeUUID()
+	p.CreatedAt = p.ProjectID.Time().UTC()
+	return p.save(session)
+}
+
+func (p *Project) Update(session *gocql.Session) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	return p.save(session)
+}
+
+func (p *Project) save(session *gocql.Session) error {
+	query := `INSERT INTO projects (workspace_id, project_id, name, description, created_at) VALUES (?, ?, ?, ?, ?)`
+	return session.Query(query, p.WorkspaceID, p.ProjectID, p.Name, p.Description, p.CreatedAt).Exec()
+}
+
+func (p *Project) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidProject)
+	}
+	if utf8.RuneCountInString(p.Name) > 100 {
+		return fmt.Errorf("%w: name cannot be longer than 100 characters", ErrInvalidProject)
+	}
+	if utf8.RuneCountInString(p.Description) > 2000 {
+		return fmt.Errorf("%w: description cannot be longer than 2000 characters", ErrInvalidProject)
+	}
+	return nil
+}
+
+// Delete removes the project. Projects that tasks still belong to, including
+// tasks in the trash, are kept and ErrProjectNotEmpty is returned.
+func (p *Project) Delete(session *gocql.Session) error {
+	query := `SELECT task_id FROM tasks WHERE workspace_id = ? AND project_id = ? LIMIT 1 ALLOW FILTERING`
+	var taskID gocql.UUID
+	err := session.Query(query, p.WorkspaceID, p.ProjectID).Scan(&taskID)
+	if err == nil {
+		return ErrProjectNotEmpty
+	} else if err != gocql.ErrNotFound {
+		return err
+	}
+	return session.Query(`DELETE FROM projects WHERE workspace_id = ? AND project_id = ?`,
+		p.WorkspaceID, p.ProjectID).Exec()
+}
+
+// GetProject returns a project of the workspace, or ErrProjectNotFound.
+func GetProject(session *gocql.Session, workspaceID, projectID gocql.UUID) (*Project, error) {
+	p := &Project{}
+	query := `SELECT workspace_id, project_id, name, description, created_at FROM projects
+             WHERE workspace_id = ? AND project_id = ?`
+	err := session.Query(query, workspaceID, projectID).Scan(
+		&p.WorkspaceID, &p.ProjectID, &p.Name, &p.Description, &p.CreatedAt)
+	if err == gocql.ErrNotFound {
+		return nil, ErrProjectNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetProjects returns the projects of the workspace by name.
+func GetProjects(session *gocql.Session, workspaceID gocql.UUID) ([]Project, error) {
+	projects := []Project{}
+	query := `SELECT workspace_id, project_id, name, description, created_at FROM projects WHERE workspace_id = ?`
+	iter := session.Query(query, workspaceID).Iter()
+	var p Project
+	for iter.Scan(&p.WorkspaceID, &p.ProjectID, &p.Name, &p.Description, &p.CreatedAt) {
+		projects = append(projects, p)
+		p = Project{}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return strings.ToLower(projects[i].Name) < strings.ToLower(projects[j].Name)
+	})
+	return projects, nil
+}
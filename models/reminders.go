@@ -0,0 +1,450 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"todo-app/netguard"
+
+	"github.com/gocql/gocql"
+)
+
+// States of a reminder. Reminders of tasks that are closed or in the trash
+// when they come due are cancelled rather than sent.
+const (
+	ReminderPending   = "pending"
+	ReminderFiring    = "firing"
+	ReminderSent      = "sent"
+	ReminderFailed    = "failed"
+	ReminderCancelled = "cancelled"
+)
+
+const (
+	ChannelInApp   = "in_app"
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+)
+
+// MaxReminderAttempts is how many times delivery of a reminder is tried
+// before it is marked as failed.
+const MaxReminderAttempts = 5
+
+var ErrInvalidReminder = errors.New("invalid reminder")
+
+// Reminder fires either OffsetMinutes before the task is due, or at AtTime
+// ("15:04", in the task's time zone) on the day the task is due.
+type Reminder struct {
+	ReminderID    gocql.UUID `json:"reminder_id"`
+	TaskID        gocql.UUID `json:"task_id"`
+	UserID        gocql.UUID `json:"user_id"`
+	Channel       string     `json:"channel"`
+	Target        string     `json:"target,omitempty"`
+	OffsetMinutes int        `json:"offset_minutes"`
+	AtTime        string     `json:"at_time,omitempty"`
+	FireAt        time.Time  `json:"fire_at"`
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	LeaseOwner    string     `json:"-"`
+	LeaseUntil    time.Time  `json:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+}
+
+// PendingReminder is an entry of the scheduler's queue.
+type PendingReminder struct {
+	Bucket     string
+	FireAt     time.Time
+	ReminderID gocql.UUID
+}
+
+const reminderColumns = `reminder_id, task_id, user_id, channel, target, offset_minutes, at_time, fire_at,
+	state, attempts, last_error, lease_owner, lease_until, created_at, sent_at`
+
+func (r *Reminder) scanDest() []interface{} {
+	return []interface{}{
+		&r.ReminderID,
+		&r.TaskID,
+		&r.UserID,
+		&r.Channel,
+		&r.Target,
+		&r.OffsetMinutes,
+		&r.AtTime,
+		&r.FireAt,
+		&r.State,
+		&r.Attempts,
+		&r.LastError,
+		&r.LeaseOwner,
+		&r.LeaseUntil,
+		&r.CreatedAt,
+		&r.SentAt,
+	}
+}
+
+// ReminderBucket returns the pending_reminders partition for a fire time.
+func ReminderBucket(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15")
+}
+
+// Create validates the reminder against its task and schedules it for the
+// actor.
+func (r *Reminder) Create(session *gocql.Session, task *Task, actor Actor) error {
+	if err := r.validate(); err != nil {
+		return err
+	}
+	if err := r.computeFireAt(task); err != nil {
+		return err
+	}
+
+	r.ReminderID = gocql.TimeUUID()
+	r.TaskID = task.TaskID
+	r.UserID = actor.UserID
+	r.State = ReminderPending
+	r.Attempts = 0
+	r.CreatedAt = time.Now().UTC()
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO reminders (reminder_id, task_id, user_id, channel, target, offset_minutes, at_time,
+             fire_at, state, attempts, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.ReminderID, r.TaskID, r.UserID, r.Channel, r.Target, r.OffsetMinutes, r.AtTime,
+		r.FireAt, r.State, r.Attempts, r.CreatedAt)
+	batch.Query(`INSERT INTO pending_reminders (bucket, fire_at, reminder_id) VALUES (?, ?, ?)`,
+		ReminderBucket(r.FireAt), r.FireAt, r.ReminderID)
+	return session.ExecuteBatch(batch)
+}
+
+func (r *Reminder) validate() error {
+	switch r.Channel {
+	case ChannelInApp:
+	case ChannelEmail:
+		if r.Target != "" && !strings.Contains(r.Target, "@") {
+			return fmt.Errorf("%w: invalid email address", ErrInvalidReminder)
+		}
+	case ChannelWebhook:
+		if err := netguard.CheckURL(r.Target); err != nil {
+			return fmt.Errorf("%w: webhook target: %v", ErrInvalidReminder, err)
+		}
+	default:
+		return fmt.Errorf("%w: unknown channel %q", ErrInvalidReminder, r.Channel)
+	}
+
+	if r.OffsetMinutes < 0 {
+		return fmt.Errorf("%w: offset_minutes cannot be negative", ErrInvalidReminder)
+	}
+	if r.AtTime != "" {
+		if r.OffsetMinutes != 0 {
+			return fmt.Errorf("%w: use either offset_minutes or at_time", ErrInvalidReminder)
+		}
+		if _, err := time.Parse("15:04", r.AtTime); err != nil {
+			return fmt.Errorf("%w: at_time must be HH:MM", ErrInvalidReminder)
+		}
+	}
+	return nil
+}
+
+func (r *Reminder) computeFireAt(task *Task) error {
+	if task.DueAt == nil {
+		return fmt.Errorf("%w: task has no due date", ErrInvalidReminder)
+	}
+	if r.AtTime == "" {
+		r.FireAt = task.DueAt.Add(-time.Duration(r.OffsetMinutes) * time.Minute).UTC()
+		return nil
+	}
+
+	loc, err := time.LoadLocation(task.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	at, _ := time.Parse("15:04", r.AtTime)
+	due := task.DueAt.In(loc)
+	r.FireAt = time.Date(due.Year(), due.Month(), due.Day(), at.Hour(), at.Minute(), 0, 0, loc).UTC()
+	return nil
+}
+
+func GetReminderByID(session *gocql.Session, reminderID gocql.UUID) (*Reminder, error) {
+	r := &Reminder{}
+	query := `SELECT ` + reminderColumns + ` FROM reminders WHERE reminder_id = ?`
+	if err := session.Query(query, reminderID).Scan(r.scanDest()...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func GetRemindersByTaskID(session *gocql.Session, taskID gocql.UUID) ([]*Reminder, error) {
+	reminders := []*Reminder{}
+	query := `SELECT ` + reminderColumns + ` FROM reminders WHERE task_id = ?`
+	iter := session.Query(query, taskID).Iter()
+	for {
+		r := &Reminder{}
+		if !iter.Scan(r.scanDest()...) {
+			break
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, iter.Close()
+}
+
+func (r *Reminder) Delete(session *gocql.Session) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM reminders WHERE reminder_id = ?`, r.ReminderID)
+	batch.Query(`DELETE FROM pending_reminders WHERE bucket = ? AND fire_at = ? AND reminder_id = ?`,
+		ReminderBucket(r.FireAt), r.FireAt, r.ReminderID)
+	return session.ExecuteBatch(batch)
+}
+
+// RescheduleReminders moves the task's pending reminders after its due date
+// changed. Like the scheduler's claim, the move is conditional on the
+// reminder still being pending at its old time, so a reminder claimed in the
+// meantime is left to fire. The new queue entry is written first and the
+// old one removed last; an entry that no longer matches its reminder is
+// dropped by the scheduler.
+func RescheduleReminders(session *gocql.Session, task *Task) error {
+	if task.DueAt == nil {
+		return nil
+	}
+	reminders, err := GetRemindersByTaskID(session, task.TaskID)
+	if err != nil {
+		return err
+	}
+	for _, r := range reminders {
+		if r.State != ReminderPending {
+			continue
+		}
+		previous := r.FireAt
+		if err := r.computeFireAt(task); err != nil {
+			return err
+		}
+		if r.FireAt.Equal(previous) {
+			continue
+		}
+
+		next := PendingReminder{Bucket: ReminderBucket(r.FireAt), FireAt: r.FireAt, ReminderID: r.ReminderID}
+		query := `INSERT INTO pending_reminders (bucket, fire_at, reminder_id) VALUES (?, ?, ?)`
+		if err := session.Query(query, next.Bucket, next.FireAt, next.ReminderID).Exec(); err != nil {
+			return err
+		}
+		query = `UPDATE reminders SET fire_at = ? WHERE reminder_id = ? IF state = ? AND fire_at = ?`
+		applied, err := session.Query(query, r.FireAt, r.ReminderID, ReminderPending, previous).
+			MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		stale := PendingReminder{Bucket: ReminderBucket(previous), FireAt: previous, ReminderID: r.ReminderID}
+		if !applied {
+			stale = next
+		}
+		if err := DeletePendingReminder(session, stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelReminders cancels the pending reminders of the tasks, as when they
+// are moved to the trash.
+func cancelReminders(session *gocql.Session, tasks []*Task) error {
+	for _, task := range tasks {
+		reminders, err := GetRemindersByTaskID(session, task.TaskID)
+		if err != nil {
+			return err
+		}
+		for _, r := range reminders {
+			if r.State != ReminderPending {
+				continue
+			}
+			query := `UPDATE reminders SET state = ? WHERE reminder_id = ? IF state = ? AND fire_at = ?`
+			applied, err := session.Query(query, ReminderCancelled, r.ReminderID, ReminderPending, r.FireAt).
+				MapScanCAS(map[string]interface{}{})
+			if err != nil {
+				return err
+			}
+			if !applied {
+				continue
+			}
+			err = DeletePendingReminder(session, PendingReminder{
+				Bucket:     ReminderBucket(r.FireAt),
+				FireAt:     r.FireAt,
+				ReminderID: r.ReminderID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resumeReminders schedules the cancelled reminders of restored tasks again
+// if they have not come due yet. Reminders are only cancelled before they
+// come due when their task goes to the trash, so these are the ones the
+// trash cancelled.
+func resumeReminders(session *gocql.Session, tasks []*Task) error {
+	now := time.Now()
+	for _, task := range tasks {
+		reminders, err := GetRemindersByTaskID(session, task.TaskID)
+		if err != nil {
+			return err
+		}
+		for _, r := range reminders {
+			if r.State != ReminderCancelled || !r.FireAt.After(now) {
+				continue
+			}
+			query := `INSERT INTO pending_reminders (bucket, fire_at, reminder_id) VALUES (?, ?, ?)`
+			if err := session.Query(query, ReminderBucket(r.FireAt), r.FireAt, r.ReminderID).Exec(); err != nil {
+				return err
+			}
+			query = `UPDATE reminders SET state = ? WHERE reminder_id = ? IF state = ? AND fire_at = ?`
+			_, err := session.Query(query, ReminderPending, r.ReminderID, ReminderCancelled, r.FireAt).
+				MapScanCAS(map[string]interface{}{})
+			if err != nil {
+				return err
+			}
+		}
+		if err := RescheduleReminders(session, task); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyReminders schedules the reminders of one task again for another, as
+// when a recurring task moves on to its next occurrence.
+func CopyReminders(session *gocql.Session, from, to *Task) error {
+	reminders, err := GetRemindersByTaskID(session, from.TaskID)
+	if err != nil {
+		return err
+	}
+	for _, r := range reminders {
+		copied := &Reminder{
+			Channel:       r.Channel,
+			Target:        r.Target,
+			OffsetMinutes: r.OffsetMinutes,
+			AtTime:        r.AtTime,
+		}
+		// The copy goes to the user the original reminder was for.
+		if err := copied.Create(session, to, Actor{UserID: r.UserID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetReminderBuckets returns the pending_reminders partitions, oldest first.
+func GetReminderBuckets(session *gocql.Session) ([]string, error) {
+	var buckets []string
+	iter := session.Query(`SELECT DISTINCT bucket FROM pending_reminders`).Iter()
+	var bucket string
+	for iter.Scan(&bucket) {
+		buckets = append(buckets, bucket)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Strings(buckets)
+	return buckets, nil
+}
+
+// GetDueReminders returns the queue entries in the bucket that are due by now.
+func GetDueReminders(session *gocql.Session, bucket string, now time.Time) ([]PendingReminder, error) {
+	var pending []PendingReminder
+	query := `SELECT bucket, fire_at, reminder_id FROM pending_reminders WHERE bucket = ? AND fire_at <= ?`
+	iter := session.Query(query, bucket, now).Iter()
+	var p PendingReminder
+	for iter.Scan(&p.Bucket, &p.FireAt, &p.ReminderID) {
+		pending = append(pending, p)
+	}
+	return pending, iter.Close()
+}
+
+func DeletePendingReminder(session *gocql.Session, p PendingReminder) error {
+	query := `DELETE FROM pending_reminders WHERE bucket = ? AND fire_at = ? AND reminder_id = ?`
+	return session.Query(query, p.Bucket, p.FireAt, p.ReminderID).Exec()
+}
+
+// Claim takes a lease on a pending reminder with a lightweight transaction,
+// so that only one instance delivers it. It returns false if another
+// instance got there first.
+func (r *Reminder) Claim(session *gocql.Session, owner string, lease time.Duration) (bool, error) {
+	leaseUntil := time.Now().Add(lease).UTC()
+	query := `UPDATE reminders SET state = ?, lease_owner = ?, lease_until = ?
+             WHERE reminder_id = ? IF state = ? AND fire_at = ?`
+	applied, err := session.Query(query, ReminderFiring, owner, leaseUntil,
+		r.ReminderID, ReminderPending, r.FireAt).MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return false, err
+	}
+
+	r.State = ReminderFiring
+	r.LeaseOwner = owner
+	r.LeaseUntil = leaseUntil
+	err = DeletePendingReminder(session, PendingReminder{
+		Bucket:     ReminderBucket(r.FireAt),
+		FireAt:     r.FireAt,
+		ReminderID: r.ReminderID,
+	})
+	return true, err
+}
+
+// MarkSent records a successful delivery.
+func (r *Reminder) MarkSent(session *gocql.Session) error {
+	now := time.Now().UTC()
+	r.State = ReminderSent
+	r.SentAt = &now
+	r.Attempts++
+	query := `UPDATE reminders SET state = ?, sent_at = ?, attempts = ? WHERE reminder_id = ? IF lease_owner = ?`
+	_, err := session.Query(query, r.State, r.SentAt, r.Attempts, r.ReminderID, r.LeaseOwner).
+		MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+// MarkAttemptFailed records a failed delivery and either schedules a retry
+// at retryAt or, once MaxReminderAttempts is reached, gives up. The retry is
+// queued before the reminder is released: an entry whose release does not
+// happen no longer matches the reminder and is dropped by the scheduler.
+func (r *Reminder) MarkAttemptFailed(session *gocql.Session, cause error, retryAt time.Time) error {
+	if r.Attempts+1 >= MaxReminderAttempts {
+		return r.MarkFailed(session, cause)
+	}
+
+	fireAt := retryAt.UTC()
+	query := `INSERT INTO pending_reminders (bucket, fire_at, reminder_id) VALUES (?, ?, ?)`
+	if err := session.Query(query, ReminderBucket(fireAt), fireAt, r.ReminderID).Exec(); err != nil {
+		return err
+	}
+
+	r.Attempts++
+	r.LastError = cause.Error()
+	r.State = ReminderPending
+	r.FireAt = fireAt
+	query = `UPDATE reminders SET state = ?, attempts = ?, last_error = ?, fire_at = ?, lease_owner = null
+             WHERE reminder_id = ? IF lease_owner = ?`
+	applied, err := session.Query(query, r.State, r.Attempts, r.LastError, r.FireAt, r.ReminderID, r.LeaseOwner).
+		MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return err
+	}
+	r.LeaseOwner = ""
+	return nil
+}
+
+// MarkCancelled records that a claimed reminder was not sent because its task
+// is closed or in the trash.
+func (r *Reminder) MarkCancelled(session *gocql.Session) error {
+	r.State = ReminderCancelled
+	query := `UPDATE reminders SET state = ? WHERE reminder_id = ? IF lease_owner = ?`
+	_, err := session.Query(query, r.State, r.ReminderID, r.LeaseOwner).MapScanCAS(map[string]interface{}{})
+	return err
+}
+
+// MarkFailed gives up on a reminder without further retries.
+func (r *Reminder) MarkFailed(session *gocql.Session, cause error) error {
+	r.Attempts++
+	r.LastError = cause.Error()
+	r.State = ReminderFailed
+	query := `UPDATE reminders SET state = ?, attempts = ?, last_error = ? WHERE reminder_id = ? IF lease_owner = ?`
+	_, err := session.Query(query, r.State, r.Attempts, r.LastError, r.ReminderID, r.LeaseOwner).
+		MapScanCAS(map[string]interface{}{})
+	return err
+}
@@ -0,0 +1,501 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A share link shows a single task with its subtasks, the tasks of a
+// category or the tasks matching a saved filter.
+const (
+	ShareKindTask     = "task"
+	ShareKindCategory = "category"
+	ShareKindFilter   = "filter"
+)
+
+// Outcomes recorded for each use of a share link.
+const (
+	ShareAccessViewed = "viewed"
+	ShareAccessDenied = "denied"
+)
+
+// ShareAccessRetention is how long the audit trail of a share link's uses is
+// kept.
+var ShareAccessRetention = 90 * 24 * time.Hour
+
+var (
+	ErrInvalidShareLink  = errors.New("invalid share link")
+	ErrShareLinkNotFound = errors.New("share link not found, expired or revoked")
+)
+
+// ShareFilter is the filter saved with a filter link. Empty fields match
+// every task of the workspace.
+type ShareFilter struct {
+	Status    string      `json:"status,omitempty"`
+	Tag       string      `json:"tag,omitempty"`
+	ProjectID *gocql.UUID `json:"project_id,omitempty"`
+}
+
+// matches reports whether the task passes the filter.
+func (f *ShareFilter) matches(t *Task) bool {
+	if f.Status != "" && t.Status != f.Status {
+		return false
+	}
+	if f.ProjectID != nil && !sameUUID(f.ProjectID, t.ProjectID) {
+		return false
+	}
+	if f.Tag != "" {
+		for _, tag := range t.Tags {
+			if tag == f.Tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// ShareLink gives anyone holding its token read-only access to part of a
+// workspace. Like invitations, only a hash of the token is stored and the
+// token itself is handed out once, when the link is created. Password is
+// only read on creation; the stored bcrypt hash is never returned.
+type ShareLink struct {
+	WorkspaceID gocql.UUID   `json:"workspace_id"`
+	LinkID      gocql.UUID   `json:"link_id"`
+	Kind        string       `json:"kind"`
+	TargetID    *gocql.UUID  `json:"target_id,omitempty"`
+	Filter      *ShareFilter `json:"filter,omitempty"`
+	Title       string       `json:"title,omitempty"`
+	Password    string       `json:"password,omitempty"`
+	Protected   bool         `json:"protected"`
+	CreatedBy   gocql.UUID   `json:"created_by"`
+	CreatedAt   time.Time    `json:"created_at"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+	RevokedAt   *time.Time   `json:"revoked_at,omitempty"`
+	Uses        int64        `json:"uses"`
+	Token       string       `json:"token,omitempty"`
+
+	tokenHash    string
+	passwordHash string
+}
+
+const shareLinkColumns = `workspace_id, link_id, kind, target_id, filter_status, filter_tag, filter_project_id, title,
+	token_hash, password_hash, created_by, created_at, expires_at, revoked_at`
+
+// shareLinkRow receives a stored link, whose filter is spread over several
+// columns.
+type shareLinkRow struct {
+	link   ShareLink
+	filter ShareFilter
+}
+
+func (row *shareLinkRow) scanDest() []interface{} {
+	return []interface{}{
+		&row.link.WorkspaceID,
+		&row.link.LinkID,
+		&row.link.Kind,
+		&row.link.TargetID,
+		&row.filter.Status,
+		&row.filter.Tag,
+		&row.filter.ProjectID,
+		&row.link.Title,
+		&row.link.tokenHash,
+		&row.link.passwordHash,
+		&row.link.CreatedBy,
+		&row.link.CreatedAt,
+		&row.link.ExpiresAt,
+		&row.link.RevokedAt,
+	}
+}
+
+func (row *shareLinkRow) result() *ShareLink {
+	link := row.link
+	if link.Kind == ShareKindFilter {
+		filter := row.filter
+		link.Filter = &filter
+	}
+	link.Protected = link.passwordHash != ""
+	return &link
+}
+
+// Create saves a link to part of the workspace from the actor and sets Token.
+// The token stops working when the link expires or is revoked.
+func (l *ShareLink) Create(session *gocql.Session, actor Actor) error {
+	if err := l.validate(session); err != nil {
+		return err
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	if l.Password != "" {
+		if l.passwordHash, err = HashPassword(l.Password); err != nil {
+			return err
+		}
+	}
+	l.Password = ""
+	l.Protected = l.passwordHash != ""
+	l.Token = token
+	l.tokenHash = hashToken(token)
+	l.LinkID = gocql.TimeUUID()
+	l.CreatedBy = actor.UserID
+	l.CreatedAt = l.LinkID.Time().UTC()
+
+	filter := ShareFilter{}
+	if l.Filter != nil {
+		filter = *l.Filter
+	}
+	ttl := 0
+	if l.ExpiresAt != nil {
+		ttl = int(time.Until(*l.ExpiresAt).Seconds()) + 1
+	}
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO share_links (workspace_id, link_id, kind, target_id, filter_status, filter_tag,
+             filter_project_id, title, token_hash, password_hash, created_by, created_at, expires_at)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		l.WorkspaceID, l.LinkID, l.Kind, l.TargetID, filter.Status, filter.Tag, filter.ProjectID, l.Title,
+		l.tokenHash, l.passwordHash, l.CreatedBy, l.CreatedAt, l.ExpiresAt)
+	batch.Query(`INSERT INTO share_link_tokens (token_hash, workspace_id, link_id) VALUES (?, ?, ?) USING TTL ?`,
+		l.tokenHash, l.WorkspaceID, l.LinkID, ttl)
+	return session.ExecuteBatch(batch)
+}
+
+// validate checks that the link points at something in its workspace and
+// that it has not expired already.
+func (l *ShareLink) validate(session *gocql.Session) error {
+	l.Title = strings.TrimSpace(l.Title)
+	if utf8.RuneCountInString(l.Title) > 200 {
+		return fmt.Errorf("%w: title cannot be longer than 200 characters", ErrInvalidShareLink)
+	}
+	if l.ExpiresAt != nil && !l.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expires_at must be in the future", ErrInvalidShareLink)
+	}
+
+	switch l.Kind {
+	case ShareKindTask, ShareKindCategory:
+		if l.TargetID == nil {
+			return fmt.Errorf("%w: a %s link needs a target_id", ErrInvalidShareLink, l.Kind)
+		}
+		l.Filter = nil
+	case ShareKindFilter:
+		if l.Filter == nil {
+			l.Filter = &ShareFilter{}
+		}
+		l.TargetID = nil
+		return l.Filter.validate(session, l.WorkspaceID)
+	default:
+		return fmt.Errorf("%w: kind must be task, category or filter", ErrInvalidShareLink)
+	}
+
+	if l.Kind == ShareKindTask {
+		task, err := GetTaskByID(session, *l.TargetID)
+		if err == gocql.ErrNotFound || err == nil && task.WorkspaceID != l.WorkspaceID {
+			return fmt.Errorf("%w: task not found", ErrInvalidShareLink)
+		}
+		return err
+	}
+	return checkCategory(session, l.WorkspaceID, *l.TargetID)
+}
+
+func (f *ShareFilter) validate(session *gocql.Session, workspaceID gocql.UUID) error {
+	if f.Status != "" {
+		workflow, err := GetWorkflow(session, workspaceID)
+		if err != nil {
+			return err
+		}
+		if _, ok := workflow.Status(f.Status); !ok {
+			return fmt.Errorf("%w: %q is not a status of the workspace", ErrInvalidStatus, f.Status)
+		}
+	}
+	if f.Tag != "" {
+		tag, err := NormalizeTag(f.Tag)
+		if err != nil {
+			return err
+		}
+		f.Tag = tag
+	}
+	if f.ProjectID != nil {
+		if _, err := GetProject(session, workspaceID, *f.ProjectID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetShareLink returns a link of the workspace, including revoked and
+// expired ones, or ErrShareLinkNotFound.
+func GetShareLink(session *gocql.Session, workspaceID, linkID gocql.UUID) (*ShareLink, error) {
+	row := &shareLinkRow{}
+	query := `SELECT ` + shareLinkColumns + ` FROM share_links WHERE workspace_id = ? AND link_id = ?`
+	err := session.Query(query, workspaceID, linkID).Scan(row.scanDest()...)
+	if err == gocql.ErrNotFound {
+		return nil, ErrShareLinkNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	link := row.result()
+	if err := loadShareLinkUses(session, []*ShareLink{link}); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// GetShareLinks returns the workspace's links, newest first. Revoked and
+// expired links are included for auditing.
+func GetShareLinks(session *gocql.Session, workspaceID gocql.UUID) ([]*ShareLink, error) {
+	links := []*ShareLink{}
+	query := `SELECT ` + shareLinkColumns + ` FROM share_links WHERE workspace_id = ? ORDER BY link_id DESC`
+	iter := session.Query(query, workspaceID).Iter()
+	for {
+		row := &shareLinkRow{}
+		if !iter.Scan(row.scanDest()...) {
+			break
+		}
+		links = append(links, row.result())
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return links, loadShareLinkUses(session, links)
+}
+
+// loadShareLinkUses fills in how often each link was used.
+func loadShareLinkUses(session *gocql.Session, links []*ShareLink) error {
+	if len(links) == 0 {
+		return nil
+	}
+	byID := make(map[gocql.UUID]*ShareLink, len(links))
+	ids := make([]gocql.UUID, 0, len(links))
+	for _, link := range links {
+		byID[link.LinkID] = link
+		ids = append(ids, link.LinkID)
+	}
+	iter := session.Query(`SELECT link_id, uses FROM share_link_uses WHERE link_id IN ?`, ids).Iter()
+	var id gocql.UUID
+	var uses int64
+	for iter.Scan(&id, &uses) {
+		byID[id].Uses = uses
+	}
+	return iter.Close()
+}
+
+// ResolveShareLink returns the active link the token was issued for, or
+// ErrShareLinkNotFound.
+func ResolveShareLink(session *gocql.Session, token string) (*ShareLink, error) {
+	var workspaceID, linkID gocql.UUID
+	query := `SELECT workspace_id, link_id FROM share_link_tokens WHERE token_hash = ?`
+	err := session.Query(query, hashToken(token)).Scan(&workspaceID, &linkID)
+	if err == gocql.ErrNotFound {
+		return nil, ErrShareLinkNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	link, err := GetShareLink(session, workspaceID, linkID)
+	if err != nil {
+		return nil, err
+	}
+	if !link.Active() {
+		return nil, ErrShareLinkNotFound
+	}
+	return link, nil
+}
+
+// Active reports whether the link is neither revoked nor expired.
+func (l *ShareLink) Active() bool {
+	return l.RevokedAt == nil && (l.ExpiresAt == nil || time.Now().Before(*l.ExpiresAt))
+}
+
+// CheckPassword reports whether the password opens the link. Links without
+// a password accept any.
+func (l *ShareLink) CheckPassword(password string) bool {
+	if l.passwordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(l.passwordHash), []byte(password)) == nil
+}
+
+// Revoke stops the link's token from working. The link itself is kept with
+// its audit trail.
+func (l *ShareLink) Revoke(session *gocql.Session) error {
+	if l.RevokedAt != nil {
+		return nil
+	}
+	now := time.Now().UTC()
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`UPDATE share_links SET revoked_at = ? WHERE workspace_id = ? AND link_id = ?`,
+		now, l.WorkspaceID, l.LinkID)
+	batch.Query(`DELETE FROM share_link_tokens WHERE token_hash = ?`, l.tokenHash)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	l.RevokedAt = &now
+	return nil
+}
+
+// ShareAccess is an entry of a link's audit trail.
+type ShareAccess struct {
+	AccessID   gocql.UUID `json:"access_id"`
+	Outcome    string     `json:"outcome"`
+	RemoteAddr string     `json:"remote_addr"`
+	UserAgent  string     `json:"user_agent"`
+	AccessedAt time.Time  `json:"accessed_at"`
+}
+
+// RecordAccess adds a use of the link to its audit trail. Views are also
+// counted towards the link's uses.
+func (l *ShareLink) RecordAccess(session *gocql.Session, outcome, remoteAddr, userAgent string) error {
+	if outcome == ShareAccessViewed {
+		if err := session.Query(`UPDATE share_link_uses SET uses = uses + 1 WHERE link_id = ?`, l.LinkID).Exec(); err != nil {
+			return err
+		}
+		l.Uses++
+	}
+	return session.Query(`INSERT INTO share_link_accesses (link_id, access_id, outcome, remote_addr, user_agent)
+             VALUES (?, ?, ?, ?, ?) USING TTL ?`,
+		l.LinkID, gocql.TimeUUID(), outcome, remoteAddr, userAgent, int(ShareAccessRetention.Seconds())).Exec()
+}
+
+// GetShareAccesses returns up to limit of the most recent uses of the link.
+func GetShareAccesses(session *gocql.Session, linkID gocql.UUID, limit int) ([]ShareAccess, error) {
+	accesses := []ShareAccess{}
+	query := `SELECT access_id, outcome, remote_addr, user_agent FROM share_link_accesses WHERE link_id = ? LIMIT ?`
+	iter := session.Query(query, linkID, limit).Iter()
+	var a ShareAccess
+	for iter.Scan(&a.AccessID, &a.Outcome, &a.RemoteAddr, &a.UserAgent) {
+		a.AccessedAt = a.AccessID.Time().UTC()
+		accesses = append(accesses, a)
+		a = ShareAccess{}
+	}
+	return accesses, iter.Close()
+}
+
+// SharedTask is the read-only view of a task shown through a share link.
+// Members, IDs and other internal fields are left out.
+type SharedTask struct {
+	Title       string                `json:"title"`
+	Description string                `json:"description,omitempty"`
+	Status      string                `json:"status"`
+	Closed      bool                  `json:"closed"`
+	DueAt       *time.Time            `json:"due_at,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Checklist   []SharedChecklistItem `json:"checklist,omitempty"`
+	Progress    *Progress             `json:"progress,omitempty"`
+	Subtasks    []*SharedTask         `json:"subtasks,omitempty"`
+}
+
+type SharedChecklistItem struct {
+	Text string `json:"text"`
+	Done bool   `json:"done"`
+}
+
+// SharedView is what a share link shows.
+type SharedView struct {
+	Kind      string        `json:"kind"`
+	Title     string        `json:"title"`
+	Tasks     []*SharedTask `json:"tasks"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+}
+
+// View returns the current, sanitized contents of the link. Tasks moved to
+// the trash or out of the workspace drop out of it.
+func (l *ShareLink) View(session *gocql.Session) (*SharedView, error) {
+	workflow, err := GetWorkflow(session, l.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	view := &SharedView{Kind: l.Kind, Title: l.Title, Tasks: []*SharedTask{}, ExpiresAt: l.ExpiresAt}
+
+	if l.Kind == ShareKindTask {
+		task, err := GetTaskByID(session, *l.TargetID)
+		if err == gocql.ErrNotFound || err == nil && task.WorkspaceID != l.WorkspaceID {
+			return nil, ErrShareLinkNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		shared, err := sharedSubtree(session, task, workflow, MaxTaskDepth)
+		if err != nil {
+			return nil, err
+		}
+		if view.Title == "" {
+			view.Title = task.Title
+		}
+		view.Tasks = append(view.Tasks, shared)
+		return view, nil
+	}
+
+	tasks, err := GetTasksByWorkspaceID(session, l.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	var matching []*Task
+	for _, task := range tasks {
+		if l.Kind == ShareKindCategory && sameUUID(task.CategoryID, l.TargetID) ||
+			l.Kind == ShareKindFilter && l.Filter.matches(task) {
+			matching = append(matching, task)
+		}
+	}
+	sortByDueDate(matching)
+	for _, task := range matching {
+		view.Tasks = append(view.Tasks, sharedTask(task, workflow))
+	}
+
+	if view.Title == "" {
+		view.Title = "Shared tasks"
+		if l.Kind == ShareKindCategory {
+			if category, err := GetCategoryByID(session, *l.TargetID); err == nil && category.WorkspaceID == l.WorkspaceID {
+				view.Title = category.Name
+			}
+		}
+	}
+	return view, nil
+}
+
+// sharedSubtree returns the view of the task with its subtasks, down to the
+// given number of levels.
+func sharedSubtree(session *gocql.Session, t *Task, workflow *Workflow, levels int) (*SharedTask, error) {
+	children, err := GetChildTasks(session, t.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	t.Progress = computeProgress(t, children, workflow)
+	shared := sharedTask(t, workflow)
+	if levels <= 1 {
+		return shared, nil
+	}
+	sortByDueDate(children)
+	for _, child := range children {
+		subtask, err := sharedSubtree(session, child, workflow, levels-1)
+		if err != nil {
+			return nil, err
+		}
+		shared.Subtasks = append(shared.Subtasks, subtask)
+	}
+	return shared, nil
+}
+
+func sharedTask(t *Task, workflow *Workflow) *SharedTask {
+	shared := &SharedTask{
+		Title:       t.Title,
+		Description: t.Description,
+		Status:      t.Status,
+		Closed:      workflow.IsClosed(t.Status),
+		DueAt:       t.DueAt,
+		Tags:        t.Tags,
+		Progress:    t.Progress,
+	}
+	if status, ok := workflow.Status(t.Status); ok {
+		shared.Status = status.Name
+	}
+	for _, item := range t.Checklist {
+		shared.Checklist = append(shared.Checklist, SharedChecklistItem{Text: item.Text, Done: item.Done})
+	}
+	return shared
+}
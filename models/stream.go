@@ -0,0 +1,108 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+	"todo-app/realtime"
+
+	"github.com/gocql/gocql"
+)
+
+// Types of the events pushed to clients streaming changes.
+const (
+	StreamTaskCreated     = "task.created"
+	StreamTaskUpdated     = "task.updated"
+	StreamTaskDeleted     = "task.deleted"
+	StreamCategoryCreated = "category.created"
+	StreamCategoryUpdated = "category.updated"
+	StreamCategoryDeleted = "category.deleted"
+)
+
+// EventBroker fans changes out to the clients streaming them. Without one,
+// changes are not published.
+var EventBroker realtime.Broker
+
+// EventLogRetention is how long published events are kept for clients
+// resuming their stream.
+var EventLogRetention = 15 * time.Minute
+
+// publishEvent logs the change and hands it to the broker. Failures are
+// logged; the change stands either way.
+func publishEvent(session *gocql.Session, scope gocql.UUID, eventType string, data interface{}) {
+	if EventBroker == nil {
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Failed to encode %s event: %v", eventType, err)
+		return
+	}
+
+	e := realtime.Event{ID: gocql.TimeUUID(), Scope: scope, Type: eventType, Data: payload}
+	query := `INSERT INTO event_log (scope, event_id, type, data) VALUES (?, ?, ?, ?) USING TTL ?`
+	if err := session.Query(query, e.Scope, e.ID, e.Type, string(e.Data), int(EventLogRetention.Seconds())).Exec(); err != nil {
+		log.Printf("Failed to log %s event: %v", eventType, err)
+	}
+	if err := EventBroker.Publish(context.Background(), e); err != nil {
+		log.Printf("Failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// publishTaskChange publishes the change to the task recorded in its
+// history as an event of the given type.
+func publishTaskChange(session *gocql.Session, taskEventType string, t *Task) {
+	eventType := StreamTaskUpdated
+	switch taskEventType {
+	case TaskEventCreated, TaskEventRestored:
+		eventType = StreamTaskCreated
+	case TaskEventDeleted:
+		eventType = StreamTaskDeleted
+	}
+	publishEvent(session, t.WorkspaceID, eventType, t)
+}
+
+// GetEventsSince returns the logged events of the given scopes published
+// after the given one, oldest first.
+func GetEventsSince(session *gocql.Session, scopes []gocql.UUID, after gocql.UUID) ([]realtime.Event, error) {
+	events := []realtime.Event{}
+	for _, scope := range scopes {
+		iter := session.Query(`SELECT event_id, type, data FROM event_log WHERE scope = ? AND event_id > ?`,
+			scope, after).Iter()
+		var id gocql.UUID
+		var eventType, data string
+		for iter.Scan(&id, &eventType, &data) {
+			events = append(events, realtime.Event{ID: id, Scope: scope, Type: eventType, Data: json.RawMessage(data)})
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return timeUUIDLess(events[i].ID, events[j].ID)
+	})
+	return events, nil
+}
+
+// timeUUIDLess orders time UUIDs the way Cassandra orders TIMEUUID columns.
+func timeUUIDLess(a, b gocql.UUID) bool {
+	if !a.Time().Equal(b.Time()) {
+		return a.Time().Before(b.Time())
+	}
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+// GetStreamScopes returns the scopes of the events the user may receive:
+// their workspaces.
+func GetStreamScopes(session *gocql.Session, userID gocql.UUID) ([]gocql.UUID, error) {
+	scopes := []gocql.UUID{}
+	iter := session.Query(`SELECT workspace_id FROM workspaces_by_user WHERE user_id = ?`, userID).Iter()
+	var id gocql.UUID
+	for iter.Scan(&id) {
+		scopes = append(scopes, id)
+	}
+	return scopes, iter.Close()
+}
@@ -0,0 +1,718 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"todo-app/hlc"
+
+	"github.com/gocql/gocql"
+)
+
+// Kinds of items exchanged with syncing clients.
+const (
+	SyncKindTask     = "task"
+	SyncKindCategory = "category"
+	SyncKindTag      = "tag"
+)
+
+// Sides that can win a conflict resolved while applying a client change.
+const (
+	SyncWinnerServer = "server"
+	SyncWinnerClient = "client"
+)
+
+// MaxSyncChanges is the number of logged changes one delta covers. Clients
+// told there are more fetch again with the token they were given.
+const MaxSyncChanges = 1000
+
+// serverNode is the node of the clock stamping the writes made through the
+// rest of the API.
+const serverNode = "server"
+
+// SyncLogRetention is how long changes are logged for offline clients. A
+// client whose token is older receives a snapshot instead.
+var SyncLogRetention = 30 * 24 * time.Hour
+
+// SyncSettleTime is how far behind the present deltas stop. Changes are
+// logged under a time taken just before they are written, so the latest
+// entries could still be joined by earlier ones.
+var SyncSettleTime = 5 * time.Second
+
+var (
+	ErrInvalidSyncToken  = errors.New("invalid sync token")
+	ErrInvalidSyncChange = errors.New("invalid sync change")
+)
+
+// syncClock stamps the fields written by the server. It is moved past the
+// clock of every client change it sees.
+var syncClock = hlc.NewClock(serverNode)
+
+// SyncTask is a task as sent to syncing clients, with the clock of the last
+// write to each field clients may change.
+type SyncTask struct {
+	*Task
+	Clocks map[string]string `json:"clocks"`
+}
+
+// SyncCategory is a category as sent to syncing clients, with the clock of
+// the last write to its name.
+type SyncCategory struct {
+	*Category
+	Clocks map[string]string `json:"clocks"`
+}
+
+// SyncTag is a tag carried by tasks of a workspace.
+type SyncTag struct {
+	WorkspaceID gocql.UUID `json:"workspace_id"`
+	Tag         string     `json:"tag"`
+	Count       int        `json:"count"`
+}
+
+// SyncTombstone is an item deleted since the client last synced: a task or
+// a category, by ID, or a tag no task of the workspace carries anymore.
+type SyncTombstone struct {
+	Kind        string      `json:"kind"`
+	ID          *gocql.UUID `json:"id,omitempty"`
+	WorkspaceID *gocql.UUID `json:"workspace_id,omitempty"`
+	Tag         string      `json:"tag,omitempty"`
+}
+
+// SyncDelta is what changed since a sync token. With Reset set it is a
+// snapshot of everything the user can see, replacing what the client holds.
+// Token is where the next delta starts.
+type SyncDelta struct {
+	Token      string          `json:"token"`
+	Reset      bool            `json:"reset"`
+	HasMore    bool            `json:"has_more"`
+	Tasks      []SyncTask      `json:"tasks"`
+	Categories []SyncCategory  `json:"categories"`
+	Tags       []SyncTag       `json:"tags"`
+	Deleted    []SyncTombstone `json:"deleted"`
+}
+
+func newSyncDelta(token string) *SyncDelta {
+	return &SyncDelta{
+		Token:      token,
+		Tasks:      []SyncTask{},
+		Categories: []SyncCategory{},
+		Tags:       []SyncTag{},
+		Deleted:    []SyncTombstone{},
+	}
+}
+
+// syncChange is an entry of the sync log.
+type syncChange struct {
+	id     gocql.UUID
+	scope  gocql.UUID
+	kind   string
+	itemID gocql.UUID
+	tag    string
+}
+
+// addSyncChange queues the entry of the sync log recording that a task or
+// category of the scope changed.
+func addSyncChange(batch *gocql.Batch, scope gocql.UUID, kind string, itemID gocql.UUID) {
+	batch.Query(`INSERT INTO sync_log (scope, change_id, kind, item_id) VALUES (?, ?, ?, ?) USING TTL ?`,
+		scope, gocql.TimeUUID(), kind, itemID, int(SyncLogRetention.Seconds()))
+}
+
+// addTagSyncChange queues the entry of the sync log recording that a task
+// of the workspace gained or lost the tag.
+func addTagSyncChange(batch *gocql.Batch, workspaceID gocql.UUID, tag string) {
+	batch.Query(`INSERT INTO sync_log (scope, change_id, kind, tag) VALUES (?, ?, ?, ?) USING TTL ?`,
+		workspaceID, gocql.TimeUUID(), SyncKindTag, tag, int(SyncLogRetention.Seconds()))
+}
+
+// addTaskSync queues the stamps of the clocks of the task's fields that
+// changed from before to after, and the entry of the change in the sync
+// log.
+func addTaskSync(batch *gocql.Batch, before, after *Task) {
+	if clocks := taskClockStamps(before, after); len(clocks) > 0 {
+		batch.Query(`UPDATE tasks SET field_clocks = field_clocks + ? WHERE task_id = ?`, clocks, after.TaskID)
+	}
+	addSyncChange(batch, after.WorkspaceID, SyncKindTask, after.TaskID)
+}
+
+// taskClockStamps returns the clocks to stamp on the task's fields that
+// changed from before to after. Fields with a clock in after.syncClocks take
+// that clock; others are stamped with the server clock.
+func taskClockStamps(before, after *Task) map[string]string {
+	now := syncClock.Now().String()
+	clocks := make(map[string]string)
+	for name := range diffTasks(before, after) {
+		if !isUpdatableTaskField(name) && name != "deleted_at" {
+			continue
+		}
+		if clock, ok := after.syncClocks[name]; ok {
+			clocks[name] = clock
+		} else {
+			clocks[name] = now
+		}
+	}
+	return clocks
+}
+
+// stampClock returns a copy of the clocks with the field stamped with the
+// server clock.
+func stampClock(clocks map[string]string, field string) map[string]string {
+	stamped := make(map[string]string, len(clocks)+1)
+	for name, clock := range clocks {
+		stamped[name] = clock
+	}
+	stamped[field] = syncClock.Now().String()
+	return stamped
+}
+
+// fieldClocks returns the clock of the last write to each of the fields.
+// Fields not written since clocks were kept fall back to the given time.
+func fieldClocks(stored map[string]string, fields []string, fallback time.Time) map[string]hlc.Timestamp {
+	clocks := make(map[string]hlc.Timestamp, len(fields))
+	for _, name := range fields {
+		clock, err := hlc.Parse(stored[name])
+		if err != nil {
+			clock = hlc.Timestamp{Wall: fallback.UnixMilli(), Node: serverNode}
+		}
+		clocks[name] = clock
+	}
+	return clocks
+}
+
+func clockStrings(clocks map[string]hlc.Timestamp) map[string]string {
+	strs := make(map[string]string, len(clocks))
+	for name, clock := range clocks {
+		strs[name] = clock.String()
+	}
+	return strs
+}
+
+func newSyncTask(task *Task, stored map[string]string) SyncTask {
+	return SyncTask{Task: task, Clocks: clockStrings(fieldClocks(stored, updatableTaskFields, task.UpdatedAt))}
+}
+
+func newSyncCategory(category *Category) SyncCategory {
+	clocks := fieldClocks(category.clocks, []string{"name"}, category.CreatedAt)
+	return SyncCategory{Category: category, Clocks: clockStrings(clocks)}
+}
+
+// syncTaskColumns are the task's columns followed by its field clocks.
+const syncTaskColumns = taskColumns + `, field_clocks`
+
+// scanSyncTasks scans rows of syncTaskColumns.
+func scanSyncTasks(iter *gocql.Iter) ([]SyncTask, error) {
+	var tasks []SyncTask
+	for {
+		task := &Task{}
+		var clocks map[string]string
+		if !iter.Scan(append(task.scanDest(), &clocks)...) {
+			break
+		}
+		tasks = append(tasks, newSyncTask(task, clocks))
+	}
+	return tasks, iter.Close()
+}
+
+// getSyncTask returns the task, whether or not it is in the trash, along
+// with its stored field clocks.
+func getSyncTask(session *gocql.Session, taskID gocql.UUID) (*Task, map[string]string, error) {
+	task := &Task{}
+	var clocks map[string]string
+	query := `SELECT ` + syncTaskColumns + ` FROM tasks WHERE task_id = ?`
+	if err := session.Query(query, taskID).Scan(append(task.scanDest(), &clocks)...); err != nil {
+		return nil, nil, err
+	}
+	return task, clocks, nil
+}
+
+func encodeSyncToken(id gocql.UUID) string {
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+// ParseSyncToken returns the position in the sync log a token stands for.
+func ParseSyncToken(token string) (gocql.UUID, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return gocql.UUID{}, ErrInvalidSyncToken
+	}
+	id, err := gocql.UUIDFromBytes(data)
+	if err != nil || id.Version() != 1 {
+		return gocql.UUID{}, ErrInvalidSyncToken
+	}
+	return id, nil
+}
+
+// GetSyncDelta returns what changed among the tasks, categories and tags
+// the user can see since the token was issued. Without a token, or with one
+// older than SyncLogRetention, it returns a snapshot.
+func GetSyncDelta(session *gocql.Session, userID gocql.UUID, token string) (*SyncDelta, error) {
+	var after gocql.UUID
+	if token != "" {
+		var err error
+		if after, err = ParseSyncToken(token); err != nil {
+			return nil, err
+		}
+	}
+	scopes, err := GetStreamScopes(session, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	until := gocql.UUIDFromTime(time.Now().Add(-SyncSettleTime))
+	if token == "" || time.Since(after.Time()) > SyncLogRetention {
+		return syncSnapshot(session, scopes, until)
+	}
+	if !timeUUIDLess(after, until) {
+		return newSyncDelta(token), nil
+	}
+
+	changes, err := getSyncChanges(session, scopes, after, until)
+	if err != nil {
+		return nil, err
+	}
+	delta := newSyncDelta(encodeSyncToken(until))
+	if len(changes) > MaxSyncChanges {
+		changes = changes[:MaxSyncChanges]
+		delta.HasMore = true
+		delta.Token = encodeSyncToken(changes[len(changes)-1].id)
+	}
+	if err := delta.load(session, changes); err != nil {
+		return nil, err
+	}
+	return delta, nil
+}
+
+// getSyncChanges returns the first entries of the scopes' sync logs between
+// after and until, oldest first. It reads one more entry than a delta
+// covers, so callers can tell whether there are more.
+func getSyncChanges(session *gocql.Session, scopes []gocql.UUID, after, until gocql.UUID) ([]syncChange, error) {
+	var changes []syncChange
+	for _, scope := range scopes {
+		iter := session.Query(`SELECT change_id, kind, item_id, tag FROM sync_log
+			WHERE scope = ? AND change_id > ? AND change_id < ? LIMIT ?`, scope, after, until, MaxSyncChanges+1).Iter()
+		change := syncChange{scope: scope}
+		for iter.Scan(&change.id, &change.kind, &change.itemID, &change.tag) {
+			changes = append(changes, change)
+			change = syncChange{scope: scope}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return timeUUIDLess(changes[i].id, changes[j].id)
+	})
+	return changes, nil
+}
+
+// load adds the current state of each item named by the changes to the
+// delta, or its tombstone if it is gone.
+func (d *SyncDelta) load(session *gocql.Session, changes []syncChange) error {
+	type tagKey struct {
+		workspaceID gocql.UUID
+		tag         string
+	}
+	seen := make(map[interface{}]bool)
+	for _, change := range changes {
+		var key interface{} = change.itemID
+		if change.kind == SyncKindTag {
+			key = tagKey{change.scope, change.tag}
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var err error
+		switch change.kind {
+		case SyncKindTask:
+			err = d.loadTask(session, change)
+		case SyncKindCategory:
+			err = d.loadCategory(session, change)
+		case SyncKindTag:
+			err = d.loadTag(session, change)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *SyncDelta) loadTask(session *gocql.Session, change syncChange) error {
+	task, clocks, err := getSyncTask(session, change.itemID)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	if err == gocql.ErrNotFound || task.DeletedAt != nil {
+		id, workspaceID := change.itemID, change.scope
+		d.Deleted = append(d.Deleted, SyncTombstone{Kind: SyncKindTask, ID: &id, WorkspaceID: &workspaceID})
+		return nil
+	}
+	d.Tasks = append(d.Tasks, newSyncTask(task, clocks))
+	return nil
+}
+
+func (d *SyncDelta) loadCategory(session *gocql.Session, change syncChange) error {
+	category, err := getCategory(session, change.itemID)
+	if err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	if err == gocql.ErrNotFound || category.DeletedAt != nil {
+		id, workspaceID := change.itemID, change.scope
+		d.Deleted = append(d.Deleted, SyncTombstone{Kind: SyncKindCategory, ID: &id, WorkspaceID: &workspaceID})
+		return nil
+	}
+	d.Categories = append(d.Categories, newSyncCategory(category))
+	return nil
+}
+
+func (d *SyncDelta) loadTag(session *gocql.Session, change syncChange) error {
+	var count int
+	query := `SELECT COUNT(*) FROM tasks_by_tag WHERE workspace_id = ? AND tag = ?`
+	if err := session.Query(query, change.scope, change.tag).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		workspaceID := change.scope
+		d.Deleted = append(d.Deleted, SyncTombstone{Kind: SyncKindTag, WorkspaceID: &workspaceID, Tag: change.tag})
+		return nil
+	}
+	d.Tags = append(d.Tags, SyncTag{WorkspaceID: change.scope, Tag: change.tag, Count: count})
+	return nil
+}
+
+// syncSnapshot returns every task, category and tag the user can see. The
+// token is taken before reading, so changes made meanwhile are sent again
+// with the next delta rather than missed.
+func syncSnapshot(session *gocql.Session, scopes []gocql.UUID, until gocql.UUID) (*SyncDelta, error) {
+	delta := newSyncDelta(encodeSyncToken(until))
+	delta.Reset = true
+	for _, scope := range scopes {
+		query := `SELECT ` + syncTaskColumns + ` FROM tasks WHERE workspace_id = ?`
+		tasks, err := scanSyncTasks(session.Query(query, scope).Iter())
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			if task.DeletedAt == nil {
+				delta.Tasks = append(delta.Tasks, task)
+			}
+		}
+
+		tags, err := GetTags(session, scope)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags {
+			delta.Tags = append(delta.Tags, SyncTag{WorkspaceID: scope, Tag: tag.Tag, Count: tag.Count})
+		}
+
+		categories, err := GetCategories(session, scope)
+		if err != nil {
+			return nil, err
+		}
+		for i := range categories {
+			delta.Categories = append(delta.Categories, newSyncCategory(&categories[i]))
+		}
+	}
+	return delta, nil
+}
+
+// SyncChange is a change a client made while offline: new values for some
+// fields of a task or category, or its deletion. Tags change through the
+// tags field of tasks. HLC is the reading of the client's hybrid logical
+// clock when the change was made. A task or category the server does not
+// know is created, in WorkspaceID or else the user's personal workspace.
+type SyncChange struct {
+	Kind        string                     `json:"kind"`
+	ID          gocql.UUID                 `json:"id"`
+	WorkspaceID *gocql.UUID                `json:"workspace_id,omitempty"`
+	HLC         string                     `json:"hlc"`
+	Deleted     bool                       `json:"deleted,omitempty"`
+	Fields      map[string]json.RawMessage `json:"fields,omitempty"`
+}
+
+// SyncConflict is a field changed both by the client and elsewhere, and how
+// last-writer-wins resolved it. Value is the value that won.
+type SyncConflict struct {
+	Field     string          `json:"field"`
+	Winner    string          `json:"winner"`
+	ClientHLC string          `json:"client_hlc"`
+	ServerHLC string          `json:"server_hlc"`
+	Value     json.RawMessage `json:"value"`
+}
+
+func newSyncConflict(field, winner string, client, server hlc.Timestamp, value interface{}) SyncConflict {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data = []byte("null")
+	}
+	return SyncConflict{Field: field, Winner: winner, ClientHLC: client.String(), ServerHLC: server.String(), Value: data}
+}
+
+// validate checks the change and returns its clock.
+func (ch *SyncChange) validate() (hlc.Timestamp, error) {
+	clock, err := hlc.Parse(ch.HLC)
+	if err != nil {
+		return clock, fmt.Errorf("%w: %v", ErrInvalidSyncChange, err)
+	}
+	if ch.ID == (gocql.UUID{}) {
+		return clock, fmt.Errorf("%w: id is required", ErrInvalidSyncChange)
+	}
+	if ch.Deleted == (len(ch.Fields) > 0) {
+		return clock, fmt.Errorf("%w: a change either sets fields or deletes", ErrInvalidSyncChange)
+	}
+	for name := range ch.Fields {
+		switch ch.Kind {
+		case SyncKindTask:
+			if !isUpdatableTaskField(name) {
+				return clock, fmt.Errorf("%w: %s", ErrReadOnlyField, name)
+			}
+		case SyncKindCategory:
+			if name != "name" {
+				return clock, fmt.Errorf("%w: %s", ErrReadOnlyField, name)
+			}
+		}
+	}
+	if ch.Kind != SyncKindTask && ch.Kind != SyncKindCategory {
+		return clock, fmt.Errorf("%w: kind must be %s or %s", ErrInvalidSyncChange, SyncKindTask, SyncKindCategory)
+	}
+	return clock, nil
+}
+
+// ApplySyncChange applies a client change field by field, each field taking
+// the client's value only if the change's clock is after the clock of the
+// field's last write. The fields the client loses are reported as conflicts,
+// and so are those it wins over a write made by someone else after since,
+// the client's last sync, when that is known.
+//
+// Deleting a task or category loses to edits made after the deletion.
+// Items already in the trash are not edited; the edits are reported as
+// conflicts and the item can be restored from the trash.
+//
+// authorize is called with the workspace of a task or category before it is
+// written.
+func ApplySyncChange(session *gocql.Session, ch *SyncChange, since time.Time, actor Actor,
+	authorize func(workspaceID gocql.UUID) error) ([]SyncConflict, error) {
+	clock, err := ch.validate()
+	if err != nil {
+		return nil, err
+	}
+	if err := syncClock.Update(clock); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSyncChange, err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		var conflicts []SyncConflict
+		if ch.Kind == SyncKindTask {
+			conflicts, err = applyTaskChange(session, ch, clock, since, actor, authorize)
+		} else {
+			conflicts, err = applyCategoryChange(session, ch, clock, since, actor, authorize)
+		}
+		if errors.Is(err, ErrVersionMismatch) && attempt < 3 {
+			continue
+		}
+		return conflicts, err
+	}
+}
+
+// overwrites reports whether a client write with the given clock replacing
+// one with the stored clock is a conflict: the stored write was made by
+// someone else after the client's last sync.
+func overwrites(stored, clock hlc.Timestamp, since time.Time) bool {
+	return !since.IsZero() && stored.Node != clock.Node && stored.Time().After(since)
+}
+
+func latestClock(clocks map[string]hlc.Timestamp) hlc.Timestamp {
+	var latest hlc.Timestamp
+	for _, clock := range clocks {
+		if clock.Compare(latest) > 0 {
+			latest = clock
+		}
+	}
+	return latest
+}
+
+func applyTaskChange(session *gocql.Session, ch *SyncChange, clock hlc.Timestamp, since time.Time, actor Actor,
+	authorize func(gocql.UUID) error) ([]SyncConflict, error) {
+	task, stored, err := getSyncTask(session, ch.ID)
+	if err == gocql.ErrNotFound {
+		if ch.Deleted {
+			return nil, nil
+		}
+		return nil, createSyncTask(session, ch, clock, actor, authorize)
+	} else if err != nil {
+		return nil, err
+	}
+	if err := authorize(task.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	if task.DeletedAt != nil {
+		if ch.Deleted {
+			return nil, nil
+		}
+		deleted := fieldClocks(stored, []string{"deleted_at"}, *task.DeletedAt)["deleted_at"]
+		return []SyncConflict{newSyncConflict("deleted_at", SyncWinnerServer, clock, deleted, task.DeletedAt)}, nil
+	}
+	clocks := fieldClocks(stored, updatableTaskFields, task.UpdatedAt)
+	if ch.Deleted {
+		if latest := latestClock(clocks); clock.Compare(latest) <= 0 {
+			return []SyncConflict{newSyncConflict("deleted_at", SyncWinnerServer, clock, latest, nil)}, nil
+		}
+		return nil, task.Trash(session, actor)
+	}
+
+	current, err := jsonFields(task)
+	if err != nil {
+		return nil, err
+	}
+	var conflicts []SyncConflict
+	accepted := make(map[string]string)
+	for _, name := range sortedKeys(ch.Fields) {
+		value := ch.Fields[name]
+		if clock.Compare(clocks[name]) <= 0 {
+			conflicts = append(conflicts, newSyncConflict(name, SyncWinnerServer, clock, clocks[name], current[name]))
+			continue
+		}
+		if overwrites(clocks[name], clock, since) {
+			conflicts = append(conflicts, newSyncConflict(name, SyncWinnerClient, clock, clocks[name], value))
+		}
+		current[name] = value
+		accepted[name] = clock.String()
+	}
+	if len(accepted) == 0 {
+		return conflicts, nil
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := PatchedTask(task, doc)
+	if err != nil {
+		return nil, err
+	}
+	patched.syncClocks = accepted
+	if err := patched.Update(session, actor); err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// createSyncTask creates a task a client made offline under the ID the
+// client gave it.
+func createSyncTask(session *gocql.Session, ch *SyncChange, clock hlc.Timestamp, actor Actor,
+	authorize func(gocql.UUID) error) error {
+	workspaceID := PersonalWorkspaceID(actor.UserID)
+	if ch.WorkspaceID != nil {
+		workspaceID = *ch.WorkspaceID
+	}
+	if err := authorize(workspaceID); err != nil {
+		return err
+	}
+
+	task := NewTask(actor.UserID, "", "", "")
+	task.TaskID = ch.ID
+	task.WorkspaceID = workspaceID
+	fields, err := jsonFields(task)
+	if err != nil {
+		return err
+	}
+	clocks := make(map[string]string, len(ch.Fields))
+	for name, value := range ch.Fields {
+		fields[name] = value
+		clocks[name] = clock.String()
+	}
+	doc, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	created, err := PatchedTask(task, doc)
+	if err != nil {
+		return err
+	}
+	created.syncClocks = clocks
+	return created.Create(session, actor)
+}
+
+func applyCategoryChange(session *gocql.Session, ch *SyncChange, clock hlc.Timestamp, since time.Time, actor Actor,
+	authorize func(gocql.UUID) error) ([]SyncConflict, error) {
+	var name string
+	if !ch.Deleted {
+		if err := json.Unmarshal(ch.Fields["name"], &name); err != nil {
+			return nil, fmt.Errorf("%w: name must be a string", ErrInvalidCategory)
+		}
+	}
+
+	category, err := getCategory(session, ch.ID)
+	if err == gocql.ErrNotFound {
+		if ch.Deleted {
+			return nil, nil
+		}
+		workspaceID := PersonalWorkspaceID(actor.UserID)
+		if ch.WorkspaceID != nil {
+			workspaceID = *ch.WorkspaceID
+		}
+		if err := authorize(workspaceID); err != nil {
+			return nil, err
+		}
+		category = &Category{CategoryID: ch.ID, WorkspaceID: workspaceID, Name: name}
+		return nil, category.createWithID(session, clock.String())
+	} else if err != nil {
+		return nil, err
+	}
+	if err := authorize(category.WorkspaceID); err != nil {
+		return nil, err
+	}
+
+	if category.DeletedAt != nil {
+		if ch.Deleted {
+			return nil, nil
+		}
+		deleted := fieldClocks(category.clocks, []string{"deleted_at"}, *category.DeletedAt)["deleted_at"]
+		return []SyncConflict{newSyncConflict("deleted_at", SyncWinnerServer, clock, deleted, category.DeletedAt)}, nil
+	}
+	stored := fieldClocks(category.clocks, []string{"name"}, category.CreatedAt)["name"]
+	if ch.Deleted {
+		if clock.Compare(stored) <= 0 {
+			return []SyncConflict{newSyncConflict("deleted_at", SyncWinnerServer, clock, stored, nil)}, nil
+		}
+		return nil, category.Trash(session)
+	}
+
+	if clock.Compare(stored) <= 0 {
+		return []SyncConflict{newSyncConflict("name", SyncWinnerServer, clock, stored, category.Name)}, nil
+	}
+	var conflicts []SyncConflict
+	if overwrites(stored, clock, since) {
+		conflicts = append(conflicts, newSyncConflict("name", SyncWinnerClient, clock, stored, name))
+	}
+	category.Name = name
+	return conflicts, category.update(session, clock.String())
+}
+
+// jsonFields returns the members of the task's JSON document.
+func jsonFields(t *Task) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
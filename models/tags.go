@@ -0,0 +1,245 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// MaxTagsPerTask is the number of tags a task can carry.
+const MaxTagsPerTask = 20
+
+var ErrInvalidTag = errors.New("invalid tag")
+
+var tagPattern = regexp.MustCompile(`^[\p{Ll}\p{N}][\p{Ll}\p{N}_\-]{0,49}$`)
+
+// TagCount is a tag together with the number of tasks carrying it.
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag lower-cases a tag and checks that it is made of letters,
+// digits, dashes and underscores.
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if !tagPattern.MatchString(tag) {
+		return "", fmt.Errorf("%w: %q must be 1 to 50 letters, digits, dashes or underscores", ErrInvalidTag, tag)
+	}
+	return tag, nil
+}
+
+// normalizeTags normalizes each tag and returns them sorted and without
+// duplicates.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	var normalized []string
+	for _, tag := range tags {
+		tag, err := NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	if len(normalized) > MaxTagsPerTask {
+		return nil, fmt.Errorf("%w: a task can have at most %d tags", ErrInvalidTag, MaxTagsPerTask)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// addTagIndex queues the index rows for the given tags of the task, and
+// their entries in the sync log.
+func addTagIndex(batch *gocql.Batch, t *Task, tags []string) {
+	for _, tag := range tags {
+		batch.Query(`INSERT INTO tasks_by_tag (workspace_id, tag, task_id) VALUES (?, ?, ?)`, t.WorkspaceID, tag, t.TaskID)
+		addTagSyncChange(batch, t.WorkspaceID, tag)
+	}
+}
+
+// removeTagIndex queues the removal of the index rows for the given tags of
+// the task, and their entries in the sync log.
+func removeTagIndex(batch *gocql.Batch, t *Task, tags []string) {
+	for _, tag := range tags {
+		batch.Query(`DELETE FROM tasks_by_tag WHERE workspace_id = ? AND tag = ? AND task_id = ?`,
+			t.WorkspaceID, tag, t.TaskID)
+		addTagSyncChange(batch, t.WorkspaceID, tag)
+	}
+}
+
+// syncTagIndex brings the index in line with the task's tags after they
+// changed from previous.
+func syncTagIndex(session *gocql.Session, t *Task, previous []string) error {
+	current := make(map[string]bool, len(t.Tags))
+	for _, tag := range t.Tags {
+		current[tag] = true
+	}
+	var removed, added []string
+	for _, tag := range previous {
+		if !current[tag] {
+			removed = append(removed, tag)
+		}
+		delete(current, tag)
+	}
+	for tag := range current {
+		added = append(added, tag)
+	}
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	removeTagIndex(batch, t, removed)
+	addTagIndex(batch, t, added)
+	return session.ExecuteBatch(batch)
+}
+
+// GetTags returns the workspace's tags with the number of tasks carrying
+// each, most used first.
+func GetTags(session *gocql.Session, workspaceID gocql.UUID) ([]TagCount, error) {
+	iter := session.Query(`SELECT tag FROM tasks_by_tag WHERE workspace_id = ?`, workspaceID).Iter()
+	counts, err := countTags(iter, 0)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].Count > counts[j].Count
+	})
+	return counts, nil
+}
+
+// AutocompleteTags returns up to limit of the workspace's tags starting with
+// prefix, in alphabetical order.
+func AutocompleteTags(session *gocql.Session, workspaceID gocql.UUID, prefix string, limit int) ([]TagCount, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	query := `SELECT tag FROM tasks_by_tag WHERE workspace_id = ? AND tag >= ? AND tag < ?`
+	iter := session.Query(query, workspaceID, prefix, prefix+"\U0010FFFF").Iter()
+	return countTags(iter, limit)
+}
+
+// countTags counts the rows of a scan over tasks_by_tag, which come grouped
+// by tag. With a limit above zero it stops after that many tags.
+func countTags(iter *gocql.Iter, limit int) ([]TagCount, error) {
+	counts := []TagCount{}
+	var tag string
+	for iter.Scan(&tag) {
+		if n := len(counts); n > 0 && counts[n-1].Tag == tag {
+			counts[n-1].Count++
+			continue
+		}
+		if limit > 0 && len(counts) == limit {
+			break
+		}
+		counts = append(counts, TagCount{Tag: tag, Count: 1})
+	}
+	return counts, iter.Close()
+}
+
+func getTaskIDsByTag(session *gocql.Session, workspaceID gocql.UUID, tag string) ([]gocql.UUID, error) {
+	var ids []gocql.UUID
+	query := `SELECT task_id FROM tasks_by_tag WHERE workspace_id = ? AND tag = ?`
+	iter := session.Query(query, workspaceID, tag).Iter()
+	var id gocql.UUID
+	for iter.Scan(&id) {
+		ids = append(ids, id)
+	}
+	return ids, iter.Close()
+}
+
+// GetTasksByTag returns the workspace's tasks carrying the tag.
+func GetTasksByTag(session *gocql.Session, workspaceID gocql.UUID, tag string) ([]*Task, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := getTaskIDsByTag(session, workspaceID, tag)
+	if err != nil || len(ids) == 0 {
+		return []*Task{}, err
+	}
+
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE task_id IN ?`
+	tasks, err := scanTasks(session.Query(query, ids).Iter())
+	if err != nil {
+		return nil, err
+	}
+	tasks = withoutTrashed(tasks)
+
+	workflow, err := GetWorkflow(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	deps, err := GetDependenciesByWorkspaceID(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	applyBlocked(tasks, deps, workflow)
+	return tasks, nil
+}
+
+// RenameTag replaces the tag from with to on all of the workspace's tasks.
+// If some tasks already carry to, the two tags are merged. It returns the
+// number of tasks changed.
+func RenameTag(session *gocql.Session, workspaceID gocql.UUID, from, to string, actor Actor) (int, error) {
+	from, err := NormalizeTag(from)
+	if err != nil {
+		return 0, err
+	}
+	if to, err = NormalizeTag(to); err != nil {
+		return 0, err
+	}
+	if from == to {
+		return 0, nil
+	}
+
+	ids, err := getTaskIDsByTag(session, workspaceID, from)
+	if err != nil {
+		return 0, err
+	}
+	renamed := 0
+	for _, id := range ids {
+		changed, err := renameTaskTag(session, workspaceID, id, from, to, actor)
+		if err != nil {
+			return renamed, fmt.Errorf("failed to rename tag on task %s: %w", id, err)
+		}
+		if changed {
+			renamed++
+		}
+	}
+	return renamed, nil
+}
+
+// renameTaskTag renames the tag on one task, retrying when the task is
+// edited concurrently. Index rows of tasks that are gone are dropped.
+func renameTaskTag(session *gocql.Session, workspaceID, taskID gocql.UUID, from, to string, actor Actor) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		task, err := GetTaskByID(session, taskID)
+		if err == gocql.ErrNotFound {
+			stale := &Task{TaskID: taskID, WorkspaceID: workspaceID}
+			batch := session.NewBatch(gocql.LoggedBatch)
+			removeTagIndex(batch, stale, []string{from})
+			return false, session.ExecuteBatch(batch)
+		} else if err != nil {
+			return false, err
+		}
+		if task.WorkspaceID != workspaceID {
+			return false, nil
+		}
+
+		tags := []string{to}
+		for _, tag := range task.Tags {
+			if tag != from {
+				tags = append(tags, tag)
+			}
+		}
+		task.Tags = tags
+		err = task.Update(session, actor)
+		if err == ErrVersionMismatch && attempt < 3 {
+			continue
+		}
+		return err == nil, err
+	}
+}
@@ -0,0 +1,267 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	TaskEventCreated       = "created"
+	TaskEventUpdated       = "updated"
+	TaskEventStatusChanged = "status_changed"
+	TaskEventDeleted       = "deleted"
+	TaskEventRestored      = "restored"
+	TaskEventReassigned    = "reassigned"
+
+	TaskEventCommentAdded   = "comment_added"
+	TaskEventCommentEdited  = "comment_edited"
+	TaskEventCommentDeleted = "comment_deleted"
+)
+
+// isCommentEvent reports whether events of the type record a comment rather
+// than a change to the task's fields.
+func isCommentEvent(eventType string) bool {
+	switch eventType {
+	case TaskEventCommentAdded, TaskEventCommentEdited, TaskEventCommentDeleted:
+		return true
+	}
+	return false
+}
+
+// trackedTaskFields are the task fields, by JSON name, recorded in the
+// history. Computed fields and updated_at are left out.
+var trackedTaskFields = []string{
+	"task_id", "user_id", "workspace_id", "project_id", "parent_id", "category_id", "title", "description", "status",
+	"checklist", "tags", "assignees", "auto_complete", "due_at", "recurrence", "recur_from", "time_zone", "priority",
+	"created_at", "deleted_at",
+}
+
+// Actor identifies who made a change and in which request. Automation
+// counts the automation rules that led to the change one after the other;
+// it is zero for changes people make.
+type Actor struct {
+	UserID     gocql.UUID
+	RequestID  string
+	Automation int
+}
+
+// FieldChange holds the JSON encoded value of a field before and after a
+// change. An empty string means the field had no value.
+type FieldChange struct {
+	Old string `cql:"old_value"`
+	New string `cql:"new_value"`
+}
+
+func (c FieldChange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Old json.RawMessage `json:"old"`
+		New json.RawMessage `json:"new"`
+	}{rawJSON(c.Old), rawJSON(c.New)})
+}
+
+func (c *FieldChange) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Old json.RawMessage `json:"old"`
+		New json.RawMessage `json:"new"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	c.Old, c.New = fromRawJSON(raw.Old), fromRawJSON(raw.New)
+	return nil
+}
+
+func rawJSON(s string) json.RawMessage {
+	if s == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(s)
+}
+
+func fromRawJSON(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+	return string(raw)
+}
+
+// TaskEvent is an entry of a task's append-only history.
+type TaskEvent struct {
+	TaskID    gocql.UUID             `json:"task_id"`
+	EventID   gocql.UUID             `json:"event_id"`
+	Type      string                 `json:"type"`
+	UserID    gocql.UUID             `json:"user_id"`
+	ActorID   gocql.UUID             `json:"actor_id"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Changes   map[string]FieldChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+
+	// automation is the Automation of the actor, handed on to the domain
+	// event.
+	automation int
+}
+
+// taskFields returns the JSON encoding of each tracked field of the task.
+// A nil task has no fields.
+func taskFields(t *Task) map[string]string {
+	fields := make(map[string]string)
+	if t == nil {
+		return fields
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fields
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return fields
+	}
+	for _, name := range trackedTaskFields {
+		if value, ok := all[name]; ok && string(value) != "null" && string(value) != "[]" {
+			fields[name] = string(value)
+		}
+	}
+	return fields
+}
+
+// diffTasks returns the tracked fields that differ between two versions of
+// a task. The first version is nil for a new task.
+func diffTasks(before, after *Task) map[string]FieldChange {
+	oldFields, newFields := taskFields(before), taskFields(after)
+	changes := make(map[string]FieldChange)
+	for _, name := range trackedTaskFields {
+		if oldFields[name] != newFields[name] {
+			changes[name] = FieldChange{Old: oldFields[name], New: newFields[name]}
+		}
+	}
+	return changes
+}
+
+// newTaskEvent describes the change from before to after. It returns nil
+// when nothing tracked changed.
+func newTaskEvent(before, after *Task, actor Actor) *TaskEvent {
+	changes := diffTasks(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	event := &TaskEvent{
+		EventID:   gocql.TimeUUID(),
+		Type:      TaskEventUpdated,
+		ActorID:   actor.UserID,
+		RequestID: actor.RequestID,
+		Changes:   changes,
+
+		automation: actor.Automation,
+	}
+	event.CreatedAt = event.EventID.Time().UTC()
+
+	_, trashed := changes["deleted_at"]
+	_, statusChanged := changes["status"]
+	_, reassigned := changes["assignees"]
+	switch {
+	case before == nil:
+		event.Type = TaskEventCreated
+	case trashed && after.DeletedAt != nil:
+		event.Type = TaskEventDeleted
+	case trashed:
+		event.Type = TaskEventRestored
+	case statusChanged:
+		event.Type = TaskEventStatusChanged
+	case reassigned:
+		event.Type = TaskEventReassigned
+	}
+	event.TaskID = after.TaskID
+	event.UserID = after.UserID
+	return event
+}
+
+// addToBatch queues the event's insert, so that it is written together with
+// the change it records where the change allows a batch.
+func (e *TaskEvent) addToBatch(batch *gocql.Batch) {
+	batch.Query(`INSERT INTO task_events (task_id, event_id, type, user_id, actor_id, request_id, changes, created_at)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.TaskID, e.EventID, e.Type, e.UserID, e.ActorID, e.RequestID, e.Changes, e.CreatedAt)
+}
+
+// GetTaskEvents returns the task's history, oldest first. Besides changes to
+// the task it records the comments posted on it, making it the task's
+// activity timeline.
+func GetTaskEvents(session *gocql.Session, taskID gocql.UUID) ([]TaskEvent, error) {
+	events := []TaskEvent{}
+	query := `SELECT task_id, event_id, type, user_id, actor_id, request_id, changes, created_at
+             FROM task_events WHERE task_id = ?`
+	iter := session.Query(query, taskID).Iter()
+	var e TaskEvent
+	for iter.Scan(&e.TaskID, &e.EventID, &e.Type, &e.UserID, &e.ActorID, &e.RequestID, &e.Changes, &e.CreatedAt) {
+		e.redactComment()
+		events = append(events, e)
+		e = TaskEvent{}
+	}
+	return events, iter.Close()
+}
+
+// redactComment drops the text of the comment an event records. Comment
+// events written before the history left the text out still hold it.
+func (e *TaskEvent) redactComment() {
+	if isCommentEvent(e.Type) {
+		delete(e.Changes, "body")
+	}
+}
+
+// TaskAt reconstructs the task as it was at the given time by replaying its
+// history. It returns gocql.ErrNotFound if the task did not exist or was in
+// the trash then.
+func TaskAt(session *gocql.Session, taskID gocql.UUID, at time.Time) (*Task, error) {
+	events, err := GetTaskEvents(session, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]string
+	var updatedAt time.Time
+	for _, event := range events {
+		if event.CreatedAt.After(at) {
+			break
+		}
+		if isCommentEvent(event.Type) {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]string)
+		}
+		for name, change := range event.Changes {
+			if change.New == "" {
+				delete(fields, name)
+			} else {
+				fields[name] = change.New
+			}
+		}
+		updatedAt = event.CreatedAt
+	}
+	if fields == nil || fields["deleted_at"] != "" {
+		return nil, gocql.ErrNotFound
+	}
+
+	raw := make(map[string]json.RawMessage, len(fields))
+	for name, value := range fields {
+		raw[name] = json.RawMessage(value)
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	task := &Task{}
+	if err := json.Unmarshal(data, task); err != nil {
+		return nil, err
+	}
+	// History written before workspaces existed has no workspace; those tasks
+	// lived in their creator's personal workspace.
+	if task.WorkspaceID == (gocql.UUID{}) {
+		task.WorkspaceID = PersonalWorkspaceID(task.UserID)
+	}
+	task.UpdatedAt = updatedAt
+	return task, nil
+}
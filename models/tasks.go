@@ -1,8 +1,12 @@
 package models
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"todo-app/recurrence"
+	"unicode/utf8"
 
 	"github.com/gocql/gocql"
 )
@@ -13,71 +17,328 @@ const (
 	StatusCompleted  = "done"
 )
 
+// Recurring tasks compute their next occurrence either from the scheduled
+// due date or from the date the previous occurrence was completed.
+const (
+	RecurFromSchedule   = "schedule"
+	RecurFromCompletion = "completion"
+)
+
+// Priorities a task may have. Tasks without one have an empty priority.
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// MaxTaskDepth is the maximum number of levels in a task tree, counting the
+// root task as the first level.
+const MaxTaskDepth = 3
+
+var (
+	ErrTaskCycle         = errors.New("task cannot be its own ancestor")
+	ErrTaskDepthExceeded = fmt.Errorf("task tree cannot be deeper than %d levels", MaxTaskDepth)
+	ErrParentNotFound    = errors.New("parent task not found")
+	ErrInvalidRecurrence = errors.New("invalid recurrence")
+	ErrNoMoreOccurrences = errors.New("task has no further occurrences")
+	ErrInvalidTask       = errors.New("invalid task")
+	ErrCategoryNotFound  = errors.New("category not found")
+	ErrInvalidAssignee   = errors.New("assignees must be members of the task's workspace")
+)
+
+// updatableTaskFields are the task fields, by JSON name and column, that
+// clients may change.
+var updatableTaskFields = []string{
+	"parent_id", "project_id", "category_id", "title", "description", "status", "checklist", "tags", "assignees",
+	"auto_complete", "due_at", "recurrence", "recur_from", "time_zone", "priority",
+}
+
+type ChecklistItem struct {
+	ItemID gocql.UUID `json:"item_id" cql:"item_id"`
+	Text   string     `json:"text" cql:"text"`
+	Done   bool       `json:"done" cql:"done"`
+}
+
+// Progress counts the finished checklist items and direct subtasks of a task.
+type Progress struct {
+	Done  int `json:"done"`
+	Total int `json:"total"`
+}
+
 type Task struct {
-	TaskID      gocql.UUID `json:"task_id"`
-	UserID      gocql.UUID `json:"user_id"`
-	Title       string     `json:"title"`
-	Description string     `json:"description"`
-	Status      string     `json:"status"`
-	CreatedAt   time.Time  `json:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at"`
+	TaskID       gocql.UUID      `json:"task_id"`
+	UserID       gocql.UUID      `json:"user_id"`
+	WorkspaceID  gocql.UUID      `json:"workspace_id"`
+	ProjectID    *gocql.UUID     `json:"project_id,omitempty"`
+	ParentID     *gocql.UUID     `json:"parent_id,omitempty"`
+	CategoryID   *gocql.UUID     `json:"category_id,omitempty"`
+	Title        string          `json:"title"`
+	Description  string          `json:"description"`
+	Status       string          `json:"status"`
+	Checklist    []ChecklistItem `json:"checklist"`
+	Tags         []string        `json:"tags"`
+	Assignees    []gocql.UUID    `json:"assignees"`
+	AutoComplete bool            `json:"auto_complete"`
+	DueAt        *time.Time      `json:"due_at,omitempty"`
+	Recurrence   string          `json:"recurrence,omitempty"`
+	RecurFrom    string          `json:"recur_from,omitempty"`
+	TimeZone     string          `json:"time_zone,omitempty"`
+	Priority     string          `json:"priority,omitempty"`
+	Progress     *Progress       `json:"progress,omitempty"`
+	Blocked      bool            `json:"blocked"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+	DeletedAt    *time.Time      `json:"deleted_at,omitempty"`
+
+	// Version is incremented by every write. Writes are conditional on the
+	// version the caller read, so concurrent edits are detected.
+	Version int `json:"version"`
+
+	// NextOccurrence is the task generated when a recurring task is completed.
+	NextOccurrence *Task `json:"next_occurrence,omitempty"`
+
+	// syncClocks are the clocks, by field, of the client changes being
+	// applied by ApplySyncChange. Other changed fields are stamped with the
+	// server clock.
+	syncClocks map[string]string
 }
 
-func NewTask(userID gocql.UUID, title, description, status string) *Task {
-	if !isValidStatus(status) {
-		status = StatusPending
+const taskColumns = `task_id, user_id, workspace_id, project_id, parent_id, category_id, title, description, status,
+	checklist, tags, assignees, auto_complete, due_at, recurrence, recur_from, time_zone, priority, created_at,
+	updated_at, deleted_at, version`
+
+func (t *Task) scanDest() []interface{} {
+	return []interface{}{
+		&t.TaskID,
+		&t.UserID,
+		&t.WorkspaceID,
+		&t.ProjectID,
+		&t.ParentID,
+		&t.CategoryID,
+		&t.Title,
+		&t.Description,
+		&t.Status,
+		&t.Checklist,
+		&t.Tags,
+		&t.Assignees,
+		&t.AutoComplete,
+		&t.DueAt,
+		&t.Recurrence,
+		&t.RecurFrom,
+		&t.TimeZone,
+		&t.Priority,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.DeletedAt,
+		&t.Version,
 	}
+}
+
+// NewTask builds a task created by the user in their personal workspace.
+// The status is checked against the workspace's workflow when the task is
+// created; an empty status becomes the workflow's initial status.
+func NewTask(userID gocql.UUID, title, description, status string) *Task {
 	return &Task{
 		TaskID:      gocql.TimeUUID(), // Generate unique TimeUUID for each task
 		UserID:      userID,
+		WorkspaceID: PersonalWorkspaceID(userID),
 		Title:       title,
 		Description: description,
-		Status:      status,
+		Status:      normalizeStatusKey(status),
 		CreatedAt:   time.Now().UTC(),
 		UpdatedAt:   time.Now().UTC(),
+		Version:     1,
 	}
 }
 
-func (t *Task) Create(session *gocql.Session) error {
-	query := `INSERT INTO tasks (task_id, user_id, title, description, status, created_at, updated_at) 
-             VALUES (?, ?, ?, ?, ?, ?, ?)`
+// Create saves the new task and notifies its assignees.
+func (t *Task) Create(session *gocql.Session, actor Actor) error {
+	return t.create(session, actor, true)
+}
+
+func (t *Task) create(session *gocql.Session, actor Actor, notify bool) error {
+	if err := t.ValidateCreate(session); err != nil {
+		return err
+	}
 
-	return session.Query(query,
+	query := `INSERT INTO tasks (` + taskColumns + `)
+             VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(query,
 		t.TaskID,
 		t.UserID,
+		t.WorkspaceID,
+		t.ProjectID,
+		t.ParentID,
+		t.CategoryID,
 		t.Title,
 		t.Description,
 		t.Status,
+		t.Checklist,
+		t.Tags,
+		t.Assignees,
+		t.AutoComplete,
+		t.DueAt,
+		t.Recurrence,
+		t.RecurFrom,
+		t.TimeZone,
+		t.Priority,
 		t.CreatedAt,
-		t.UpdatedAt).Exec()
+		t.UpdatedAt,
+		t.DeletedAt,
+		t.Version)
+	event := newTaskEvent(nil, t, actor)
+	event.addToBatch(batch)
+	addTaskSync(batch, nil, t)
+	addTaskOutbox(batch, event, nil, t)
+	addTagIndex(batch, t, t.Tags)
+	addAssigneeIndex(batch, t, t.Assignees)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	publishTaskChange(session, TaskEventCreated, t)
+	if notify {
+		notifyAssignees(session, t, t.Assignees, actor)
+	}
+	return nil
 }
 
-func GetTasksByUserID(session *gocql.Session, userID gocql.UUID) ([]*Task, error) {
-	var tasks []*Task
+// ValidateCreate runs the checks of Create without writing anything. An empty
+// status becomes the workflow's initial status.
+func (t *Task) ValidateCreate(session *gocql.Session) error {
+	workflow, err := GetWorkflow(session, t.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	t.Status = normalizeStatusKey(t.Status)
+	if t.Status == "" {
+		t.Status = workflow.InitialStatus()
+	}
+	if _, ok := workflow.Status(t.Status); !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidStatus, t.Status)
+	}
+
+	if err := t.validateFields(); err != nil {
+		return err
+	}
+	if err := t.validateCategory(session); err != nil {
+		return err
+	}
+	if err := t.validateParent(session); err != nil {
+		return err
+	}
+	if err := t.validateProject(session); err != nil {
+		return err
+	}
+	if err := t.validateAssignees(session); err != nil {
+		return err
+	}
+	if err := t.validateRecurrence(); err != nil {
+		return err
+	}
+	t.normalizeChecklist()
+	return nil
+}
+
+// GetTaskByID returns the task, or gocql.ErrNotFound if it is in the trash.
+func GetTaskByID(session *gocql.Session, taskID gocql.UUID) (*Task, error) {
+	task, err := getTask(session, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if task.DeletedAt != nil {
+		return nil, gocql.ErrNotFound
+	}
+	return task, nil
+}
+
+// getTask returns the task whether or not it is in the trash.
+func getTask(session *gocql.Session, taskID gocql.UUID) (*Task, error) {
+	task := &Task{}
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE task_id = ?`
+	if err := session.Query(query, taskID).Scan(task.scanDest()...); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// GetTasksByWorkspaceID returns the tasks of the workspace.
+func GetTasksByWorkspaceID(session *gocql.Session, workspaceID gocql.UUID) ([]*Task, error) {
+	tasks, err := getWorkspaceTasks(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	tasks = withoutTrashed(tasks)
+	workflow, err := GetWorkflow(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every subtask of a workspace's task belongs to the same workspace, so
+	// progress can be computed from the list without further queries.
+	children := make(map[gocql.UUID][]*Task)
+	for _, task := range tasks {
+		if task.ParentID != nil {
+			children[*task.ParentID] = append(children[*task.ParentID], task)
+		}
+	}
+	for _, task := range tasks {
+		task.Progress = computeProgress(task, children[task.TaskID], workflow)
+	}
 
-	query := `SELECT task_id, user_id, title, description, status, created_at, updated_at 
-             FROM tasks WHERE user_id = ? ALLOW FILTERING`
-
-	iter := session.Query(query, userID).Iter()
-
-	var task Task
-	for iter.Scan(
-		&task.TaskID,
-		&task.UserID,
-		&task.Title,
-		&task.Description,
-		&task.Status,
-		&task.CreatedAt,
-		&task.UpdatedAt) {
-		tasks = append(tasks, &Task{
-			TaskID:      task.TaskID,
-			UserID:      task.UserID,
-			Title:       task.Title,
-			Description: task.Description,
-			Status:      task.Status,
-			CreatedAt:   task.CreatedAt,
-			UpdatedAt:   task.UpdatedAt,
-		})
+	deps, err := GetDependenciesByWorkspaceID(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	applyBlocked(tasks, deps, workflow)
+
+	return tasks, nil
+}
+
+// getWorkspaceTasks returns the tasks of the workspace, including those in
+// the trash, without their computed fields.
+func getWorkspaceTasks(session *gocql.Session, workspaceID gocql.UUID) ([]*Task, error) {
+	query := `SELECT ` + taskColumns + `
+             FROM tasks WHERE workspace_id = ?`
+	return scanTasks(session.Query(query, workspaceID).Iter())
+}
+
+// GetChildTasks returns the direct subtasks of a task.
+func GetChildTasks(session *gocql.Session, parentID gocql.UUID) ([]*Task, error) {
+	children, err := getChildTasks(session, parentID)
+	if err != nil {
+		return nil, err
+	}
+	return withoutTrashed(children), nil
+}
+
+// getChildTasks returns the direct subtasks of a task, including those in
+// the trash.
+func getChildTasks(session *gocql.Session, parentID gocql.UUID) ([]*Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE parent_id = ?`
+	return scanTasks(session.Query(query, parentID).Iter())
+}
+
+func withoutTrashed(tasks []*Task) []*Task {
+	live := tasks[:0]
+	for _, task := range tasks {
+		if task.DeletedAt == nil {
+			live = append(live, task)
+		}
+	}
+	return live
+}
+
+func scanTasks(iter *gocql.Iter) ([]*Task, error) {
+	var tasks []*Task
+	for {
+		task := &Task{}
+		if !iter.Scan(task.scanDest()...) {
+			break
+		}
+		tasks = append(tasks, task)
 	}
 
 	if err := iter.Close(); err != nil {
@@ -87,29 +348,587 @@ func GetTasksByUserID(session *gocql.Session, userID gocql.UUID) ([]*Task, error
 	return tasks, nil
 }
 
-func (t *Task) Update(session *gocql.Session) error {
-	if !isValidStatus(t.Status) {
-		return fmt.Errorf("invalid status: %s", t.Status)
+// LoadProgress fills in the task's progress from its checklist and subtasks.
+func (t *Task) LoadProgress(session *gocql.Session) error {
+	children, err := GetChildTasks(session, t.TaskID)
+	if err != nil {
+		return err
+	}
+	workflow, err := GetWorkflow(session, t.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	t.Progress = computeProgress(t, children, workflow)
+	return nil
+}
+
+func computeProgress(task *Task, children []*Task, workflow *Workflow) *Progress {
+	progress := &Progress{Total: len(task.Checklist) + len(children)}
+	for _, item := range task.Checklist {
+		if item.Done {
+			progress.Done++
+		}
+	}
+	for _, child := range children {
+		if workflow.IsClosed(child.Status) {
+			progress.Done++
+		}
+	}
+	return progress
+}
+
+// Update saves the task, writing only the columns that changed. The status
+// must be reachable from the current one in the workspace's workflow, and moving a
+// task to a closed status fails with ErrTaskBlocked while any of its blockers
+// are still open. The task's Version must be the stored one, otherwise
+// ErrVersionMismatch is returned; on success it holds the new version.
+func (t *Task) Update(session *gocql.Session, actor Actor) error {
+	return t.update(session, actor, false)
+}
+
+// ForceUpdate saves the task like Update but allows completing a task whose
+// blockers are still open.
+func (t *Task) ForceUpdate(session *gocql.Session, actor Actor) error {
+	return t.update(session, actor, true)
+}
+
+// ValidateUpdate runs the checks of Update, or of ForceUpdate if force is
+// set, without writing anything.
+func (t *Task) ValidateUpdate(session *gocql.Session, force bool) error {
+	_, err := t.prepareUpdate(session, force)
+	return err
+}
+
+// taskUpdate is what prepareUpdate learns about an update.
+type taskUpdate struct {
+	workflow   *Workflow
+	previous   *Task
+	completing bool
+}
+
+func (t *Task) prepareUpdate(session *gocql.Session, force bool) (*taskUpdate, error) {
+	workflow, err := GetWorkflow(session, t.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	t.Status = normalizeStatusKey(t.Status)
+	if _, ok := workflow.Status(t.Status); !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidStatus, t.Status)
+	}
+
+	if err := t.validateFields(); err != nil {
+		return nil, err
+	}
+	if err := t.validateParent(session); err != nil {
+		return nil, err
+	}
+	if err := t.validateRecurrence(); err != nil {
+		return nil, err
+	}
+
+	previous, err := GetTaskByID(session, t.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	if previous.Version != t.Version {
+		return nil, ErrVersionMismatch
+	}
+	if t.WorkspaceID != previous.WorkspaceID {
+		return nil, fmt.Errorf("%w: tasks cannot move between workspaces", ErrInvalidTask)
+	}
+	// Like categories, only a new project is checked, and assignees who have
+	// left the workspace stay on the task until it is reassigned.
+	if !sameUUID(t.ProjectID, previous.ProjectID) {
+		if err := t.validateProject(session); err != nil {
+			return nil, err
+		}
+	}
+	if sameUUIDs(t.Assignees, previous.Assignees) {
+		t.Assignees = previous.Assignees
+	} else if err := t.validateAssignees(session); err != nil {
+		return nil, err
+	}
+	// Tasks keep pointing at a category that went to the trash until they
+	// are moved, so only a new category is checked.
+	if !sameUUID(t.CategoryID, previous.CategoryID) {
+		if err := t.validateCategory(session); err != nil {
+			return nil, err
+		}
+	}
+	if !workflow.CanTransition(previous.Status, t.Status) {
+		return nil, fmt.Errorf("%w: %s to %s", ErrTransitionNotAllowed, previous.Status, t.Status)
+	}
+	completing := workflow.IsClosed(t.Status) && !workflow.IsClosed(previous.Status)
+
+	if completing && !force {
+		open, err := openBlockers(session, t, workflow)
+		if err != nil {
+			return nil, err
+		}
+		if len(open) > 0 {
+			return nil, ErrTaskBlocked
+		}
 	}
+	t.normalizeChecklist()
+	return &taskUpdate{workflow: workflow, previous: previous, completing: completing}, nil
+}
+
+func (t *Task) update(session *gocql.Session, actor Actor, force bool) error {
+	plan, err := t.prepareUpdate(session, force)
+	if err != nil {
+		return err
+	}
+	workflow, previous := plan.workflow, plan.previous
+
+	// Completing an occurrence of a recurring task hands the rule on to the
+	// next occurrence.
+	var next *Task
+	if plan.completing && t.Recurrence != "" {
+		var err error
+		if next, err = t.nextOccurrence(time.Now(), workflow.InitialStatus()); err != nil {
+			return err
+		}
+		t.Recurrence = ""
+		t.RecurFrom = ""
+	}
+
+	changed := diffTasks(previous, t)
+	var assignments []string
+	var values []interface{}
+	for _, name := range updatableTaskFields {
+		if _, ok := changed[name]; ok {
+			assignments = append(assignments, name+" = ?")
+			values = append(values, t.columnValue(name))
+		}
+	}
+	if len(assignments) == 0 {
+		t.UpdatedAt = previous.UpdatedAt
+		return nil
+	}
+
 	t.UpdatedAt = time.Now()
-	query := `UPDATE tasks 
-			 SET title = ?, description = ?, status = ?, updated_at = ? 
-			 WHERE task_id = ?`
-	return session.Query(query,
-		t.Title,
-		t.Description,
-		t.Status,
-		t.UpdatedAt,
-		t.TaskID).Exec()
+	t.Version = previous.Version + 1
+	assignments = append(assignments, "updated_at = ?", "version = ?")
+	values = append(values, t.UpdatedAt, t.Version)
+	pending, err := writeTaskChange(session, previous, t, actor, assignments, values...)
+	if err != nil {
+		t.Version = previous.Version
+		return err
+	}
+	recordTaskEvent(session, pending, t)
+	if _, ok := changed["tags"]; ok {
+		if err := syncTagIndex(session, t, previous.Tags); err != nil {
+			return fmt.Errorf("failed to update tag index: %v", err)
+		}
+	}
+	if _, ok := changed["assignees"]; ok {
+		added, err := syncAssigneeIndex(session, t, previous.Assignees)
+		if err != nil {
+			return fmt.Errorf("failed to update assignee index: %v", err)
+		}
+		notifyAssignees(session, t, added, actor)
+	}
+
+	if err := RescheduleReminders(session, t); err != nil {
+		return fmt.Errorf("failed to reschedule reminders: %v", err)
+	}
+
+	if next != nil {
+		// The assignees already know about the series, so the next
+		// occurrence is created without notifying them again.
+		if err := next.create(session, actor, false); err != nil {
+			return fmt.Errorf("failed to create next occurrence: %v", err)
+		}
+		if err := CopyReminders(session, t, next); err != nil {
+			return fmt.Errorf("failed to copy reminders: %v", err)
+		}
+		t.NextOccurrence = next
+	}
+
+	if workflow.IsClosed(t.Status) && t.ParentID != nil {
+		return completeAncestors(session, *t.ParentID, workflow, actor)
+	}
+	return nil
 }
 
-func DeleteTaskByID(session *gocql.Session, taskID gocql.UUID) error {
-	query := `DELETE FROM tasks WHERE task_id = ?`
-	return session.Query(query, taskID).Exec()
+// columnValue returns the value written to the column of an updatable field.
+func (t *Task) columnValue(name string) interface{} {
+	switch name {
+	case "parent_id":
+		return t.ParentID
+	case "project_id":
+		return t.ProjectID
+	case "category_id":
+		return t.CategoryID
+	case "title":
+		return t.Title
+	case "description":
+		return t.Description
+	case "status":
+		return t.Status
+	case "checklist":
+		return t.Checklist
+	case "tags":
+		return t.Tags
+	case "assignees":
+		return t.Assignees
+	case "auto_complete":
+		return t.AutoComplete
+	case "due_at":
+		return t.DueAt
+	case "recurrence":
+		return t.Recurrence
+	case "recur_from":
+		return t.RecurFrom
+	case "time_zone":
+		return t.TimeZone
+	case "priority":
+		return t.Priority
+	}
+	panic("models: unknown task column " + name)
 }
 
-func isValidStatus(status string) bool {
-	return status == StatusPending ||
-		status == StatusInProgress ||
-		status == StatusCompleted
+// SkipOccurrence moves a recurring task to its next scheduled occurrence
+// without completing it.
+func (t *Task) SkipOccurrence(session *gocql.Session, actor Actor) error {
+	nextDue, remaining, err := t.skippedOccurrence()
+	if err != nil {
+		return err
+	}
+
+	previous := *t
+	t.DueAt = &nextDue
+	t.Recurrence = remaining
+	t.UpdatedAt = time.Now()
+	t.Version = previous.Version + 1
+	pending, err := writeTaskChange(session, &previous, t, actor,
+		[]string{"due_at = ?", "recurrence = ?", "updated_at = ?", "version = ?"},
+		nextDue, remaining, t.UpdatedAt, t.Version)
+	if err != nil {
+		*t = previous
+		return err
+	}
+
+	recordTaskEvent(session, pending, t)
+	return RescheduleReminders(session, t)
+}
+
+// skippedOccurrence returns the due date and rule of a recurring task once
+// its current occurrence is skipped. Skipping always follows the schedule,
+// whichever date the task recurs from.
+func (t *Task) skippedOccurrence() (time.Time, string, error) {
+	if t.Recurrence == "" || t.DueAt == nil {
+		return time.Time{}, "", fmt.Errorf("%w: task does not recur", ErrInvalidRecurrence)
+	}
+	rule, loc, err := t.recurrenceRule()
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	due := t.DueAt.In(loc)
+	nextDue, ok := rule.After(due, due)
+	remaining := rule.Remaining()
+	if !ok || remaining == nil {
+		return time.Time{}, "", ErrNoMoreOccurrences
+	}
+	return nextDue.UTC(), remaining.String(), nil
+}
+
+// nextOccurrence builds the task that follows this occurrence, or returns
+// nil when the rule has no further occurrences.
+func (t *Task) nextOccurrence(completedAt time.Time, status string) (*Task, error) {
+	rule, loc, err := t.recurrenceRule()
+	if err != nil {
+		return nil, err
+	}
+
+	due := t.DueAt.In(loc)
+	anchor := due
+	if t.RecurFrom == RecurFromCompletion {
+		// Keep the scheduled time of day, but count from the completion date
+		done := completedAt.In(loc)
+		anchor = time.Date(done.Year(), done.Month(), done.Day(), due.Hour(), due.Minute(), due.Second(), 0, loc)
+	}
+
+	nextDue, ok := rule.After(anchor, anchor)
+	remaining := rule.Remaining()
+	if !ok || remaining == nil {
+		return nil, nil
+	}
+	nextDue = nextDue.UTC()
+
+	next := NewTask(t.UserID, t.Title, t.Description, status)
+	next.WorkspaceID = t.WorkspaceID
+	next.ProjectID = t.ProjectID
+	next.Assignees = t.Assignees
+	next.ParentID = t.ParentID
+	next.AutoComplete = t.AutoComplete
+	next.DueAt = &nextDue
+	next.Recurrence = remaining.String()
+	next.RecurFrom = t.RecurFrom
+	next.TimeZone = t.TimeZone
+	next.Priority = t.Priority
+	next.Tags = t.Tags
+	for _, item := range t.Checklist {
+		next.Checklist = append(next.Checklist, ChecklistItem{Text: item.Text})
+	}
+	return next, nil
+}
+
+func (t *Task) recurrenceRule() (*recurrence.Rule, *time.Location, error) {
+	rule, err := recurrence.Parse(t.Recurrence)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidRecurrence, err)
+	}
+	loc, err := time.LoadLocation(t.TimeZone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalidRecurrence, t.TimeZone)
+	}
+	return rule, loc, nil
+}
+
+// validateRecurrence checks the recurrence settings and fills in defaults.
+func (t *Task) validateRecurrence() error {
+	if t.TimeZone == "" {
+		t.TimeZone = "UTC"
+	}
+	if t.Recurrence == "" {
+		t.RecurFrom = ""
+		return nil
+	}
+	if t.DueAt == nil {
+		return fmt.Errorf("%w: recurring tasks need a due date", ErrInvalidRecurrence)
+	}
+	switch t.RecurFrom {
+	case "":
+		t.RecurFrom = RecurFromSchedule
+	case RecurFromSchedule, RecurFromCompletion:
+	default:
+		return fmt.Errorf("%w: recur_from must be %q or %q", ErrInvalidRecurrence, RecurFromSchedule, RecurFromCompletion)
+	}
+
+	rule, _, err := t.recurrenceRule()
+	if err != nil {
+		return err
+	}
+	t.Recurrence = rule.String()
+	return nil
+}
+
+// validateFields checks the fields that need no lookups.
+func (t *Task) validateFields() error {
+	t.Title = strings.TrimSpace(t.Title)
+	if t.Title == "" {
+		return fmt.Errorf("%w: title is required", ErrInvalidTask)
+	}
+	if utf8.RuneCountInString(t.Title) > 200 {
+		return fmt.Errorf("%w: title cannot be longer than 200 characters", ErrInvalidTask)
+	}
+	if utf8.RuneCountInString(t.Description) > 10000 {
+		return fmt.Errorf("%w: description cannot be longer than 10000 characters", ErrInvalidTask)
+	}
+	for _, item := range t.Checklist {
+		if strings.TrimSpace(item.Text) == "" {
+			return fmt.Errorf("%w: checklist items need text", ErrInvalidTask)
+		}
+	}
+	tags, err := normalizeTags(t.Tags)
+	if err != nil {
+		return err
+	}
+	t.Tags = tags
+	if t.TimeZone != "" {
+		if _, err := time.LoadLocation(t.TimeZone); err != nil {
+			return fmt.Errorf("%w: unknown time zone %q", ErrInvalidTask, t.TimeZone)
+		}
+	}
+	switch t.Priority {
+	case "", PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent:
+	default:
+		return fmt.Errorf("%w: priority must be low, medium, high or urgent", ErrInvalidTask)
+	}
+	return nil
+}
+
+func (t *Task) validateCategory(session *gocql.Session) error {
+	if t.CategoryID == nil {
+		return nil
+	}
+	return checkCategory(session, t.WorkspaceID, *t.CategoryID)
+}
+
+// validateProject checks that the task's project belongs to its workspace.
+func (t *Task) validateProject(session *gocql.Session) error {
+	if t.ProjectID == nil {
+		return nil
+	}
+	if _, err := GetProject(session, t.WorkspaceID, *t.ProjectID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateAssignees checks that everyone assigned to the task is a member of
+// its workspace and drops duplicates.
+func (t *Task) validateAssignees(session *gocql.Session) error {
+	if len(t.Assignees) == 0 {
+		t.Assignees = nil
+		return nil
+	}
+	seen := make(map[gocql.UUID]bool, len(t.Assignees))
+	var assignees []gocql.UUID
+	for _, id := range t.Assignees {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		role, err := GetWorkspaceRole(session, t.WorkspaceID, id)
+		if err != nil {
+			return err
+		}
+		if role == "" {
+			return fmt.Errorf("%w: %s", ErrInvalidAssignee, id)
+		}
+		assignees = append(assignees, id)
+	}
+	t.Assignees = assignees
+	return nil
+}
+
+func sameUUID(a, b *gocql.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// sameUUIDs reports whether two lists hold the same IDs, ignoring order.
+func sameUUIDs(a, b []gocql.UUID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[gocql.UUID]int, len(a))
+	for _, id := range a {
+		counts[id]++
+	}
+	for _, id := range b {
+		if counts[id] == 0 {
+			return false
+		}
+		counts[id]--
+	}
+	return true
+}
+
+// validateParent checks that the task's parent exists, belongs to the same
+// workspace, and that attaching the task there neither creates a cycle nor makes
+// the tree deeper than MaxTaskDepth.
+func (t *Task) validateParent(session *gocql.Session) error {
+	if t.ParentID == nil {
+		return nil
+	}
+	if *t.ParentID == t.TaskID {
+		return ErrTaskCycle
+	}
+
+	parent, err := GetTaskByID(session, *t.ParentID)
+	if err == gocql.ErrNotFound {
+		return ErrParentNotFound
+	} else if err != nil {
+		return err
+	}
+	if parent.WorkspaceID != t.WorkspaceID {
+		return ErrParentNotFound
+	}
+
+	// Walk up from the parent, counting the levels above the task.
+	levels := 1
+	for parent.ParentID != nil {
+		if *parent.ParentID == t.TaskID {
+			return ErrTaskCycle
+		}
+		levels++
+		if levels >= MaxTaskDepth {
+			return ErrTaskDepthExceeded
+		}
+		if parent, err = GetTaskByID(session, *parent.ParentID); err != nil {
+			return fmt.Errorf("failed to load ancestor task: %v", err)
+		}
+	}
+
+	height, err := subtreeHeight(session, t.TaskID, MaxTaskDepth-levels)
+	if err != nil {
+		return err
+	}
+	if levels+height > MaxTaskDepth {
+		return ErrTaskDepthExceeded
+	}
+	return nil
+}
+
+// subtreeHeight returns the number of levels in the tree rooted at taskID,
+// stopping once the height exceeds limit.
+func subtreeHeight(session *gocql.Session, taskID gocql.UUID, limit int) (int, error) {
+	if limit <= 0 {
+		return 1, nil
+	}
+	children, err := GetChildTasks(session, taskID)
+	if err != nil {
+		return 0, err
+	}
+	height := 1
+	for _, child := range children {
+		h, err := subtreeHeight(session, child.TaskID, limit-1)
+		if err != nil {
+			return 0, err
+		}
+		if h+1 > height {
+			height = h + 1
+		}
+	}
+	return height, nil
+}
+
+// completeAncestors moves an auto-completing parent to the workflow's closed
+// status once all of its subtasks are closed. The parent is saved like any
+// other update, so it cannot close while its own blockers are open, a
+// recurring parent moves on to its next occurrence, and closing it in turn
+// completes its own parent.
+func completeAncestors(session *gocql.Session, parentID gocql.UUID, workflow *Workflow, actor Actor) error {
+	parent, err := GetTaskByID(session, parentID)
+	if err != nil {
+		return err
+	}
+	closed := workflow.ClosedStatus()
+	if !parent.AutoComplete || workflow.IsClosed(parent.Status) || !workflow.CanTransition(parent.Status, closed) {
+		return nil
+	}
+
+	children, err := GetChildTasks(session, parentID)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		if !workflow.IsClosed(child.Status) {
+			return nil
+		}
+	}
+
+	// A parent edited in the meantime is left for its editor to close, and
+	// a blocked one stays open until its blockers are done.
+	parent.Status = closed
+	err = parent.update(session, actor, false)
+	if err == ErrVersionMismatch || err == ErrTaskBlocked {
+		return nil
+	}
+	return err
+}
+
+// normalizeChecklist assigns IDs to new checklist items.
+func (t *Task) normalizeChecklist() {
+	for i := range t.Checklist {
+		if t.Checklist[i].ItemID == (gocql.UUID{}) {
+			t.Checklist[i].ItemID = gocql.TimeUUID()
+		}
+	}
 }
@@ -0,0 +1,249 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/gocql/gocql"
+)
+
+func recurringTask(t *testing.T, rule, recurFrom, zone string, due time.Time) *Task {
+	t.Helper()
+	task := NewTask(gocql.TimeUUID(), "Water the plants", "", StatusCompleted)
+	task.Recurrence = rule
+	task.RecurFrom = recurFrom
+	task.TimeZone = zone
+	task.DueAt = &due
+	if err := task.validateRecurrence(); err != nil {
+		t.Fatalf("validateRecurrence: %v", err)
+	}
+	return task
+}
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestNextOccurrence(t *testing.T) {
+	ny := loadLocation(t, "America/New_York")
+	monday := time.Date(2024, 1, 1, 9, 0, 0, 0, ny)
+
+	tests := []struct {
+		name        string
+		rule        string
+		recurFrom   string
+		due         time.Time
+		completedAt time.Time
+		wantDue     time.Time
+		wantRule    string
+	}{
+		{
+			name:        "schedule: completed late keeps the schedule",
+			rule:        "FREQ=WEEKLY;COUNT=3",
+			recurFrom:   RecurFromSchedule,
+			due:         monday,
+			completedAt: time.Date(2024, 1, 4, 17, 0, 0, 0, ny),
+			wantDue:     time.Date(2024, 1, 8, 9, 0, 0, 0, ny),
+			wantRule:    "FREQ=WEEKLY;COUNT=2",
+		},
+		{
+			name:        "schedule: completed after several occurrences moves one step",
+			rule:        "FREQ=WEEKLY",
+			recurFrom:   RecurFromSchedule,
+			due:         monday,
+			completedAt: time.Date(2024, 1, 20, 17, 0, 0, 0, ny),
+			wantDue:     time.Date(2024, 1, 8, 9, 0, 0, 0, ny),
+			wantRule:    "FREQ=WEEKLY",
+		},
+		{
+			name:        "schedule: completed early keeps the schedule",
+			rule:        "FREQ=DAILY;INTERVAL=3",
+			recurFrom:   RecurFromSchedule,
+			due:         monday,
+			completedAt: time.Date(2023, 12, 30, 12, 0, 0, 0, ny),
+			wantDue:     time.Date(2024, 1, 4, 9, 0, 0, 0, ny),
+			wantRule:    "FREQ=DAILY;INTERVAL=3",
+		},
+		{
+			name:        "completion: counts from the completion day at the scheduled time",
+			rule:        "FREQ=DAILY;INTERVAL=3",
+			recurFrom:   RecurFromCompletion,
+			due:         monday,
+			completedAt: time.Date(2024, 1, 5, 15, 0, 0, 0, ny),
+			wantDue:     time.Date(2024, 1, 8, 9, 0, 0, 0, ny),
+			wantRule:    "FREQ=DAILY;INTERVAL=3",
+		},
+		{
+			name:        "completion: the completion day is taken in the task's time zone",
+			rule:        "FREQ=DAILY;INTERVAL=3",
+			recurFrom:   RecurFromCompletion,
+			due:         monday,
+			completedAt: time.Date(2024, 1, 5, 3, 0, 0, 0, time.UTC), // 22:00 on the 4th in New York
+			wantDue:     time.Date(2024, 1, 7, 9, 0, 0, 0, ny),
+			wantRule:    "FREQ=DAILY;INTERVAL=3",
+		},
+		{
+			name:        "completion: completed early counts from the completion day",
+			rule:        "FREQ=WEEKLY;COUNT=5",
+			recurFrom:   RecurFromCompletion,
+			due:         monday,
+			completedAt: time.Date(2023, 12, 29, 8, 0, 0, 0, ny),
+			wantDue:     time.Date(2024, 1, 5, 9, 0, 0, 0, ny),
+			wantRule:    "FREQ=WEEKLY;COUNT=4",
+		},
+		{
+			name:        "schedule: keeps the local time across daylight saving",
+			rule:        "FREQ=DAILY",
+			recurFrom:   RecurFromSchedule,
+			due:         time.Date(2024, 3, 9, 9, 0, 0, 0, ny),
+			completedAt: time.Date(2024, 3, 9, 10, 0, 0, 0, ny),
+			wantDue:     time.Date(2024, 3, 10, 9, 0, 0, 0, ny),
+			wantRule:    "FREQ=DAILY",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := recurringTask(t, tt.rule, tt.recurFrom, "America/New_York", tt.due)
+			task.Checklist = []ChecklistItem{{ItemID: gocql.TimeUUID(), Text: "Fill the can", Done: true}}
+			task.Tags = []string{"home"}
+			task.Priority = PriorityHigh
+
+			next, err := task.nextOccurrence(tt.completedAt, StatusPending)
+			if err != nil {
+				t.Fatalf("nextOccurrence: %v", err)
+			}
+			if next == nil {
+				t.Fatal("nextOccurrence returned no task")
+			}
+			if !next.DueAt.Equal(tt.wantDue) {
+				t.Errorf("due = %v, want %v", next.DueAt.In(ny), tt.wantDue)
+			}
+			if next.DueAt.Location() != time.UTC {
+				t.Errorf("due is in %v, want UTC", next.DueAt.Location())
+			}
+			if next.Recurrence != tt.wantRule {
+				t.Errorf("recurrence = %q, want %q", next.Recurrence, tt.wantRule)
+			}
+			if next.RecurFrom != tt.recurFrom || next.TimeZone != task.TimeZone {
+				t.Errorf("recur_from, time_zone = %q, %q; want %q, %q",
+					next.RecurFrom, next.TimeZone, tt.recurFrom, task.TimeZone)
+			}
+			if next.TaskID == task.TaskID || next.Status != StatusPending {
+				t.Errorf("next occurrence is not a new open task: %+v", next)
+			}
+			if len(next.Checklist) != 1 || next.Checklist[0].Done || next.Checklist[0].ItemID != (gocql.UUID{}) {
+				t.Errorf("checklist = %+v, want one unchecked new item", next.Checklist)
+			}
+			if next.Priority != PriorityHigh || len(next.Tags) != 1 {
+				t.Errorf("priority, tags = %q, %v; want them copied", next.Priority, next.Tags)
+			}
+		})
+	}
+}
+
+func TestNextOccurrenceEndOfSeries(t *testing.T) {
+	due := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	for _, rule := range []string{"FREQ=DAILY;COUNT=1", "FREQ=DAILY;UNTIL=20240101"} {
+		for _, from := range []string{RecurFromSchedule, RecurFromCompletion} {
+			task := recurringTask(t, rule, from, "UTC", due)
+			next, err := task.nextOccurrence(due.Add(time.Hour), StatusPending)
+			if err != nil || next != nil {
+				t.Errorf("%s from %s: next = %v, %v; want none", rule, from, next, err)
+			}
+		}
+	}
+
+	// A completion-anchored series ends once counting from the completion
+	// passes UNTIL.
+	task := recurringTask(t, "FREQ=DAILY;UNTIL=20240110", RecurFromCompletion, "UTC", due)
+	next, err := task.nextOccurrence(due.AddDate(0, 0, 8), StatusPending)
+	if err != nil || next == nil {
+		t.Fatalf("completed on the 9th: next = %v, %v; want one on the 10th", next, err)
+	}
+	if !next.DueAt.Equal(time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("completed on the 9th: due = %v, want 2024-01-10 09:00", next.DueAt)
+	}
+	if next, err := task.nextOccurrence(due.AddDate(0, 0, 9), StatusPending); err != nil || next != nil {
+		t.Errorf("completed on the 10th: next = %v, %v; want none", next, err)
+	}
+}
+
+func TestSkippedOccurrence(t *testing.T) {
+	ny := loadLocation(t, "America/New_York")
+	due := time.Date(2024, 1, 1, 9, 0, 0, 0, ny)
+
+	tests := []struct {
+		name      string
+		rule      string
+		recurFrom string
+		wantDue   time.Time
+		wantRule  string
+	}{
+		{"schedule", "FREQ=DAILY;INTERVAL=3;COUNT=4", RecurFromSchedule, time.Date(2024, 1, 4, 9, 0, 0, 0, ny),
+			"FREQ=DAILY;INTERVAL=3;COUNT=3"},
+		// Skipping a completion-anchored task follows the schedule too: it
+		// was not completed, so there is no completion to count from.
+		{"completion", "FREQ=DAILY;INTERVAL=3;COUNT=4", RecurFromCompletion, time.Date(2024, 1, 4, 9, 0, 0, 0, ny),
+			"FREQ=DAILY;INTERVAL=3;COUNT=3"},
+		{"monthly by weekday", "FREQ=MONTHLY;BYDAY=1MO", RecurFromSchedule, time.Date(2024, 2, 5, 9, 0, 0, 0, ny),
+			"FREQ=MONTHLY;BYDAY=1MO"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := recurringTask(t, tt.rule, tt.recurFrom, "America/New_York", due)
+			nextDue, remaining, err := task.skippedOccurrence()
+			if err != nil {
+				t.Fatalf("skippedOccurrence: %v", err)
+			}
+			if !nextDue.Equal(tt.wantDue) || remaining != tt.wantRule {
+				t.Errorf("skip = %v, %q; want %v, %q", nextDue.In(ny), remaining, tt.wantDue, tt.wantRule)
+			}
+		})
+	}
+
+	last := recurringTask(t, "FREQ=DAILY;COUNT=1", RecurFromSchedule, "UTC", due)
+	if _, _, err := last.skippedOccurrence(); err != ErrNoMoreOccurrences {
+		t.Errorf("skipping the last occurrence: err = %v, want ErrNoMoreOccurrences", err)
+	}
+	once := NewTask(gocql.TimeUUID(), "Once", "", StatusPending)
+	if _, _, err := once.skippedOccurrence(); !errors.Is(err, ErrInvalidRecurrence) {
+		t.Errorf("skipping a task that does not recur: err = %v, want ErrInvalidRecurrence", err)
+	}
+}
+
+func TestValidateRecurrence(t *testing.T) {
+	due := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	task := NewTask(gocql.TimeUUID(), "Report", "", StatusPending)
+	task.DueAt = &due
+	task.Recurrence = "rrule:freq=weekly;byday=mo"
+	if err := task.validateRecurrence(); err != nil {
+		t.Fatalf("validateRecurrence: %v", err)
+	}
+	if task.Recurrence != "FREQ=WEEKLY;BYDAY=MO" || task.RecurFrom != RecurFromSchedule || task.TimeZone != "UTC" {
+		t.Errorf("normalized to %q from %q in %q", task.Recurrence, task.RecurFrom, task.TimeZone)
+	}
+
+	for _, bad := range []func(*Task){
+		func(t *Task) { t.DueAt = nil },
+		func(t *Task) { t.RecurFrom = "whenever" },
+		func(t *Task) { t.Recurrence = "FREQ=SECONDLY" },
+		func(t *Task) { t.TimeZone = "Mars/Olympus_Mons" },
+	} {
+		task := NewTask(gocql.TimeUUID(), "Report", "", StatusPending)
+		task.DueAt = &due
+		task.Recurrence = "FREQ=DAILY"
+		bad(task)
+		if err := task.validateRecurrence(); !errors.Is(err, ErrInvalidRecurrence) {
+			t.Errorf("validateRecurrence(%+v) = %v, want ErrInvalidRecurrence", task, err)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	// MaxTemplates caps the templates of a user.
+	MaxTemplates = 100
+
+	// MaxTemplateTasks caps the tasks a template creates, subtasks
+	// included.
+	MaxTemplateTasks = 50
+
+	// MaxTemplateDueDays caps how far ahead of the day a template is
+	// instantiated for its tasks may be due.
+	MaxTemplateDueDays = 365
+)
+
+// Variables every instantiation has a value for unless one is supplied:
+// the day it is for, as YYYY-MM-DD, and the name of the project the tasks
+// go to, empty without one.
+const (
+	TemplateVarDate    = "date"
+	TemplateVarProject = "project"
+)
+
+var (
+	ErrInvalidTemplate      = errors.New("invalid task template")
+	ErrTemplateNotFound     = errors.New("task template not found")
+	ErrMissingVariables     = errors.New("template variables have no value")
+	errTooManyTemplateTasks = fmt.Errorf("%w: a template can create at most %d tasks", ErrInvalidTemplate, MaxTemplateTasks)
+)
+
+// templateVariable matches a placeholder such as {{date}} or {{ sprint }}.
+var templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z][A-Za-z0-9_]*)\s*\}\}`)
+
+// TemplateTask describes a task a template creates, with its subtasks. The
+// title, description and checklist items may hold placeholder variables.
+// DueInDays sets the due date that many days after the day the template is
+// instantiated for.
+type TemplateTask struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Checklist   []string       `json:"checklist,omitempty"`
+	Tags        []string       `json:"tags,omitempty"`
+	Priority    string         `json:"priority,omitempty"`
+	DueInDays   *int           `json:"due_in_days,omitempty"`
+	Subtasks    []TemplateTask `json:"subtasks,omitempty"`
+}
+
+// validate checks the task and its subtasks, the task being at the given
+// level of the tree, and counts them into count.
+func (spec *TemplateTask) validate(level int, count *int) error {
+	if *count++; *count > MaxTemplateTasks {
+		return errTooManyTemplateTasks
+	}
+	if level > MaxTaskDepth {
+		return fmt.Errorf("%w: tasks cannot be nested deeper than %d levels", ErrInvalidTemplate, MaxTaskDepth)
+	}
+	spec.Title = strings.TrimSpace(spec.Title)
+	if spec.Title == "" {
+		return fmt.Errorf("%w: every task needs a title", ErrInvalidTemplate)
+	}
+	if utf8.RuneCountInString(spec.Title) > 200 {
+		return fmt.Errorf("%w: titles cannot be longer than 200 characters", ErrInvalidTemplate)
+	}
+	if utf8.RuneCountInString(spec.Description) > 10000 {
+		return fmt.Errorf("%w: descriptions cannot be longer than 10000 characters", ErrInvalidTemplate)
+	}
+	for i, item := range spec.Checklist {
+		if spec.Checklist[i] = strings.TrimSpace(item); spec.Checklist[i] == "" {
+			return fmt.Errorf("%w: checklist items need text", ErrInvalidTemplate)
+		}
+	}
+	tags, err := normalizeTags(spec.Tags)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	spec.Tags = tags
+	switch spec.Priority {
+	case "", PriorityLow, PriorityMedium, PriorityHigh, PriorityUrgent:
+	default:
+		return fmt.Errorf("%w: priority must be low, medium, high or urgent", ErrInvalidTemplate)
+	}
+	if spec.DueInDays != nil && (*spec.DueInDays < 0 || *spec.DueInDays > MaxTemplateDueDays) {
+		return fmt.Errorf("%w: due_in_days must be between 0 and %d", ErrInvalidTemplate, MaxTemplateDueDays)
+	}
+	for i := range spec.Subtasks {
+		if err := spec.Subtasks[i].validate(level+1, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectVariables adds the placeholders used by the task and its subtasks
+// to seen.
+func (spec *TemplateTask) collectVariables(seen map[string]bool) {
+	texts := append([]string{spec.Title, spec.Description}, spec.Checklist...)
+	for _, text := range texts {
+		for _, m := range templateVariable.FindAllStringSubmatch(text, -1) {
+			seen[m[1]] = true
+		}
+	}
+	for i := range spec.Subtasks {
+		spec.Subtasks[i].collectVariables(seen)
+	}
+}
+
+// TaskTemplate is a user's reusable description of a task and its subtasks.
+// Variables lists the placeholders it uses, which are filled in when it is
+// instantiated.
+type TaskTemplate struct {
+	UserID     gocql.UUID `json:"user_id"`
+	TemplateID gocql.UUID `json:"template_id"`
+	Name       string     `json:"name"`
+	TemplateTask
+	Variables []string  `json:"variables"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Create saves the template as one of the user's.
+func (tpl *TaskTemplate) Create(session *gocql.Session, userID gocql.UUID) error {
+	if err := tpl.validate(); err != nil {
+		return err
+	}
+	existing, err := GetTaskTemplates(session, userID)
+	if err != nil {
+		return err
+	}
+	if len(existing) >= MaxTemplates {
+		return fmt.Errorf("%w: a user can have at most %d templates", ErrInvalidTemplate, MaxTemplates)
+	}
+
+	tpl.UserID = userID
+	tpl.TemplateID = gocql.TimeUUID()
+	tpl.CreatedAt = tpl.TemplateID.Time().UTC()
+	tpl.UpdatedAt = tpl.CreatedAt
+	return tpl.save(session)
+}
+
+// Update saves the template's name and tasks.
+func (tpl *TaskTemplate) Update(session *gocql.Session) error {
+	if err := tpl.validate(); err != nil {
+		return err
+	}
+	tpl.UpdatedAt = time.Now().UTC()
+	return tpl.save(session)
+}
+
+func (tpl *TaskTemplate) save(session *gocql.Session) error {
+	body, err := json.Marshal(tpl.TemplateTask)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO task_templates (user_id, template_id, name, body, created_at, updated_at)
+             VALUES (?, ?, ?, ?, ?, ?)`
+	return session.Query(query, tpl.UserID, tpl.TemplateID, tpl.Name, string(body), tpl.CreatedAt,
+		tpl.UpdatedAt).Exec()
+}
+
+func (tpl *TaskTemplate) Delete(session *gocql.Session) error {
+	query := `DELETE FROM task_templates WHERE user_id = ? AND template_id = ?`
+	return session.Query(query, tpl.UserID, tpl.TemplateID).Exec()
+}
+
+func (tpl *TaskTemplate) validate() error {
+	tpl.Name = strings.TrimSpace(tpl.Name)
+	if tpl.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTemplate)
+	}
+	if utf8.RuneCountInString(tpl.Name) > 200 {
+		return fmt.Errorf("%w: name cannot be longer than 200 characters", ErrInvalidTemplate)
+	}
+	count := 0
+	if err := tpl.TemplateTask.validate(1, &count); err != nil {
+		return err
+	}
+	tpl.setVariables()
+	return nil
+}
+
+func (tpl *TaskTemplate) setVariables() {
+	seen := make(map[string]bool)
+	tpl.TemplateTask.collectVariables(seen)
+	tpl.Variables = make([]string, 0, len(seen))
+	for name := range seen {
+		tpl.Variables = append(tpl.Variables, name)
+	}
+	sort.Strings(tpl.Variables)
+}
+
+// GetTaskTemplate returns one of the user's templates or
+// ErrTemplateNotFound.
+func GetTaskTemplate(session *gocql.Session, userID, templateID gocql.UUID) (*TaskTemplate, error) {
+	query := `SELECT user_id, template_id, name, body, created_at, updated_at FROM task_templates
+             WHERE user_id = ? AND template_id = ?`
+	templates, err := scanTaskTemplates(session.Query(query, userID, templateID).Iter())
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, ErrTemplateNotFound
+	}
+	return templates[0], nil
+}
+
+// GetTaskTemplates returns the user's templates, oldest first.
+func GetTaskTemplates(session *gocql.Session, userID gocql.UUID) ([]*TaskTemplate, error) {
+	query := `SELECT user_id, template_id, name, body, created_at, updated_at FROM task_templates WHERE user_id = ?`
+	return scanTaskTemplates(session.Query(query, userID).Iter())
+}
+
+func scanTaskTemplates(iter *gocql.Iter) ([]*TaskTemplate, error) {
+	templates := []*TaskTemplate{}
+	for {
+		tpl := &TaskTemplate{}
+		var body string
+		if !iter.Scan(&tpl.UserID, &tpl.TemplateID, &tpl.Name, &body, &tpl.CreatedAt, &tpl.UpdatedAt) {
+			break
+		}
+		if err := json.Unmarshal([]byte(body), &tpl.TemplateTask); err != nil {
+			iter.Close()
+			return nil, fmt.Errorf("failed to decode template %s: %v", tpl.TemplateID, err)
+		}
+		tpl.setVariables()
+		templates = append(templates, tpl)
+	}
+	return templates, iter.Close()
+}
+
+// NewTemplateFromTask builds an unsaved template from a task and its
+// subtasks, named after the task unless a name is given. Checklist items
+// are taken unchecked, and due dates become offsets from the day the task
+// was created.
+func NewTemplateFromTask(session *gocql.Session, task *Task, name string) (*TaskTemplate, error) {
+	if strings.TrimSpace(name) == "" {
+		name = task.Title
+	}
+	count := 0
+	spec, err := templateTaskFrom(session, task, task.CreatedAt, &count)
+	if err != nil {
+		return nil, err
+	}
+	return &TaskTemplate{Name: name, TemplateTask: *spec}, nil
+}
+
+func templateTaskFrom(session *gocql.Session, task *Task, start time.Time, count *int) (*TemplateTask, error) {
+	if *count++; *count > MaxTemplateTasks {
+		return nil, errTooManyTemplateTasks
+	}
+	spec := &TemplateTask{
+		Title:       task.Title,
+		Description: task.Description,
+		Tags:        task.Tags,
+		Priority:    task.Priority,
+	}
+	for _, item := range task.Checklist {
+		spec.Checklist = append(spec.Checklist, item.Text)
+	}
+	if task.DueAt != nil {
+		days := int(task.DueAt.Sub(start).Hours() / 24)
+		if days < 0 {
+			days = 0
+		} else if days > MaxTemplateDueDays {
+			days = MaxTemplateDueDays
+		}
+		spec.DueInDays = &days
+	}
+
+	children, err := GetChildTasks(session, task.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].CreatedAt.Before(children[j].CreatedAt) })
+	for _, child := range children {
+		sub, err := templateTaskFrom(session, child, start, count)
+		if err != nil {
+			return nil, err
+		}
+		spec.Subtasks = append(spec.Subtasks, *sub)
+	}
+	return spec, nil
+}
+
+// TemplateInstance says where a template's tasks go and what day they are
+// for, by default the user's personal workspace and today. Variables holds
+// the values of the placeholders and may override the built-in ones.
+type TemplateInstance struct {
+	WorkspaceID *gocql.UUID       `json:"workspace_id,omitempty"`
+	ProjectID   *gocql.UUID       `json:"project_id,omitempty"`
+	CategoryID  *gocql.UUID       `json:"category_id,omitempty"`
+	ParentID    *gocql.UUID       `json:"parent_id,omitempty"`
+	Start       *time.Time        `json:"start,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"`
+}
+
+// Workspace returns the workspace the tasks are created in.
+func (in *TemplateInstance) Workspace(userID gocql.UUID) gocql.UUID {
+	if in.WorkspaceID != nil {
+		return *in.WorkspaceID
+	}
+	return PersonalWorkspaceID(userID)
+}
+
+// Instantiate creates the template's tasks for the actor, each subtask
+// below the task it belongs to, and returns them in the order they were
+// created. Every variable needs a value, supplied or built in; otherwise
+// nothing is created and the error wraps ErrMissingVariables. The tasks are
+// created one at a time, so a failure part way leaves those created before
+// it.
+func (tpl *TaskTemplate) Instantiate(session *gocql.Session, in TemplateInstance, actor Actor) ([]*Task, error) {
+	workspaceID := in.Workspace(actor.UserID)
+	start := time.Now().UTC()
+	if in.Start != nil {
+		start = in.Start.UTC()
+	}
+
+	values := map[string]string{TemplateVarDate: start.Format("2006-01-02"), TemplateVarProject: ""}
+	if in.ProjectID != nil {
+		project, err := GetProject(session, workspaceID, *in.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		values[TemplateVarProject] = project.Name
+	}
+	for name, value := range in.Variables {
+		values[name] = value
+	}
+	var missing []string
+	for _, name := range tpl.Variables {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMissingVariables, strings.Join(missing, ", "))
+	}
+
+	expand := func(text string) string {
+		return templateVariable.ReplaceAllStringFunc(text, func(m string) string {
+			return values[templateVariable.FindStringSubmatch(m)[1]]
+		})
+	}
+	var created []*Task
+	var create func(spec *TemplateTask, parentID *gocql.UUID) error
+	create = func(spec *TemplateTask, parentID *gocql.UUID) error {
+		task := NewTask(actor.UserID, expand(spec.Title), expand(spec.Description), "")
+		task.WorkspaceID = workspaceID
+		task.ProjectID = in.ProjectID
+		task.CategoryID = in.CategoryID
+		task.ParentID = parentID
+		task.Tags = append([]string(nil), spec.Tags...)
+		task.Priority = spec.Priority
+		for _, item := range spec.Checklist {
+			task.Checklist = append(task.Checklist, ChecklistItem{Text: expand(item)})
+		}
+		if spec.DueInDays != nil {
+			due := start.AddDate(0, 0, *spec.DueInDays)
+			task.DueAt = &due
+		}
+		if err := task.Create(session, actor); err != nil {
+			return err
+		}
+		created = append(created, task)
+		for i := range spec.Subtasks {
+			if err := create(&spec.Subtasks[i], &task.TaskID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return created, create(&tpl.TemplateTask, in.ParentID)
+}
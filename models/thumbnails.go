@@ -0,0 +1,165 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"todo-app/storage"
+	"todo-app/thumbnail"
+
+	"github.com/gocql/gocql"
+)
+
+// ThumbnailSizes maps the names of the thumbnail sizes to the longest side of
+// the thumbnail in pixels.
+var ThumbnailSizes = map[string]int{
+	"small":  160,
+	"medium": 640,
+}
+
+var (
+	ErrNoThumbnail          = errors.New("attachment has no thumbnails")
+	ErrThumbnailPending     = errors.New("thumbnails are not generated yet")
+	ErrInvalidThumbnailSize = errors.New("invalid thumbnail size")
+)
+
+const (
+	insertThumbnailJobQuery = `INSERT INTO thumbnail_jobs (task_id, attachment_id, created_at) VALUES (?, ?, ?)`
+	deleteThumbnailJobQuery = `DELETE FROM thumbnail_jobs WHERE task_id = ? AND attachment_id = ?`
+)
+
+// HasThumbnails reports whether thumbnails are made of the attachment.
+func (a *Attachment) HasThumbnails() bool {
+	return thumbnail.Supported(a.ContentType)
+}
+
+// ThumbnailContentType returns the media type of the attachment's thumbnails.
+func (a *Attachment) ThumbnailContentType() string {
+	return thumbnail.ContentType(a.ContentType)
+}
+
+// thumbnailKey returns the key the thumbnail of the given size is stored
+// under, next to the original.
+func (a *Attachment) thumbnailKey(size string) string {
+	return a.StorageKey + "_" + size
+}
+
+// GenerateThumbnails makes the thumbnails of the attachment in all sizes and
+// stores them. It returns an error wrapping thumbnail.ErrUnsupported if the
+// attachment cannot be decoded as an image, and records it, so that the
+// thumbnails are not asked for again.
+func (a *Attachment) GenerateThumbnails(ctx context.Context, session *gocql.Session) error {
+	if !a.HasThumbnails() {
+		return ErrNoThumbnail
+	}
+	original, err := AttachmentStore.Get(ctx, a.StorageKey, 0, -1)
+	if err != nil {
+		return err
+	}
+	source, err := thumbnail.Decode(original, a.ContentType)
+	original.Close()
+	if errors.Is(err, thumbnail.ErrUnsupported) {
+		query := `UPDATE attachments SET thumbnail_error = ? WHERE task_id = ? AND attachment_id = ? IF EXISTS`
+		if _, cerr := session.Query(query, err.Error(), a.TaskID, a.AttachmentID).MapScanCAS(map[string]interface{}{}); cerr != nil {
+			return cerr
+		}
+		a.ThumbnailError = err.Error()
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	contentType := a.ThumbnailContentType()
+	sizes := make(map[string]int64, len(ThumbnailSizes))
+	for size, max := range ThumbnailSizes {
+		var buf bytes.Buffer
+		if err := thumbnail.Encode(&buf, source.Thumbnail(max), contentType); err != nil {
+			return err
+		}
+		n := int64(buf.Len())
+		if err := AttachmentStore.Put(ctx, a.thumbnailKey(size), &buf, n, contentType); err != nil {
+			return err
+		}
+		sizes[size] = n
+	}
+
+	// IF EXISTS keeps a concurrently deleted attachment from coming back.
+	query := `UPDATE attachments SET thumbnails = ? WHERE task_id = ? AND attachment_id = ? IF EXISTS`
+	applied, err := session.Query(query, sizes, a.TaskID, a.AttachmentID).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		a.deleteContents(ctx)
+		return gocql.ErrNotFound
+	}
+	a.Thumbnails = sizes
+	return nil
+}
+
+// OpenThumbnail returns the thumbnail of the given size. Thumbnails are only
+// made by ProcessThumbnailJobs: if they are missing, they are queued again
+// and ErrThumbnailPending is returned.
+func (a *Attachment) OpenThumbnail(ctx context.Context, session *gocql.Session, size string) (*storage.ReadSeeker, error) {
+	if _, ok := ThumbnailSizes[size]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidThumbnailSize, size)
+	}
+	if !a.HasThumbnails() {
+		return nil, ErrNoThumbnail
+	}
+	if a.ThumbnailError != "" {
+		return nil, fmt.Errorf("%w: %s", ErrNoThumbnail, a.ThumbnailError)
+	}
+
+	if n, ok := a.Thumbnails[size]; ok {
+		contents, err := storage.OpenReadSeeker(ctx, AttachmentStore, a.thumbnailKey(size), n)
+		if !errors.Is(err, storage.ErrNotFound) {
+			return contents, err
+		}
+	}
+	if err := session.Query(insertThumbnailJobQuery, a.TaskID, a.AttachmentID, time.Now().UTC()).Exec(); err != nil {
+		return nil, err
+	}
+	return nil, ErrThumbnailPending
+}
+
+// ProcessThumbnailJobs generates the thumbnails of the attachments uploaded
+// since the last run and returns how many were generated. A job that fails
+// for a reason other than a broken image is kept and retried on the next run.
+func ProcessThumbnailJobs(ctx context.Context, session *gocql.Session) (int, error) {
+	type job struct{ taskID, attachmentID gocql.UUID }
+	var jobs []job
+	iter := session.Query(`SELECT task_id, attachment_id FROM thumbnail_jobs`).Iter()
+	var j job
+	for iter.Scan(&j.taskID, &j.attachmentID) {
+		jobs = append(jobs, j)
+	}
+	if err := iter.Close(); err != nil {
+		return 0, err
+	}
+
+	generated := 0
+	var firstErr error
+	for _, j := range jobs {
+		a, err := GetAttachment(session, j.taskID, j.attachmentID)
+		if err == nil {
+			err = a.GenerateThumbnails(ctx, session)
+		}
+		switch {
+		case err == nil:
+			generated++
+		case err == gocql.ErrNotFound, errors.Is(err, thumbnail.ErrUnsupported):
+		default:
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to generate thumbnails of attachment %s: %w", j.attachmentID, err)
+			}
+			continue
+		}
+		if err := session.Query(deleteThumbnailJobQuery, j.taskID, j.attachmentID).Exec(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return generated, firstErr
+}
@@ -0,0 +1,428 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Kinds of items kept in the trash.
+const (
+	TrashItemTask     = "task"
+	TrashItemCategory = "category"
+)
+
+// TrashRetention is how long deleted items stay in the trash before they are
+// purged.
+var TrashRetention = 30 * 24 * time.Hour
+
+// TrashItem is a deleted task or category that can still be restored.
+// Subtasks deleted along with their parent are not listed separately.
+type TrashItem struct {
+	WorkspaceID gocql.UUID `json:"-"`
+	ItemType    string     `json:"item_type"`
+	ItemID      gocql.UUID `json:"item_id"`
+	Name        string     `json:"name"`
+	DeletedAt   time.Time  `json:"deleted_at"`
+	PurgeAt     time.Time  `json:"purge_at"`
+}
+
+// GetTrash returns the items deleted from the workspace, most recent first.
+func GetTrash(session *gocql.Session, workspaceID gocql.UUID) ([]TrashItem, error) {
+	query := `SELECT workspace_id, item_type, item_id, name, deleted_at FROM trash WHERE workspace_id = ?`
+	items, err := scanTrashItems(session.Query(query, workspaceID).Iter())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+func scanTrashItems(iter *gocql.Iter) ([]TrashItem, error) {
+	items := []TrashItem{}
+	var item TrashItem
+	for iter.Scan(&item.WorkspaceID, &item.ItemType, &item.ItemID, &item.Name, &item.DeletedAt) {
+		item.PurgeAt = item.DeletedAt.Add(TrashRetention)
+		items = append(items, item)
+		item = TrashItem{}
+	}
+	return items, iter.Close()
+}
+
+func getTrashItem(session *gocql.Session, workspaceID gocql.UUID, itemType string, itemID gocql.UUID) (*TrashItem, error) {
+	item := &TrashItem{}
+	query := `SELECT workspace_id, item_type, item_id, name, deleted_at FROM trash
+             WHERE workspace_id = ? AND item_type = ? AND item_id = ?`
+	err := session.Query(query, workspaceID, itemType, itemID).Scan(
+		&item.WorkspaceID, &item.ItemType, &item.ItemID, &item.Name, &item.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	item.PurgeAt = item.DeletedAt.Add(TrashRetention)
+	return item, nil
+}
+
+const deleteTrashItemQuery = `DELETE FROM trash WHERE workspace_id = ? AND item_type = ? AND item_id = ?`
+
+// Trash moves the task and its subtasks to the trash and cancels their
+// pending reminders. The task's Version must be the stored one, otherwise
+// ErrVersionMismatch is returned. Subtasks are trashed with writes
+// conditional on their own versions: one edited meanwhile is read again and
+// trashed as edited, and one moved out of the tree or trashed on its own
+// meanwhile is left where it is.
+func (t *Task) Trash(session *gocql.Session, actor Actor) error {
+	notTrashed := func(task *Task) bool { return task.DeletedAt == nil }
+	tree, err := taskTree(session, t, notTrashed)
+	if err != nil {
+		return err
+	}
+
+	// Cassandra stores milliseconds; truncating lets RestoreTask match the
+	// subtasks trashed together with the task.
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	before, trashed, pending, err := changeTaskTree(session, tree, notTrashed, func(task *Task) (*Task, *pendingWrite, error) {
+		after := *task
+		after.DeletedAt = &now
+		after.UpdatedAt = now
+		after.Version = task.Version + 1
+		w, err := writeTaskChange(session, task, &after, actor,
+			[]string{"deleted_at = ?", "updated_at = ?", "version = ?"}, now, now, after.Version)
+		return &after, w, err
+	})
+	if len(trashed) == 0 {
+		return err
+	}
+
+	for i, task := range trashed {
+		recordTaskEvent(session, pending[i], task)
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	for _, task := range before {
+		removeTagIndex(batch, task, task.Tags)
+		removeAssigneeIndex(batch, task, task.Assignees)
+	}
+	batch.Query(`INSERT INTO trash (workspace_id, item_type, item_id, name, deleted_at) VALUES (?, ?, ?, ?, ?)`,
+		t.WorkspaceID, TrashItemTask, t.TaskID, t.Title, now)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	t.DeletedAt = &now
+	t.Version = trashed[0].Version
+	if err := cancelReminders(session, trashed); err != nil {
+		return fmt.Errorf("failed to cancel reminders: %v", err)
+	}
+	return err
+}
+
+// RestoreTask takes the task and the subtasks deleted with it out of the
+// workspace's trash, along with the reminders the trash cancelled that have
+// not come due yet. A task whose parent is gone becomes a top-level task. It
+// returns gocql.ErrNotFound if the task is not in the workspace's trash, and
+// ErrVersionMismatch if it changes while being restored. Subtasks are
+// restored as Trash trashes them.
+func RestoreTask(session *gocql.Session, workspaceID, taskID gocql.UUID, actor Actor) (*Task, error) {
+	if _, err := getTrashItem(session, workspaceID, TrashItemTask, taskID); err != nil {
+		return nil, err
+	}
+	task, err := getTask(session, taskID)
+	if err == gocql.ErrNotFound {
+		session.Query(deleteTrashItemQuery, workspaceID, TrashItemTask, taskID).Exec()
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+	if task.DeletedAt == nil {
+		return task, session.Query(deleteTrashItemQuery, workspaceID, TrashItemTask, taskID).Exec()
+	}
+
+	deletedAt := *task.DeletedAt
+	trashedWith := func(t *Task) bool {
+		return t.DeletedAt != nil && t.DeletedAt.Equal(deletedAt)
+	}
+	tree, err := taskTree(session, task, trashedWith)
+	if err != nil {
+		return nil, err
+	}
+
+	detach := false
+	if task.ParentID != nil {
+		if _, err := GetTaskByID(session, *task.ParentID); err == gocql.ErrNotFound {
+			detach = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	// Tasks trashed before their workflow was replaced may be in a status
+	// it no longer has; they come back in its initial status.
+	workflow, err := GetWorkflow(session, task.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	before, restored, pending, err := changeTaskTree(session, tree, trashedWith, func(t *Task) (*Task, *pendingWrite, error) {
+		after := *t
+		after.DeletedAt = nil
+		after.UpdatedAt = now
+		after.Version = t.Version + 1
+		if t.TaskID == taskID && detach {
+			after.ParentID = nil
+		}
+		if _, ok := workflow.Status(t.Status); !ok {
+			after.Status = workflow.InitialStatus()
+		}
+		w, err := writeTaskChange(session, t, &after, actor,
+			[]string{"deleted_at = null", "parent_id = ?", "status = ?", "updated_at = ?", "version = ?"},
+			after.ParentID, after.Status, now, after.Version)
+		return &after, w, err
+	})
+	if len(restored) == 0 {
+		return nil, err
+	}
+
+	for i, t := range restored {
+		recordTaskEvent(session, pending[i], t)
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	for _, t := range before {
+		addTagIndex(batch, t, t.Tags)
+		addAssigneeIndex(batch, t, t.Assignees)
+	}
+	batch.Query(deleteTrashItemQuery, workspaceID, TrashItemTask, taskID)
+	if err := session.ExecuteBatch(batch); err != nil {
+		return nil, err
+	}
+	if err := resumeReminders(session, restored); err != nil {
+		return nil, fmt.Errorf("failed to resume reminders: %v", err)
+	}
+	return restored[0], err
+}
+
+// maxSubtaskAttempts bounds how often a subtask that keeps changing is read
+// again while its tree is trashed or restored.
+const maxSubtaskAttempts = 5
+
+// changeTaskTree applies change, a write made with writeTaskChange, to the
+// tasks of a tree returned by taskTree, parents before children. If the root
+// changed since it was read, nothing is changed and ErrVersionMismatch is
+// returned. A subtask changed since is read again and changed as it now is,
+// so the concurrent edit is kept; one no longer under the same parent, or
+// for which include no longer reports true, is left out along with its own
+// subtasks. It returns the tasks changed, as they were before and after,
+// and the follow-ups of their changes, together with any error that stopped
+// it part way.
+func changeTaskTree(session *gocql.Session, tree []*Task, include func(*Task) bool,
+	change func(*Task) (*Task, *pendingWrite, error)) (before, after []*Task, pending []*pendingWrite, err error) {
+	changed := make(map[gocql.UUID]bool, len(tree))
+	for i, task := range tree {
+		if i > 0 && !changed[*task.ParentID] {
+			continue
+		}
+		current := task
+		for attempt := 1; current != nil; attempt++ {
+			updated, w, err := change(current)
+			if err == nil {
+				before = append(before, current)
+				after = append(after, updated)
+				pending = append(pending, w)
+				changed[task.TaskID] = true
+				break
+			}
+			if i == 0 {
+				return nil, nil, nil, err
+			}
+			if err != ErrVersionMismatch {
+				return before, after, pending, err
+			}
+			if attempt == maxSubtaskAttempts {
+				return before, after, pending, fmt.Errorf("subtask %s keeps changing: %w", task.TaskID, err)
+			}
+			current, err = getTask(session, task.TaskID)
+			if err == gocql.ErrNotFound {
+				current = nil
+			} else if err != nil {
+				return before, after, pending, err
+			} else if current.ParentID == nil || *current.ParentID != *task.ParentID || !include(current) {
+				current = nil
+			}
+		}
+	}
+	return before, after, pending, nil
+}
+
+// taskTree returns the task followed by those of its descendants, parents
+// before children, for which include reports true. A descendant that is
+// left out is not descended into.
+func taskTree(session *gocql.Session, root *Task, include func(*Task) bool) ([]*Task, error) {
+	tree := []*Task{root}
+	for i := 0; i < len(tree); i++ {
+		children, err := getChildTasks(session, tree[i].TaskID)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if include(child) {
+				tree = append(tree, child)
+			}
+		}
+	}
+	return tree, nil
+}
+
+// Trash moves the category to the trash of its workspace. Categories have
+// no data hanging off them, so the row and its trash entry simply expire
+// through a TTL once the retention period is over. The category's Version
+// must be the stored one, otherwise ErrVersionMismatch is returned.
+func (c *Category) Trash(session *gocql.Session) error {
+	now := time.Now().UTC()
+	ttl := int(TrashRetention.Seconds())
+	version := c.Version + 1
+	clocks := stampClock(c.clocks, "deleted_at")
+	deleted := *c
+	deleted.DeletedAt = &now
+	deleted.Version = version
+
+	// TTLs cannot be set conditionally on the whole row, so the version is
+	// claimed first and the row then rewritten with a TTL.
+	query := `UPDATE categories SET version = ? WHERE category_id = ? IF version = ?`
+	if err := applyIfVersion(session.Query(query, version, c.CategoryID, c.Version)); err != nil {
+		return err
+	}
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO categories (category_id, workspace_id, name, created_at, deleted_at, version, field_clocks)
+	             VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?`,
+		c.CategoryID, c.WorkspaceID, c.Name, c.CreatedAt, now, version, clocks, ttl)
+	batch.Query(`INSERT INTO trash (workspace_id, item_type, item_id, name, deleted_at) VALUES (?, ?, ?, ?, ?) USING TTL ?`,
+		c.WorkspaceID, TrashItemCategory, c.CategoryID, c.Name, now, ttl)
+	addSyncChange(batch, c.WorkspaceID, SyncKindCategory, c.CategoryID)
+	addOutboxEvent(batch, CategoryDeleted{Category: &deleted})
+	if err := session.ExecuteBatch(batch); err != nil {
+		return err
+	}
+	c.DeletedAt = &now
+	c.Version = version
+	c.clocks = clocks
+	publishEvent(session, c.WorkspaceID, StreamCategoryDeleted, c)
+	return nil
+}
+
+// RestoreCategory takes the category out of the workspace's trash. It
+// returns gocql.ErrNotFound if the category is not in the workspace's trash.
+func RestoreCategory(session *gocql.Session, workspaceID, categoryID gocql.UUID) (*Category, error) {
+	if _, err := getTrashItem(session, workspaceID, TrashItemCategory, categoryID); err != nil {
+		return nil, err
+	}
+	category, err := getCategory(session, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	if category.WorkspaceID != workspaceID {
+		return nil, gocql.ErrNotFound
+	}
+
+	// Rewriting the row without a TTL clears the one set by Trash.
+	category.DeletedAt = nil
+	category.Version++
+	category.clocks = stampClock(category.clocks, "deleted_at")
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO categories (category_id, workspace_id, name, created_at, deleted_at, version, field_clocks)
+	             VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		category.CategoryID, category.WorkspaceID, category.Name, category.CreatedAt, nil, category.Version, category.clocks)
+	batch.Query(deleteTrashItemQuery, workspaceID, TrashItemCategory, categoryID)
+	addSyncChange(batch, workspaceID, SyncKindCategory, categoryID)
+	addOutboxEvent(batch, CategoryRestored{Category: category})
+	if err := session.ExecuteBatch(batch); err != nil {
+		return nil, err
+	}
+	publishEvent(session, workspaceID, StreamCategoryCreated, category)
+	return category, nil
+}
+
+// PurgeTrash permanently deletes the items trashed before the given time
+// and returns how many were purged.
+func PurgeTrash(session *gocql.Session, before time.Time) (int, error) {
+	items, err := scanTrashItems(session.Query(`SELECT workspace_id, item_type, item_id, name, deleted_at FROM trash`).Iter())
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, item := range items {
+		if !item.DeletedAt.Before(before) {
+			continue
+		}
+		if err := item.purge(session); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (item TrashItem) purge(session *gocql.Session) error {
+	switch item.ItemType {
+	case TrashItemTask:
+		if err := purgeTask(session, item.ItemID); err != nil {
+			return err
+		}
+	case TrashItemCategory:
+		if err := session.Query(`DELETE FROM categories WHERE category_id = ?`, item.ItemID).Exec(); err != nil {
+			return err
+		}
+	}
+	return session.Query(deleteTrashItemQuery, item.WorkspaceID, item.ItemType, item.ItemID).Exec()
+}
+
+// purgeTask deletes a trashed task and its trashed subtasks along with their
+// dependencies, reminders, attachments, comments and history. Subtasks go first, so an interrupted
+// purge is picked up again on the next run.
+func purgeTask(session *gocql.Session, taskID gocql.UUID) error {
+	task, err := getTask(session, taskID)
+	if err == gocql.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if task.DeletedAt == nil {
+		return nil
+	}
+
+	tree, err := taskTree(session, task, func(t *Task) bool { return t.DeletedAt != nil })
+	if err != nil {
+		return err
+	}
+	for i := len(tree) - 1; i >= 0; i-- {
+		t := tree[i]
+		if err := DeleteDependenciesOfTask(session, t.WorkspaceID, t.TaskID); err != nil {
+			return err
+		}
+		reminders, err := GetRemindersByTaskID(session, t.TaskID)
+		if err != nil {
+			return err
+		}
+		for _, r := range reminders {
+			if err := r.Delete(session); err != nil {
+				return err
+			}
+		}
+		if err := deleteTaskAttachments(context.Background(), session, t.TaskID); err != nil {
+			return err
+		}
+
+		batch := session.NewBatch(gocql.LoggedBatch)
+		batch.Query(`DELETE FROM task_events WHERE task_id = ?`, t.TaskID)
+		batch.Query(`DELETE FROM comments WHERE task_id = ?`, t.TaskID)
+		batch.Query(`DELETE FROM tasks WHERE task_id = ?`, t.TaskID)
+		batch.Query(deleteTrashItemQuery, t.WorkspaceID, TrashItemTask, t.TaskID)
+		if err := session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -52,15 +52,22 @@ func (u *User) Create(session *gocql.Session) error {
 	query := `INSERT INTO users (user_id, username, email, password, created_at) 
              VALUES (?, ?, ?, ?, ?)`
 
-	if err := session.Query(query,
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(query,
 		u.UserID,
 		u.Username,
 		u.Email,
 		hashedPassword,
-		u.CreatedAt).Exec(); err != nil {
+		u.CreatedAt)
+	addOutboxEvent(batch, UserRegistered{UserID: u.UserID, Username: u.Username, Email: u.Email})
+	if err := session.ExecuteBatch(batch); err != nil {
 		return fmt.Errorf("database error: %v", err)
 	}
 
+	if err := CreatePersonalWorkspace(session, u); err != nil {
+		return fmt.Errorf("failed to create personal workspace: %v", err)
+	}
+
 	return nil
 }
 
@@ -93,7 +100,7 @@ func GetUserByEmail(session *gocql.Session, email string) (*User, error) {
 
 func GetUserByID(session *gocql.Session, userID gocql.UUID) (*User, error) {
 	user := &User{}
-	query := `SELECT user_id, username, email, password, created_at FROM users WHERE user_id = ?`
+	query := `SELECT user_id, username, email, password, created_at FROM users WHERE user_id = ? ALLOW FILTERING`
 
 	err := session.Query(query, userID).Consistency(gocql.One).
 		Scan(&user.UserID, &user.Username, &user.Email, &user.Password, &user.CreatedAt)
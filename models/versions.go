@@ -0,0 +1,55 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// ErrVersionMismatch is returned when a task or category was changed by
+// someone else since the caller read it.
+var ErrVersionMismatch = errors.New("resource was modified concurrently")
+
+// applyIfVersion runs a write guarded by an IF version = ? condition and
+// returns ErrVersionMismatch when the condition did not hold.
+func applyIfVersion(query *gocql.Query) error {
+	applied, err := query.MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// MigrateToVersions gives tasks and categories written before versioning
+// version 1, so that conditional writes can match them. Trashed rows are left
+// alone: a trashed category expires through its TTL and must not gain a
+// column without one, and restoring sets the version anyway.
+func MigrateToVersions(session *gocql.Session) error {
+	for _, table := range []struct{ name, key string }{
+		{"tasks", "task_id"},
+		{"categories", "category_id"},
+	} {
+		iter := session.Query(`SELECT ` + table.key + `, version, deleted_at FROM ` + table.name).Iter()
+		var id gocql.UUID
+		var version *int
+		var deletedAt *time.Time
+		for iter.Scan(&id, &version, &deletedAt) {
+			if version != nil || deletedAt != nil {
+				continue
+			}
+			query := `UPDATE ` + table.name + ` SET version = 1 WHERE ` + table.key + ` = ? IF EXISTS`
+			if _, err := session.Query(query, id).MapScanCAS(map[string]interface{}{}); err != nil {
+				iter.Close()
+				return err
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,754 @@
+package models
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"todo-app/eventbus"
+	"todo-app/netguard"
+	"todo-app/webhook"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+)
+
+// Events webhooks can subscribe to: the changes pushed to streaming clients,
+// and tasks moving to a closed status of their workflow.
+const WebhookTaskCompleted = "task.completed"
+
+// WebhookPing is the event sent by Ping, whatever the endpoint subscribes
+// to.
+const WebhookPing = "ping"
+
+// WebhookAutomation is the event sent by the call_webhook action of an
+// automation rule, whatever the endpoint subscribes to.
+const WebhookAutomation = "automation.fired"
+
+var WebhookEvents = []string{
+	StreamTaskCreated,
+	StreamTaskUpdated,
+	WebhookTaskCompleted,
+	StreamTaskDeleted,
+	StreamCategoryCreated,
+	StreamCategoryUpdated,
+	StreamCategoryDeleted,
+}
+
+const (
+	WebhookDeliveryPending    = "pending"
+	WebhookDeliveryDelivering = "delivering"
+	WebhookDeliverySucceeded  = "succeeded"
+	WebhookDeliveryFailed     = "failed"
+)
+
+const (
+	// MaxWebhooks caps the endpoints of a workspace.
+	MaxWebhooks = 20
+
+	// MaxWebhookAttempts is how many times a delivery is tried before it is
+	// marked as failed.
+	MaxWebhookAttempts = 8
+
+	// WebhookDisableAfter is how many attempts in a row may fail before the
+	// endpoint is disabled.
+	WebhookDisableAfter = 20
+)
+
+// WebhookDeliveryRetention is how long deliveries and their attempts are
+// kept in the log.
+var WebhookDeliveryRetention = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidWebhook          = errors.New("invalid webhook")
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+)
+
+// Webhook is an endpoint receiving the events of a workspace it subscribes
+// to, category events included. Deliveries are signed with the endpoint's
+// secret, which is only returned when the endpoint is created or the secret
+// rotated. Endpoints that keep failing are disabled until they are enabled
+// again.
+type Webhook struct {
+	WorkspaceID    gocql.UUID `json:"workspace_id"`
+	WebhookID      gocql.UUID `json:"webhook_id"`
+	URL            string     `json:"url"`
+	Description    string     `json:"description,omitempty"`
+	Events         []string   `json:"events"`
+	Enabled        bool       `json:"enabled"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	Failures       int        `json:"failures"`
+	CreatedBy      gocql.UUID `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Secret         string     `json:"secret,omitempty"`
+
+	secret string
+}
+
+const webhookColumns = `workspace_id, webhook_id, url, description, events, secret, enabled, disabled_reason,
+	failures, created_by, created_at`
+
+func (h *Webhook) scanDest() []interface{} {
+	return []interface{}{
+		&h.WorkspaceID,
+		&h.WebhookID,
+		&h.URL,
+		&h.Description,
+		&h.Events,
+		&h.secret,
+		&h.Enabled,
+		&h.DisabledReason,
+		&h.Failures,
+		&h.CreatedBy,
+		&h.CreatedAt,
+	}
+}
+
+// Create saves an enabled endpoint from the actor and sets Secret.
+func (h *Webhook) Create(session *gocql.Session, actor Actor) error {
+	if err := h.validate(); err != nil {
+		return err
+	}
+	existing, err := GetWebhooks(session, h.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	if len(existing) >= MaxWebhooks {
+		return fmt.Errorf("%w: a workspace can have at most %d webhooks", ErrInvalidWebhook, MaxWebhooks)
+	}
+	if h.secret, err = newWebhookSecret(); err != nil {
+		return err
+	}
+
+	h.Secret = h.secret
+	h.WebhookID = gocql.TimeUUID()
+	h.Enabled = true
+	h.DisabledReason = ""
+	h.Failures = 0
+	h.CreatedBy = actor.UserID
+	h.CreatedAt = h.WebhookID.Time().UTC()
+	query := `INSERT INTO webhooks (` + webhookColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	return session.Query(query, h.WorkspaceID, h.WebhookID, h.URL, h.Description, h.Events, h.secret,
+		h.Enabled, h.DisabledReason, h.Failures, h.CreatedBy, h.CreatedAt).Exec()
+}
+
+func newWebhookSecret() (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + token, nil
+}
+
+// validate checks the URL and normalizes the subscribed events.
+func (h *Webhook) validate() error {
+	h.URL = strings.TrimSpace(h.URL)
+	if err := netguard.CheckURL(h.URL); err != nil {
+		return fmt.Errorf("%w: url: %v", ErrInvalidWebhook, err)
+	}
+	h.Description = strings.TrimSpace(h.Description)
+	if utf8.RuneCountInString(h.Description) > 200 {
+		return fmt.Errorf("%w: description cannot be longer than 200 characters", ErrInvalidWebhook)
+	}
+
+	if len(h.Events) == 0 {
+		return fmt.Errorf("%w: subscribe to at least one event", ErrInvalidWebhook)
+	}
+	seen := make(map[string]bool, len(h.Events))
+	events := make([]string, 0, len(h.Events))
+	for _, event := range h.Events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if !isWebhookEvent(event) {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			events = append(events, event)
+		}
+	}
+	sort.Strings(events)
+	h.Events = events
+	return nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// subscribes reports whether the endpoint receives events of the type.
+func (h *Webhook) subscribes(eventType string) bool {
+	for _, e := range h.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// SetEnabled enables or disables the endpoint. Enabling it clears its
+// failures, giving it a fresh start.
+func (h *Webhook) SetEnabled(enabled bool) {
+	if enabled && !h.Enabled {
+		h.Failures = 0
+		h.DisabledReason = ""
+	}
+	h.Enabled = enabled
+}
+
+// Update saves the endpoint's URL, description, events and whether it is
+// enabled.
+func (h *Webhook) Update(session *gocql.Session) error {
+	if err := h.validate(); err != nil {
+		return err
+	}
+	query := `UPDATE webhooks SET url = ?, description = ?, events = ?, enabled = ?, disabled_reason = ?, failures = ?
+             WHERE workspace_id = ? AND webhook_id = ?`
+	return session.Query(query, h.URL, h.Description, h.Events, h.Enabled, h.DisabledReason, h.Failures,
+		h.WorkspaceID, h.WebhookID).Exec()
+}
+
+// RotateSecret replaces the endpoint's secret and sets Secret. Deliveries
+// attempted from now on are signed with the new one.
+func (h *Webhook) RotateSecret(session *gocql.Session) error {
+	secret, err := newWebhookSecret()
+	if err != nil {
+		return err
+	}
+	query := `UPDATE webhooks SET secret = ? WHERE workspace_id = ? AND webhook_id = ?`
+	if err := session.Query(query, secret, h.WorkspaceID, h.WebhookID).Exec(); err != nil {
+		return err
+	}
+	h.secret = secret
+	h.Secret = secret
+	return nil
+}
+
+// Delete removes the endpoint. Its queued deliveries fail when they come
+// up; its delivery log expires on its own.
+func (h *Webhook) Delete(session *gocql.Session) error {
+	query := `DELETE FROM webhooks WHERE workspace_id = ? AND webhook_id = ?`
+	return session.Query(query, h.WorkspaceID, h.WebhookID).Exec()
+}
+
+// RecordSuccess clears the endpoint's failures after a successful attempt.
+func (h *Webhook) RecordSuccess(session *gocql.Session) error {
+	if h.Failures == 0 {
+		return nil
+	}
+	h.Failures = 0
+	query := `UPDATE webhooks SET failures = 0 WHERE workspace_id = ? AND webhook_id = ?`
+	return session.Query(query, h.WorkspaceID, h.WebhookID).Exec()
+}
+
+// RecordFailure counts a failed attempt against the endpoint and disables it
+// once WebhookDisableAfter attempts in a row have failed. Concurrent
+// attempts may undercount, which only delays disabling a little.
+func (h *Webhook) RecordFailure(session *gocql.Session, cause error) error {
+	if !h.countFailure(cause) {
+		query := `UPDATE webhooks SET failures = ? WHERE workspace_id = ? AND webhook_id = ?`
+		return session.Query(query, h.Failures, h.WorkspaceID, h.WebhookID).Exec()
+	}
+	query := `UPDATE webhooks SET failures = ?, enabled = ?, disabled_reason = ? WHERE workspace_id = ? AND webhook_id = ?`
+	return session.Query(query, h.Failures, h.Enabled, h.DisabledReason, h.WorkspaceID, h.WebhookID).Exec()
+}
+
+// countFailure counts a failed attempt and reports whether it disabled the
+// endpoint.
+func (h *Webhook) countFailure(cause error) bool {
+	h.Failures++
+	if h.Failures < WebhookDisableAfter {
+		return false
+	}
+	h.Enabled = false
+	h.DisabledReason = fmt.Sprintf("Disabled after %d failed attempts in a row, the last one: %v", h.Failures, cause)
+	return true
+}
+
+// GetWebhook returns an endpoint of the workspace or ErrWebhookNotFound.
+func GetWebhook(session *gocql.Session, workspaceID, webhookID gocql.UUID) (*Webhook, error) {
+	h := &Webhook{}
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE workspace_id = ? AND webhook_id = ?`
+	err := session.Query(query, workspaceID, webhookID).Scan(h.scanDest()...)
+	if err == gocql.ErrNotFound {
+		return nil, ErrWebhookNotFound
+	}
+	return h, err
+}
+
+// GetWebhooks returns the workspace's endpoints, oldest first.
+func GetWebhooks(session *gocql.Session, workspaceID gocql.UUID) ([]*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE workspace_id = ?`
+	return scanWebhooks(session.Query(query, workspaceID).Iter())
+}
+
+func scanWebhooks(iter *gocql.Iter) ([]*Webhook, error) {
+	hooks := []*Webhook{}
+	for {
+		h := &Webhook{}
+		if !iter.Scan(h.scanDest()...) {
+			break
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, iter.Close()
+}
+
+// WebhookEvent is the body of a delivery. Its ID is that of the domain event
+// it was queued for, shared by the deliveries to different endpoints and by
+// redeliveries, so receivers can discard duplicates. A task moving to a
+// closed status is delivered as task.updated and task.completed under the
+// same ID; duplicates are told apart by ID and type.
+type WebhookEvent struct {
+	ID          gocql.UUID      `json:"id"`
+	Type        string          `json:"type"`
+	WorkspaceID gocql.UUID      `json:"workspace_id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// WebhookSubscriber is the name the webhooks are subscribed to the event bus
+// under.
+const WebhookSubscriber = "webhooks"
+
+// SubscribeWebhooks subscribes the webhooks to the domain events of tasks
+// and categories. Restored items are delivered as created, like to streaming
+// clients.
+func SubscribeWebhooks(bus *eventbus.Bus, session *gocql.Session) {
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskCreated) error {
+		return queueWebhookEvent(session, meta, e.Task.WorkspaceID, StreamTaskCreated, e.Task)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskUpdated) error {
+		return queueWebhookEvent(session, meta, e.Task.WorkspaceID, StreamTaskUpdated, e.Task)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskStatusChanged) error {
+		if err := queueWebhookEvent(session, meta, e.Task.WorkspaceID, StreamTaskUpdated, e.Task); err != nil {
+			return err
+		}
+		workflow, err := GetWorkflow(session, e.Task.WorkspaceID)
+		if err != nil {
+			return err
+		}
+		if !workflow.IsClosed(e.To) || workflow.IsClosed(e.From) {
+			return nil
+		}
+		return queueWebhookEvent(session, meta, e.Task.WorkspaceID, WebhookTaskCompleted, e.Task)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskDeleted) error {
+		return queueWebhookEvent(session, meta, e.Task.WorkspaceID, StreamTaskDeleted, e.Task)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e TaskRestored) error {
+		return queueWebhookEvent(session, meta, e.Task.WorkspaceID, StreamTaskCreated, e.Task)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e CategoryCreated) error {
+		return queueWebhookEvent(session, meta, e.Category.WorkspaceID, StreamCategoryCreated, e.Category)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e CategoryUpdated) error {
+		return queueWebhookEvent(session, meta, e.Category.WorkspaceID, StreamCategoryUpdated, e.Category)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e CategoryDeleted) error {
+		return queueWebhookEvent(session, meta, e.Category.WorkspaceID, StreamCategoryDeleted, e.Category)
+	})
+	eventbus.Subscribe(bus, WebhookSubscriber, func(ctx context.Context, meta eventbus.Meta, e CategoryRestored) error {
+		return queueWebhookEvent(session, meta, e.Category.WorkspaceID, StreamCategoryCreated, e.Category)
+	})
+}
+
+// queueWebhookEvent queues a delivery of the domain event, as an event of
+// the given type, to every enabled endpoint of the workspace subscribed to
+// it. An endpoint that cannot be queued for does not hold up the others.
+// Queueing is idempotent, so an event handed over again after a failure
+// only reaches the endpoints it missed.
+func queueWebhookEvent(session *gocql.Session, meta eventbus.Meta, workspaceID gocql.UUID, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	hooks, err := GetWebhooks(session, workspaceID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, h := range hooks {
+		if !h.Enabled || !h.subscribes(eventType) {
+			continue
+		}
+		if _, err := h.queue(session, meta.ID, eventType, payload); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", h.WebhookID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ping queues a ping event for the endpoint, to check that it is reachable
+// and verifies signatures.
+func (h *Webhook) Ping(session *gocql.Session) (*WebhookDelivery, error) {
+	data, err := json.Marshal(map[string]interface{}{"webhook_id": h.WebhookID, "events": h.Events})
+	if err != nil {
+		return nil, err
+	}
+	return h.queue(session, gocql.TimeUUID(), WebhookPing, data)
+}
+
+// queue queues a delivery of the event to the endpoint, unless one was
+// queued already.
+func (h *Webhook) queue(session *gocql.Session, eventID gocql.UUID, eventType string, data json.RawMessage) (*WebhookDelivery, error) {
+	payload, err := json.Marshal(WebhookEvent{
+		ID:          eventID,
+		Type:        eventType,
+		WorkspaceID: h.WorkspaceID,
+		CreatedAt:   eventID.Time().UTC(),
+		Data:        data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d := &WebhookDelivery{
+		WebhookID:   h.WebhookID,
+		DeliveryID:  webhookDeliveryID(h.WebhookID, eventID, eventType),
+		WorkspaceID: h.WorkspaceID,
+		EventID:     eventID,
+		EventType:   eventType,
+		Payload:     payload,
+	}
+	return d, d.insertOnce(session)
+}
+
+// webhookDeliveryID returns the ID of the delivery of an event to an
+// endpoint: a time UUID of the event's time, with the remaining bits derived
+// from the endpoint, event and type, so that the event queued again gets
+// the same ID.
+func webhookDeliveryID(webhookID, eventID gocql.UUID, eventType string) gocql.UUID {
+	hash := sha1.New()
+	hash.Write(webhookID[:])
+	hash.Write(eventID[:])
+	io.WriteString(hash, eventType)
+	id := eventID
+	copy(id[8:], hash.Sum(nil))
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return id
+}
+
+// WebhookDelivery is an event queued for, or sent to, an endpoint.
+type WebhookDelivery struct {
+	WebhookID     gocql.UUID       `json:"webhook_id"`
+	DeliveryID    gocql.UUID       `json:"delivery_id"`
+	WorkspaceID   gocql.UUID       `json:"workspace_id"`
+	EventID       gocql.UUID       `json:"event_id"`
+	EventType     string           `json:"event_type"`
+	Payload       json.RawMessage  `json:"payload"`
+	State         string           `json:"state"`
+	Attempts      int              `json:"attempts"`
+	NextAttemptAt time.Time        `json:"next_attempt_at"`
+	LastStatus    int              `json:"last_status,omitempty"`
+	LastError     string           `json:"last_error,omitempty"`
+	LeaseOwner    string           `json:"-"`
+	LeaseUntil    time.Time        `json:"-"`
+	RedeliveryOf  *gocql.UUID      `json:"redelivery_of,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeliveredAt   *time.Time       `json:"delivered_at,omitempty"`
+	AttemptLog    []WebhookAttempt `json:"attempt_log,omitempty"`
+}
+
+// WebhookAttempt is an entry of a delivery's log. StatusCode is zero if the
+// endpoint could not be reached.
+type WebhookAttempt struct {
+	Attempt     int       `json:"attempt"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Response    string    `json:"response,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+}
+
+// PendingWebhookDelivery is an entry of the dispatcher's queue.
+type PendingWebhookDelivery struct {
+	Bucket     string
+	AttemptAt  time.Time
+	WebhookID  gocql.UUID
+	DeliveryID gocql.UUID
+}
+
+const webhookDeliveryColumns = `webhook_id, delivery_id, workspace_id, event_id, event_type, payload, state, attempts,
+	next_attempt_at, last_status, last_error, lease_owner, lease_until, redelivery_of, created_at, delivered_at`
+
+func (d *WebhookDelivery) scanDest() []interface{} {
+	return []interface{}{
+		&d.WebhookID,
+		&d.DeliveryID,
+		&d.WorkspaceID,
+		&d.EventID,
+		&d.EventType,
+		&d.Payload,
+		&d.State,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.LastStatus,
+		&d.LastError,
+		&d.LeaseOwner,
+		&d.LeaseUntil,
+		&d.RedeliveryOf,
+		&d.CreatedAt,
+		&d.DeliveredAt,
+	}
+}
+
+// WebhookBucket returns the pending_webhook_deliveries partition for an
+// attempt time.
+func WebhookBucket(t time.Time) string {
+	return ReminderBucket(t)
+}
+
+// insert logs the delivery under a new ID and queues it for an attempt
+// right away.
+func (d *WebhookDelivery) insert(session *gocql.Session) error {
+	d.DeliveryID = gocql.TimeUUID()
+	d.CreatedAt = d.DeliveryID.Time().UTC()
+	d.NextAttemptAt = d.CreatedAt
+	d.State = WebhookDeliveryPending
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO webhook_deliveries (webhook_id, delivery_id, workspace_id, event_id, event_type, payload,
+             state, attempts, next_attempt_at, redelivery_of, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+             USING TTL ?`,
+		d.WebhookID, d.DeliveryID, d.WorkspaceID, d.EventID, d.EventType, string(d.Payload),
+		d.State, d.Attempts, d.NextAttemptAt, d.RedeliveryOf, d.CreatedAt, d.ttl())
+	batch.Query(`INSERT INTO pending_webhook_deliveries (bucket, attempt_at, webhook_id, delivery_id) VALUES (?, ?, ?, ?)`,
+		WebhookBucket(d.NextAttemptAt), d.NextAttemptAt, d.WebhookID, d.DeliveryID)
+	return session.ExecuteBatch(batch)
+}
+
+// insertOnce logs the delivery under its DeliveryID, unless a delivery with
+// that ID is logged already, and queues it for an attempt right away. The
+// queue entry is written either way: the dispatcher drops the entries of
+// deliveries that have moved on.
+func (d *WebhookDelivery) insertOnce(session *gocql.Session) error {
+	d.CreatedAt = d.DeliveryID.Time().UTC()
+	d.NextAttemptAt = d.CreatedAt
+	d.State = WebhookDeliveryPending
+
+	query := `INSERT INTO webhook_deliveries (webhook_id, delivery_id, workspace_id, event_id, event_type, payload,
+             state, attempts, next_attempt_at, redelivery_of, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+             IF NOT EXISTS USING TTL ?`
+	_, err := session.Query(query, d.WebhookID, d.DeliveryID, d.WorkspaceID, d.EventID, d.EventType, string(d.Payload),
+		d.State, d.Attempts, d.NextAttemptAt, d.RedeliveryOf, d.CreatedAt, d.ttl()).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	query = `INSERT INTO pending_webhook_deliveries (bucket, attempt_at, webhook_id, delivery_id) VALUES (?, ?, ?, ?)`
+	return session.Query(query, WebhookBucket(d.NextAttemptAt), d.NextAttemptAt, d.WebhookID, d.DeliveryID).Exec()
+}
+
+// ttl returns the seconds left until the delivery leaves the log. Every
+// write to the delivery uses it, so that its columns expire together.
+func (d *WebhookDelivery) ttl() int {
+	ttl := int(time.Until(d.CreatedAt.Add(WebhookDeliveryRetention)).Seconds())
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
+}
+
+// Redeliver queues the delivery's event for the endpoint again, with the
+// same body, as a new delivery.
+func (d *WebhookDelivery) Redeliver(session *gocql.Session) (*WebhookDelivery, error) {
+	original := d.DeliveryID
+	redelivery := &WebhookDelivery{
+		WebhookID:    d.WebhookID,
+		WorkspaceID:  d.WorkspaceID,
+		EventID:      d.EventID,
+		EventType:    d.EventType,
+		Payload:      d.Payload,
+		RedeliveryOf: &original,
+	}
+	return redelivery, redelivery.insert(session)
+}
+
+// Request returns the signed request making an attempt at the delivery to
+// the endpoint.
+func (d *WebhookDelivery) Request(h *Webhook) webhook.Delivery {
+	return webhook.Delivery{
+		URL:        h.URL,
+		Secret:     h.secret,
+		EventType:  d.EventType,
+		DeliveryID: d.DeliveryID.String(),
+		Body:       d.Payload,
+	}
+}
+
+// GetWebhookDelivery returns a delivery to the endpoint with its attempts,
+// or ErrWebhookDeliveryNotFound.
+func GetWebhookDelivery(session *gocql.Session, webhookID, deliveryID gocql.UUID) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = ? AND delivery_id = ?`
+	err := session.Query(query, webhookID, deliveryID).Scan(d.scanDest()...)
+	if err == gocql.ErrNotFound {
+		return nil, ErrWebhookDeliveryNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	d.AttemptLog = []WebhookAttempt{}
+	iter := session.Query(`SELECT attempt, attempted_at, status_code, error, response, duration_ms
+             FROM webhook_attempts WHERE delivery_id = ?`, deliveryID).Iter()
+	var a WebhookAttempt
+	for iter.Scan(&a.Attempt, &a.AttemptedAt, &a.StatusCode, &a.Error, &a.Response, &a.DurationMs) {
+		d.AttemptLog = append(d.AttemptLog, a)
+		a = WebhookAttempt{}
+	}
+	return d, iter.Close()
+}
+
+// GetWebhookDeliveries returns up to limit of the endpoint's most recent
+// deliveries, without their attempts.
+func GetWebhookDeliveries(session *gocql.Session, webhookID gocql.UUID, limit int) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = ? LIMIT ?`
+	iter := session.Query(query, webhookID, limit).Iter()
+	for {
+		d := &WebhookDelivery{}
+		if !iter.Scan(d.scanDest()...) {
+			break
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, iter.Close()
+}
+
+// GetDueWebhookDeliveries returns the queue entries in the bucket that are
+// due by now.
+func GetDueWebhookDeliveries(session *gocql.Session, bucket string, now time.Time) ([]PendingWebhookDelivery, error) {
+	var pending []PendingWebhookDelivery
+	query := `SELECT bucket, attempt_at, webhook_id, delivery_id FROM pending_webhook_deliveries
+             WHERE bucket = ? AND attempt_at <= ?`
+	iter := session.Query(query, bucket, now).Iter()
+	var p PendingWebhookDelivery
+	for iter.Scan(&p.Bucket, &p.AttemptAt, &p.WebhookID, &p.DeliveryID) {
+		pending = append(pending, p)
+	}
+	return pending, iter.Close()
+}
+
+func DeletePendingWebhookDelivery(session *gocql.Session, p PendingWebhookDelivery) error {
+	query := `DELETE FROM pending_webhook_deliveries WHERE bucket = ? AND attempt_at = ? AND webhook_id = ? AND delivery_id = ?`
+	return session.Query(query, p.Bucket, p.AttemptAt, p.WebhookID, p.DeliveryID).Exec()
+}
+
+// Claim takes a lease on a pending delivery with a lightweight transaction,
+// so that only one instance attempts it. It returns false if another
+// instance got there first.
+func (d *WebhookDelivery) Claim(session *gocql.Session, owner string, lease time.Duration) (bool, error) {
+	leaseUntil := time.Now().Add(lease).UTC()
+	query := `UPDATE webhook_deliveries USING TTL ? SET state = ?, lease_owner = ?, lease_until = ?
+             WHERE webhook_id = ? AND delivery_id = ? IF state = ? AND next_attempt_at = ?`
+	applied, err := session.Query(query, d.ttl(), WebhookDeliveryDelivering, owner, leaseUntil,
+		d.WebhookID, d.DeliveryID, WebhookDeliveryPending, d.NextAttemptAt).MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return false, err
+	}
+
+	d.State = WebhookDeliveryDelivering
+	d.LeaseOwner = owner
+	d.LeaseUntil = leaseUntil
+	err = DeletePendingWebhookDelivery(session, PendingWebhookDelivery{
+		Bucket:     WebhookBucket(d.NextAttemptAt),
+		AttemptAt:  d.NextAttemptAt,
+		WebhookID:  d.WebhookID,
+		DeliveryID: d.DeliveryID,
+	})
+	return true, err
+}
+
+// MarkDelivered records a successful attempt.
+func (d *WebhookDelivery) MarkDelivered(session *gocql.Session, a WebhookAttempt) error {
+	now := time.Now().UTC()
+	d.State = WebhookDeliverySucceeded
+	d.DeliveredAt = &now
+	d.LastError = ""
+	query := `UPDATE webhook_deliveries USING TTL ? SET state = ?, attempts = ?, last_status = ?, last_error = ?,
+             delivered_at = ? WHERE webhook_id = ? AND delivery_id = ? IF lease_owner = ?`
+	return d.finish(session, a, query, d.State, a.Attempt, a.StatusCode, d.LastError, d.DeliveredAt)
+}
+
+// MarkAttemptFailed records a failed attempt and either schedules a retry
+// or, once MaxWebhookAttempts is reached, gives up.
+func (d *WebhookDelivery) MarkAttemptFailed(session *gocql.Session, a WebhookAttempt) error {
+	if !d.scheduleRetry(a, time.Now()) {
+		return d.MarkFailed(session, a)
+	}
+	query := `UPDATE webhook_deliveries USING TTL ? SET state = ?, attempts = ?, last_status = ?, last_error = ?,
+             next_attempt_at = ?, lease_owner = null WHERE webhook_id = ? AND delivery_id = ? IF lease_owner = ?`
+	if err := d.finish(session, a, query, d.State, a.Attempt, a.StatusCode, a.Error, d.NextAttemptAt); err != nil {
+		return err
+	}
+	query = `INSERT INTO pending_webhook_deliveries (bucket, attempt_at, webhook_id, delivery_id) VALUES (?, ?, ?, ?)`
+	return session.Query(query, WebhookBucket(d.NextAttemptAt), d.NextAttemptAt, d.WebhookID, d.DeliveryID).Exec()
+}
+
+// scheduleRetry moves the delivery back to pending after a failed attempt,
+// due after WebhookRetryDelay. It returns false, changing nothing, once
+// MaxWebhookAttempts is reached.
+func (d *WebhookDelivery) scheduleRetry(a WebhookAttempt, now time.Time) bool {
+	if a.Attempt >= MaxWebhookAttempts {
+		return false
+	}
+	d.State = WebhookDeliveryPending
+	d.NextAttemptAt = now.Add(WebhookRetryDelay(a.Attempt)).UTC()
+	return true
+}
+
+// WebhookRetryDelay is how long a delivery waits for its next attempt after
+// the given attempt failed: a minute after the first, doubling each time.
+func WebhookRetryDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return time.Minute << uint(attempt-1)
+}
+
+// MarkFailed gives up on the delivery without further retries. The attempt
+// is only logged if one was made.
+func (d *WebhookDelivery) MarkFailed(session *gocql.Session, a WebhookAttempt) error {
+	if a.Attempt == 0 {
+		a.Attempt = d.Attempts
+		a.StatusCode = d.LastStatus
+	}
+	d.State = WebhookDeliveryFailed
+	query := `UPDATE webhook_deliveries USING TTL ? SET state = ?, attempts = ?, last_status = ?, last_error = ?
+             WHERE webhook_id = ? AND delivery_id = ? IF lease_owner = ?`
+	return d.finish(session, a, query, d.State, a.Attempt, a.StatusCode, a.Error)
+}
+
+// finish applies an update of the delivery under its lease and logs the
+// attempt.
+func (d *WebhookDelivery) finish(session *gocql.Session, a WebhookAttempt, query string, values ...interface{}) error {
+	values = append([]interface{}{d.ttl()}, values...)
+	values = append(values, d.WebhookID, d.DeliveryID, d.LeaseOwner)
+	applied, err := session.Query(query, values...).MapScanCAS(map[string]interface{}{})
+	if err != nil || !applied {
+		return err
+	}
+	// Deliveries given up on before their next attempt keep their count.
+	made := a.Attempt > d.Attempts
+	d.Attempts = a.Attempt
+	d.LastStatus = a.StatusCode
+	d.LastError = a.Error
+	d.LeaseOwner = ""
+	if !made {
+		return nil
+	}
+	return session.Query(`INSERT INTO webhook_attempts (delivery_id, attempt, attempted_at, status_code, error, response,
+             duration_ms) VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?`,
+		d.DeliveryID, a.Attempt, a.AttemptedAt, a.StatusCode, a.Error, a.Response, a.DurationMs, d.ttl()).Exec()
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"todo-app/netguard"
+	"todo-app/webhook"
+
+	"github.com/gocql/gocql"
+)
+
+func TestWebhookValidateURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		allowHTTP bool
+		ok        bool
+	}{
+		{" https://example.com/hook ", false, true},
+		{"http://example.com/hook", false, false},
+		{"http://example.com/hook", true, true},
+		{"ftp://example.com/hook", true, false},
+		{"https://127.0.0.1/hook", false, false},
+		{"https://169.254.169.254/latest/meta-data", false, false},
+		{"https://localhost/hook", false, false},
+		{"example.com/hook", false, false},
+	}
+	defer func(allowHTTP bool) { netguard.AllowHTTP = allowHTTP }(netguard.AllowHTTP)
+	for _, tt := range tests {
+		netguard.AllowHTTP = tt.allowHTTP
+		h := &Webhook{URL: tt.url, Events: []string{WebhookTaskCompleted}}
+		err := h.validate()
+		if (err == nil) != tt.ok {
+			t.Errorf("validate(%q) with AllowHTTP=%v = %v, want ok=%v", tt.url, tt.allowHTTP, err, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("validate(%q) = %v, want ErrInvalidWebhook", tt.url, err)
+		}
+	}
+}
+
+func TestWebhookRetryDelay(t *testing.T) {
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute}
+	for i, delay := range want {
+		if got := WebhookRetryDelay(i + 1); got != delay {
+			t.Errorf("WebhookRetryDelay(%d) = %s, want %s", i+1, got, delay)
+		}
+	}
+}
+
+// TestWebhookFailingEndpoint makes attempts at deliveries to an endpoint
+// that keeps failing, the way the dispatcher does, until the endpoint is
+// disabled.
+func TestWebhookFailingEndpoint(t *testing.T) {
+	var mu sync.Mutex
+	var verified []error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		verified = append(verified, webhook.Verify("whsec_test", r.Header, body, webhook.DefaultTolerance))
+		mu.Unlock()
+		http.Error(w, "database is down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	defer func(allow bool) { netguard.AllowPrivate = allow }(netguard.AllowPrivate)
+	netguard.AllowPrivate = true
+	sender := webhook.NewSender(nil)
+
+	hook := &Webhook{URL: server.URL, Enabled: true, secret: "whsec_test"}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	attempts := 0
+	for hook.Enabled {
+		d := &WebhookDelivery{
+			DeliveryID:    gocql.TimeUUID(),
+			EventType:     WebhookTaskCompleted,
+			Payload:       []byte(`{"type":"task.completed"}`),
+			State:         WebhookDeliveryPending,
+			NextAttemptAt: now,
+		}
+		for hook.Enabled {
+			a := WebhookAttempt{Attempt: d.Attempts + 1}
+			result, err := sender.Send(context.Background(), d.Request(hook))
+			attempts++
+			if err == nil || result.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("attempt %d: status %d, err %v", a.Attempt, result.StatusCode, err)
+			}
+			hook.countFailure(err)
+
+			due := d.NextAttemptAt
+			if !d.scheduleRetry(a, due) {
+				if a.Attempt != MaxWebhookAttempts {
+					t.Fatalf("gave up after %d attempts, want %d", a.Attempt, MaxWebhookAttempts)
+				}
+				break
+			}
+			if d.State != WebhookDeliveryPending {
+				t.Fatalf("attempt %d: state %s, want pending", a.Attempt, d.State)
+			}
+			if got := d.NextAttemptAt.Sub(due); got != WebhookRetryDelay(a.Attempt) {
+				t.Fatalf("attempt %d: retried after %s, want %s", a.Attempt, got, WebhookRetryDelay(a.Attempt))
+			}
+			d.Attempts = a.Attempt
+		}
+	}
+
+	if attempts != WebhookDisableAfter {
+		t.Errorf("disabled after %d attempts, want %d", attempts, WebhookDisableAfter)
+	}
+	if !strings.Contains(hook.DisabledReason, "status 503") {
+		t.Errorf("disabled reason %q does not name the last failure", hook.DisabledReason)
+	}
+	mu.Lock()
+	for i, err := range verified {
+		if err != nil {
+			t.Errorf("attempt %d did not verify: %v", i+1, err)
+		}
+	}
+	mu.Unlock()
+
+	hook.SetEnabled(true)
+	if !hook.Enabled || hook.Failures != 0 || hook.DisabledReason != "" {
+		t.Errorf("re-enabled endpoint = %+v, want a fresh start", hook)
+	}
+}
+
+func TestWebhookDeliveryID(t *testing.T) {
+	webhookID, eventID := gocql.TimeUUID(), gocql.TimeUUID()
+	id := webhookDeliveryID(webhookID, eventID, StreamTaskUpdated)
+	if again := webhookDeliveryID(webhookID, eventID, StreamTaskUpdated); again != id {
+		t.Errorf("queueing the event again gave %s, want %s", again, id)
+	}
+	if id.Version() != 1 || id.Variant() != gocql.VariantIETF || !id.Time().Equal(eventID.Time()) {
+		t.Errorf("%s is not a time UUID of the event's time", id)
+	}
+	others := []gocql.UUID{
+		webhookDeliveryID(webhookID, eventID, WebhookTaskCompleted),
+		webhookDeliveryID(gocql.TimeUUID(), eventID, StreamTaskUpdated),
+		webhookDeliveryID(webhookID, gocql.TimeUUID(), StreamTaskUpdated),
+	}
+	for _, other := range others {
+		if other == id {
+			t.Errorf("another delivery got the same ID %s", id)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Every workflow status belongs to one of these categories. Closed statuses
+// count as done for progress, blockers, recurrence and auto-completion.
+const (
+	CategoryOpen   = "open"
+	CategoryActive = "active"
+	CategoryClosed = "closed"
+)
+
+var (
+	ErrInvalidStatus        = errors.New("invalid status")
+	ErrTransitionNotAllowed = errors.New("status transition not allowed")
+	ErrInvalidWorkflow      = errors.New("invalid workflow")
+)
+
+var (
+	statusKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+	colorPattern     = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+type WorkflowStatus struct {
+	Key      string `json:"key" cql:"key"`
+	Name     string `json:"name" cql:"name"`
+	Position int    `json:"position" cql:"position"`
+	Color    string `json:"color" cql:"color"`
+	Category string `json:"category" cql:"category"`
+}
+
+// Workflow is a workspace's set of task statuses. Transitions maps a status to
+// the statuses it may move to; a status without an entry may move anywhere.
+type Workflow struct {
+	WorkspaceID gocql.UUID          `json:"workspace_id"`
+	Statuses    []WorkflowStatus    `json:"statuses"`
+	Transitions map[string][]string `json:"transitions"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+}
+
+// DefaultWorkflow is the workflow of workspaces that have not defined their
+// own.
+func DefaultWorkflow(workspaceID gocql.UUID) *Workflow {
+	return &Workflow{
+		WorkspaceID: workspaceID,
+		Statuses: []WorkflowStatus{
+			{Key: StatusPending, Name: "To Do", Position: 0, Color: "#6c757d", Category: CategoryOpen},
+			{Key: StatusInProgress, Name: "In Progress", Position: 1, Color: "#0d6efd", Category: CategoryActive},
+			{Key: StatusCompleted, Name: "Done", Position: 2, Color: "#198754", Category: CategoryClosed},
+		},
+		Transitions: map[string][]string{},
+	}
+}
+
+func GetWorkflow(session *gocql.Session, workspaceID gocql.UUID) (*Workflow, error) {
+	w := &Workflow{}
+	query := `SELECT workspace_id, statuses, transitions, updated_at FROM workflows WHERE workspace_id = ?`
+	err := session.Query(query, workspaceID).Scan(&w.WorkspaceID, &w.Statuses, &w.Transitions, &w.UpdatedAt)
+	if err == gocql.ErrNotFound {
+		return DefaultWorkflow(workspaceID), nil
+	} else if err != nil {
+		return nil, err
+	}
+	if w.Transitions == nil {
+		w.Transitions = map[string][]string{}
+	}
+	w.sortStatuses()
+	return w, nil
+}
+
+func (w *Workflow) Save(session *gocql.Session) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	w.UpdatedAt = time.Now().UTC()
+	query := `INSERT INTO workflows (workspace_id, statuses, transitions, updated_at) VALUES (?, ?, ?, ?)`
+	return session.Query(query, w.WorkspaceID, w.Statuses, w.Transitions, w.UpdatedAt).Exec()
+}
+
+// Validate checks the workflow and normalizes status keys to lower case.
+func (w *Workflow) Validate() error {
+	if len(w.Statuses) == 0 {
+		return fmt.Errorf("%w: at least one status is required", ErrInvalidWorkflow)
+	}
+
+	seen := make(map[string]bool)
+	categories := make(map[string]bool)
+	for i := range w.Statuses {
+		s := &w.Statuses[i]
+		s.Key = strings.ToLower(strings.TrimSpace(s.Key))
+		if !statusKeyPattern.MatchString(s.Key) {
+			return fmt.Errorf("%w: status key %q must be lower case letters, digits or underscores", ErrInvalidWorkflow, s.Key)
+		}
+		if seen[s.Key] {
+			return fmt.Errorf("%w: duplicate status %q", ErrInvalidWorkflow, s.Key)
+		}
+		seen[s.Key] = true
+		if strings.TrimSpace(s.Name) == "" {
+			s.Name = s.Key
+		}
+		if s.Color != "" && !colorPattern.MatchString(s.Color) {
+			return fmt.Errorf("%w: color of %q must look like #rrggbb", ErrInvalidWorkflow, s.Key)
+		}
+		switch s.Category {
+		case CategoryOpen, CategoryActive, CategoryClosed:
+			categories[s.Category] = true
+		default:
+			return fmt.Errorf("%w: category of %q must be open, active or closed", ErrInvalidWorkflow, s.Key)
+		}
+	}
+	if !categories[CategoryOpen] || !categories[CategoryClosed] {
+		return fmt.Errorf("%w: at least one open and one closed status are required", ErrInvalidWorkflow)
+	}
+
+	transitions := make(map[string][]string, len(w.Transitions))
+	for from, targets := range w.Transitions {
+		from = strings.ToLower(from)
+		if !seen[from] {
+			return fmt.Errorf("%w: transition from unknown status %q", ErrInvalidWorkflow, from)
+		}
+		for i, to := range targets {
+			targets[i] = strings.ToLower(to)
+			if !seen[targets[i]] {
+				return fmt.Errorf("%w: transition to unknown status %q", ErrInvalidWorkflow, to)
+			}
+		}
+		transitions[from] = targets
+	}
+	w.Transitions = transitions
+
+	w.sortStatuses()
+	return nil
+}
+
+func (w *Workflow) sortStatuses() {
+	sort.SliceStable(w.Statuses, func(i, j int) bool {
+		return w.Statuses[i].Position < w.Statuses[j].Position
+	})
+}
+
+func (w *Workflow) Status(key string) (*WorkflowStatus, bool) {
+	for i := range w.Statuses {
+		if w.Statuses[i].Key == key {
+			return &w.Statuses[i], true
+		}
+	}
+	return nil, false
+}
+
+// IsClosed reports whether the status is in the closed category.
+func (w *Workflow) IsClosed(key string) bool {
+	s, ok := w.Status(key)
+	return ok && s.Category == CategoryClosed
+}
+
+// InitialStatus is the first open status, used for new tasks.
+func (w *Workflow) InitialStatus() string {
+	return w.firstIn(CategoryOpen)
+}
+
+// ClosedStatus is the first closed status, used when tasks are completed
+// automatically.
+func (w *Workflow) ClosedStatus() string {
+	return w.firstIn(CategoryClosed)
+}
+
+func (w *Workflow) firstIn(category string) string {
+	for _, s := range w.Statuses {
+		if s.Category == category {
+			return s.Key
+		}
+	}
+	return ""
+}
+
+// CanTransition reports whether a task may move from one status to another.
+func (w *Workflow) CanTransition(from, to string) bool {
+	if from == to {
+		return true
+	}
+	targets, restricted := w.Transitions[from]
+	if !restricted {
+		return true
+	}
+	for _, target := range targets {
+		if target == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceWorkflow saves a new workflow for the workspace. Tasks in statuses the new
+// workflow drops, including those in the trash, are moved according to
+// mapping, which must cover each of them.
+func ReplaceWorkflow(session *gocql.Session, w *Workflow, mapping map[string]string, actor Actor) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+
+	tasks, err := getWorkspaceTasks(session, w.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	moves := make(map[*Task]string)
+	for _, task := range tasks {
+		if _, ok := w.Status(task.Status); ok {
+			continue
+		}
+		target, ok := mapping[task.Status]
+		if !ok {
+			return fmt.Errorf("%w: tasks still use status %q; map it to a new status", ErrInvalidWorkflow, task.Status)
+		}
+		if _, ok := w.Status(target); !ok {
+			return fmt.Errorf("%w: status %q is mapped to unknown status %q", ErrInvalidWorkflow, task.Status, target)
+		}
+		moves[task] = target
+	}
+
+	if err := w.Save(session); err != nil {
+		return err
+	}
+	for task, status := range moves {
+		moved := *task
+		moved.Status = status
+		moved.UpdatedAt = time.Now()
+		moved.Version = task.Version + 1
+
+		pending, err := writeTaskChange(session, task, &moved, actor,
+			[]string{"status = ?", "updated_at = ?", "version = ?"}, moved.Status, moved.UpdatedAt, moved.Version)
+		if err != nil {
+			return err
+		}
+		recordTaskEvent(session, pending, &moved)
+	}
+	return nil
+}
+
+// normalizeStatusKey maps the upper-case values older clients submit, such
+// as "IN_PROGRESS", onto workflow keys.
+func normalizeStatusKey(status string) string {
+	return strings.ToLower(strings.TrimSpace(status))
+}
+
+// MigrateToDefaultWorkflow stores the default workflow for every user that
+// has tasks but no workflow, and normalizes task statuses onto its keys.
+// Statuses the default workflow does not know become StatusPending.
+func MigrateToDefaultWorkflow(session *gocql.Session) error {
+	defaults := DefaultWorkflow(gocql.UUID{})
+	users := make(map[gocql.UUID]bool)
+
+	iter := session.Query(`SELECT task_id, user_id, status FROM tasks`).Iter()
+	var taskID, userID gocql.UUID
+	var status string
+	for iter.Scan(&taskID, &userID, &status) {
+		users[userID] = true
+		key := normalizeStatusKey(status)
+		if _, ok := defaults.Status(key); !ok {
+			key = StatusPending
+		}
+		if key == status {
+			continue
+		}
+		if err := session.Query(`UPDATE tasks SET status = ? WHERE task_id = ?`, key, taskID).Exec(); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for userID := range users {
+		var existing gocql.UUID
+		err := session.Query(`SELECT workspace_id FROM workflows WHERE workspace_id = ?`, userID).Scan(&existing)
+		if err == nil {
+			continue
+		} else if err != gocql.ErrNotFound {
+			return err
+		}
+		if err := DefaultWorkflow(userID).Save(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
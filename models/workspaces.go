@@ -0,0 +1,339 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+)
+
+// Workspace roles, from least to most privileged. Viewers can read and
+// comment, editors can also change tasks and projects, and owners manage the
+// workspace, its members and its workflow.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+var (
+	ErrInvalidWorkspace  = errors.New("invalid workspace")
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	ErrNotMember         = errors.New("user is not a member of the workspace")
+	ErrPersonalWorkspace = errors.New("personal workspaces cannot be shared")
+	ErrLastOwner         = errors.New("a workspace needs at least one owner")
+)
+
+// Workspace groups the tasks, projects, workflow, tags and trash shared by its
+// members. Every user has a personal workspace whose ID is their user ID, so
+// tasks created before workspaces existed stay where they were.
+type Workspace struct {
+	WorkspaceID gocql.UUID `json:"workspace_id"`
+	Name        string     `json:"name"`
+	Personal    bool       `json:"personal"`
+	CreatedBy   gocql.UUID `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Role is the requesting user's role, filled in when listing a user's
+	// workspaces.
+	Role string `json:"role,omitempty"`
+}
+
+// WorkspaceMember is a user's membership of a workspace.
+type WorkspaceMember struct {
+	WorkspaceID gocql.UUID `json:"workspace_id"`
+	UserID      gocql.UUID `json:"user_id"`
+	Username    string     `json:"username,omitempty"`
+	Role        string     `json:"role"`
+	JoinedAt    time.Time  `json:"joined_at"`
+}
+
+// ValidRole reports whether role is one of the workspace roles.
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the access of required.
+func HasRole(role, required string) bool {
+	return role != "" && roleRanks[role] >= roleRanks[required]
+}
+
+// PersonalWorkspaceID returns the ID of the user's personal workspace.
+func PersonalWorkspaceID(userID gocql.UUID) gocql.UUID {
+	return userID
+}
+
+// Create saves the workspace with owner as its only member.
+func (ws *Workspace) Create(session *gocql.Session, owner gocql.UUID) error {
+	if err := ws.validate(); err != nil {
+		return err
+	}
+	ws.WorkspaceID = gocql.TimeUUID()
+	ws.Personal = false
+	ws.CreatedBy = owner
+	ws.CreatedAt = time.Now().UTC()
+	ws.Role = RoleOwner
+	return ws.insert(session)
+}
+
+// CreatePersonalWorkspace saves the user's personal workspace. Running it
+// again for the same user rewrites the same rows.
+func CreatePersonalWorkspace(session *gocql.Session, user *User) error {
+	ws := &Workspace{
+		WorkspaceID: PersonalWorkspaceID(user.UserID),
+		Name:        "Personal",
+		Personal:    true,
+		CreatedBy:   user.UserID,
+		CreatedAt:   user.CreatedAt,
+	}
+	return ws.insert(session)
+}
+
+func (ws *Workspace) insert(session *gocql.Session) error {
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`INSERT INTO workspaces (workspace_id, name, personal, created_by, created_at) VALUES (?, ?, ?, ?, ?)`,
+		ws.WorkspaceID, ws.Name, ws.Personal, ws.CreatedBy, ws.CreatedAt)
+	addMember(batch, ws.WorkspaceID, ws.CreatedBy, RoleOwner, ws.CreatedAt)
+	return session.ExecuteBatch(batch)
+}
+
+// Rename changes the name of the workspace.
+func (ws *Workspace) Rename(session *gocql.Session, name string) error {
+	previous := ws.Name
+	ws.Name = name
+	if err := ws.validate(); err != nil {
+		ws.Name = previous
+		return err
+	}
+	return session.Query(`UPDATE workspaces SET name = ? WHERE workspace_id = ?`, ws.Name, ws.WorkspaceID).Exec()
+}
+
+func (ws *Workspace) validate() error {
+	ws.Name = strings.TrimSpace(ws.Name)
+	if ws.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidWorkspace)
+	}
+	if utf8.RuneCountInString(ws.Name) > 100 {
+		return fmt.Errorf("%w: name cannot be longer than 100 characters", ErrInvalidWorkspace)
+	}
+	return nil
+}
+
+// GetWorkspace returns the workspace, or ErrWorkspaceNotFound.
+func GetWorkspace(session *gocql.Session, workspaceID gocql.UUID) (*Workspace, error) {
+	ws := &Workspace{}
+	query := `SELECT workspace_id, name, personal, created_by, created_at FROM workspaces WHERE workspace_id = ?`
+	err := session.Query(query, workspaceID).Scan(&ws.WorkspaceID, &ws.Name, &ws.Personal, &ws.CreatedBy, &ws.CreatedAt)
+	if err == gocql.ErrNotFound {
+		return nil, ErrWorkspaceNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// GetWorkspacesByUser returns the workspaces the user belongs to, the
+// personal one first and the others by name.
+func GetWorkspacesByUser(session *gocql.Session, userID gocql.UUID) ([]*Workspace, error) {
+	roles := make(map[gocql.UUID]string)
+	iter := session.Query(`SELECT workspace_id, role FROM workspaces_by_user WHERE user_id = ?`, userID).Iter()
+	var id gocql.UUID
+	var role string
+	for iter.Scan(&id, &role) {
+		roles[id] = role
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	workspaces := []*Workspace{}
+	for id, role := range roles {
+		ws, err := GetWorkspace(session, id)
+		if err == ErrWorkspaceNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ws.Role = role
+		workspaces = append(workspaces, ws)
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		if workspaces[i].Personal != workspaces[j].Personal {
+			return workspaces[i].Personal
+		}
+		return strings.ToLower(workspaces[i].Name) < strings.ToLower(workspaces[j].Name)
+	})
+	return workspaces, nil
+}
+
+// GetWorkspaceRole returns the user's role in the workspace, or an empty
+// string if they are not a member. Users always own their personal
+// workspace.
+func GetWorkspaceRole(session *gocql.Session, workspaceID, userID gocql.UUID) (string, error) {
+	if workspaceID == PersonalWorkspaceID(userID) {
+		return RoleOwner, nil
+	}
+	var role string
+	query := `SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?`
+	err := session.Query(query, workspaceID, userID).Scan(&role)
+	if err == gocql.ErrNotFound {
+		return "", nil
+	}
+	return role, err
+}
+
+// GetWorkspaceMembers returns the members of the workspace.
+func GetWorkspaceMembers(session *gocql.Session, workspaceID gocql.UUID) ([]WorkspaceMember, error) {
+	members, err := getWorkspaceMembers(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range members {
+		if user, err := GetUserByID(session, members[i].UserID); err == nil {
+			members[i].Username = user.Username
+		}
+	}
+	return members, nil
+}
+
+func getWorkspaceMembers(session *gocql.Session, workspaceID gocql.UUID) ([]WorkspaceMember, error) {
+	members := []WorkspaceMember{}
+	query := `SELECT workspace_id, user_id, role, joined_at FROM workspace_members WHERE workspace_id = ?`
+	iter := session.Query(query, workspaceID).Iter()
+	var m WorkspaceMember
+	for iter.Scan(&m.WorkspaceID, &m.UserID, &m.Role, &m.JoinedAt) {
+		members = append(members, m)
+		m = WorkspaceMember{}
+	}
+	return members, iter.Close()
+}
+
+// memberIDs returns the IDs of the workspace's members.
+func memberIDs(session *gocql.Session, workspaceID gocql.UUID) ([]gocql.UUID, error) {
+	members, err := getWorkspaceMembers(session, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]gocql.UUID, len(members))
+	for i, m := range members {
+		ids[i] = m.UserID
+	}
+	return ids, nil
+}
+
+// SetMemberRole changes the role of a member of the workspace. The last
+// owner cannot be demoted.
+func SetMemberRole(session *gocql.Session, ws *Workspace, userID gocql.UUID, role string) error {
+	if ws.Personal {
+		return ErrPersonalWorkspace
+	}
+	if !ValidRole(role) {
+		return fmt.Errorf("%w: role must be owner, editor or viewer", ErrInvalidWorkspace)
+	}
+	members, err := getWorkspaceMembers(session, ws.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	member := findMember(members, userID)
+	if member == nil {
+		return ErrNotMember
+	}
+	if member.Role == RoleOwner && role != RoleOwner && countOwners(members) == 1 {
+		return ErrLastOwner
+	}
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	addMember(batch, ws.WorkspaceID, userID, role, member.JoinedAt)
+	return session.ExecuteBatch(batch)
+}
+
+// RemoveMember takes the user out of the workspace. The last owner cannot
+// leave.
+func RemoveMember(session *gocql.Session, ws *Workspace, userID gocql.UUID) error {
+	if ws.Personal {
+		return ErrPersonalWorkspace
+	}
+	members, err := getWorkspaceMembers(session, ws.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	member := findMember(members, userID)
+	if member == nil {
+		return ErrNotMember
+	}
+	if member.Role == RoleOwner && countOwners(members) == 1 {
+		return ErrLastOwner
+	}
+
+	batch := session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?`, ws.WorkspaceID, userID)
+	batch.Query(`DELETE FROM workspaces_by_user WHERE user_id = ? AND workspace_id = ?`, userID, ws.WorkspaceID)
+	return session.ExecuteBatch(batch)
+}
+
+// addMember queues the rows recording the user's role in the workspace.
+func addMember(batch *gocql.Batch, workspaceID, userID gocql.UUID, role string, joinedAt time.Time) {
+	batch.Query(`INSERT INTO workspace_members (workspace_id, user_id, role, joined_at) VALUES (?, ?, ?, ?)`,
+		workspaceID, userID, role, joinedAt)
+	batch.Query(`INSERT INTO workspaces_by_user (user_id, workspace_id, role) VALUES (?, ?, ?)`,
+		userID, workspaceID, role)
+}
+
+func findMember(members []WorkspaceMember, userID gocql.UUID) *WorkspaceMember {
+	for i := range members {
+		if members[i].UserID == userID {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+func countOwners(members []WorkspaceMember) int {
+	n := 0
+	for _, m := range members {
+		if m.Role == RoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+// MigrateToWorkspaces creates the personal workspace of every user and files
+// their existing tasks there. The other per-user tables are keyed by the
+// personal workspace ID already, which is the user ID.
+func MigrateToWorkspaces(session *gocql.Session) error {
+	iter := session.Query(`SELECT user_id, username, email, created_at FROM users`).Iter()
+	var user User
+	for iter.Scan(&user.UserID, &user.Username, &user.Email, &user.CreatedAt) {
+		if err := CreatePersonalWorkspace(session, &user); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	iter = session.Query(`SELECT task_id, user_id, workspace_id FROM tasks`).Iter()
+	var taskID, userID gocql.UUID
+	var workspaceID *gocql.UUID
+	for iter.Scan(&taskID, &userID, &workspaceID) {
+		if workspaceID != nil {
+			continue
+		}
+		err := session.Query(`UPDATE tasks SET workspace_id = ? WHERE task_id = ?`,
+			PersonalWorkspaceID(userID), taskID).Exec()
+		if err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
@@ -0,0 +1,149 @@
+// Package netguard keeps requests to user-supplied URLs, such as webhook
+// endpoints, away from the server's own network. Addresses are checked when
+// the connection is made, after DNS resolution, so a host name that resolves
+// to an internal address is refused as well.
+package netguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrBlockedAddress = errors.New("address is not publicly routable")
+	ErrInvalidURL     = errors.New("invalid URL")
+)
+
+// AllowHTTP lets URLs use plain http rather than https, and AllowPrivate
+// lets requests reach loopback and private addresses. Both are meant for
+// development against local receivers.
+var (
+	AllowHTTP    = false
+	AllowPrivate = false
+)
+
+// blockedNets are the special-purpose ranges not covered by the net.IP
+// predicates used in IsPublic.
+var blockedNets = mustParseCIDRs(
+	"0.0.0.0/8",       // "this" network
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // reserved, including broadcast
+	"64:ff9b::/96",    // NAT64, which can reach any IPv4 address
+	"64:ff9b:1::/48",  // local-use NAT64
+	"2001:db8::/32",   // documentation
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// IsPublic reports whether ip is a publicly routable unicast address: not
+// loopback, private (RFC 1918 or IPv6 unique local), link-local, multicast,
+// unspecified or otherwise reserved.
+func IsPublic(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() {
+		return false
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Control is a net.Dialer Control function refusing connections to
+// addresses that are not public, unless AllowPrivate is set.
+func Control(network, address string, _ syscall.RawConn) error {
+	if AllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !IsPublic(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// NewClient returns a client for requests to user-supplied URLs. It dials
+// through Control, bypasses proxies, which would dial in its place, and
+// does not follow redirects, which could lead anywhere.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   Control,
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// CheckURL checks a user-supplied URL before it is saved: it must be an
+// absolute https URL, or http if AllowHTTP is set, and must not carry
+// credentials or name a non-public address literally. Host names are only
+// checked when a request is made, as what they resolve to can change.
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Hostname() == "" {
+		return fmt.Errorf("%w: must be an absolute URL", ErrInvalidURL)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !AllowHTTP {
+			return fmt.Errorf("%w: must use https", ErrInvalidURL)
+		}
+	default:
+		return fmt.Errorf("%w: must use https", ErrInvalidURL)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: must not contain credentials", ErrInvalidURL)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !AllowPrivate && !IsPublic(ip) {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, ErrBlockedAddress)
+	}
+	if host := strings.ToLower(u.Hostname()); !AllowPrivate && (host == "localhost" || strings.HasSuffix(host, ".localhost")) {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, ErrBlockedAddress)
+	}
+	return nil
+}
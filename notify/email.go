@@ -0,0 +1,54 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type EmailConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// EmailNotifier sends messages through an SMTP server.
+type EmailNotifier struct {
+	config EmailConfig
+}
+
+func NewEmailNotifier(config EmailConfig) *EmailNotifier {
+	return &EmailNotifier{config: config}
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, msg Message) error {
+	if n.config.Host == "" {
+		return fmt.Errorf("email delivery is not configured")
+	}
+	if msg.Recipient == "" || strings.ContainsAny(msg.Recipient, "\r\n") {
+		return fmt.Errorf("invalid email recipient %q", msg.Recipient)
+	}
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(msg.Subject)
+	body := "From: " + n.config.From + "\r\n" +
+		"To: " + msg.Recipient + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + msg.Body + "\r\n"
+
+	port := n.config.Port
+	if port == "" {
+		port = "587"
+	}
+	addr := net.JoinHostPort(n.config.Host, port)
+	return smtp.SendMail(addr, auth, n.config.From, []string{msg.Recipient}, []byte(body))
+}
@@ -0,0 +1,28 @@
+package notify
+
+import (
+	"context"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// InAppNotifier stores messages in the user's notification feed.
+type InAppNotifier struct {
+	session *gocql.Session
+}
+
+func NewInAppNotifier(session *gocql.Session) *InAppNotifier {
+	return &InAppNotifier{session: session}
+}
+
+func (n *InAppNotifier) Notify(ctx context.Context, msg Message) error {
+	notification := &models.Notification{
+		UserID: msg.UserID,
+		Kind:   msg.Kind,
+		Title:  msg.Subject,
+		Body:   msg.Body,
+		TaskID: msg.TaskID,
+	}
+	return notification.Create(n.session)
+}
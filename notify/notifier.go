@@ -0,0 +1,25 @@
+// Package notify delivers messages to users over pluggable channels.
+package notify
+
+import (
+	"context"
+
+	"github.com/gocql/gocql"
+)
+
+// Message is a single notification addressed to a user.
+type Message struct {
+	UserID gocql.UUID
+	TaskID *gocql.UUID
+	Kind   string
+	// Recipient is the channel-specific address, such as an email address
+	// or webhook URL. In-app delivery ignores it.
+	Recipient string
+	Subject   string
+	Body      string
+}
+
+// Notifier delivers a message over one channel.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"todo-app/netguard"
+
+	"github.com/gocql/gocql"
+)
+
+// WebhookNotifier posts messages as JSON to the recipient URL. Recipients
+// are chosen by users, so the default client only reaches public addresses
+// and does not follow redirects.
+type WebhookNotifier struct {
+	client *http.Client
+}
+
+func NewWebhookNotifier(client *http.Client) *WebhookNotifier {
+	if client == nil {
+		client = netguard.NewClient(10 * time.Second)
+	}
+	return &WebhookNotifier{client: client}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(struct {
+		Kind    string      `json:"kind"`
+		UserID  gocql.UUID  `json:"user_id"`
+		TaskID  *gocql.UUID `json:"task_id,omitempty"`
+		Subject string      `json:"subject"`
+		Body    string      `json:"body"`
+		SentAt  time.Time   `json:"sent_at"`
+	}{msg.Kind, msg.UserID, msg.TaskID, msg.Subject, msg.Body, time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.Recipient, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// Package realtime fans out changes to the clients streaming them through a
+// pluggable broker.
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/gocql/gocql"
+)
+
+// Event is a change pushed to clients. ID is a time UUID, so events can be
+// resumed from the last one a client saw.
+type Event struct {
+	ID gocql.UUID
+	// Scope is the workspace the change happened in.
+	Scope gocql.UUID
+	Type  string
+	Data  json.RawMessage
+}
+
+// Broker delivers published events to every subscriber, on this instance
+// and, for brokers backed by a message bus, on all others.
+type Broker interface {
+	// Publish hands the event to the subscribers. It must not block on slow
+	// subscribers.
+	Publish(ctx context.Context, e Event) error
+
+	// Subscribe returns a channel receiving the events published from now
+	// on, and a function to call once done with it. The broker closes the
+	// channel when the subscriber falls too far behind; the subscriber can
+	// then catch up from the event log.
+	Subscribe() (<-chan Event, func())
+}
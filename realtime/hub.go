@@ -0,0 +1,378 @@
+package realtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+)
+
+// Limits and timings of collaboration connections. A client is dropped once
+// it falls sendBuffer messages behind or misses pongs for pongWait.
+const (
+	sendBuffer       = 32
+	maxMessageSize   = 4096
+	maxSubscriptions = 50
+	writeWait        = 10 * time.Second
+	closeWait        = time.Second
+	pongWait         = 60 * time.Second
+	pingPeriod       = pongWait * 9 / 10
+)
+
+// ErrForbidden is returned by an Authorizer for topics the user may not see.
+var ErrForbidden = errors.New("topic not found or access denied")
+
+// Authorizer reports whether the user may subscribe to the topic, returning
+// ErrForbidden if not, and whether they may signal that they are editing it.
+type Authorizer func(ctx context.Context, userID gocql.UUID, topic Topic) (canEdit bool, err error)
+
+// message is the JSON envelope of everything sent over a collaboration
+// connection in either direction.
+type message struct {
+	Type     string      `json:"type"`
+	Topic    string      `json:"topic,omitempty"`
+	Editing  *bool       `json:"editing,omitempty"`
+	UserID   *gocql.UUID `json:"user_id,omitempty"`
+	Present  *bool       `json:"present,omitempty"`
+	Presence []Presence  `json:"presence,omitempty"`
+	Message  string      `json:"message,omitempty"`
+}
+
+// Hub connects collaboration clients subscribed to the same topics. Clients
+// send
+//
+//	{"type": "subscribe", "topic": "task:<id>"}
+//	{"type": "unsubscribe", "topic": "task:<id>"}
+//	{"type": "editing", "topic": "task:<id>", "editing": true}
+//	{"type": "ping"}
+//
+// and receive a "subscribed" message with everyone present, then a
+// "presence" message whenever a user arrives, leaves or starts or stops
+// editing. Presence is kept in the store; broadcasts reach the connections
+// of this instance.
+type Hub struct {
+	store     PresenceStore
+	authorize Authorizer
+	upgrader  websocket.Upgrader
+
+	mu    sync.Mutex
+	rooms map[string]map[*client]struct{}
+}
+
+func NewHub(store PresenceStore, authorize Authorizer) *Hub {
+	return &Hub{
+		store:     store,
+		authorize: authorize,
+		rooms:     make(map[string]map[*client]struct{}),
+	}
+}
+
+// Serve upgrades the request to a WebSocket connection of the user and
+// handles it until it closes.
+func (h *Hub) Serve(w http.ResponseWriter, r *http.Request, userID gocql.UUID) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already responded.
+		return
+	}
+
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	c := &client{
+		hub:    h,
+		ws:     ws,
+		id:     hex.EncodeToString(buf),
+		userID: userID,
+		send:   make(chan []byte, sendBuffer),
+		done:   make(chan struct{}),
+		topics: make(map[string]*subscription),
+	}
+	go c.writePump()
+	c.readPump()
+}
+
+// broadcast queues the message for every connection subscribed to the
+// topic except the given one.
+func (h *Hub) broadcast(topic string, msg message, except *client) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", msg.Type, err)
+		return
+	}
+	h.mu.Lock()
+	clients := make([]*client, 0, len(h.rooms[topic]))
+	for c := range h.rooms[topic] {
+		if c != except {
+			clients = append(clients, c)
+		}
+	}
+	h.mu.Unlock()
+	for _, c := range clients {
+		c.enqueue(data)
+	}
+}
+
+func (h *Hub) join(topic string, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.rooms[topic]
+	if !ok {
+		room = make(map[*client]struct{})
+		h.rooms[topic] = room
+	}
+	room[c] = struct{}{}
+}
+
+func (h *Hub) leave(topic string, c *client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.rooms[topic], c)
+	if len(h.rooms[topic]) == 0 {
+		delete(h.rooms, topic)
+	}
+}
+
+// userPresence returns the merged presence of the user on the topic, or nil
+// if none of their connections is there anymore.
+func (h *Hub) userPresence(ctx context.Context, topic string, userID gocql.UUID) (*Presence, error) {
+	list, err := h.store.List(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range byUser(list) {
+		if p.UserID == userID {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+// announce tells the other connections on the topic about the user's
+// current presence.
+func (h *Hub) announce(ctx context.Context, topic string, c *client) {
+	p, err := h.userPresence(ctx, topic, c.userID)
+	if err != nil {
+		log.Printf("Failed to read presence of %s: %v", topic, err)
+		return
+	}
+	present := p != nil
+	msg := message{Type: "presence", Topic: topic, UserID: &c.userID, Present: &present}
+	if present {
+		msg.Editing = &p.Editing
+	}
+	h.broadcast(topic, msg, c)
+}
+
+type subscription struct {
+	topic   Topic
+	canEdit bool
+	since   time.Time
+}
+
+// client is one collaboration connection. Its topics are only touched by
+// readPump; everything it is sent goes through the send buffer.
+type client struct {
+	hub    *Hub
+	ws     *websocket.Conn
+	id     string
+	userID gocql.UUID
+	send   chan []byte
+	topics map[string]*subscription
+
+	closeOnce   sync.Once
+	done        chan struct{}
+	closeCode   int
+	closeReason string
+}
+
+// enqueue queues data for the client, dropping the client if its buffer is
+// full rather than holding up everyone else.
+func (c *client) enqueue(data []byte) {
+	select {
+	case c.send <- data:
+	case <-c.done:
+	default:
+		c.drop()
+	}
+}
+
+func (c *client) reply(msg message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Failed to encode %s message: %v", msg.Type, err)
+		return
+	}
+	c.enqueue(data)
+}
+
+func (c *client) fail(topic, text string) {
+	c.reply(message{Type: "error", Topic: topic, Message: text})
+}
+
+// drop disconnects a client that fell behind. Its socket is most likely
+// full, so a write in progress is cut short rather than left to time out.
+func (c *client) drop() {
+	c.close(websocket.ClosePolicyViolation, "client too slow")
+	c.ws.NetConn().SetWriteDeadline(time.Now())
+}
+
+// close shuts the connection down with the given close code and reason.
+func (c *client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.done)
+	})
+}
+
+func (c *client) readPump() {
+	ctx := context.Background()
+	defer func() {
+		c.close(websocket.CloseNormalClosure, "")
+		for topic := range c.topics {
+			c.unsubscribe(ctx, topic)
+		}
+	}()
+
+	c.ws.SetReadLimit(maxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.ws.SetReadDeadline(time.Now().Add(pongWait))
+
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.fail("", "Invalid message")
+			continue
+		}
+		switch msg.Type {
+		case "subscribe":
+			c.subscribe(ctx, msg.Topic)
+		case "unsubscribe":
+			if _, ok := c.topics[msg.Topic]; ok {
+				c.unsubscribe(ctx, msg.Topic)
+			}
+		case "editing":
+			c.setEditing(ctx, msg.Topic, msg.Editing != nil && *msg.Editing)
+		case "ping":
+			c.reply(message{Type: "pong"})
+		default:
+			c.fail(msg.Topic, "Unknown message type")
+		}
+	}
+}
+
+func (c *client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+	for {
+		select {
+		case data := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			if err := c.ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				c.close(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.done:
+			// A slow client's socket may be full; do not wait long to say
+			// goodbye.
+			c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, c.closeReason),
+				time.Now().Add(closeWait))
+			return
+		}
+	}
+}
+
+func (c *client) subscribe(ctx context.Context, name string) {
+	topic, err := ParseTopic(name)
+	if err != nil {
+		c.fail(name, err.Error())
+		return
+	}
+	name = topic.String()
+	sub, ok := c.topics[name]
+	if !ok {
+		if len(c.topics) >= maxSubscriptions {
+			c.fail(name, "Too many subscriptions")
+			return
+		}
+		canEdit, err := c.hub.authorize(ctx, c.userID, topic)
+		if errors.Is(err, ErrForbidden) {
+			c.fail(name, err.Error())
+			return
+		} else if err != nil {
+			log.Printf("Failed to authorize %s: %v", name, err)
+			c.fail(name, "Failed to subscribe")
+			return
+		}
+		sub = &subscription{topic: topic, canEdit: canEdit, since: time.Now().UTC()}
+		if err := c.hub.store.Set(ctx, name, c.id, Presence{UserID: c.userID, Since: sub.since}); err != nil {
+			log.Printf("Failed to record presence on %s: %v", name, err)
+			c.fail(name, "Failed to subscribe")
+			return
+		}
+		c.topics[name] = sub
+		c.hub.join(name, c)
+		c.hub.announce(ctx, name, c)
+	}
+
+	list, err := c.hub.store.List(ctx, name)
+	if err != nil {
+		log.Printf("Failed to read presence of %s: %v", name, err)
+		c.fail(name, "Failed to read presence")
+		return
+	}
+	c.reply(message{Type: "subscribed", Topic: name, Presence: byUser(list)})
+}
+
+func (c *client) unsubscribe(ctx context.Context, name string) {
+	delete(c.topics, name)
+	c.hub.leave(name, c)
+	if err := c.hub.store.Remove(ctx, name, c.id); err != nil {
+		log.Printf("Failed to remove presence from %s: %v", name, err)
+	}
+	c.hub.announce(ctx, name, c)
+}
+
+func (c *client) setEditing(ctx context.Context, name string, editing bool) {
+	sub, ok := c.topics[name]
+	switch {
+	case !ok:
+		c.fail(name, "Not subscribed to the topic")
+		return
+	case sub.topic.Kind != TopicTask:
+		c.fail(name, "Only tasks can be edited")
+		return
+	case !sub.canEdit:
+		c.fail(name, "This requires the editor role in the workspace")
+		return
+	}
+	p := Presence{UserID: c.userID, Editing: editing, Since: sub.since}
+	if err := c.hub.store.Set(ctx, name, c.id, p); err != nil {
+		log.Printf("Failed to record presence on %s: %v", name, err)
+		c.fail(name, "Failed to update presence")
+		return
+	}
+	c.hub.announce(ctx, name, c)
+}
@@ -0,0 +1,458 @@
+package realtime_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"todo-app/middleware"
+	"todo-app/realtime"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+)
+
+const waitTimeout = 10 * time.Second
+
+// world is the fixture the hub authorizes against: one workspace with
+// editors and a viewer, and a task in it.
+type world struct {
+	workspace gocql.UUID
+	task      gocql.UUID
+
+	mu      sync.Mutex
+	editors map[gocql.UUID]bool
+	viewers map[gocql.UUID]bool
+}
+
+func (wd *world) add(userID gocql.UUID, editor bool) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if editor {
+		wd.editors[userID] = true
+	} else {
+		wd.viewers[userID] = true
+	}
+}
+
+func (wd *world) authorize(ctx context.Context, userID gocql.UUID, topic realtime.Topic) (bool, error) {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	if topic.ID != wd.workspace && topic.ID != wd.task {
+		return false, realtime.ErrForbidden
+	}
+	if wd.editors[userID] {
+		return true, nil
+	}
+	if wd.viewers[userID] {
+		return false, nil
+	}
+	return false, realtime.ErrForbidden
+}
+
+// testClient is a collaboration client recording what it is told.
+type testClient struct {
+	name   string
+	userID gocql.UUID
+	ws     *websocket.Conn
+
+	mu       sync.Mutex
+	presence map[string]map[gocql.UUID]bool
+	errors   []string
+	updates  int
+	pongs    int
+	closed   bool
+}
+
+type serverMessage struct {
+	Type     string              `json:"type"`
+	Topic    string              `json:"topic"`
+	Editing  *bool               `json:"editing"`
+	UserID   *gocql.UUID         `json:"user_id"`
+	Present  *bool               `json:"present"`
+	Presence []realtime.Presence `json:"presence"`
+	Message  string              `json:"message"`
+}
+
+// dial connects a client of the user. A positive readBuffer shrinks the
+// client's socket receive buffer, so a client that stops reading pushes back
+// on the server sooner.
+func dial(server *httptest.Server, name string, userID gocql.UUID, token string, readBuffer int) (*testClient, error) {
+	dialer := *websocket.DefaultDialer
+	if readBuffer > 0 {
+		dialer.NetDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			if tcp, ok := conn.(*net.TCPConn); ok {
+				tcp.SetReadBuffer(readBuffer)
+			}
+			return conn, nil
+		}
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?access_token=" + token
+	ws, resp, err := dialer.Dial(url, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%s: %w (HTTP %d)", name, err, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &testClient{name: name, userID: userID, ws: ws, presence: make(map[string]map[gocql.UUID]bool)}, nil
+}
+
+func (c *testClient) readLoop() {
+	for {
+		var msg serverMessage
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			c.mu.Lock()
+			c.closed = true
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Lock()
+		switch msg.Type {
+		case "subscribed":
+			users := make(map[gocql.UUID]bool)
+			for _, p := range msg.Presence {
+				users[p.UserID] = p.Editing
+			}
+			c.presence[msg.Topic] = users
+		case "presence":
+			c.updates++
+			users := c.presence[msg.Topic]
+			if users == nil {
+				users = make(map[gocql.UUID]bool)
+				c.presence[msg.Topic] = users
+			}
+			if *msg.Present {
+				users[*msg.UserID] = msg.Editing != nil && *msg.Editing
+			} else {
+				delete(users, *msg.UserID)
+			}
+		case "error":
+			c.errors = append(c.errors, msg.Topic+": "+msg.Message)
+		case "pong":
+			c.pongs++
+		}
+		c.mu.Unlock()
+	}
+}
+
+func (c *testClient) send(msg map[string]interface{}) error {
+	return c.ws.WriteJSON(msg)
+}
+
+// sees reports whether the client currently sees the user on the topic,
+// and whether as editing.
+func (c *testClient) sees(topic string, userID gocql.UUID) (present, editing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	editing, present = c.presence[topic][userID]
+	return present, editing
+}
+
+func (c *testClient) lastError() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errors) == 0 {
+		return ""
+	}
+	return c.errors[len(c.errors)-1]
+}
+
+func waitFor(what string, cond func() bool) error {
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+// smallBufferListener shrinks the send buffer of the server's sockets, so a
+// client that stops reading fills it after a few messages rather than
+// megabytes.
+type smallBufferListener struct {
+	net.Listener
+}
+
+func (l smallBufferListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetWriteBuffer(4096)
+	}
+	return conn, err
+}
+
+type harness struct {
+	server   *httptest.Server
+	world    *world
+	clients  []*testClient
+	viewer   *testClient
+	outsider *testClient
+}
+
+func newHarness(n int) (*harness, error) {
+	wd := &world{
+		workspace: gocql.TimeUUID(),
+		task:      gocql.TimeUUID(),
+		editors:   make(map[gocql.UUID]bool),
+		viewers:   make(map[gocql.UUID]bool),
+	}
+	hub := realtime.NewHub(realtime.NewMemoryPresenceStore(), wd.authorize)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r.Context())
+		hub.Serve(w, r, userID)
+	})))
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = smallBufferListener{server.Listener}
+	server.Start()
+	h := &harness{server: server, world: wd}
+
+	connect := func(name string) (*testClient, error) {
+		userID := gocql.TimeUUID()
+		token, err := middleware.IssueToken(userID)
+		if err != nil {
+			return nil, err
+		}
+		c, err := dial(h.server, name, userID, token, 0)
+		if err != nil {
+			return nil, err
+		}
+		go c.readLoop()
+		return c, nil
+	}
+	for i := 0; i < n; i++ {
+		c, err := connect(fmt.Sprintf("editor-%d", i))
+		if err != nil {
+			return h, err
+		}
+		wd.add(c.userID, true)
+		h.clients = append(h.clients, c)
+	}
+	var err error
+	if h.viewer, err = connect("viewer"); err != nil {
+		return h, err
+	}
+	wd.add(h.viewer.userID, false)
+	h.outsider, err = connect("outsider")
+	return h, err
+}
+
+func (h *harness) close() {
+	for _, c := range append(h.clients, h.viewer, h.outsider) {
+		if c != nil {
+			c.ws.Close()
+		}
+	}
+	h.server.Close()
+}
+
+func (h *harness) workspaceTopic() string {
+	return realtime.Topic{Kind: realtime.TopicWorkspace, ID: h.world.workspace}.String()
+}
+
+func (h *harness) taskTopic() string {
+	return realtime.Topic{Kind: realtime.TopicTask, ID: h.world.task}.String()
+}
+
+// scenarios are run in order against the same clients.
+var scenarios = []struct {
+	name string
+	run  func(h *harness) error
+}{
+	{"rejects connections without a valid token", func(h *harness) error {
+		_, err := dial(h.server, "anonymous", gocql.TimeUUID(), "not-a-token", 0)
+		if err == nil {
+			return errors.New("connection was accepted")
+		}
+		return nil
+	}},
+	{"everyone subscribed to the workspace sees everyone", func(h *harness) error {
+		topic := h.workspaceTopic()
+		for _, c := range h.clients {
+			if err := c.send(map[string]interface{}{"type": "subscribe", "topic": topic}); err != nil {
+				return err
+			}
+		}
+		for _, c := range h.clients {
+			for _, other := range h.clients {
+				c, other := c, other
+				err := waitFor(c.name+" sees "+other.name, func() bool {
+					present, _ := c.sees(topic, other.userID)
+					return present
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}},
+	{"outsiders cannot subscribe", func(h *harness) error {
+		if err := h.outsider.send(map[string]interface{}{"type": "subscribe", "topic": h.workspaceTopic()}); err != nil {
+			return err
+		}
+		if err := waitFor("the outsider is refused", func() bool { return h.outsider.lastError() != "" }); err != nil {
+			return err
+		}
+		if present, _ := h.clients[0].sees(h.workspaceTopic(), h.outsider.userID); present {
+			return errors.New("the outsider was announced")
+		}
+		return nil
+	}},
+	{"editing signals reach the task's viewers", func(h *harness) error {
+		topic := h.taskTopic()
+		for _, c := range append(h.clients, h.viewer) {
+			if err := c.send(map[string]interface{}{"type": "subscribe", "topic": topic}); err != nil {
+				return err
+			}
+		}
+		editor := h.clients[0]
+		if err := waitFor(h.viewer.name+" sees "+editor.name, func() bool {
+			present, _ := h.viewer.sees(topic, editor.userID)
+			return present
+		}); err != nil {
+			return err
+		}
+		if err := editor.send(map[string]interface{}{"type": "editing", "topic": topic, "editing": true}); err != nil {
+			return err
+		}
+		for _, c := range append(h.clients[1:], h.viewer) {
+			c := c
+			if err := waitFor(c.name+" sees "+editor.name+" editing", func() bool {
+				_, editing := c.sees(topic, editor.userID)
+				return editing
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"viewers cannot signal editing", func(h *harness) error {
+		if err := h.viewer.send(map[string]interface{}{"type": "editing", "topic": h.taskTopic(), "editing": true}); err != nil {
+			return err
+		}
+		return waitFor("the viewer is refused", func() bool {
+			return strings.Contains(h.viewer.lastError(), "editor role")
+		})
+	}},
+	{"answers heartbeats", func(h *harness) error {
+		c := h.clients[len(h.clients)-1]
+		if err := c.send(map[string]interface{}{"type": "ping"}); err != nil {
+			return err
+		}
+		return waitFor(c.name+" gets a pong", func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.pongs > 0
+		})
+	}},
+	{"drops slow clients and announces that they left", func(h *harness) error {
+		userID := gocql.TimeUUID()
+		h.world.add(userID, true)
+		token, err := middleware.IssueToken(userID)
+		if err != nil {
+			return err
+		}
+		slow, err := dial(h.server, "slow", userID, token, 1024)
+		if err != nil {
+			return err
+		}
+		defer slow.ws.Close()
+
+		// The slow client subscribes but never reads what it is sent.
+		topic := h.taskTopic()
+		if err := slow.send(map[string]interface{}{"type": "subscribe", "topic": topic}); err != nil {
+			return err
+		}
+		watcher := h.clients[1]
+		if err := waitFor(watcher.name+" sees the slow client", func() bool {
+			present, _ := watcher.sees(topic, userID)
+			return present
+		}); err != nil {
+			return err
+		}
+
+		// The editor sends in small batches, waiting for the watcher to catch
+		// up after each, so only the slow client falls behind.
+		const batch = 16
+		editor := h.clients[0]
+		deadline := time.Now().Add(waitTimeout)
+		for editing := false; ; {
+			if present, _ := watcher.sees(topic, userID); !present {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return errors.New("the slow client was not dropped")
+			}
+			watcher.mu.Lock()
+			want := watcher.updates + batch
+			watcher.mu.Unlock()
+			for i := 0; i < batch; i++ {
+				editing = !editing
+				if err := editor.send(map[string]interface{}{"type": "editing", "topic": topic, "editing": editing}); err != nil {
+					return err
+				}
+			}
+			for {
+				watcher.mu.Lock()
+				done := watcher.updates >= want || watcher.closed
+				watcher.mu.Unlock()
+				if done || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(50 * time.Microsecond)
+			}
+		}
+	}},
+	{"announces clients that disconnect", func(h *harness) error {
+		leaving := h.clients[len(h.clients)-1]
+		leaving.ws.Close()
+		for _, c := range h.clients[:len(h.clients)-1] {
+			c := c
+			if err := waitFor(c.name+" sees "+leaving.name+" leave", func() bool {
+				present, _ := c.sees(h.workspaceTopic(), leaving.userID)
+				return !present
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// TestHub connects several collaboration clients to the WebSocket hub backed
+// by the in-memory presence store, and checks subscriptions, presence,
+// editing signals, heartbeats and the dropping of slow clients end to end.
+func TestHub(t *testing.T) {
+	h, err := newHarness(5)
+	if h != nil {
+		defer h.close()
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect clients: %v", err)
+	}
+
+	for _, s := range scenarios {
+		if !t.Run(s.name, func(t *testing.T) {
+			if err := s.run(h); err != nil {
+				t.Fatal(err)
+			}
+		}) {
+			// Later scenarios build on the state earlier ones leave behind.
+			return
+		}
+	}
+}
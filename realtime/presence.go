@@ -0,0 +1,134 @@
+package realtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Kinds of topics collaboration clients subscribe to.
+const (
+	TopicWorkspace = "workspace"
+	TopicTask      = "task"
+)
+
+var ErrInvalidTopic = errors.New("topic must be workspace:<id> or task:<id>")
+
+// Topic is a workspace or task whose viewers see each other.
+type Topic struct {
+	Kind string
+	ID   gocql.UUID
+}
+
+func ParseTopic(s string) (Topic, error) {
+	kind, id, ok := strings.Cut(s, ":")
+	if !ok || kind != TopicWorkspace && kind != TopicTask {
+		return Topic{}, ErrInvalidTopic
+	}
+	uuid, err := gocql.ParseUUID(id)
+	if err != nil {
+		return Topic{}, ErrInvalidTopic
+	}
+	return Topic{Kind: kind, ID: uuid}, nil
+}
+
+func (t Topic) String() string {
+	return fmt.Sprintf("%s:%s", t.Kind, t.ID)
+}
+
+// Presence is a user's presence on a topic.
+type Presence struct {
+	UserID  gocql.UUID `json:"user_id"`
+	Editing bool       `json:"editing"`
+	Since   time.Time  `json:"since"`
+}
+
+// PresenceStore keeps who is present on each topic, per connection, so a
+// user with several tabs open stays present until the last one leaves.
+type PresenceStore interface {
+	// Set records or replaces the presence of the connection on the topic.
+	Set(ctx context.Context, topic, connID string, p Presence) error
+
+	// Remove drops the connection from the topic. Removing an absent
+	// connection is not an error.
+	Remove(ctx context.Context, topic, connID string) error
+
+	// List returns the presence of every connection on the topic.
+	List(ctx context.Context, topic string) ([]Presence, error)
+}
+
+// MemoryPresenceStore is a PresenceStore held in memory. It suits a single
+// instance; deployments running several need a shared store.
+type MemoryPresenceStore struct {
+	mu     sync.Mutex
+	topics map[string]map[string]Presence
+}
+
+func NewMemoryPresenceStore() *MemoryPresenceStore {
+	return &MemoryPresenceStore{topics: make(map[string]map[string]Presence)}
+}
+
+func (s *MemoryPresenceStore) Set(ctx context.Context, topic, connID string, p Presence) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conns, ok := s.topics[topic]
+	if !ok {
+		conns = make(map[string]Presence)
+		s.topics[topic] = conns
+	}
+	conns[connID] = p
+	return nil
+}
+
+func (s *MemoryPresenceStore) Remove(ctx context.Context, topic, connID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.topics[topic], connID)
+	if len(s.topics[topic]) == 0 {
+		delete(s.topics, topic)
+	}
+	return nil
+}
+
+func (s *MemoryPresenceStore) List(ctx context.Context, topic string) ([]Presence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Presence, 0, len(s.topics[topic]))
+	for _, p := range s.topics[topic] {
+		list = append(list, p)
+	}
+	return list, nil
+}
+
+// byUser merges the presence of each user's connections: a user is editing
+// if any of their connections is, and present since the earliest one
+// joined. Users are ordered by arrival.
+func byUser(list []Presence) []Presence {
+	merged := make(map[gocql.UUID]*Presence)
+	for _, p := range list {
+		m, ok := merged[p.UserID]
+		if !ok {
+			p := p
+			merged[p.UserID] = &p
+			continue
+		}
+		m.Editing = m.Editing || p.Editing
+		if p.Since.Before(m.Since) {
+			m.Since = p.Since
+		}
+	}
+	users := make([]Presence, 0, len(merged))
+	for _, p := range merged {
+		users = append(users, *p)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Since.Before(users[j].Since)
+	})
+	return users
+}
@@ -0,0 +1,622 @@
+// Package recurrence parses and expands RFC 5545 recurrence rules (RRULE).
+//
+// Occurrences are expanded in the wall-clock time of the start date's
+// location, so a rule that fires at 09:00 keeps firing at 09:00 local time
+// across daylight saving transitions.
+package recurrence
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency int
+
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencyNames = map[Frequency]string{
+	Daily:   "DAILY",
+	Weekly:  "WEEKLY",
+	Monthly: "MONTHLY",
+	Yearly:  "YEARLY",
+}
+
+var weekdayNames = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// maxPeriods bounds the expansion of rules that can never match, such as
+// the 30th of February.
+const maxPeriods = 50000
+
+// WeekdayNum is a BYDAY entry. N selects the Nth weekday of the month or
+// year, counting from the end when negative; zero selects every such weekday.
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int
+}
+
+// Rule is a parsed RRULE.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []int
+	ByHour     []int
+	ByMinute   []int
+	BySetPos   []int
+	WeekStart  time.Weekday
+
+	// untilFloating means Until has no zone and is read in the start
+	// date's location; untilDate means it names a whole day.
+	untilFloating bool
+	untilDate     bool
+}
+
+// Parse parses an RRULE value such as "FREQ=WEEKLY;BYDAY=MO,WE". A leading
+// "RRULE:" is accepted.
+func Parse(s string) (*Rule, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimPrefix(s, "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("empty recurrence rule")
+	}
+
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	hasFreq := false
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		key, value := kv[0], kv[1]
+
+		var err error
+		switch key {
+		case "FREQ":
+			hasFreq = true
+			r.Freq, err = parseFrequency(value)
+		case "INTERVAL":
+			r.Interval, err = parsePositive(value)
+		case "COUNT":
+			r.Count, err = parsePositive(value)
+		case "UNTIL":
+			err = r.parseUntil(value)
+		case "BYDAY":
+			r.ByDay, err = parseWeekdayNums(value)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseIntList(value, -31, 31, false)
+		case "BYMONTH":
+			r.ByMonth, err = parseIntList(value, 1, 12, false)
+		case "BYHOUR":
+			r.ByHour, err = parseIntList(value, 0, 23, true)
+		case "BYMINUTE":
+			r.ByMinute, err = parseIntList(value, 0, 59, true)
+		case "BYSETPOS":
+			r.BySetPos, err = parseIntList(value, -366, 366, false)
+		case "WKST":
+			r.WeekStart, err = parseWeekday(value)
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+
+	if !hasFreq {
+		return nil, fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT and UNTIL cannot both be set")
+	}
+	if r.Freq == Weekly && len(r.ByMonthDay) > 0 {
+		return nil, fmt.Errorf("BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	if r.Freq == Daily || r.Freq == Weekly {
+		for _, wd := range r.ByDay {
+			if wd.N != 0 {
+				return nil, fmt.Errorf("BYDAY ordinals require FREQ=MONTHLY or FREQ=YEARLY")
+			}
+		}
+	}
+	return r, nil
+}
+
+func parseFrequency(value string) (Frequency, error) {
+	for freq, name := range frequencyNames {
+		if name == value {
+			return freq, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported frequency %s", value)
+}
+
+func parsePositive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q is not a positive integer", value)
+	}
+	return n, nil
+}
+
+func parseIntList(value string, min, max int, allowZero bool) ([]int, error) {
+	var list []int
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(item)
+		if err != nil || n < min || n > max || (n == 0 && !allowZero) {
+			return nil, fmt.Errorf("%q is out of range", item)
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func parseWeekday(value string) (time.Weekday, error) {
+	for i, name := range weekdayNames {
+		if name == value {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", value)
+}
+
+func parseWeekdayNums(value string) ([]WeekdayNum, error) {
+	var list []WeekdayNum
+	for _, item := range strings.Split(value, ",") {
+		if len(item) < 2 {
+			return nil, fmt.Errorf("invalid weekday %q", item)
+		}
+		weekday, err := parseWeekday(item[len(item)-2:])
+		if err != nil {
+			return nil, err
+		}
+		wd := WeekdayNum{Weekday: weekday}
+		if prefix := item[:len(item)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, fmt.Errorf("invalid weekday ordinal %q", item)
+			}
+			wd.N = n
+		}
+		list = append(list, wd)
+	}
+	return list, nil
+}
+
+func (r *Rule) parseUntil(value string) error {
+	var err error
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		r.Until, err = time.Parse("20060102T150405Z", value)
+	case strings.Contains(value, "T"):
+		r.Until, err = time.Parse("20060102T150405", value)
+		r.untilFloating = true
+	default:
+		r.Until, err = time.Parse("20060102", value)
+		r.untilFloating = true
+		r.untilDate = true
+	}
+	return err
+}
+
+// String formats the rule as an RRULE value without the "RRULE:" prefix.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + frequencyNames[r.Freq]}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		switch {
+		case r.untilDate:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102"))
+		case r.untilFloating:
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+		default:
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = weekdayNames[wd.Weekday]
+			if wd.N != 0 {
+				days[i] = strconv.Itoa(wd.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	parts = appendIntList(parts, "BYMONTHDAY", r.ByMonthDay)
+	parts = appendIntList(parts, "BYMONTH", r.ByMonth)
+	parts = appendIntList(parts, "BYHOUR", r.ByHour)
+	parts = appendIntList(parts, "BYMINUTE", r.ByMinute)
+	parts = appendIntList(parts, "BYSETPOS", r.BySetPos)
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+func appendIntList(parts []string, key string, list []int) []string {
+	if len(list) == 0 {
+		return parts
+	}
+	items := make([]string, len(list))
+	for i, n := range list {
+		items[i] = strconv.Itoa(n)
+	}
+	return append(parts, key+"="+strings.Join(items, ","))
+}
+
+// Remaining returns the rule for the occurrences after the first one. It
+// returns nil when the first occurrence was the last one allowed by COUNT.
+func (r *Rule) Remaining() *Rule {
+	next := *r
+	if r.Count > 0 {
+		if r.Count == 1 {
+			return nil
+		}
+		next.Count--
+	}
+	return &next
+}
+
+// All returns up to limit occurrences of the rule starting at dtstart. As in
+// RFC 5545, dtstart is always the first occurrence.
+func (r *Rule) All(dtstart time.Time, limit int) []time.Time {
+	var occurrences []time.Time
+	if limit <= 0 {
+		return occurrences
+	}
+	r.iterate(dtstart, func(t time.Time) bool {
+		occurrences = append(occurrences, t)
+		return len(occurrences) < limit
+	})
+	return occurrences
+}
+
+// Between returns the occurrences of the rule starting at dtstart that fall
+// within [from, to).
+func (r *Rule) Between(dtstart, from, to time.Time) []time.Time {
+	var occurrences []time.Time
+	r.iterate(dtstart, func(t time.Time) bool {
+		if !t.Before(to) {
+			return false
+		}
+		if !t.Before(from) {
+			occurrences = append(occurrences, t)
+		}
+		return true
+	})
+	return occurrences
+}
+
+// After returns the first occurrence of the rule starting at dtstart that is
+// strictly after t. The second result is false when there is none.
+func (r *Rule) After(dtstart, t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	r.iterate(dtstart, func(occurrence time.Time) bool {
+		if occurrence.After(t) {
+			next, found = occurrence, true
+			return false
+		}
+		return true
+	})
+	return next, found
+}
+
+// iterate calls fn with each occurrence in order until fn returns false or
+// the rule is exhausted.
+func (r *Rule) iterate(dtstart time.Time, fn func(time.Time) bool) {
+	dtstart = dtstart.Truncate(time.Second)
+	loc := dtstart.Location()
+
+	var until time.Time
+	hasUntil := !r.Until.IsZero()
+	if hasUntil {
+		until = r.Until
+		if r.untilFloating {
+			u := r.Until
+			until = time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc)
+			if r.untilDate {
+				until = until.AddDate(0, 0, 1).Add(-time.Second)
+			}
+		}
+	}
+
+	emitted := 0
+	emit := func(t time.Time) bool {
+		if hasUntil && t.After(until) {
+			return false
+		}
+		emitted++
+		if !fn(t) {
+			return false
+		}
+		return r.Count == 0 || emitted < r.Count
+	}
+
+	if !emit(dtstart) {
+		return
+	}
+
+	period := r.firstPeriod(civil(dtstart))
+	for i := 0; i < maxPeriods; i++ {
+		for _, occurrence := range r.expand(period, dtstart) {
+			if !occurrence.After(dtstart) {
+				continue
+			}
+			if !emit(occurrence) {
+				return
+			}
+		}
+		period = r.nextPeriod(period)
+		if hasUntil && period.After(civil(until)) {
+			return
+		}
+	}
+}
+
+// civil returns the wall-clock date of t as midnight UTC, which makes day
+// arithmetic independent of daylight saving changes.
+func civil(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// wallTime returns the given wall-clock time on day in loc. A time that
+// falls into a daylight saving gap is read with the UTC offset in effect
+// before the gap, as RFC 5545 requires, so 02:30 becomes 03:30.
+func wallTime(day time.Time, hour, minute, second int, loc *time.Location) time.Time {
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, loc)
+	if t.Hour() == hour && t.Minute() == minute {
+		return t
+	}
+	naive := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, second, 0, time.UTC)
+	_, offset := naive.Add(-24 * time.Hour).In(loc).Zone()
+	return naive.Add(-time.Duration(offset) * time.Second).In(loc)
+}
+
+func (r *Rule) firstPeriod(day time.Time) time.Time {
+	switch r.Freq {
+	case Weekly:
+		offset := (int(day.Weekday()) - int(r.WeekStart) + 7) % 7
+		return day.AddDate(0, 0, -offset)
+	case Monthly:
+		return time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case Yearly:
+		return time.Date(day.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return day
+	}
+}
+
+func (r *Rule) nextPeriod(period time.Time) time.Time {
+	switch r.Freq {
+	case Weekly:
+		return period.AddDate(0, 0, 7*r.Interval)
+	case Monthly:
+		return period.AddDate(0, r.Interval, 0)
+	case Yearly:
+		return period.AddDate(r.Interval, 0, 0)
+	default:
+		return period.AddDate(0, 0, r.Interval)
+	}
+}
+
+// expand returns the sorted occurrences within one period.
+func (r *Rule) expand(period, dtstart time.Time) []time.Time {
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		if r.matchesMonth(period) && r.matchesMonthDay(period) && r.matchesWeekday(period) {
+			days = []time.Time{period}
+		}
+	case Weekly:
+		for i := 0; i < 7; i++ {
+			day := period.AddDate(0, 0, i)
+			if len(r.ByDay) == 0 && day.Weekday() != dtstart.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(day) && r.matchesMonth(day) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		if r.matchesMonth(period) {
+			days = r.monthDays(period.Year(), period.Month(), dtstart)
+		}
+	case Yearly:
+		days = r.yearDays(period.Year(), dtstart)
+	}
+
+	hours := r.ByHour
+	if len(hours) == 0 {
+		hours = []int{dtstart.Hour()}
+	}
+	minutes := r.ByMinute
+	if len(minutes) == 0 {
+		minutes = []int{dtstart.Minute()}
+	}
+
+	var occurrences []time.Time
+	for _, day := range days {
+		for _, hour := range hours {
+			for _, minute := range minutes {
+				occurrences = append(occurrences, wallTime(day, hour, minute, dtstart.Second(), dtstart.Location()))
+			}
+		}
+	}
+	sort.Slice(occurrences, func(i, j int) bool {
+		return occurrences[i].Before(occurrences[j])
+	})
+	return r.applySetPos(occurrences)
+}
+
+func (r *Rule) monthDays(year int, month time.Month, dtstart time.Time) []time.Time {
+	all := daysInRange(time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC))
+
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		if dtstart.Day() <= len(all) {
+			return []time.Time{all[dtstart.Day()-1]}
+		}
+		return nil
+	}
+
+	var days []time.Time
+	byWeekday := weekdaySelection(all, r.ByDay)
+	for _, day := range all {
+		if len(r.ByMonthDay) > 0 && !r.matchesMonthDay(day) {
+			continue
+		}
+		if len(r.ByDay) > 0 && !byWeekday[day] {
+			continue
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+func (r *Rule) yearDays(year int, dtstart time.Time) []time.Time {
+	switch {
+	case len(r.ByMonth) > 0:
+		var days []time.Time
+		for _, month := range sortedInts(r.ByMonth) {
+			days = append(days, r.monthDays(year, time.Month(month), dtstart)...)
+		}
+		return days
+	case len(r.ByMonthDay) > 0:
+		var days []time.Time
+		for month := time.January; month <= time.December; month++ {
+			days = append(days, r.monthDays(year, month, dtstart)...)
+		}
+		return days
+	case len(r.ByDay) > 0:
+		all := daysInRange(time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC))
+		byWeekday := weekdaySelection(all, r.ByDay)
+		var days []time.Time
+		for _, day := range all {
+			if byWeekday[day] {
+				days = append(days, day)
+			}
+		}
+		return days
+	default:
+		day := time.Date(year, dtstart.Month(), dtstart.Day(), 0, 0, 0, 0, time.UTC)
+		if day.Month() != dtstart.Month() {
+			// February 29th in a non-leap year
+			return nil
+		}
+		return []time.Time{day}
+	}
+}
+
+func daysInRange(from, to time.Time) []time.Time {
+	var days []time.Time
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// weekdaySelection returns the days in span selected by the BYDAY entries,
+// where ordinals count within the span.
+func weekdaySelection(span []time.Time, byDay []WeekdayNum) map[time.Time]bool {
+	selected := make(map[time.Time]bool)
+	for _, wd := range byDay {
+		var matches []time.Time
+		for _, day := range span {
+			if day.Weekday() == wd.Weekday {
+				matches = append(matches, day)
+			}
+		}
+		switch {
+		case wd.N == 0:
+			for _, day := range matches {
+				selected[day] = true
+			}
+		case wd.N > 0 && wd.N <= len(matches):
+			selected[matches[wd.N-1]] = true
+		case wd.N < 0 && -wd.N <= len(matches):
+			selected[matches[len(matches)+wd.N]] = true
+		}
+	}
+	return selected
+}
+
+func (r *Rule) matchesMonth(day time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, month := range r.ByMonth {
+		if time.Month(month) == day.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesMonthDay(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, n := range r.ByMonthDay {
+		if n == day.Day() || (n < 0 && daysInMonth+n+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesWeekday(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Weekday == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) applySetPos(occurrences []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return occurrences
+	}
+	var selected []time.Time
+	seen := make(map[int]bool)
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(occurrences) + pos
+		}
+		if i >= 0 && i < len(occurrences) && !seen[i] {
+			seen[i] = true
+			selected = append(selected, occurrences[i])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		return selected[i].Before(selected[j])
+	})
+	return selected
+}
+
+func sortedInts(list []int) []int {
+	sorted := append([]int(nil), list...)
+	sort.Ints(sorted)
+	return sorted
+}
@@ -0,0 +1,518 @@
+package recurrence
+
+import (
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+const localLayout = "20060102T150405"
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load %s: %v", name, err)
+	}
+	return loc
+}
+
+func mustParse(t *testing.T, rule string) *Rule {
+	t.Helper()
+	r, err := Parse(rule)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", rule, err)
+	}
+	return r
+}
+
+func localTime(t *testing.T, s string, loc *time.Location) time.Time {
+	t.Helper()
+	v, err := time.ParseInLocation(localLayout, s, loc)
+	if err != nil {
+		t.Fatalf("parse %s: %v", s, err)
+	}
+	return v
+}
+
+// formatLocal formats occurrences as wall-clock times in loc.
+func formatLocal(times []time.Time, loc *time.Location) []string {
+	out := make([]string, len(times))
+	for i, v := range times {
+		out[i] = v.In(loc).Format(localLayout)
+	}
+	return out
+}
+
+// days expands a compact list of dates, each at the given time of day. An
+// entry is a full date (YYYYMMDD), a month and day of the previous entry's
+// year (MMDD), or a day of the previous entry's month (DD).
+func days(clock string, entries ...string) []string {
+	var out []string
+	year, month := "", ""
+	for _, entry := range entries {
+		switch len(entry) {
+		case 8:
+			year, month = entry[:4], entry[4:6]
+			out = append(out, entry+"T"+clock)
+		case 4:
+			month = entry[:2]
+			out = append(out, year+entry+"T"+clock)
+		default:
+			out = append(out, year+month+entry+"T"+clock)
+		}
+	}
+	return out
+}
+
+func checkOccurrences(t *testing.T, got []time.Time, loc *time.Location, want []string) {
+	t.Helper()
+	formatted := formatLocal(got, loc)
+	if strings.Join(formatted, " ") != strings.Join(want, " ") {
+		t.Errorf("occurrences:\n got  %v\n want %v", formatted, want)
+	}
+}
+
+// TestRFC5545Examples checks the examples of RFC 5545 section 3.8.5.3 that
+// use the supported rule parts. All of them start in America/New_York.
+func TestRFC5545Examples(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	tests := []struct {
+		name    string
+		dtstart string
+		rule    string
+		limit   int
+		skip    int // leading occurrences left out, for examples that exclude DTSTART
+		want    []string
+	}{
+		{
+			name:    "daily for 10 occurrences",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=DAILY;COUNT=10",
+			limit:   100,
+			want:    days("090000", "19970902", "03", "04", "05", "06", "07", "08", "09", "10", "11"),
+		},
+		{
+			name:    "every other day",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=DAILY;INTERVAL=2",
+			limit:   5,
+			want:    days("090000", "19970902", "04", "06", "08", "10"),
+		},
+		{
+			name:    "every 10 days, 5 occurrences",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=DAILY;INTERVAL=10;COUNT=5",
+			limit:   100,
+			want:    days("090000", "19970902", "12", "22", "1002", "12"),
+		},
+		{
+			name:    "weekly for 10 occurrences",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=WEEKLY;COUNT=10",
+			limit:   100,
+			want:    days("090000", "19970902", "09", "16", "23", "30", "1007", "14", "21", "28", "1104"),
+		},
+		{
+			name:    "weekly on Tuesday and Thursday for five weeks",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH",
+			limit:   100,
+			want:    days("090000", "19970902", "04", "09", "11", "16", "18", "23", "25", "30", "1002"),
+		},
+		{
+			name:    "weekly on Tuesday and Thursday, 10 occurrences",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=WEEKLY;COUNT=10;WKST=SU;BYDAY=TU,TH",
+			limit:   100,
+			want:    days("090000", "19970902", "04", "09", "11", "16", "18", "23", "25", "30", "1002"),
+		},
+		{
+			name:    "every other week on Monday, Wednesday and Friday",
+			dtstart: "19970901T090000",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;UNTIL=19971224T000000Z;WKST=SU;BYDAY=MO,WE,FR",
+			limit:   100,
+			want: days("090000", "19970901", "03", "05", "15", "17", "19", "29", "1001", "03", "13", "15", "17",
+				"27", "29", "31", "1110", "12", "14", "24", "26", "28", "1208", "10", "12", "22"),
+		},
+		{
+			name:    "every other week on Tuesday and Thursday, 8 occurrences",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=8;WKST=SU;BYDAY=TU,TH",
+			limit:   100,
+			want:    days("090000", "19970902", "04", "16", "18", "30", "1002", "14", "16"),
+		},
+		{
+			name:    "monthly on the first Friday for 10 occurrences",
+			dtstart: "19970905T090000",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYDAY=1FR",
+			limit:   100,
+			want: days("090000", "19970905", "1003", "1107", "1205", "19980102", "0206", "0306", "0403", "0501",
+				"0605"),
+		},
+		{
+			name:    "every other month on the first and last Sunday",
+			dtstart: "19970907T090000",
+			rule:    "FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU",
+			limit:   100,
+			want: days("090000", "19970907", "28", "1102", "30", "19980104", "25", "0301", "29", "0503",
+				"31"),
+		},
+		{
+			name:    "monthly on the second-to-last Monday for 6 months",
+			dtstart: "19970922T090000",
+			rule:    "FREQ=MONTHLY;COUNT=6;BYDAY=-2MO",
+			limit:   100,
+			want:    days("090000", "19970922", "1020", "1117", "1222", "19980119", "0216"),
+		},
+		{
+			name:    "monthly on the third-to-last day",
+			dtstart: "19970928T090000",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-3",
+			limit:   6,
+			want:    days("090000", "19970928", "1029", "1128", "1229", "19980129", "0226"),
+		},
+		{
+			name:    "monthly on the 2nd and 15th for 10 occurrences",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15",
+			limit:   100,
+			want: days("090000", "19970902", "15", "1002", "15", "1102", "15", "1202", "15", "19980102",
+				"15"),
+		},
+		{
+			name:    "monthly on the first and last day for 10 occurrences",
+			dtstart: "19970930T090000",
+			rule:    "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1",
+			limit:   100,
+			want: days("090000", "19970930", "1001", "31", "1101", "30", "1201", "31", "19980101", "31",
+				"0201"),
+		},
+		{
+			name:    "yearly in June and July for 10 occurrences",
+			dtstart: "19970610T090000",
+			rule:    "FREQ=YEARLY;COUNT=10;BYMONTH=6,7",
+			limit:   100,
+			want: days("090000", "19970610", "0710", "19980610", "0710", "19990610", "0710", "20000610", "0710",
+				"20010610", "0710"),
+		},
+		{
+			name:    "every 20th Monday of the year",
+			dtstart: "19970519T090000",
+			rule:    "FREQ=YEARLY;BYDAY=20MO",
+			limit:   3,
+			want:    days("090000", "19970519", "19980518", "19990517"),
+		},
+		{
+			name:    "every Thursday in March",
+			dtstart: "19970313T090000",
+			rule:    "FREQ=YEARLY;BYMONTH=3;BYDAY=TH",
+			limit:   11,
+			want: days("090000", "19970313", "20", "27", "19980305", "12", "19", "26", "19990304", "11", "18",
+				"25"),
+		},
+		{
+			name:    "every Friday the 13th",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13",
+			limit:   5,
+			skip:    1,
+			want:    days("090000", "19980213", "0313", "1113", "19990813", "20001013"),
+		},
+		{
+			name:    "first Saturday that follows the first Sunday of the month",
+			dtstart: "19970913T090000",
+			rule:    "FREQ=MONTHLY;BYDAY=SA;BYMONTHDAY=7,8,9,10,11,12,13",
+			limit:   10,
+			want: days("090000", "19970913", "1011", "1108", "1213", "19980110", "0207", "0307", "0411", "0509",
+				"0613"),
+		},
+		{
+			name:    "US presidential election day every four years",
+			dtstart: "19961105T090000",
+			rule:    "FREQ=YEARLY;INTERVAL=4;BYMONTH=11;BYDAY=TU;BYMONTHDAY=2,3,4,5,6,7,8",
+			limit:   3,
+			want:    days("090000", "19961105", "20001107", "20041102"),
+		},
+		{
+			name:    "third Tuesday, Wednesday or Thursday of the month for 3 months",
+			dtstart: "19970904T090000",
+			rule:    "FREQ=MONTHLY;COUNT=3;BYDAY=TU,WE,TH;BYSETPOS=3",
+			limit:   100,
+			want:    days("090000", "19970904", "1007", "1106"),
+		},
+		{
+			name:    "second-to-last weekday of the month",
+			dtstart: "19970929T090000",
+			rule:    "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-2",
+			limit:   7,
+			want:    days("090000", "19970929", "1030", "1127", "1230", "19980129", "0226", "0330"),
+		},
+		{
+			name:    "every 20 minutes from 9:00 to 16:40 on two days",
+			dtstart: "19970902T090000",
+			rule:    "FREQ=DAILY;BYHOUR=9,10,11,12,13,14,15,16;BYMINUTE=0,20,40",
+			limit:   26,
+			want: append(append(
+				days("090000", "19970902"),
+				"19970902T092000", "19970902T094000", "19970902T100000", "19970902T102000", "19970902T104000",
+				"19970902T110000", "19970902T112000", "19970902T114000", "19970902T120000", "19970902T122000",
+				"19970902T124000", "19970902T130000", "19970902T132000", "19970902T134000", "19970902T140000",
+				"19970902T142000", "19970902T144000", "19970902T150000", "19970902T152000", "19970902T154000",
+				"19970902T160000", "19970902T162000", "19970902T164000"),
+				"19970903T090000", "19970903T092000"),
+		},
+		{
+			name:    "week start Monday changes which weeks are skipped",
+			dtstart: "19970805T090000",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO",
+			limit:   100,
+			want:    days("090000", "19970805", "10", "19", "24"),
+		},
+		{
+			name:    "week start Sunday changes which weeks are skipped",
+			dtstart: "19970805T090000",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU",
+			limit:   100,
+			want:    days("090000", "19970805", "17", "19", "31"),
+		},
+		{
+			name:    "invalid dates such as February 30th are ignored",
+			dtstart: "20070115T090000",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=15,30;COUNT=5",
+			limit:   100,
+			want:    days("090000", "20070115", "30", "0215", "0315", "30"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := mustParse(t, tt.rule)
+			got := rule.All(localTime(t, tt.dtstart, ny), tt.limit+tt.skip)
+			if len(got) < tt.skip {
+				t.Fatalf("got %d occurrences, want more than %d", len(got), tt.skip)
+			}
+			checkOccurrences(t, got[tt.skip:], ny, tt.want)
+		})
+	}
+}
+
+func TestDailyUntilDecember(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	rule := mustParse(t, "FREQ=DAILY;UNTIL=19971224T000000Z")
+	got := rule.All(localTime(t, "19970902T090000", ny), 1000)
+	if len(got) != 113 {
+		t.Fatalf("got %d occurrences, want 113", len(got))
+	}
+	checkOccurrences(t, []time.Time{got[0], got[len(got)-1]}, ny, []string{"19970902T090000", "19971223T090000"})
+}
+
+func TestEveryDayInJanuaryForThreeYears(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	for _, rule := range []string{
+		"FREQ=YEARLY;UNTIL=20000131T140000Z;BYMONTH=1;BYDAY=SU,MO,TU,WE,TH,FR,SA",
+		"FREQ=DAILY;UNTIL=20000131T140000Z;BYMONTH=1",
+	} {
+		got := mustParse(t, rule).All(localTime(t, "19980101T090000", ny), 1000)
+		if len(got) != 93 {
+			t.Errorf("%s: got %d occurrences, want 93", rule, len(got))
+			continue
+		}
+		checkOccurrences(t, []time.Time{got[0], got[31], got[92]}, ny,
+			[]string{"19980101T090000", "19990101T090000", "20000131T090000"})
+	}
+}
+
+func TestCountAndUntil(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	dtstart := localTime(t, "19970902T090000", ny)
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		// 09:00 EDT is 13:00 UTC, so midnight UTC on the 5th ends the
+		// rule after the 4th.
+		{"FREQ=DAILY;UNTIL=19970905T000000Z", days("090000", "19970902", "03", "04")},
+		// UNTIL at exactly an occurrence includes it.
+		{"FREQ=DAILY;UNTIL=19970905T130000Z", days("090000", "19970902", "03", "04", "05")},
+		// A floating UNTIL is read in the start date's zone.
+		{"FREQ=DAILY;UNTIL=19970905T085959", days("090000", "19970902", "03", "04")},
+		// A date-only UNTIL covers the whole day.
+		{"FREQ=DAILY;UNTIL=19970905", days("090000", "19970902", "03", "04", "05")},
+		{"FREQ=DAILY;COUNT=4", days("090000", "19970902", "03", "04", "05")},
+		{"FREQ=DAILY;COUNT=1", days("090000", "19970902")},
+		// DTSTART is always the first occurrence, even when the rule does
+		// not select it.
+		{"FREQ=WEEKLY;BYDAY=MO;COUNT=2", days("090000", "19970902", "08")},
+	}
+	for _, tt := range tests {
+		got := mustParse(t, tt.rule).All(dtstart, 100)
+		if strings.Join(formatLocal(got, ny), " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s:\n got  %v\n want %v", tt.rule, formatLocal(got, ny), tt.want)
+		}
+	}
+
+	if _, err := Parse("FREQ=DAILY;COUNT=3;UNTIL=19970905"); err == nil {
+		t.Error("COUNT with UNTIL was accepted")
+	}
+}
+
+func TestLastDayOfMonth(t *testing.T) {
+	got := mustParse(t, "FREQ=MONTHLY;BYMONTHDAY=-1;COUNT=6").All(time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC), 100)
+	checkOccurrences(t, got, time.UTC, days("090000", "20240131", "0229", "0331", "0430", "0531", "0630"))
+}
+
+func TestMonthlyOn31stSkipsShortMonths(t *testing.T) {
+	got := mustParse(t, "FREQ=MONTHLY;COUNT=4").All(time.Date(2024, 1, 31, 9, 0, 0, 0, time.UTC), 100)
+	checkOccurrences(t, got, time.UTC, days("090000", "20240131", "0331", "0531", "0731"))
+}
+
+func TestFebruary29(t *testing.T) {
+	leap := time.Date(2024, 2, 29, 9, 0, 0, 0, time.UTC)
+
+	got := mustParse(t, "FREQ=YEARLY;COUNT=3").All(leap, 100)
+	checkOccurrences(t, got, time.UTC, days("090000", "20240229", "20280229", "20320229"))
+
+	got = mustParse(t, "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1;COUNT=3").All(leap, 100)
+	checkOccurrences(t, got, time.UTC, days("090000", "20240229", "20250228", "20260228"))
+
+	next, ok := mustParse(t, "FREQ=YEARLY").After(leap, leap)
+	if !ok || !next.Equal(time.Date(2028, 2, 29, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("After = %v, %v; want 2028-02-29", next, ok)
+	}
+}
+
+func TestImpossibleRuleTerminates(t *testing.T) {
+	start := time.Date(2024, 1, 30, 9, 0, 0, 0, time.UTC)
+	got := mustParse(t, "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30").All(start, 5)
+	checkOccurrences(t, got, time.UTC, days("090000", "20240130"))
+	if _, ok := mustParse(t, "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30").After(start, start); ok {
+		t.Error("After found an occurrence of February 30th")
+	}
+}
+
+func TestBySetPos(t *testing.T) {
+	start := time.Date(2024, 1, 31, 17, 0, 0, 0, time.UTC)
+	// Last workday of the month.
+	got := mustParse(t, "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1;COUNT=4").All(start, 100)
+	checkOccurrences(t, got, time.UTC, days("170000", "20240131", "0229", "0329", "0430"))
+
+	// First and last workday of the month, in order even when listed the
+	// other way round.
+	start = time.Date(2024, 4, 1, 17, 0, 0, 0, time.UTC)
+	got = mustParse(t, "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1,1;COUNT=4").All(start, 100)
+	checkOccurrences(t, got, time.UTC, days("170000", "20240401", "30", "0501", "31"))
+
+	// Positions beyond the set select nothing, so months with four Mondays
+	// are skipped.
+	got = mustParse(t, "FREQ=MONTHLY;BYDAY=MO;BYSETPOS=5").All(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), 5)
+	checkOccurrences(t, got, time.UTC, days("090000", "20240101", "29", "0429", "0729", "0930"))
+}
+
+func TestDaylightSavingGap(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	// 02:30 does not exist on 10 March 2024; it is read with the offset
+	// before the gap, which makes it 03:30 EDT.
+	got := mustParse(t, "FREQ=DAILY;COUNT=3").All(time.Date(2024, 3, 9, 2, 30, 0, 0, ny), 100)
+	checkOccurrences(t, got, ny, []string{"20240309T023000", "20240310T033000", "20240311T023000"})
+	if want := time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC); !got[1].Equal(want) {
+		t.Errorf("occurrence in the gap = %v, want %v", got[1].UTC(), want)
+	}
+}
+
+func TestDaylightSavingOverlap(t *testing.T) {
+	ny := mustLoad(t, "America/New_York")
+	// 01:30 happens twice on 3 November 2024; the first one, in EDT, is
+	// used.
+	got := mustParse(t, "FREQ=DAILY;COUNT=3").All(time.Date(2024, 11, 2, 1, 30, 0, 0, ny), 100)
+	checkOccurrences(t, got, ny, []string{"20241102T013000", "20241103T013000", "20241104T013000"})
+	if want := time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC); !got[1].Equal(want) {
+		t.Errorf("occurrence in the overlap = %v, want %v", got[1].UTC(), want)
+	}
+}
+
+func TestWallClockKeptAcrossDaylightSaving(t *testing.T) {
+	berlin := mustLoad(t, "Europe/Berlin")
+	got := mustParse(t, "FREQ=WEEKLY;COUNT=3").All(time.Date(2024, 3, 24, 9, 0, 0, 0, berlin), 100)
+	checkOccurrences(t, got, berlin, days("090000", "20240324", "31", "0407"))
+	if got[1].Sub(got[0]) != 7*24*time.Hour-time.Hour {
+		t.Errorf("week across the change lasted %v", got[1].Sub(got[0]))
+	}
+}
+
+func TestBetweenAndAfter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	rule := mustParse(t, "FREQ=WEEKLY;BYDAY=MO,FR")
+
+	got := rule.Between(start, time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC), time.Date(2024, 1, 12, 9, 0, 0, 0, time.UTC))
+	checkOccurrences(t, got, time.UTC, days("090000", "20240105", "08"))
+
+	next, ok := rule.After(start, time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC))
+	if !ok || !next.Equal(time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("After = %v, %v; want 2024-01-08 09:00", next, ok)
+	}
+
+	if _, ok := mustParse(t, "FREQ=DAILY;COUNT=2").After(start, start.AddDate(0, 0, 1)); ok {
+		t.Error("After found an occurrence past COUNT")
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	rule := mustParse(t, "FREQ=DAILY;COUNT=2")
+	rest := rule.Remaining()
+	if rest == nil || rest.Count != 1 {
+		t.Fatalf("Remaining = %v, want COUNT=1", rest)
+	}
+	if rest.Remaining() != nil {
+		t.Error("Remaining of the last occurrence is not nil")
+	}
+	if rule.Count != 2 {
+		t.Error("Remaining changed the rule")
+	}
+	if mustParse(t, "FREQ=DAILY").Remaining() == nil {
+		t.Error("Remaining of an endless rule is nil")
+	}
+}
+
+func TestParseAndString(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE", "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{"freq=monthly;byday=-1fr;interval=1", "FREQ=MONTHLY;BYDAY=-1FR"},
+		{"FREQ=DAILY;UNTIL=20240301", "FREQ=DAILY;UNTIL=20240301"},
+		{"FREQ=DAILY;UNTIL=20240301T120000", "FREQ=DAILY;UNTIL=20240301T120000"},
+		{"FREQ=DAILY;UNTIL=20240301T120000Z", "FREQ=DAILY;UNTIL=20240301T120000Z"},
+		{"FREQ=YEARLY;INTERVAL=2;COUNT=3;BYMONTH=2;BYMONTHDAY=-1;WKST=SU",
+			"FREQ=YEARLY;INTERVAL=2;COUNT=3;BYMONTHDAY=-1;BYMONTH=2;WKST=SU"},
+		{"FREQ=MONTHLY;BYDAY=MO,TU;BYSETPOS=-1;BYHOUR=9;BYMINUTE=30",
+			"FREQ=MONTHLY;BYDAY=MO,TU;BYHOUR=9;BYMINUTE=30;BYSETPOS=-1"},
+	}
+	for _, tt := range tests {
+		if got := mustParse(t, tt.in).String(); got != tt.out {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"BYDAY=MO",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1",
+		"FREQ=DAILY;BYMONTHDAY=0",
+		"FREQ=DAILY;BYMONTHDAY=32",
+		"FREQ=DAILY;BYMONTH=13",
+		"FREQ=DAILY;BYHOUR=24",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=MONTHLY;BYDAY=0MO",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=DAILY;UNTIL=2024",
+		"FREQ=DAILY;BYSECOND=1",
+		"FREQ=DAILY;COUNT",
+	} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
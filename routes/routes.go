@@ -8,6 +8,8 @@ import (
 	"time"
 	"todo-app/controllers"
 	"todo-app/middleware"
+	"todo-app/notify"
+	"todo-app/realtime"
 
 	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
@@ -17,13 +19,21 @@ type RouterConfig struct {
 	Session       *gocql.Session
 	Templates     *template.Template
 	ComponentsDir string
+	// Mailer sends workspace invitations.
+	Mailer notify.Notifier
+	// Broker delivers the changes pushed over the event stream.
+	Broker realtime.Broker
+	// Presence keeps who is viewing or editing workspaces and tasks.
+	Presence realtime.PresenceStore
 }
 
 func NewRouter(config RouterConfig) *mux.Router {
 	router := mux.NewRouter()
 
+	router.Use(middleware.RequestIDMiddleware)
 	router.Use(middleware.LoggingMiddleware)
 	router.Use(middleware.NoCacheMiddleware)
+	router.Use(middleware.IdempotencyMiddleware(config.Session))
 
 	// Static file server
 	fs := http.FileServer(http.Dir("static"))
@@ -45,13 +55,32 @@ func NewRouter(config RouterConfig) *mux.Router {
 	userCtrl := controllers.NewUserController(config.Session)
 	taskCtrl := controllers.NewTaskController(config.Session)
 	categoryCtrl := controllers.NewCategoryController(config.Session)
+	dependencyCtrl := controllers.NewDependencyController(config.Session)
+	reminderCtrl := controllers.NewReminderController(config.Session)
+	notificationCtrl := controllers.NewNotificationController(config.Session)
+	workflowCtrl := controllers.NewWorkflowController(config.Session)
+	trashCtrl := controllers.NewTrashController(config.Session)
+	bulkCtrl := controllers.NewBulkController(config.Session)
+	tagCtrl := controllers.NewTagController(config.Session)
+	attachmentCtrl := controllers.NewAttachmentController(config.Session)
+	commentCtrl := controllers.NewCommentController(config.Session)
+	workspaceCtrl := controllers.NewWorkspaceController(config.Session, config.Mailer)
+	projectCtrl := controllers.NewProjectController(config.Session)
+	shareCtrl := controllers.NewShareController(config.Session, config.Templates)
+	eventCtrl := controllers.NewEventController(config.Session, config.Broker)
+	collabCtrl := controllers.NewCollabController(config.Session, config.Presence)
+	syncCtrl := controllers.NewSyncController(config.Session)
+	webhookCtrl := controllers.NewWebhookController(config.Session)
+	automationCtrl := controllers.NewAutomationController(config.Session)
+	templateCtrl := controllers.NewTemplateController(config.Session)
 
 	// API routes
 	api := router.PathPrefix("/api/v1").Subrouter()
 
 	// Public routes
-	api.HandleFunc("/login", userCtrl.Login).Methods("POST")
-	api.HandleFunc("/register", userCtrl.CreateUser).Methods("POST")
+	api.HandleFunc("/login", middleware.NotReplayed(userCtrl.Login)).Methods("POST")
+	api.HandleFunc("/register", middleware.ReplayStatusOnly(userCtrl.CreateUser)).Methods("POST")
+	api.HandleFunc("/shared/{token}", shareCtrl.ViewShared).Methods("GET")
 
 	// Protected routes
 	protected := api.PathPrefix("").Subrouter()
@@ -63,17 +92,132 @@ func NewRouter(config RouterConfig) *mux.Router {
 
 	// Protected Task routes
 	protected.HandleFunc("/tasks", taskCtrl.CreateTask).Methods("POST")
+	protected.HandleFunc("/tasks/graph", dependencyCtrl.GetGraph).Methods("GET")
+	protected.HandleFunc("/tasks/bulk", bulkCtrl.BulkTasks).Methods("POST")
+	protected.HandleFunc("/tasks/assigned", taskCtrl.GetAssignedTasks).Methods("GET")
 	protected.HandleFunc("/tasks/{id}", taskCtrl.GetTask).Methods("GET")
 	protected.HandleFunc("/tasks", taskCtrl.GetAllTasks).Methods("GET")
 	protected.HandleFunc("/tasks/{id}", taskCtrl.UpdateTask).Methods("PUT")
+	protected.HandleFunc("/tasks/{id}", taskCtrl.PatchTask).Methods("PATCH")
 	protected.HandleFunc("/tasks/{id}", taskCtrl.DeleteTask).Methods("DELETE")
+	protected.HandleFunc("/tasks/{id}/skip", taskCtrl.SkipOccurrence).Methods("POST")
+	protected.HandleFunc("/tasks/{id}/history", taskCtrl.GetTaskHistory).Methods("GET")
+	protected.HandleFunc("/tasks/{id}/restore", taskCtrl.RestoreTask).Methods("POST")
+
+	// Protected Task template routes
+	protected.HandleFunc("/templates", templateCtrl.CreateTemplate).Methods("POST")
+	protected.HandleFunc("/templates", templateCtrl.GetTemplates).Methods("GET")
+	protected.HandleFunc("/templates/{id}", templateCtrl.GetTemplate).Methods("GET")
+	protected.HandleFunc("/templates/{id}", templateCtrl.UpdateTemplate).Methods("PUT")
+	protected.HandleFunc("/templates/{id}", templateCtrl.DeleteTemplate).Methods("DELETE")
+	protected.HandleFunc("/templates/{id}/instantiate", templateCtrl.InstantiateTemplate).Methods("POST")
+	protected.HandleFunc("/tasks/{id}/template", templateCtrl.CreateTemplateFromTask).Methods("POST")
+
+	// Protected Task dependency routes
+	protected.HandleFunc("/tasks/{id}/dependencies", dependencyCtrl.AddDependency).Methods("POST")
+	protected.HandleFunc("/tasks/{id}/dependencies/{blocker_id}", dependencyCtrl.DeleteDependency).Methods("DELETE")
+
+	// Protected Reminder routes
+	protected.HandleFunc("/tasks/{id}/reminders", reminderCtrl.CreateReminder).Methods("POST")
+	protected.HandleFunc("/tasks/{id}/reminders", reminderCtrl.GetReminders).Methods("GET")
+	protected.HandleFunc("/tasks/{id}/reminders/{reminder_id}", reminderCtrl.DeleteReminder).Methods("DELETE")
+
+	// Protected Attachment routes
+	protected.HandleFunc("/tasks/{id}/attachments", attachmentCtrl.CreateAttachment).Methods("POST")
+	protected.HandleFunc("/tasks/{id}/attachments", attachmentCtrl.GetAttachments).Methods("GET")
+	protected.HandleFunc("/tasks/{id}/attachments/{attachment_id}", attachmentCtrl.DownloadAttachment).Methods("GET")
+	protected.HandleFunc("/tasks/{id}/attachments/{attachment_id}", attachmentCtrl.DeleteAttachment).Methods("DELETE")
+
+	// Protected Comment routes
+	protected.HandleFunc("/tasks/{id}/comments", commentCtrl.CreateComment).Methods("POST")
+	protected.HandleFunc("/tasks/{id}/comments", commentCtrl.GetComments).Methods("GET")
+	protected.HandleFunc("/tasks/{id}/comments/{comment_id}", commentCtrl.UpdateComment).Methods("PUT")
+	protected.HandleFunc("/tasks/{id}/comments/{comment_id}", commentCtrl.DeleteComment).Methods("DELETE")
+
+	// Protected Real-time routes
+	protected.HandleFunc("/events", eventCtrl.StreamEvents).Methods("GET")
+	protected.HandleFunc("/ws", collabCtrl.Connect).Methods("GET")
+
+	// Protected Offline sync routes
+	protected.HandleFunc("/sync", syncCtrl.GetChanges).Methods("GET")
+	protected.HandleFunc("/sync", syncCtrl.PushChanges).Methods("POST")
+
+	// Protected Workflow routes
+	protected.HandleFunc("/workflow", workflowCtrl.GetWorkflow).Methods("GET")
+	protected.HandleFunc("/workflow", workflowCtrl.UpdateWorkflow).Methods("PUT")
+
+	// Protected Notification routes
+	protected.HandleFunc("/notifications", notificationCtrl.GetNotifications).Methods("GET")
+	protected.HandleFunc("/notifications/{id}/read", notificationCtrl.MarkRead).Methods("POST")
 
 	// Protected Category routes
 	protected.HandleFunc("/categories", categoryCtrl.CreateCategory).Methods("POST")
 	protected.HandleFunc("/categories/{id}", categoryCtrl.GetCategory).Methods("GET")
 	protected.HandleFunc("/categories", categoryCtrl.GetAllCategories).Methods("GET")
 	protected.HandleFunc("/categories/{id}", categoryCtrl.UpdateCategory).Methods("PUT")
+	protected.HandleFunc("/categories/{id}", categoryCtrl.PatchCategory).Methods("PATCH")
 	protected.HandleFunc("/categories/{id}", categoryCtrl.DeleteCategory).Methods("DELETE")
+	protected.HandleFunc("/categories/{id}/restore", categoryCtrl.RestoreCategory).Methods("POST")
+
+	// Protected Tag routes
+	protected.HandleFunc("/tags", tagCtrl.GetTags).Methods("GET")
+	protected.HandleFunc("/tags/autocomplete", tagCtrl.AutocompleteTags).Methods("GET")
+	protected.HandleFunc("/tags/{tag}/tasks", tagCtrl.GetTasksByTag).Methods("GET")
+	protected.HandleFunc("/tags/{tag}/rename", tagCtrl.RenameTag).Methods("POST")
+
+	// Protected Workspace routes
+	protected.HandleFunc("/workspaces", workspaceCtrl.GetWorkspaces).Methods("GET")
+	protected.HandleFunc("/workspaces", workspaceCtrl.CreateWorkspace).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}", workspaceCtrl.GetWorkspace).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}", workspaceCtrl.UpdateWorkspace).Methods("PUT")
+	protected.HandleFunc("/workspaces/{id}/members", workspaceCtrl.GetMembers).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/members/{user_id}", workspaceCtrl.UpdateMember).Methods("PUT")
+	protected.HandleFunc("/workspaces/{id}/members/{user_id}", workspaceCtrl.RemoveMember).Methods("DELETE")
+	protected.HandleFunc("/workspaces/{id}/invitations", middleware.ReplayStatusOnly(workspaceCtrl.CreateInvitation)).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}/invitations", workspaceCtrl.GetInvitations).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/invitations/{invitation_id}", workspaceCtrl.RevokeInvitation).Methods("DELETE")
+	protected.HandleFunc("/invitations/{token}/accept", workspaceCtrl.AcceptInvitation).Methods("POST")
+
+	// Protected Project routes
+	protected.HandleFunc("/workspaces/{id}/projects", projectCtrl.CreateProject).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}/projects", projectCtrl.GetProjects).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/projects/{project_id}", projectCtrl.GetProject).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/projects/{project_id}", projectCtrl.UpdateProject).Methods("PUT")
+	protected.HandleFunc("/workspaces/{id}/projects/{project_id}", projectCtrl.DeleteProject).Methods("DELETE")
+
+	// Protected Share link routes
+	protected.HandleFunc("/workspaces/{id}/shares", middleware.ReplayStatusOnly(shareCtrl.CreateShareLink)).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}/shares", shareCtrl.GetShareLinks).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/shares/{link_id}", shareCtrl.GetShareLink).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/shares/{link_id}", shareCtrl.RevokeShareLink).Methods("DELETE")
+	protected.HandleFunc("/workspaces/{id}/shares/{link_id}/uses", shareCtrl.GetShareAccesses).Methods("GET")
+
+	// Protected Webhook routes
+	protected.HandleFunc("/workspaces/{id}/webhooks", middleware.ReplayStatusOnly(webhookCtrl.CreateWebhook)).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}/webhooks", webhookCtrl.GetWebhooks).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}", webhookCtrl.GetWebhook).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}", webhookCtrl.UpdateWebhook).Methods("PUT")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}", webhookCtrl.DeleteWebhook).Methods("DELETE")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}/secret", middleware.ReplayStatusOnly(webhookCtrl.RotateWebhookSecret)).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}/ping", webhookCtrl.PingWebhook).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}/deliveries", webhookCtrl.GetWebhookDeliveries).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}/deliveries/{delivery_id}", webhookCtrl.GetWebhookDelivery).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver", webhookCtrl.RedeliverWebhook).Methods("POST")
+
+	// Protected Automation routes
+	protected.HandleFunc("/workspaces/{id}/automations", automationCtrl.CreateAutomation).Methods("POST")
+	protected.HandleFunc("/workspaces/{id}/automations", automationCtrl.GetAutomations).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/automations/{rule_id}", automationCtrl.GetAutomation).Methods("GET")
+	protected.HandleFunc("/workspaces/{id}/automations/{rule_id}", automationCtrl.UpdateAutomation).Methods("PUT")
+	protected.HandleFunc("/workspaces/{id}/automations/{rule_id}", automationCtrl.DeleteAutomation).Methods("DELETE")
+	protected.HandleFunc("/workspaces/{id}/automations/{rule_id}/runs", automationCtrl.GetAutomationRuns).Methods("GET")
+	protected.HandleFunc("/tasks/{id}/automations/dry-run", automationCtrl.DryRunAutomations).Methods("POST")
+
+	// Protected Trash routes
+	protected.HandleFunc("/trash", trashCtrl.GetTrash).Methods("GET")
+
+	// Public share link page
+	router.HandleFunc("/s/{token}", middleware.NotReplayed(shareCtrl.ViewSharedPage)).Methods("GET", "POST")
 
 	// Main route handler
 	router.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
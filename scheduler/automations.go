@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// AutomationScheduler polls the queue of due dates and runs the due_passed
+// automation rules of tasks whose due date has passed while they were still
+// open. Each entry is claimed with a lightweight transaction, so the rules
+// run at most once for it; an instance dying before it has run them loses
+// the entry.
+type AutomationScheduler struct {
+	session *gocql.Session
+
+	// Interval is how often the queue is polled and Lookback how far back
+	// overdue buckets are scanned.
+	Interval time.Duration
+	Lookback time.Duration
+}
+
+func NewAutomationScheduler(session *gocql.Session) *AutomationScheduler {
+	return &AutomationScheduler{
+		session:  session,
+		Interval: 30 * time.Second,
+		Lookback: 24 * time.Hour,
+	}
+}
+
+// Run polls until ctx is cancelled.
+func (s *AutomationScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AutomationScheduler) poll(ctx context.Context) {
+	now := time.Now().UTC()
+	for t := now.Add(-s.Lookback).Truncate(time.Hour); !t.After(now); t = t.Add(time.Hour) {
+		due, err := models.GetDueTasks(s.session, models.DueTaskBucket(t), now)
+		if err != nil {
+			log.Printf("Failed to read due date queue: %v", err)
+			return
+		}
+		for _, pending := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			s.fire(pending)
+		}
+	}
+}
+
+func (s *AutomationScheduler) fire(pending models.PendingDueTask) {
+	task, err := models.GetTaskByID(s.session, pending.TaskID)
+	if err == gocql.ErrNotFound {
+		models.DeletePendingDueTask(s.session, pending)
+		return
+	} else if err != nil {
+		log.Printf("Failed to load task %s: %v", pending.TaskID, err)
+		return
+	}
+
+	// Entries left behind by a new due date no longer match the task, and
+	// tasks closed in time are not overdue.
+	workflow, err := models.GetWorkflow(s.session, task.WorkspaceID)
+	if err != nil {
+		log.Printf("Failed to load workflow of task %s: %v", task.TaskID, err)
+		return
+	}
+	if task.DueAt == nil || !task.DueAt.Equal(pending.DueAt) || workflow.IsClosed(task.Status) {
+		models.DeletePendingDueTask(s.session, pending)
+		return
+	}
+
+	claimed, err := models.ClaimDueTask(s.session, pending)
+	if err != nil {
+		log.Printf("Failed to claim due date of task %s: %v", task.TaskID, err)
+	}
+	if !claimed {
+		return
+	}
+
+	occurred := []models.AutomationTrigger{{Type: models.TriggerDuePassed}}
+	if err := models.RunAutomations(s.session, gocql.TimeUUID(), task, occurred, 0); err != nil {
+		log.Printf("Failed to run automations for due date of task %s: %v", task.TaskID, err)
+	}
+}
@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+	"todo-app/eventbus"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// MaxOutboxAttempts is how many times a subscriber is handed an event before
+// it is set aside as a dead letter and the subscriber moves on. With the
+// backoff between attempts, that is after about a quarter of an hour.
+const MaxOutboxAttempts = 10
+
+// OutboxDispatcher polls the outbox and hands each event to every subscriber
+// of the bus, in the order the events were written. Each subscriber's
+// progress is recorded in its cursor, leased to one instance at a time. An
+// event is handed over again until the subscriber handles it, so it may see
+// an event twice when an instance dies before recording its progress.
+type OutboxDispatcher struct {
+	session    *gocql.Session
+	bus        *eventbus.Bus
+	instanceID string
+
+	// Interval is how often the outbox is polled, Lease how long a
+	// subscriber's cursor is held and BatchSize how many events are read at
+	// a time.
+	Interval  time.Duration
+	Lease     time.Duration
+	BatchSize int
+}
+
+func NewOutboxDispatcher(session *gocql.Session, bus *eventbus.Bus, instanceID string) *OutboxDispatcher {
+	return &OutboxDispatcher{
+		session:    session,
+		bus:        bus,
+		instanceID: instanceID,
+		Interval:   2 * time.Second,
+		Lease:      30 * time.Second,
+		BatchSize:  200,
+	}
+}
+
+// Run polls until ctx is cancelled.
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, subscriber := range d.bus.Subscribers() {
+			if ctx.Err() != nil {
+				return
+			}
+			d.dispatch(ctx, subscriber)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch hands the subscriber the events past its cursor, batch after
+// batch, until it has caught up, fails or its lease runs low.
+func (d *OutboxDispatcher) dispatch(ctx context.Context, subscriber string) {
+	cursor, err := models.AcquireOutboxCursor(d.session, subscriber, d.instanceID, d.Lease)
+	if err != nil {
+		log.Printf("Failed to acquire outbox cursor of %s: %v", subscriber, err)
+		return
+	}
+	if cursor == nil {
+		return
+	}
+	// Failed events are retried with exponential backoff.
+	if cursor.Attempts > 0 && time.Since(cursor.UpdatedAt) < time.Second<<uint(cursor.Attempts) {
+		return
+	}
+
+	deadline := time.Now().Add(d.Lease / 2)
+	for ctx.Err() == nil && time.Now().Before(deadline) {
+		until := time.Now().Add(-models.OutboxSettleTime)
+		events, err := models.GetOutboxEvents(d.session, cursor.Position, until, d.BatchSize)
+		if err != nil {
+			log.Printf("Failed to read outbox for %s: %v", subscriber, err)
+			return
+		}
+		if len(events) == 0 || !d.deliver(ctx, cursor, events) || len(events) < d.BatchSize {
+			return
+		}
+	}
+}
+
+// deliver hands the events to the cursor's subscriber in order and records
+// how far it got. It returns false if the subscriber failed an event.
+func (d *OutboxDispatcher) deliver(ctx context.Context, cursor *models.OutboxCursor, events []models.OutboxEvent) bool {
+	position := cursor.Position
+	for _, e := range events {
+		if !d.bus.Handles(cursor.Subscriber, e.Name) {
+			position = e.EventID
+			continue
+		}
+
+		err := d.bus.Deliver(ctx, cursor.Subscriber, e.Meta(), e.Payload)
+		if err == nil {
+			position = e.EventID
+			continue
+		}
+
+		log.Printf("Subscriber %s failed %s event %s: %v", cursor.Subscriber, e.Name, e.EventID, err)
+		if recordErr := cursor.RecordFailure(d.session, position, err); recordErr != nil {
+			log.Printf("Failed to update outbox cursor of %s: %v", cursor.Subscriber, recordErr)
+			return false
+		}
+		if cursor.Attempts < MaxOutboxAttempts {
+			return false
+		}
+		log.Printf("Subscriber %s gave up on %s event %s", cursor.Subscriber, e.Name, e.EventID)
+		if err := cursor.DeadLetter(d.session, e, err); err != nil {
+			log.Printf("Failed to record dead letter of %s: %v", cursor.Subscriber, err)
+			return false
+		}
+		position = e.EventID
+	}
+
+	if err := cursor.Advance(d.session, position); err != nil {
+		log.Printf("Failed to update outbox cursor of %s: %v", cursor.Subscriber, err)
+		return false
+	}
+	return true
+}
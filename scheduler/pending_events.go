@@ -0,0 +1,67 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// PendingEventSweeper writes the history entries, sync log entries and
+// domain events that conditional writes to tasks and categories staged but
+// did not get to write, see models.ReconcilePendingEvent. Only what is still
+// pending is written, so several instances may run it at once; a domain
+// event two of them write at the same moment reaches subscribers twice,
+// which the outbox dispatcher allows for anyway.
+type PendingEventSweeper struct {
+	session *gocql.Session
+
+	// Interval is how often intents are checked, and Grace how old they
+	// must be, so that writes still under way are left to finish.
+	Interval time.Duration
+	Grace    time.Duration
+}
+
+func NewPendingEventSweeper(session *gocql.Session) *PendingEventSweeper {
+	return &PendingEventSweeper{
+		session:  session,
+		Interval: time.Minute,
+		Grace:    time.Minute,
+	}
+}
+
+// Run sweeps until ctx is cancelled.
+func (s *PendingEventSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *PendingEventSweeper) sweep(ctx context.Context) {
+	until := time.Now().UTC().Add(-s.Grace)
+	for t := until.Add(-models.PendingEventRetention).Truncate(time.Hour); !t.After(until); t = t.Add(time.Hour) {
+		pending, err := models.GetPendingEvents(s.session, models.PendingEventBucket(t), until)
+		if err != nil {
+			log.Printf("Failed to read pending events: %v", err)
+			return
+		}
+		for _, e := range pending {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := models.ReconcilePendingEvent(s.session, e); err != nil {
+				log.Printf("Failed to reconcile pending event %s of %s %s: %v", e.EventID, e.Kind, e.ItemID, err)
+			}
+		}
+	}
+}
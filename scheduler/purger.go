@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// TrashPurger permanently deletes items that have been in the trash longer
+// than models.TrashRetention. Purging is idempotent, so several instances
+// may run it at once.
+type TrashPurger struct {
+	session *gocql.Session
+
+	// Interval is how often the trash is checked.
+	Interval time.Duration
+}
+
+func NewTrashPurger(session *gocql.Session) *TrashPurger {
+	return &TrashPurger{
+		session:  session,
+		Interval: time.Hour,
+	}
+}
+
+// Run purges until ctx is cancelled.
+func (p *TrashPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := models.PurgeTrash(p.session, time.Now().Add(-models.TrashRetention))
+		if err != nil {
+			log.Printf("Failed to purge trash: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d items from the trash", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
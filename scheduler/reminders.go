@@ -0,0 +1,216 @@
+// Package scheduler runs the server's background jobs.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+	"todo-app/models"
+	"todo-app/notify"
+
+	"github.com/gocql/gocql"
+)
+
+// ReminderScheduler polls the pending reminder queue and delivers due
+// reminders. Several instances can run against the same keyspace: each
+// reminder is claimed with a lightweight transaction before delivery, so it
+// fires at most once. A reminder whose instance dies mid-delivery is left in
+// the firing state rather than risk a second delivery.
+type ReminderScheduler struct {
+	session    *gocql.Session
+	notifiers  map[string]notify.Notifier
+	instanceID string
+
+	// Interval is how often the queue is polled, Lookback how far back
+	// overdue buckets are scanned and Lease how long a claim is held.
+	// Buckets older than Lookback are swept once on start and after a
+	// failed poll, so that reminders queued before downtime still fire.
+	Interval time.Duration
+	Lookback time.Duration
+	Lease    time.Duration
+
+	swept bool
+}
+
+// NewReminderScheduler creates a scheduler delivering through the given
+// notifiers, keyed by reminder channel.
+func NewReminderScheduler(session *gocql.Session, notifiers map[string]notify.Notifier, instanceID string) *ReminderScheduler {
+	return &ReminderScheduler{
+		session:    session,
+		notifiers:  notifiers,
+		instanceID: instanceID,
+		Interval:   30 * time.Second,
+		Lookback:   24 * time.Hour,
+		Lease:      2 * time.Minute,
+	}
+}
+
+// Run polls until ctx is cancelled.
+func (s *ReminderScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ReminderScheduler) poll(ctx context.Context) {
+	now := time.Now().UTC()
+	swept := s.swept
+	var buckets []string
+	if !swept {
+		all, err := models.GetReminderBuckets(s.session)
+		if err != nil {
+			log.Printf("Failed to list reminder queue buckets: %v", err)
+		}
+		swept = err == nil
+		from := models.ReminderBucket(now.Add(-s.Lookback))
+		for _, bucket := range all {
+			if bucket < from {
+				buckets = append(buckets, bucket)
+			}
+		}
+	}
+	for t := now.Add(-s.Lookback).Truncate(time.Hour); !t.After(now); t = t.Add(time.Hour) {
+		buckets = append(buckets, models.ReminderBucket(t))
+	}
+
+	for _, bucket := range buckets {
+		due, err := models.GetDueReminders(s.session, bucket, now)
+		if err != nil {
+			log.Printf("Failed to read reminder queue: %v", err)
+			s.swept = false
+			return
+		}
+		for _, pending := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			s.fire(ctx, pending)
+		}
+	}
+	s.swept = swept
+}
+
+func (s *ReminderScheduler) fire(ctx context.Context, pending models.PendingReminder) {
+	reminder, err := models.GetReminderByID(s.session, pending.ReminderID)
+	if err == gocql.ErrNotFound {
+		models.DeletePendingReminder(s.session, pending)
+		return
+	} else if err != nil {
+		log.Printf("Failed to load reminder %s: %v", pending.ReminderID, err)
+		return
+	}
+
+	// Queue entries left behind by a reschedule no longer match the reminder
+	if reminder.State != models.ReminderPending || !reminder.FireAt.Equal(pending.FireAt) {
+		models.DeletePendingReminder(s.session, pending)
+		return
+	}
+
+	claimed, err := reminder.Claim(s.session, s.instanceID, s.Lease)
+	if err != nil {
+		log.Printf("Failed to claim reminder %s: %v", reminder.ReminderID, err)
+	}
+	if !claimed {
+		return
+	}
+
+	// Reminders of tasks that were trashed or closed are no longer wanted.
+	task, err := models.GetTaskByID(s.session, reminder.TaskID)
+	if err == gocql.ErrNotFound {
+		s.cancel(reminder)
+		return
+	} else if err != nil {
+		s.finish(reminder, fmt.Errorf("task unavailable: %v", err), true)
+		return
+	}
+	workflow, err := models.GetWorkflow(s.session, task.WorkspaceID)
+	if err != nil {
+		s.finish(reminder, fmt.Errorf("workflow unavailable: %v", err), true)
+		return
+	}
+	if workflow.IsClosed(task.Status) {
+		s.cancel(reminder)
+		return
+	}
+
+	notifier, ok := s.notifiers[reminder.Channel]
+	if !ok {
+		s.finish(reminder, fmt.Errorf("no notifier for channel %q", reminder.Channel), false)
+		return
+	}
+
+	msg, err := s.message(reminder, task)
+	if err != nil {
+		s.finish(reminder, err, false)
+		return
+	}
+
+	deliverCtx, cancel := context.WithTimeout(ctx, s.Lease/2)
+	defer cancel()
+	s.finish(reminder, notifier.Notify(deliverCtx, msg), true)
+}
+
+func (s *ReminderScheduler) cancel(reminder *models.Reminder) {
+	if err := reminder.MarkCancelled(s.session); err != nil {
+		log.Printf("Failed to update reminder %s: %v", reminder.ReminderID, err)
+	}
+}
+
+// finish records the outcome of a delivery attempt. Failed attempts are
+// retried with exponential backoff when retry is set.
+func (s *ReminderScheduler) finish(reminder *models.Reminder, deliveryErr error, retry bool) {
+	var err error
+	switch {
+	case deliveryErr == nil:
+		err = reminder.MarkSent(s.session)
+	case retry:
+		log.Printf("Reminder %s delivery failed: %v", reminder.ReminderID, deliveryErr)
+		backoff := time.Minute << uint(reminder.Attempts)
+		err = reminder.MarkAttemptFailed(s.session, deliveryErr, time.Now().Add(backoff))
+	default:
+		log.Printf("Reminder %s cannot be delivered: %v", reminder.ReminderID, deliveryErr)
+		err = reminder.MarkFailed(s.session, deliveryErr)
+	}
+	if err != nil {
+		log.Printf("Failed to update reminder %s: %v", reminder.ReminderID, err)
+	}
+}
+
+func (s *ReminderScheduler) message(reminder *models.Reminder, task *models.Task) (notify.Message, error) {
+	due := "soon"
+	if task.DueAt != nil {
+		loc, err := time.LoadLocation(task.TimeZone)
+		if err != nil {
+			loc = time.UTC
+		}
+		due = "at " + task.DueAt.In(loc).Format("Mon Jan 2 15:04 MST")
+	}
+
+	recipient := reminder.Target
+	if reminder.Channel == models.ChannelEmail && recipient == "" {
+		user, err := models.GetUserByID(s.session, reminder.UserID)
+		if err != nil {
+			return notify.Message{}, fmt.Errorf("failed to look up recipient: %v", err)
+		}
+		recipient = user.Email
+	}
+
+	taskID := task.TaskID
+	return notify.Message{
+		UserID:    reminder.UserID,
+		TaskID:    &taskID,
+		Kind:      "reminder",
+		Recipient: recipient,
+		Subject:   "Reminder: " + task.Title,
+		Body:      fmt.Sprintf("%q is due %s.", task.Title, due),
+	}, nil
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+	"todo-app/models"
+
+	"github.com/gocql/gocql"
+)
+
+// ThumbnailWorker generates the thumbnails of uploaded images in the
+// background. Generating is idempotent, so several instances may run it at
+// once; downloads of missing thumbnails queue them again.
+type ThumbnailWorker struct {
+	session *gocql.Session
+
+	// Interval is how often pending thumbnails are checked.
+	Interval time.Duration
+}
+
+func NewThumbnailWorker(session *gocql.Session) *ThumbnailWorker {
+	return &ThumbnailWorker{
+		session:  session,
+		Interval: 5 * time.Second,
+	}
+}
+
+// Run generates thumbnails until ctx is cancelled.
+func (w *ThumbnailWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		generated, err := models.ProcessThumbnailJobs(ctx, w.session)
+		if err != nil {
+			log.Printf("Failed to generate thumbnails: %v", err)
+		}
+		if generated > 0 {
+			log.Printf("Generated thumbnails for %d attachments", generated)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
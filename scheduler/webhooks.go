@@ -0,0 +1,158 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"todo-app/models"
+	"todo-app/webhook"
+
+	"github.com/gocql/gocql"
+)
+
+var (
+	errWebhookDeleted  = errors.New("webhook was deleted")
+	errWebhookDisabled = errors.New("webhook is disabled")
+)
+
+// WebhookDispatcher polls the pending webhook delivery queue and sends due
+// deliveries. Like reminders, each delivery is claimed with a lightweight
+// transaction before it is attempted, and one whose instance dies mid-attempt
+// is left in the delivering state; it can be redelivered by hand.
+type WebhookDispatcher struct {
+	session    *gocql.Session
+	sender     *webhook.Sender
+	instanceID string
+
+	// Interval is how often the queue is polled, Lookback how far back
+	// overdue buckets are scanned and Lease how long a claim is held.
+	Interval time.Duration
+	Lookback time.Duration
+	Lease    time.Duration
+}
+
+func NewWebhookDispatcher(session *gocql.Session, sender *webhook.Sender, instanceID string) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		session:    session,
+		sender:     sender,
+		instanceID: instanceID,
+		Interval:   5 * time.Second,
+		Lookback:   24 * time.Hour,
+		Lease:      time.Minute,
+	}
+}
+
+// Run polls until ctx is cancelled.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) poll(ctx context.Context) {
+	now := time.Now().UTC()
+	for t := now.Add(-d.Lookback).Truncate(time.Hour); !t.After(now); t = t.Add(time.Hour) {
+		due, err := models.GetDueWebhookDeliveries(d.session, models.WebhookBucket(t), now)
+		if err != nil {
+			log.Printf("Failed to read webhook delivery queue: %v", err)
+			return
+		}
+		for _, pending := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			d.deliver(ctx, pending)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliver(ctx context.Context, pending models.PendingWebhookDelivery) {
+	delivery, err := models.GetWebhookDelivery(d.session, pending.WebhookID, pending.DeliveryID)
+	if errors.Is(err, models.ErrWebhookDeliveryNotFound) {
+		models.DeletePendingWebhookDelivery(d.session, pending)
+		return
+	} else if err != nil {
+		log.Printf("Failed to load webhook delivery %s: %v", pending.DeliveryID, err)
+		return
+	}
+
+	// Queue entries left behind by an earlier attempt no longer match
+	if delivery.State != models.WebhookDeliveryPending || !delivery.NextAttemptAt.Equal(pending.AttemptAt) {
+		models.DeletePendingWebhookDelivery(d.session, pending)
+		return
+	}
+
+	claimed, err := delivery.Claim(d.session, d.instanceID, d.Lease)
+	if err != nil {
+		log.Printf("Failed to claim webhook delivery %s: %v", delivery.DeliveryID, err)
+	}
+	if !claimed {
+		return
+	}
+
+	hook, err := models.GetWebhook(d.session, delivery.WorkspaceID, delivery.WebhookID)
+	switch {
+	case errors.Is(err, models.ErrWebhookNotFound):
+		d.abandon(delivery, errWebhookDeleted)
+		return
+	case err != nil:
+		d.abandon(delivery, fmt.Errorf("webhook unavailable: %v", err))
+		return
+	case !hook.Enabled:
+		d.abandon(delivery, errWebhookDisabled)
+		return
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, d.Lease/2)
+	defer cancel()
+	attempt := models.WebhookAttempt{Attempt: delivery.Attempts + 1, AttemptedAt: time.Now().UTC()}
+	result, sendErr := d.sender.Send(sendCtx, delivery.Request(hook))
+	attempt.StatusCode = result.StatusCode
+	attempt.Response = result.Response
+	attempt.DurationMs = result.Duration.Milliseconds()
+	if sendErr != nil {
+		attempt.Error = sendErr.Error()
+	}
+	d.finish(delivery, hook, attempt, sendErr)
+}
+
+// finish records the outcome of an attempt against the delivery and its
+// endpoint. Failed attempts are retried with exponential backoff.
+func (d *WebhookDispatcher) finish(delivery *models.WebhookDelivery, hook *models.Webhook, attempt models.WebhookAttempt, sendErr error) {
+	var err error
+	if sendErr == nil {
+		err = delivery.MarkDelivered(d.session, attempt)
+		if hookErr := hook.RecordSuccess(d.session); hookErr != nil {
+			log.Printf("Failed to update webhook %s: %v", hook.WebhookID, hookErr)
+		}
+	} else {
+		log.Printf("Webhook delivery %s failed: %v", delivery.DeliveryID, sendErr)
+		err = delivery.MarkAttemptFailed(d.session, attempt)
+		if hookErr := hook.RecordFailure(d.session, sendErr); hookErr != nil {
+			log.Printf("Failed to update webhook %s: %v", hook.WebhookID, hookErr)
+		} else if !hook.Enabled {
+			log.Printf("Webhook %s disabled: %s", hook.WebhookID, hook.DisabledReason)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to update webhook delivery %s: %v", delivery.DeliveryID, err)
+	}
+}
+
+// abandon gives up on a delivery that cannot be attempted.
+func (d *WebhookDispatcher) abandon(delivery *models.WebhookDelivery, cause error) {
+	log.Printf("Webhook delivery %s cannot be attempted: %v", delivery.DeliveryID, cause)
+	if err := delivery.MarkFailed(d.session, models.WebhookAttempt{Error: cause.Error()}); err != nil {
+		log.Printf("Failed to update webhook delivery %s: %v", delivery.DeliveryID, err)
+	}
+}
@@ -131,7 +131,7 @@ class App {
                 console.log('Tasks array:', tasks); // Debug log
 
                 tasksList.innerHTML = tasks.map(task => `
-                <div class="task-item" data-id="${task.task_id}">
+                <div class="task-item" data-id="${task.task_id}" data-version="${task.version}">
                     <div class="task-content">
                         <h3 class="task-title">${task.title}</h3>
                         <p class="task-description">${task.description || 'No description'}</p>
@@ -151,6 +151,29 @@ class App {
         }
     }
 
+    static async loadStatuses() {
+        try {
+            const select = document.getElementById('task-status');
+            if (!select) {
+                return;
+            }
+
+            const response = await API.getWorkflow();
+            if (response.status === 'success' && response.data && Array.isArray(response.data.statuses)) {
+                // Status names are chosen by workspace editors, so they are
+                // set as text rather than parsed as markup
+                select.replaceChildren(...response.data.statuses.map(status => {
+                    const option = document.createElement('option');
+                    option.value = status.key;
+                    option.textContent = status.name;
+                    return option;
+                }));
+            }
+        } catch (error) {
+            console.error('Error loading statuses:', error);
+        }
+    }
+
     static async handleAddTask(event) {
         event.preventDefault();
         event.stopPropagation();
@@ -176,7 +199,7 @@ class App {
         try {
             if (editId) {
                 // Update existing task
-                await App.handleUpdateTask(editId, taskData);
+                await App.handleUpdateTask(editId, taskData, submitButton.getAttribute('data-edit-version'));
             } else {
                 // Create new task
                 const response = await API.createTask(taskData);
@@ -194,9 +217,14 @@ class App {
 
     static async handleDeleteTask(taskId) {
         try {
-            const response = await API.deleteTask(taskId);
+            const taskElement = document.querySelector(`[data-id="${taskId}"]`);
+            const version = taskElement ? taskElement.dataset.version : undefined;
+            const response = await API.deleteTask(taskId, version);
             if (response.status === 'success') {
                 await App.loadTasks();
+            } else if (response.conflict) {
+                alert(response.message);
+                await App.loadTasks();
             }
         } catch (error) {
             console.error('Error deleting task:', error);
@@ -225,6 +253,7 @@ class App {
             const submitButton = form.querySelector('button[type="submit"]');
             submitButton.textContent = 'Update Task';
             submitButton.setAttribute('data-edit-id', taskId);
+            submitButton.setAttribute('data-edit-version', taskElement.dataset.version);
             
             // Scroll to form
             form.scrollIntoView({ behavior: 'smooth' });
@@ -234,16 +263,21 @@ class App {
         }
     }
 
-    static async handleUpdateTask(taskId, taskData) {
+    static async handleUpdateTask(taskId, taskData, version) {
         try {
-            const response = await API.updateTask(taskId, taskData);
-            if (response.status === 'success') {
+            const response = await API.updateTask(taskId, taskData, version);
+            if (response.status === 'success' || response.conflict) {
+                if (response.conflict) {
+                    // Someone else changed the task; show their version instead
+                    alert(response.message);
+                }
                 await App.loadTasks();
                 // Reset form to add mode
                 const form = document.getElementById('add-task-form');
                 const submitButton = form.querySelector('button[type="submit"]');
                 submitButton.textContent = 'Add Task';
                 submitButton.removeAttribute('data-edit-id');
+                submitButton.removeAttribute('data-edit-version');
                 form.reset();
             }
         } catch (error) {
@@ -251,6 +285,25 @@ class App {
         }
     }
 
+    // subscribeToEvents reloads the task list whenever a task changes in
+    // another tab or is changed by a teammate. EventSource reconnects on its
+    // own and resumes from the last event it received.
+    static subscribeToEvents() {
+        if (App.events || !window.EventSource) {
+            return;
+        }
+        const token = localStorage.getItem('token');
+        App.events = new EventSource(`/api/v1/events?access_token=${encodeURIComponent(token)}`);
+
+        let pending = null;
+        const reload = () => {
+            clearTimeout(pending);
+            pending = setTimeout(() => App.loadTasks(), 200);
+        };
+        ['task.created', 'task.updated', 'task.deleted', 'reset'].forEach(type => {
+            App.events.addEventListener(type, reload);
+        });
+    }
 
     static async init() {
         // Debug: Check localStorage contents
@@ -270,7 +323,9 @@ class App {
         // Only load tasks if the tasks list element exists (meaning we're on the tasks page)
         const tasksList = document.getElementById('tasks-list');
         if (this.isAuthenticated() && tasksList) {
+            await this.loadStatuses();
             await this.loadTasks();
+            this.subscribeToEvents();
         }
     }
 }
@@ -12,6 +12,33 @@ export class API {
         return `Bearer ${token}`;
     }
 
+    // withIfMatch adds an If-Match header so the server rejects the request
+    // when the task changed since it was loaded.
+    static withIfMatch(headers, version) {
+        if (version !== undefined && version !== null && version !== '') {
+            headers['If-Match'] = `"${version}"`;
+        }
+        return headers;
+    }
+
+    // postWithRetry sends an idempotent POST, retrying after network errors
+    // and while the first attempt is still being processed.
+    static async postWithRetry(url, options, attempts = 3) {
+        for (let attempt = 1; ; attempt++) {
+            try {
+                const response = await fetch(url, options);
+                if (response.status !== 409 || !response.headers.has('Retry-After') || attempt >= attempts) {
+                    return response;
+                }
+            } catch (error) {
+                if (attempt >= attempts) {
+                    throw error;
+                }
+            }
+            await new Promise(resolve => setTimeout(resolve, 1000 * attempt));
+        }
+    }
+
     static async login(credentials) {
         const response = await fetch('/api/v1/login', {
             method: 'POST',
@@ -63,11 +90,13 @@ export class API {
 
     static async createTask(taskData) {
         try {
-            const response = await fetch('/api/v1/tasks', {
+            // Retries reuse the key, so the server creates the task only once
+            const response = await this.postWithRetry('/api/v1/tasks', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
-                    'Authorization': this.getAuthHeader()
+                    'Authorization': this.getAuthHeader(),
+                    'Idempotency-Key': crypto.randomUUID()
                 },
                 body: JSON.stringify(taskData)
             });
@@ -96,14 +125,14 @@ export class API {
     }
 
 
-    static async deleteTask(taskId) {
+    static async deleteTask(taskId, version) {
         try {
             const response = await fetch(`/api/v1/tasks/${taskId}`, {
                 method: 'DELETE',
-                headers: {
+                headers: this.withIfMatch({
                     'Content-Type': 'application/json',
                     'Authorization': this.getAuthHeader()
-                }
+                }, version)
             });
             
             if (response.status === 401) {
@@ -113,6 +142,10 @@ export class API {
                 return { status: 'error', message: 'Unauthorized' };
             }
             
+            if (response.status === 412) {
+                return { ...(await response.json()), conflict: true };
+            }
+
             return await response.json();
         } catch (error) {
             console.error('Error in deleteTask:', error);
@@ -129,14 +162,15 @@ export class API {
         }
     }
 
-    static async updateTask(taskId, taskData) {
+    static async updateTask(taskId, taskData, version) {
         try {
+            // A merge patch leaves the fields the form does not edit alone
             const response = await fetch(`/api/v1/tasks/${taskId}`, {
-                method: 'PUT',
-                headers: {
-                    'Content-Type': 'application/json',
+                method: 'PATCH',
+                headers: this.withIfMatch({
+                    'Content-Type': 'application/merge-patch+json',
                     'Authorization': this.getAuthHeader()
-                },
+                }, version),
                 body: JSON.stringify(taskData)
             });
             
@@ -147,6 +181,10 @@ export class API {
                 return { status: 'error', message: 'Unauthorized' };
             }
             
+            if (response.status === 412) {
+                return { ...(await response.json()), conflict: true };
+            }
+
             return await response.json();
         } catch (error) {
             console.error('Error in updateTask:', error);
@@ -162,4 +200,26 @@ export class API {
             throw error;
         }
     }
+
+    static async getWorkflow() {
+        try {
+            const response = await fetch('/api/v1/workflow', {
+                headers: {
+                    'Authorization': this.getAuthHeader()
+                }
+            });
+
+            if (response.status === 401) {
+                console.log('Token expired or invalid, redirecting to login');
+                localStorage.removeItem('token');
+                window.location.href = '/login';
+                return { status: 'error', message: 'Unauthorized' };
+            }
+
+            return await response.json();
+        } catch (error) {
+            console.error('Error in getWorkflow:', error);
+            throw error;
+        }
+    }
 }
@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAccessKeyID     = "AKIDEXAMPLE"
+	testSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+func TestSignKnownRequest(t *testing.T) {
+	store, err := NewS3Store(S3Config{
+		Endpoint:        "http://localhost:9000/",
+		Bucket:          "attachments",
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: testSecretAccessKey,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := store.newRequest(context.Background(), http.MethodGet, "tasks/a b.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.URL.String(), "http://localhost:9000/attachments/tasks/a%20b.txt"; got != want {
+		t.Errorf("URL = %s, want %s", got, want)
+	}
+
+	store.sign(req, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	// Computed independently with openssl from the canonical request.
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+		"Signature=8d7a014feecce9f8721b76a0e0a2f30b7bdf2c9376acf55243657ad4a3fbdd41"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20240102T030405Z" {
+		t.Errorf("X-Amz-Date = %s", got)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != "UNSIGNED-PAYLOAD" {
+		t.Errorf("X-Amz-Content-Sha256 = %s", got)
+	}
+}
+
+// fakeS3 is a minimal path-style S3 endpoint keeping objects in memory. It
+// rejects requests whose signature does not match what it received.
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	ranges  []string
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	fake := &fakeS3{t: t, objects: make(map[string]fakeObject)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := f.checkSignature(r); err != nil {
+		f.t.Errorf("%s %s: %v", r.Method, r.URL.Path, err)
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(data)) != r.ContentLength {
+			http.Error(w, "IncompleteBody", http.StatusBadRequest)
+			return
+		}
+		f.objects[path] = fakeObject{data: data, contentType: r.Header.Get("Content-Type")}
+	case http.MethodGet:
+		obj, ok := f.objects[path]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		spec := r.Header.Get("Range")
+		if spec == "" {
+			w.Write(obj.data)
+			return
+		}
+		f.ranges = append(f.ranges, spec)
+		var start, end int
+		if _, err := fmt.Sscanf(spec, "bytes=%d-%d", &start, &end); err != nil {
+			end = len(obj.data) - 1
+			if _, err := fmt.Sscanf(spec, "bytes=%d-", &start); err != nil {
+				http.Error(w, "InvalidRange", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+		if end >= len(obj.data) {
+			end = len(obj.data) - 1
+		}
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(end)+"/"+strconv.Itoa(len(obj.data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(obj.data[start : end+1])
+	case http.MethodDelete:
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// checkSignature verifies the request's Signature Version 4 Authorization
+// header against the request as received.
+func (f *fakeS3) checkSignature(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	rest, ok := strings.CutPrefix(auth, "AWS4-HMAC-SHA256 ")
+	if !ok {
+		return fmt.Errorf("unexpected Authorization %q", auth)
+	}
+	for _, part := range strings.Split(rest, ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(signedAt).Abs() > 15*time.Minute {
+		return fmt.Errorf("X-Amz-Date %q missing or stale", amzDate)
+	}
+	scope := amzDate[:8] + "/us-east-1/s3/aws4_request"
+	if fields["Credential"] != testAccessKeyID+"/"+scope {
+		return fmt.Errorf("credential %q", fields["Credential"])
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	var canonicalHeaders strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		if value == "" {
+			return fmt.Errorf("signed header %s was not sent", name)
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		r.URL.RawQuery,
+		canonicalHeaders.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+testSecretAccessKey), amzDate[:8])
+	for _, part := range []string{"us-east-1", "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	if want := hex.EncodeToString(hmacSHA256(key, stringToSign)); fields["Signature"] != want {
+		return fmt.Errorf("signature %s, want %s", fields["Signature"], want)
+	}
+	return nil
+}
+
+func newTestS3Store(t *testing.T, endpoint string) *S3Store {
+	t.Helper()
+	store, err := NewS3Store(S3Config{
+		Endpoint:        endpoint,
+		Bucket:          "attachments",
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: testSecretAccessKey,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func readAll(t *testing.T, body io.ReadCloser) string {
+	t.Helper()
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestS3PutGetDelete(t *testing.T) {
+	fake, server := newFakeS3(t)
+	store := newTestS3Store(t, server.URL)
+	ctx := context.Background()
+	key := "tasks/1/report (final).txt"
+	content := "0123456789abcdef"
+
+	if err := store.Put(ctx, key, strings.NewReader(content), int64(len(content)), "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	obj, ok := fake.objects["/attachments/"+key]
+	if !ok || string(obj.data) != content || obj.contentType != "text/plain" {
+		t.Fatalf("stored objects = %v", fake.objects)
+	}
+
+	body, err := store.Get(ctx, key, 0, -1)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := readAll(t, body); got != content {
+		t.Errorf("Get = %q, want %q", got, content)
+	}
+
+	body, err = store.Get(ctx, key, 4, 6)
+	if err != nil {
+		t.Fatalf("Get range: %v", err)
+	}
+	if got := readAll(t, body); got != "456789" {
+		t.Errorf("Get(4, 6) = %q, want 456789", got)
+	}
+	body, err = store.Get(ctx, key, 10, -1)
+	if err != nil {
+		t.Fatalf("Get from offset: %v", err)
+	}
+	if got := readAll(t, body); got != "abcdef" {
+		t.Errorf("Get(10, -1) = %q, want abcdef", got)
+	}
+	if want := []string{"bytes=4-9", "bytes=10-"}; strings.Join(fake.ranges, ",") != strings.Join(want, ",") {
+		t.Errorf("ranges requested = %v, want %v", fake.ranges, want)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key, 0, -1); err != ErrNotFound {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+}
+
+func TestS3ReadSeeker(t *testing.T) {
+	_, server := newFakeS3(t)
+	store := newTestS3Store(t, server.URL)
+	ctx := context.Background()
+	content := "hello, attachments"
+	if err := store.Put(ctx, "blob", strings.NewReader(content), int64(len(content)), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := OpenReadSeeker(ctx, store, "blob", int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	if _, err := rs.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rs)
+	if err != nil || string(data) != "attachments" {
+		t.Errorf("read after seek = %q, %v; want attachments", data, err)
+	}
+
+	if _, err := OpenReadSeeker(ctx, store, "missing", 1); err != ErrNotFound {
+		t.Errorf("OpenReadSeeker of a missing blob: err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestS3ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+	}))
+	defer server.Close()
+	store := newTestS3Store(t, server.URL)
+
+	err := store.Put(context.Background(), "blob", strings.NewReader("x"), 1, "")
+	if err == nil || errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "403") ||
+		!strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Put against a failing server: err = %v", err)
+	}
+	if _, err := store.newRequest(context.Background(), http.MethodGet, "/absolute", nil); err == nil {
+		t.Error("a key starting with a slash was accepted")
+	}
+}
@@ -0,0 +1,103 @@
+// Package storage keeps binary objects such as task attachments in a
+// pluggable blob store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores blobs under string keys. Keys are slash-separated paths
+// chosen by the application.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any blob
+	// already stored there.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+
+	// Get returns a reader for length bytes of the blob starting at offset.
+	// A negative length reads to the end of the blob. It returns ErrNotFound
+	// if there is no blob under key.
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+
+	// Delete removes the blob. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// ReadSeeker exposes a blob of known size as an io.ReadSeeker, so it can be
+// served with http.ServeContent. Seeking is free; the first read from a new
+// position fetches the rest of the blob from there.
+type ReadSeeker struct {
+	ctx    context.Context
+	store  BlobStore
+	key    string
+	size   int64
+	offset int64
+
+	body    io.ReadCloser
+	bodyPos int64
+}
+
+func NewReadSeeker(ctx context.Context, store BlobStore, key string, size int64) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+// OpenReadSeeker is like NewReadSeeker but fetches the blob right away, so a
+// missing blob is reported before anything is read.
+func OpenReadSeeker(ctx context.Context, store BlobStore, key string, size int64) (*ReadSeeker, error) {
+	body, err := store.Get(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	rs := NewReadSeeker(ctx, store, key, size)
+	rs.body = body
+	return rs, nil
+}
+
+func (rs *ReadSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.body != nil && rs.bodyPos != rs.offset {
+		rs.body.Close()
+		rs.body = nil
+	}
+	if rs.body == nil {
+		body, err := rs.store.Get(rs.ctx, rs.key, rs.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		rs.body = body
+		rs.bodyPos = rs.offset
+	}
+	n, err := rs.body.Read(p)
+	rs.offset += int64(n)
+	rs.bodyPos += int64(n)
+	return n, err
+}
+
+func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += rs.offset
+	case io.SeekEnd:
+		offset += rs.size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	rs.offset = offset
+	return offset, nil
+}
+
+// Close releases the underlying reader, if any.
+func (rs *ReadSeeker) Close() error {
+	if rs.body == nil {
+		return nil
+	}
+	err := rs.body.Close()
+	rs.body = nil
+	return err
+}
@@ -0,0 +1,25 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateTasksByAssigneeTable creates the 'tasks_by_assignee' table indexing
+// tasks by the users assigned to them, across workspaces, so a user's
+// assignments can be listed from a single partition.
+func CreateTasksByAssigneeTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS tasks_by_assignee (
+			user_id UUID,
+			task_id UUID,
+			workspace_id UUID,
+			PRIMARY KEY ((user_id), task_id)
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'tasks_by_assignee' table: %v", err)
+	}
+	log.Println("'tasks_by_assignee' table created successfully!")
+}
@@ -0,0 +1,55 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateAttachmentsTable creates the 'attachments' table holding the
+// metadata of files attached to tasks. The contents live in the blob store.
+func CreateAttachmentsTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS attachments (
+			task_id UUID,
+			attachment_id TIMEUUID,
+			user_id UUID,
+			file_name TEXT,
+			content_type TEXT,
+			size BIGINT,
+			sha256 TEXT,
+			storage_key TEXT,
+			created_at TIMESTAMP,
+			thumbnails MAP<TEXT, BIGINT>,
+			thumbnail_error TEXT,
+			PRIMARY KEY (task_id, attachment_id)
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'attachments' table: %v", err)
+	}
+
+	addColumns(session, "attachments", [][2]string{
+		{"thumbnails", "MAP<TEXT, BIGINT>"},
+		{"thumbnail_error", "TEXT"},
+	})
+
+	log.Println("'attachments' table created successfully!")
+}
+
+// CreateThumbnailJobsTable creates the 'thumbnail_jobs' table listing the
+// attachments whose thumbnails still have to be generated.
+func CreateThumbnailJobsTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS thumbnail_jobs (
+			task_id UUID,
+			attachment_id TIMEUUID,
+			created_at TIMESTAMP,
+			PRIMARY KEY ((task_id, attachment_id))
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'thumbnail_jobs' table: %v", err)
+	}
+	log.Println("'thumbnail_jobs' table created successfully!")
+}
@@ -0,0 +1,60 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateAutomationsTables creates the 'automation_rules' of each workspace,
+// the log of their runs, kept per rule and keyed by the event that set the
+// rule off, and the queue of due dates the automation scheduler waits for,
+// bucketed by the hour they fall in.
+func CreateAutomationsTables(session *gocql.Session) {
+	tables := []struct{ name, query string }{
+		{"automation_rules", `
+			CREATE TABLE IF NOT EXISTS automation_rules (
+				workspace_id UUID,
+				rule_id TIMEUUID,
+				name TEXT,
+				enabled BOOLEAN,
+				trigger_type TEXT,
+				trigger_status TEXT,
+				trigger_tag TEXT,
+				conditions TEXT,
+				actions TEXT,
+				created_by UUID,
+				created_at TIMESTAMP,
+				updated_at TIMESTAMP,
+				PRIMARY KEY (workspace_id, rule_id)
+			);
+		`},
+		{"automation_runs", `
+			CREATE TABLE IF NOT EXISTS automation_runs (
+				rule_id TIMEUUID,
+				event_id TIMEUUID,
+				task_id UUID,
+				depth INT,
+				outcome TEXT,
+				error TEXT,
+				started_at TIMESTAMP,
+				finished_at TIMESTAMP,
+				PRIMARY KEY (rule_id, event_id)
+			) WITH CLUSTERING ORDER BY (event_id DESC);
+		`},
+		{"pending_due_tasks", `
+			CREATE TABLE IF NOT EXISTS pending_due_tasks (
+				bucket TEXT,
+				due_at TIMESTAMP,
+				task_id UUID,
+				PRIMARY KEY (bucket, due_at, task_id)
+			);
+		`},
+	}
+	for _, table := range tables {
+		if err := session.Query(table.query).Exec(); err != nil {
+			log.Fatalf("Failed to create '%s' table: %v", table.name, err)
+		}
+	}
+	log.Println("Automation tables created successfully!")
+}
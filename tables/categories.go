@@ -11,12 +11,32 @@ func CreateCategoriesTable(session *gocql.Session) {
 	query := `
 		CREATE TABLE IF NOT EXISTS categories (
 			category_id UUID PRIMARY KEY,
+			workspace_id UUID,
 			name TEXT,
-			created_at TIMESTAMP
+			created_at TIMESTAMP,
+			deleted_at TIMESTAMP,
+			version INT,
+			field_clocks MAP<TEXT, TEXT>,
+			pending_events MAP<TIMEUUID, TEXT>
 		);
 	`
 	if err := session.Query(query).Exec(); err != nil {
 		log.Fatalf("Failed to create 'categories' table: %v", err)
 	}
-	log.Println("'categories' table created successfully!")
+
+	addColumns(session, "categories", [][2]string{
+		{"deleted_at", "TIMESTAMP"},
+		{"version", "INT"},
+		{"field_clocks", "MAP<TEXT, TEXT>"},
+		{"workspace_id", "UUID"},
+		{"pending_events", "MAP<TIMEUUID, TEXT>"},
+	})
+
+	// Create index on workspace_id
+	indexQuery := `CREATE INDEX IF NOT EXISTS ON categories (workspace_id);`
+	if err := session.Query(indexQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create index on categories.workspace_id: %v", err)
+	}
+
+	log.Println("'categories' table and index created successfully!")
 }
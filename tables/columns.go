@@ -0,0 +1,56 @@
+package tables
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// keyspaceName is the keyspace created by keyspace.CreateTodoKeyspace.
+const keyspaceName = "todo"
+
+// addColumns adds any of the given columns that are missing from an existing
+// table. CREATE TABLE IF NOT EXISTS leaves older tables untouched, so new
+// columns have to be added explicitly.
+func addColumns(session *gocql.Session, table string, columns [][2]string) {
+	existing := make(map[string]bool)
+	iter := session.Query(`SELECT column_name FROM system_schema.columns
+		WHERE keyspace_name = ? AND table_name = ?`, keyspaceName, table).Iter()
+	var name string
+	for iter.Scan(&name) {
+		existing[name] = true
+	}
+	if err := iter.Close(); err != nil {
+		log.Fatalf("Failed to read columns of '%s' table: %v", table, err)
+	}
+
+	for _, column := range columns {
+		if existing[column[0]] {
+			continue
+		}
+		query := fmt.Sprintf(`ALTER TABLE %s ADD %s %s`, table, column[0], column[1])
+		if err := session.Query(query).Exec(); err != nil {
+			log.Fatalf("Failed to add column %s.%s: %v", table, column[0], err)
+		}
+	}
+}
+
+// renameColumn renames a primary key column of an existing table, unless it
+// has been renamed already. Cassandra only allows renaming primary key
+// columns.
+func renameColumn(session *gocql.Session, table, from, to string) {
+	var name string
+	err := session.Query(`SELECT column_name FROM system_schema.columns
+		WHERE keyspace_name = ? AND table_name = ? AND column_name = ?`, keyspaceName, table, from).Scan(&name)
+	if err == gocql.ErrNotFound {
+		return
+	} else if err != nil {
+		log.Fatalf("Failed to read columns of '%s' table: %v", table, err)
+	}
+
+	query := fmt.Sprintf(`ALTER TABLE %s RENAME %s TO %s`, table, from, to)
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to rename column %s.%s: %v", table, from, err)
+	}
+}
@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateCommentsTable creates the 'comments' table holding the comments on
+// each task in the order they were posted.
+func CreateCommentsTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS comments (
+			task_id UUID,
+			comment_id TIMEUUID,
+			parent_id TIMEUUID,
+			author_id UUID,
+			body TEXT,
+			mentions LIST<UUID>,
+			created_at TIMESTAMP,
+			edited_at TIMESTAMP,
+			deleted_at TIMESTAMP,
+			PRIMARY KEY (task_id, comment_id)
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'comments' table: %v", err)
+	}
+	log.Println("'comments' table created successfully!")
+}
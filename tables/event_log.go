@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateEventLogTable creates the 'event_log' table holding the recent
+// changes pushed to clients, per workspace, so that a client reconnecting to
+// the event stream can catch up on what it missed. Rows expire shortly after
+// they are written.
+func CreateEventLogTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS event_log (
+			scope UUID,
+			event_id TIMEUUID,
+			type TEXT,
+			data TEXT,
+			PRIMARY KEY (scope, event_id)
+		) WITH CLUSTERING ORDER BY (event_id ASC);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'event_log' table: %v", err)
+	}
+	log.Println("'event_log' table created successfully!")
+}
@@ -0,0 +1,30 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateIdempotencyKeysTable creates the 'idempotency_keys' table holding
+// the responses to POST requests sent with an Idempotency-Key header. Rows
+// are written with a TTL and expire on their own.
+func CreateIdempotencyKeysTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id UUID,
+			key TEXT,
+			request_hash TEXT,
+			state TEXT,
+			status_code INT,
+			headers MAP<TEXT, TEXT>,
+			body BLOB,
+			created_at TIMESTAMP,
+			PRIMARY KEY ((user_id, key))
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'idempotency_keys' table: %v", err)
+	}
+	log.Println("'idempotency_keys' table created successfully!")
+}
@@ -0,0 +1,22 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateMigrationsTable creates the 'schema_migrations' table recording which
+// data migrations have run.
+func CreateMigrationsTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'schema_migrations' table: %v", err)
+	}
+	log.Println("'schema_migrations' table created successfully!")
+}
@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateNotificationsTable creates the 'notifications' table for in-app
+// notifications, newest first per user.
+func CreateNotificationsTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS notifications (
+			user_id UUID,
+			notification_id TIMEUUID,
+			kind TEXT,
+			title TEXT,
+			body TEXT,
+			task_id UUID,
+			read BOOLEAN,
+			created_at TIMESTAMP,
+			PRIMARY KEY (user_id, notification_id)
+		) WITH CLUSTERING ORDER BY (notification_id DESC);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'notifications' table: %v", err)
+	}
+	log.Println("'notifications' table created successfully!")
+}
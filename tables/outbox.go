@@ -0,0 +1,53 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateOutboxTables creates the 'outbox' of domain events, bucketed by the
+// hour they were written in, the cursor recording how far each subscriber
+// of the event bus has got, and the dead letters of events a subscriber gave
+// up on.
+func CreateOutboxTables(session *gocql.Session) {
+	tables := []struct{ name, query string }{
+		{"outbox", `
+			CREATE TABLE IF NOT EXISTS outbox (
+				bucket TEXT,
+				event_id TIMEUUID,
+				name TEXT,
+				payload TEXT,
+				PRIMARY KEY (bucket, event_id)
+			) WITH CLUSTERING ORDER BY (event_id ASC);
+		`},
+		{"outbox_cursors", `
+			CREATE TABLE IF NOT EXISTS outbox_cursors (
+				subscriber TEXT PRIMARY KEY,
+				position TIMEUUID,
+				attempts INT,
+				last_error TEXT,
+				lease_owner TEXT,
+				lease_until TIMESTAMP,
+				updated_at TIMESTAMP
+			);
+		`},
+		{"outbox_dead_letters", `
+			CREATE TABLE IF NOT EXISTS outbox_dead_letters (
+				subscriber TEXT,
+				event_id TIMEUUID,
+				name TEXT,
+				payload TEXT,
+				error TEXT,
+				attempts INT,
+				PRIMARY KEY (subscriber, event_id)
+			);
+		`},
+	}
+	for _, table := range tables {
+		if err := session.Query(table.query).Exec(); err != nil {
+			log.Fatalf("Failed to create '%s' table: %v", table.name, err)
+		}
+	}
+	log.Println("Outbox tables created successfully!")
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreatePendingEventsTable creates the 'pending_events' table of intents
+// written before a conditional write to a task or category, bucketed by the
+// hour they were written in. Each names the row whose follow-up, its history
+// entry, sync log entry and domain event, is still to be written.
+func CreatePendingEventsTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS pending_events (
+			bucket TEXT,
+			event_id TIMEUUID,
+			kind TEXT,
+			item_id UUID,
+			PRIMARY KEY (bucket, event_id)
+		) WITH CLUSTERING ORDER BY (event_id ASC);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'pending_events' table: %v", err)
+	}
+	log.Println("'pending_events' table created successfully!")
+}
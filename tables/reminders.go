@@ -0,0 +1,53 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateRemindersTable creates the 'reminders' table and the
+// 'pending_reminders' table the scheduler polls. Pending reminders are
+// bucketed by the hour they fire in.
+func CreateRemindersTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS reminders (
+			reminder_id UUID PRIMARY KEY,
+			task_id UUID,
+			user_id UUID,
+			channel TEXT,
+			target TEXT,
+			offset_minutes INT,
+			at_time TEXT,
+			fire_at TIMESTAMP,
+			state TEXT,
+			attempts INT,
+			last_error TEXT,
+			lease_owner TEXT,
+			lease_until TIMESTAMP,
+			created_at TIMESTAMP,
+			sent_at TIMESTAMP
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'reminders' table: %v", err)
+	}
+
+	indexQuery := `CREATE INDEX IF NOT EXISTS ON reminders (task_id);`
+	if err := session.Query(indexQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create index on reminders.task_id: %v", err)
+	}
+
+	pendingQuery := `
+		CREATE TABLE IF NOT EXISTS pending_reminders (
+			bucket TEXT,
+			fire_at TIMESTAMP,
+			reminder_id UUID,
+			PRIMARY KEY (bucket, fire_at, reminder_id)
+		);
+	`
+	if err := session.Query(pendingQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create 'pending_reminders' table: %v", err)
+	}
+	log.Println("'reminders' tables created successfully!")
+}
@@ -0,0 +1,63 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateShareLinksTables creates the tables holding public share links, the
+// lookup of links by token hash and the audit trail of each link's uses.
+// Revoked and expired links are kept so their audit trail stays readable.
+func CreateShareLinksTables(session *gocql.Session) {
+	tables := []struct{ name, query string }{
+		{"share_links", `
+			CREATE TABLE IF NOT EXISTS share_links (
+				workspace_id UUID,
+				link_id TIMEUUID,
+				kind TEXT,
+				target_id UUID,
+				filter_status TEXT,
+				filter_tag TEXT,
+				filter_project_id UUID,
+				title TEXT,
+				token_hash TEXT,
+				password_hash TEXT,
+				created_by UUID,
+				created_at TIMESTAMP,
+				expires_at TIMESTAMP,
+				revoked_at TIMESTAMP,
+				PRIMARY KEY (workspace_id, link_id)
+			);
+		`},
+		{"share_link_tokens", `
+			CREATE TABLE IF NOT EXISTS share_link_tokens (
+				token_hash TEXT PRIMARY KEY,
+				workspace_id UUID,
+				link_id TIMEUUID
+			);
+		`},
+		{"share_link_uses", `
+			CREATE TABLE IF NOT EXISTS share_link_uses (
+				link_id TIMEUUID PRIMARY KEY,
+				uses COUNTER
+			);
+		`},
+		{"share_link_accesses", `
+			CREATE TABLE IF NOT EXISTS share_link_accesses (
+				link_id TIMEUUID,
+				access_id TIMEUUID,
+				outcome TEXT,
+				remote_addr TEXT,
+				user_agent TEXT,
+				PRIMARY KEY (link_id, access_id)
+			) WITH CLUSTERING ORDER BY (access_id DESC);
+		`},
+	}
+	for _, table := range tables {
+		if err := session.Query(table.query).Exec(); err != nil {
+			log.Fatalf("Failed to create '%s' table: %v", table.name, err)
+		}
+	}
+	log.Println("Share link tables created successfully!")
+}
@@ -0,0 +1,29 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateSyncLogTable creates the 'sync_log' table recording which tasks,
+// categories and tags changed, per workspace, so that offline clients can
+// fetch what changed since they last synced. Entries name the changed item
+// rather than holding its data; rows expire once clients that old are
+// expected to start over.
+func CreateSyncLogTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS sync_log (
+			scope UUID,
+			change_id TIMEUUID,
+			kind TEXT,
+			item_id UUID,
+			tag TEXT,
+			PRIMARY KEY (scope, change_id)
+		) WITH CLUSTERING ORDER BY (change_id ASC);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'sync_log' table: %v", err)
+	}
+	log.Println("'sync_log' table created successfully!")
+}
@@ -0,0 +1,26 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateTasksByTagTable creates the 'tasks_by_tag' table indexing each
+// workspace's tasks by tag. Tags are clustering columns, so a workspace's
+// tags can be listed and searched by prefix within a single partition.
+func CreateTasksByTagTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS tasks_by_tag (
+			workspace_id UUID,
+			tag TEXT,
+			task_id UUID,
+			PRIMARY KEY ((workspace_id), tag, task_id)
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'tasks_by_tag' table: %v", err)
+	}
+	renameColumn(session, "tasks_by_tag", "user_id", "workspace_id")
+	log.Println("'tasks_by_tag' table created successfully!")
+}
@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateTaskDependenciesTable creates the 'task_dependencies' table. Edges are
+// partitioned by workspace so a workspace's whole dependency graph is a
+// single read.
+func CreateTaskDependenciesTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS task_dependencies (
+			workspace_id UUID,
+			task_id UUID,
+			blocker_id UUID,
+			created_at TIMESTAMP,
+			PRIMARY KEY (workspace_id, task_id, blocker_id)
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'task_dependencies' table: %v", err)
+	}
+	renameColumn(session, "task_dependencies", "user_id", "workspace_id")
+	log.Println("'task_dependencies' table created successfully!")
+}
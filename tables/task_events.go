@@ -0,0 +1,39 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateTaskEventsTable creates the append-only 'task_events' table holding
+// each task's change history in order.
+func CreateTaskEventsTable(session *gocql.Session) {
+	typeQuery := `
+		CREATE TYPE IF NOT EXISTS field_change (
+			old_value TEXT,
+			new_value TEXT
+		);
+	`
+	if err := session.Query(typeQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create 'field_change' type: %v", err)
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS task_events (
+			task_id UUID,
+			event_id TIMEUUID,
+			type TEXT,
+			user_id UUID,
+			actor_id UUID,
+			request_id TEXT,
+			changes MAP<TEXT, FROZEN<field_change>>,
+			created_at TIMESTAMP,
+			PRIMARY KEY (task_id, event_id)
+		) WITH CLUSTERING ORDER BY (event_id ASC);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'task_events' table: %v", err)
+	}
+	log.Println("'task_events' table created successfully!")
+}
@@ -7,16 +7,45 @@ import (
 )
 
 func CreateTasksTable(session *gocql.Session) {
+	// Checklist items are stored inline on the task
+	typeQuery := `
+        CREATE TYPE IF NOT EXISTS checklist_item (
+            item_id UUID,
+            text TEXT,
+            done BOOLEAN
+        );
+    `
+	if err := session.Query(typeQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create 'checklist_item' type: %v", err)
+	}
+
 	// Create tasks table
 	query := `
         CREATE TABLE IF NOT EXISTS tasks (
             task_id UUID,
             user_id UUID,
+            workspace_id UUID,
+            project_id UUID,
+            parent_id UUID,
+            category_id UUID,
             title TEXT,
             description TEXT,
             status TEXT,
+            checklist LIST<FROZEN<checklist_item>>,
+            tags SET<TEXT>,
+            assignees SET<UUID>,
+            auto_complete BOOLEAN,
+            due_at TIMESTAMP,
+            recurrence TEXT,
+            recur_from TEXT,
+            time_zone TEXT,
+            priority TEXT,
             created_at TIMESTAMP,
             updated_at TIMESTAMP,
+            deleted_at TIMESTAMP,
+            version INT,
+            field_clocks MAP<TEXT, TEXT>,
+            pending_events MAP<TIMEUUID, TEXT>,
             PRIMARY KEY (task_id)
         );
     `
@@ -24,11 +53,43 @@ func CreateTasksTable(session *gocql.Session) {
 		log.Fatalf("Failed to create 'tasks' table: %v", err)
 	}
 
+	addColumns(session, "tasks", [][2]string{
+		{"parent_id", "UUID"},
+		{"category_id", "UUID"},
+		{"checklist", "LIST<FROZEN<checklist_item>>"},
+		{"tags", "SET<TEXT>"},
+		{"auto_complete", "BOOLEAN"},
+		{"due_at", "TIMESTAMP"},
+		{"recurrence", "TEXT"},
+		{"recur_from", "TEXT"},
+		{"time_zone", "TEXT"},
+		{"deleted_at", "TIMESTAMP"},
+		{"version", "INT"},
+		{"workspace_id", "UUID"},
+		{"project_id", "UUID"},
+		{"assignees", "SET<UUID>"},
+		{"field_clocks", "MAP<TEXT, TEXT>"},
+		{"priority", "TEXT"},
+		{"pending_events", "MAP<TIMEUUID, TEXT>"},
+	})
+
 	// Create index on user_id
 	indexQuery := `CREATE INDEX IF NOT EXISTS ON tasks (user_id);`
 	if err := session.Query(indexQuery).Exec(); err != nil {
 		log.Fatalf("Failed to create index on tasks.user_id: %v", err)
 	}
 
+	// Create index on workspace_id
+	indexQuery = `CREATE INDEX IF NOT EXISTS ON tasks (workspace_id);`
+	if err := session.Query(indexQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create index on tasks.workspace_id: %v", err)
+	}
+
+	// Create index on parent_id for subtask lookups
+	parentIndexQuery := `CREATE INDEX IF NOT EXISTS ON tasks (parent_id);`
+	if err := session.Query(parentIndexQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create index on tasks.parent_id: %v", err)
+	}
+
 	log.Println("'tasks' table and indices created successfully!")
 }
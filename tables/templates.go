@@ -0,0 +1,28 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateTaskTemplatesTable creates the 'task_templates' table holding each
+// user's templates. The tasks a template describes are stored as a JSON
+// document.
+func CreateTaskTemplatesTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS task_templates (
+			user_id UUID,
+			template_id TIMEUUID,
+			name TEXT,
+			body TEXT,
+			created_at TIMESTAMP,
+			updated_at TIMESTAMP,
+			PRIMARY KEY (user_id, template_id)
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'task_templates' table: %v", err)
+	}
+	log.Println("'task_templates' table created successfully!")
+}
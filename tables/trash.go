@@ -0,0 +1,27 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateTrashTable creates the 'trash' table listing each workspace's
+// deleted tasks and categories until they are restored or purged.
+func CreateTrashTable(session *gocql.Session) {
+	query := `
+		CREATE TABLE IF NOT EXISTS trash (
+			workspace_id UUID,
+			item_type TEXT,
+			item_id UUID,
+			name TEXT,
+			deleted_at TIMESTAMP,
+			PRIMARY KEY (workspace_id, item_type, item_id)
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'trash' table: %v", err)
+	}
+	renameColumn(session, "trash", "user_id", "workspace_id")
+	log.Println("'trash' table created successfully!")
+}
@@ -0,0 +1,80 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateWebhooksTables creates the tables holding webhook endpoints, their
+// delivery log with the attempts made at each delivery, and the
+// 'pending_webhook_deliveries' queue the dispatcher polls. Like pending
+// reminders, queued deliveries are bucketed by the hour they are due in.
+func CreateWebhooksTables(session *gocql.Session) {
+	tables := []struct{ name, query string }{
+		{"webhooks", `
+			CREATE TABLE IF NOT EXISTS webhooks (
+				workspace_id UUID,
+				webhook_id TIMEUUID,
+				url TEXT,
+				description TEXT,
+				events SET<TEXT>,
+				secret TEXT,
+				enabled BOOLEAN,
+				disabled_reason TEXT,
+				failures INT,
+				created_by UUID,
+				created_at TIMESTAMP,
+				PRIMARY KEY (workspace_id, webhook_id)
+			);
+		`},
+		{"webhook_deliveries", `
+			CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				webhook_id TIMEUUID,
+				delivery_id TIMEUUID,
+				workspace_id UUID,
+				event_id TIMEUUID,
+				event_type TEXT,
+				payload TEXT,
+				state TEXT,
+				attempts INT,
+				next_attempt_at TIMESTAMP,
+				last_status INT,
+				last_error TEXT,
+				lease_owner TEXT,
+				lease_until TIMESTAMP,
+				redelivery_of TIMEUUID,
+				created_at TIMESTAMP,
+				delivered_at TIMESTAMP,
+				PRIMARY KEY (webhook_id, delivery_id)
+			) WITH CLUSTERING ORDER BY (delivery_id DESC);
+		`},
+		{"webhook_attempts", `
+			CREATE TABLE IF NOT EXISTS webhook_attempts (
+				delivery_id TIMEUUID,
+				attempt INT,
+				attempted_at TIMESTAMP,
+				status_code INT,
+				error TEXT,
+				response TEXT,
+				duration_ms BIGINT,
+				PRIMARY KEY (delivery_id, attempt)
+			);
+		`},
+		{"pending_webhook_deliveries", `
+			CREATE TABLE IF NOT EXISTS pending_webhook_deliveries (
+				bucket TEXT,
+				attempt_at TIMESTAMP,
+				webhook_id TIMEUUID,
+				delivery_id TIMEUUID,
+				PRIMARY KEY (bucket, attempt_at, webhook_id, delivery_id)
+			);
+		`},
+	}
+	for _, table := range tables {
+		if err := session.Query(table.query).Exec(); err != nil {
+			log.Fatalf("Failed to create '%s' table: %v", table.name, err)
+		}
+	}
+	log.Println("Webhook tables created successfully!")
+}
@@ -0,0 +1,38 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateWorkflowsTable creates the 'workflows' table holding each
+// workspace's task statuses.
+func CreateWorkflowsTable(session *gocql.Session) {
+	typeQuery := `
+		CREATE TYPE IF NOT EXISTS workflow_status (
+			key TEXT,
+			name TEXT,
+			position INT,
+			color TEXT,
+			category TEXT
+		);
+	`
+	if err := session.Query(typeQuery).Exec(); err != nil {
+		log.Fatalf("Failed to create 'workflow_status' type: %v", err)
+	}
+
+	query := `
+		CREATE TABLE IF NOT EXISTS workflows (
+			workspace_id UUID PRIMARY KEY,
+			statuses LIST<FROZEN<workflow_status>>,
+			transitions MAP<TEXT, FROZEN<LIST<TEXT>>>,
+			updated_at TIMESTAMP
+		);
+	`
+	if err := session.Query(query).Exec(); err != nil {
+		log.Fatalf("Failed to create 'workflows' table: %v", err)
+	}
+	renameColumn(session, "workflows", "user_id", "workspace_id")
+	log.Println("'workflows' table created successfully!")
+}
@@ -0,0 +1,77 @@
+package tables
+
+import (
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// CreateWorkspacesTables creates the tables holding workspaces, their
+// members, projects and pending invitations. Members are stored both per
+// workspace and per user, so either side can be listed with a single read.
+func CreateWorkspacesTables(session *gocql.Session) {
+	tables := []struct{ name, query string }{
+		{"workspaces", `
+			CREATE TABLE IF NOT EXISTS workspaces (
+				workspace_id UUID PRIMARY KEY,
+				name TEXT,
+				personal BOOLEAN,
+				created_by UUID,
+				created_at TIMESTAMP
+			);
+		`},
+		{"workspace_members", `
+			CREATE TABLE IF NOT EXISTS workspace_members (
+				workspace_id UUID,
+				user_id UUID,
+				role TEXT,
+				joined_at TIMESTAMP,
+				PRIMARY KEY (workspace_id, user_id)
+			);
+		`},
+		{"workspaces_by_user", `
+			CREATE TABLE IF NOT EXISTS workspaces_by_user (
+				user_id UUID,
+				workspace_id UUID,
+				role TEXT,
+				PRIMARY KEY (user_id, workspace_id)
+			);
+		`},
+		{"projects", `
+			CREATE TABLE IF NOT EXISTS projects (
+				workspace_id UUID,
+				project_id TIMEUUID,
+				name TEXT,
+				description TEXT,
+				created_at TIMESTAMP,
+				PRIMARY KEY (workspace_id, project_id)
+			);
+		`},
+		{"invitations", `
+			CREATE TABLE IF NOT EXISTS invitations (
+				workspace_id UUID,
+				invitation_id TIMEUUID,
+				email TEXT,
+				role TEXT,
+				token_hash TEXT,
+				invited_by UUID,
+				created_at TIMESTAMP,
+				expires_at TIMESTAMP,
+				PRIMARY KEY (workspace_id, invitation_id)
+			);
+		`},
+		{"invitation_tokens", `
+			CREATE TABLE IF NOT EXISTS invitation_tokens (
+				token_hash TEXT PRIMARY KEY,
+				workspace_id UUID,
+				invitation_id TIMEUUID
+			);
+		`},
+	}
+	for _, table := range tables {
+		if err := session.Query(table.query).Exec(); err != nil {
+			log.Fatalf("Failed to create '%s' table: %v", table.name, err)
+		}
+	}
+	log.Println("Workspace tables created successfully!")
+}
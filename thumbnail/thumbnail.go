@@ -0,0 +1,153 @@
+// Package thumbnail makes downscaled copies of PNG, JPEG and GIF images
+// using only the standard library. Re-encoding drops all metadata, EXIF
+// included; the EXIF orientation of JPEGs is applied to the pixels first.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// MaxPixels bounds the size of the images that are decoded, so a small file
+// cannot expand into a huge image in memory: at 4 bytes a pixel, an image
+// takes up to 80 MB.
+const MaxPixels = 20_000_000
+
+var ErrUnsupported = errors.New("unsupported image")
+
+// Supported reports whether thumbnails can be made of images of the media
+// type.
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// ContentType returns the media type of the thumbnails of an image. JPEGs
+// stay JPEGs; GIFs become PNGs of their first frame.
+func ContentType(contentType string) string {
+	if contentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// Source is a decoded image thumbnails are made of.
+type Source struct {
+	img         image.Image
+	orientation int
+}
+
+// Decode reads an image of the given media type.
+func Decode(r io.Reader, contentType string) (*Source, error) {
+	if !Supported(contentType) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, contentType)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d pixels is too large", ErrUnsupported, config.Width, config.Height)
+	}
+
+	var img image.Image
+	switch contentType {
+	case "image/png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "image/jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "image/gif":
+		img, err = gif.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	source := &Source{img: img, orientation: 1}
+	if contentType == "image/jpeg" {
+		source.orientation = jpegOrientation(data)
+	}
+	return source, nil
+}
+
+// Thumbnail returns the image scaled down to fit in a max by max square and
+// turned upright according to its EXIF orientation. Orienting after scaling
+// keeps the per-pixel work small.
+func (s *Source) Thumbnail(max int) image.Image {
+	return orient(Resize(s.img, max), s.orientation)
+}
+
+// Resize scales the image down so that neither side is longer than max
+// pixels, averaging the source pixels covered by each target pixel. The
+// source is converted a band of rows at a time rather than copied whole.
+// Images that already fit are returned as they are.
+func Resize(img image.Image, max int) image.Image {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= max && sh <= max {
+		return img
+	}
+	dw, dh := max, max
+	if sw > sh {
+		dh = (sh*max + sw/2) / sw
+	} else {
+		dw = (sw*max + sh/2) / sh
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	// Each target row covers at most this many source rows.
+	band := image.NewRGBA(image.Rect(0, 0, sw, (sh+dh-1)/dh))
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		draw.Draw(band, image.Rect(0, 0, sw, y1-y0), img, image.Pt(b.Min.X, b.Min.Y+y0), draw.Src)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			var sum [4]int
+			for sy := 0; sy < y1-y0; sy++ {
+				row := band.Pix[sy*band.Stride+x0*4 : sy*band.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := y*dst.Stride + x*4
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8((sum[c] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Encode writes the image in the given thumbnail media type.
+func Encode(w io.Writer, img image.Image, contentType string) error {
+	if contentType == "image/jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,92 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// TestResize compares scaling down band by band with averaging a full-size
+// copy of the source, for the image types the decoders return.
+func TestResize(t *testing.T) {
+	rect := image.Rect(3, 5, 3+301, 5+97)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio420)
+	nrgba := image.NewNRGBA(rect)
+	paletted := image.NewPaletted(rect, color.Palette{color.Black, color.White, color.RGBA{200, 10, 10, 255}})
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			ycbcr.Y[ycbcr.YOffset(x, y)] = uint8(x * y)
+			ycbcr.Cb[ycbcr.COffset(x, y)] = uint8(x)
+			ycbcr.Cr[ycbcr.COffset(x, y)] = uint8(y)
+			nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x), uint8(y), uint8(x + y), uint8(x * 7)})
+			paletted.SetColorIndex(x, y, uint8((x+y)%3))
+		}
+	}
+
+	for _, img := range []image.Image{ycbcr, nrgba, paletted} {
+		for _, max := range []int{160, 40, 1} {
+			got := Resize(img, max).(*image.RGBA)
+			want := resizeCopy(img, got.Bounds().Dx(), got.Bounds().Dy())
+			if !bytes.Equal(got.Pix, want.Pix) {
+				t.Errorf("Resize(%T, %d) differs from averaging a full copy", img, max)
+			}
+		}
+	}
+}
+
+// resizeCopy scales the image to w by h pixels from a full-size copy.
+func resizeCopy(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*b.Dy()/h, (y+1)*b.Dy()/h
+		for x := 0; x < w; x++ {
+			x0, x1 := x*b.Dx()/w, (x+1)*b.Dx()/w
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := src.RGBAAt(sx, sy)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					sum[3] += int(c.A)
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			dst.SetRGBA(x, y, color.RGBA{
+				uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n), uint8((sum[3] + n/2) / n),
+			})
+		}
+	}
+	return dst
+}
+
+// TestDecodeRefusesLargeImages checks that an image claiming more than
+// MaxPixels is refused from its header, before any pixels are allocated.
+func TestDecodeRefusesLargeImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	// Rewrite the width and height in the IHDR chunk, which follows the
+	// 8-byte signature, and its checksum.
+	data := buf.Bytes()
+	ihdr := data[8+4 : 8+4+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], 5000)
+	binary.BigEndian.PutUint32(ihdr[8:], MaxPixels/5000+1)
+	binary.BigEndian.PutUint32(data[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+
+	_, err := Decode(bytes.NewReader(data), "image/png")
+	if !errors.Is(err, ErrUnsupported) || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Decode of a %dx%d image returned %v, want it refused as too large", 5000, MaxPixels/5000+1, err)
+	}
+}
@@ -0,0 +1,149 @@
+// Package webhook signs and sends webhook deliveries, and verifies them on
+// the receiving end.
+//
+// Every delivery is a JSON POST carrying the headers
+//
+//	X-Webhook-Event:     the event type, e.g. task.created
+//	X-Webhook-Delivery:  the ID of the delivery, new for every redelivery
+//	X-Webhook-Timestamp: when the attempt was made, in Unix seconds
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of "<timestamp>.<body>">
+//
+// The signature is keyed with the endpoint's secret. Receivers check it with
+// Verify, which also rejects deliveries whose timestamp is too old to rule
+// out replays.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"todo-app/netguard"
+	"unicode/utf8"
+)
+
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// DefaultTolerance is how far a delivery's timestamp may be from the
+// receiver's clock before Verify rejects it.
+const DefaultTolerance = 5 * time.Minute
+
+// maxResponse caps how much of an endpoint's response is kept for the
+// delivery log.
+const maxResponse = 1024
+
+var (
+	ErrMissingSignature = errors.New("webhook signature or timestamp missing")
+	ErrInvalidSignature = errors.New("webhook signature does not match")
+	ErrStaleTimestamp   = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// Sign returns the signature of a body sent at the given time, in the format
+// of the X-Webhook-Signature header.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp.Unix())
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a received delivery
+// against its body.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration) error {
+	signature := header.Get(HeaderSignature)
+	timestamp := header.Get(HeaderTimestamp)
+	if signature == "" || timestamp == "" {
+		return ErrMissingSignature
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrMissingSignature
+	}
+	sent := time.Unix(seconds, 0)
+	if d := time.Since(sent); d > tolerance || d < -tolerance {
+		return ErrStaleTimestamp
+	}
+	if !hmac.Equal([]byte(signature), []byte(Sign(secret, sent, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Delivery is a signed event to send to an endpoint.
+type Delivery struct {
+	URL        string
+	Secret     string
+	EventType  string
+	DeliveryID string
+	Body       []byte
+}
+
+// Result describes how an endpoint answered an attempt. StatusCode is zero
+// if no response was received.
+type Result struct {
+	StatusCode int
+	Response   string
+	Duration   time.Duration
+}
+
+// Sender posts deliveries to endpoints. Endpoints are chosen by users, so
+// the default client only reaches public addresses. Redirects are not
+// followed: an endpoint that moved has to be updated by its owner.
+type Sender struct {
+	client *http.Client
+}
+
+func NewSender(client *http.Client) *Sender {
+	if client == nil {
+		client = netguard.NewClient(10 * time.Second)
+	}
+	return &Sender{client: client}
+}
+
+// Send makes one attempt at a delivery, signed with the current time. It
+// returns an error unless the endpoint answered with a 2xx status.
+func (s *Sender) Send(ctx context.Context, d Delivery) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return Result{}, err
+	}
+	now := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "todo-app-webhooks")
+	req.Header.Set(HeaderEvent, d.EventType)
+	req.Header.Set(HeaderDelivery, d.DeliveryID)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(d.Secret, now, d.Body))
+
+	resp, err := s.client.Do(req)
+	result := Result{Duration: time.Since(now)}
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	// Drain a little more so the connection can be reused.
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	result.StatusCode = resp.StatusCode
+	result.Response = strings.ToValidUTF8(string(body), string(utf8.RuneError))
+	result.Duration = time.Since(now)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return result, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return result, nil
+}
@@ -0,0 +1,269 @@
+package webhook_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"todo-app/netguard"
+	"todo-app/webhook"
+)
+
+const secret = "whsec_test"
+
+// received is a request the receiver got, with the outcome of verifying it.
+type received struct {
+	header http.Header
+	body   []byte
+	err    error
+}
+
+// receiver is a webhook endpoint answering with respond, or 204 if it is
+// nil.
+type receiver struct {
+	server  *httptest.Server
+	respond http.HandlerFunc
+
+	mu  sync.Mutex
+	got []received
+}
+
+func newReceiver(t *testing.T, respond http.HandlerFunc) *receiver {
+	rc := &receiver{respond: respond}
+	rc.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		rc.got = append(rc.got, received{
+			header: r.Header.Clone(),
+			body:   body,
+			err:    webhook.Verify(secret, r.Header, body, webhook.DefaultTolerance),
+		})
+		rc.mu.Unlock()
+		if rc.respond == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		rc.respond(w, r)
+	}))
+	t.Cleanup(rc.server.Close)
+	return rc
+}
+
+func (rc *receiver) received(t *testing.T) []received {
+	t.Helper()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.got) == 0 {
+		t.Fatal("receiver got nothing")
+	}
+	return append([]received(nil), rc.got...)
+}
+
+func (rc *receiver) last(t *testing.T) received {
+	t.Helper()
+	got := rc.received(t)
+	return got[len(got)-1]
+}
+
+func (rc *receiver) delivery(body string) webhook.Delivery {
+	return webhook.Delivery{
+		URL:        rc.server.URL + "/hook",
+		Secret:     secret,
+		EventType:  "task.created",
+		DeliveryID: "test-delivery",
+		Body:       []byte(body),
+	}
+}
+
+// post sends a request to the receiver as an attacker would, bypassing the
+// sender.
+func (rc *receiver) post(t *testing.T, header http.Header, body []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, rc.server.URL+"/hook", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+// newSender returns a sender that may reach the local receivers, as it may
+// in development.
+func newSender(t *testing.T) *webhook.Sender {
+	allow := netguard.AllowPrivate
+	netguard.AllowPrivate = true
+	t.Cleanup(func() { netguard.AllowPrivate = allow })
+	return webhook.NewSender(nil)
+}
+
+func TestSignatures(t *testing.T) {
+	rc := newReceiver(t, nil)
+	result, err := newSender(t).Send(context.Background(), rc.delivery(`{"id":"1","type":"task.created"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCode != http.StatusNoContent {
+		t.Errorf("status %d, want %d", result.StatusCode, http.StatusNoContent)
+	}
+	got := rc.last(t)
+	if got.err != nil {
+		t.Fatalf("receiver rejected the delivery: %v", got.err)
+	}
+	if event := got.header.Get(webhook.HeaderEvent); event != "task.created" {
+		t.Errorf("event header %q", event)
+	}
+	if id := got.header.Get(webhook.HeaderDelivery); id != "test-delivery" {
+		t.Errorf("delivery header %q", id)
+	}
+
+	t.Run("tampered body", func(t *testing.T) {
+		body := strings.Replace(string(got.body), "task.created", "task.deleted", 1)
+		rc.post(t, got.header.Clone(), []byte(body))
+		if err := rc.last(t).err; !errors.Is(err, webhook.ErrInvalidSignature) {
+			t.Errorf("verification returned %v", err)
+		}
+	})
+
+	t.Run("another secret", func(t *testing.T) {
+		d := rc.delivery(`{"id":"2"}`)
+		d.Secret = "whsec_other"
+		if _, err := newSender(t).Send(context.Background(), d); err != nil {
+			t.Fatal(err)
+		}
+		if err := rc.last(t).err; !errors.Is(err, webhook.ErrInvalidSignature) {
+			t.Errorf("verification returned %v", err)
+		}
+	})
+
+	t.Run("old delivery replayed with its own signature", func(t *testing.T) {
+		body := []byte(`{"id":"3"}`)
+		sent := time.Now().Add(-2 * webhook.DefaultTolerance)
+		header := http.Header{}
+		header.Set(webhook.HeaderTimestamp, strconv.FormatInt(sent.Unix(), 10))
+		header.Set(webhook.HeaderSignature, webhook.Sign(secret, sent, body))
+		rc.post(t, header, body)
+		if err := rc.last(t).err; !errors.Is(err, webhook.ErrStaleTimestamp) {
+			t.Errorf("verification returned %v", err)
+		}
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		rc.post(t, http.Header{}, []byte(`{}`))
+		if err := rc.last(t).err; !errors.Is(err, webhook.ErrMissingSignature) {
+			t.Errorf("verification returned %v", err)
+		}
+	})
+}
+
+func TestSendErrorStatus(t *testing.T) {
+	rc := newReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database is down", http.StatusServiceUnavailable)
+	})
+	result, err := newSender(t).Send(context.Background(), rc.delivery(`{"id":"4"}`))
+	if err == nil {
+		t.Fatal("attempt succeeded")
+	}
+	if result.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status %d, want %d", result.StatusCode, http.StatusServiceUnavailable)
+	}
+	if !strings.Contains(result.Response, "database is down") {
+		t.Errorf("response %q was not kept", result.Response)
+	}
+}
+
+func TestSendTruncatesResponse(t *testing.T) {
+	rc := newReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100000)))
+	})
+	result, err := newSender(t).Send(context.Background(), rc.delivery(`{"id":"5"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Response) > 1024 {
+		t.Errorf("kept %d bytes of the response", len(result.Response))
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	rc := newReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hook" {
+			http.Redirect(w, r, "/moved", http.StatusTemporaryRedirect)
+		}
+	})
+	result, err := newSender(t).Send(context.Background(), rc.delivery(`{"id":"6"}`))
+	if err == nil {
+		t.Fatal("attempt succeeded")
+	}
+	if result.StatusCode != http.StatusTemporaryRedirect {
+		t.Errorf("status %d, want %d", result.StatusCode, http.StatusTemporaryRedirect)
+	}
+	if got := rc.received(t); len(got) != 1 {
+		t.Errorf("receiver got %d requests", len(got))
+	}
+}
+
+func TestSendTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	rc := newReceiver(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result, err := newSender(t).Send(ctx, rc.delivery(`{"id":"7"}`))
+	if err == nil {
+		t.Fatal("attempt succeeded")
+	}
+	if result.StatusCode != 0 {
+		t.Errorf("status %d, want none", result.StatusCode)
+	}
+	if result.Duration > 2*time.Second {
+		t.Errorf("attempt took %s", result.Duration)
+	}
+}
+
+func TestSendUnreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	d := webhook.Delivery{URL: "http://" + addr + "/hook", Secret: secret, Body: []byte(`{"id":"8"}`)}
+	result, err := newSender(t).Send(context.Background(), d)
+	if err == nil {
+		t.Fatal("attempt succeeded")
+	}
+	if result.StatusCode != 0 {
+		t.Errorf("status %d, want none", result.StatusCode)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	rc := newReceiver(t, nil)
+	_, err := webhook.NewSender(nil).Send(context.Background(), rc.delivery(`{"id":"9"}`))
+	if !errors.Is(err, netguard.ErrBlockedAddress) {
+		t.Errorf("delivery to %s: err = %v, want ErrBlockedAddress", rc.server.URL, err)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.got) != 0 {
+		t.Error("the receiver was reached")
+	}
+}